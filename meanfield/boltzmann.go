@@ -0,0 +1,68 @@
+// Pieces of the single-site mean-field problem shared by the vo2solve and
+// twodof models: Boltzmann distributions over the single-site levels which
+// stay finite down to T = 0, and thermal averages and susceptibilities of
+// observables over them.
+package meanfield
+
+import (
+	"math"
+)
+
+// Boltzmann distribution over a set of energies at inverse temperature Beta.
+// The weights exp(-Beta (E - E0)) are taken relative to the lowest energy E0,
+// so that the distribution is finite for all Beta >= 0, including
+// Beta = Inf (T = 0), where the configurations of energy E0 share the
+// probability equally.
+type Boltzmann struct {
+	Beta, E0 float64
+	// Occupation probabilities, in the order of the energies.
+	Probs []float64
+	// Sum of the weights, between 1 and the number of energies.
+	Sum float64
+}
+
+// Return the Boltzmann distribution over energies at inverse temperature
+// beta.
+func NewBoltzmann(energies []float64, beta float64) *Boltzmann {
+	E0 := math.Inf(1)
+	for _, E := range energies {
+		E0 = math.Min(E0, E)
+	}
+	probs := make([]float64, len(energies))
+	sum := 0.0
+	for i, E := range energies {
+		probs[i] = math.Exp(-ScaleBeta(beta, E-E0))
+		sum += probs[i]
+	}
+	for i := range probs {
+		probs[i] /= sum
+	}
+	return &Boltzmann{beta, E0, probs, sum}
+}
+
+// Logarithm of the partition function, -Beta E0 + log(Sum). At Beta = Inf
+// this is infinite unless E0 = 0.
+func (b *Boltzmann) LogZ() float64 {
+	return -ScaleBeta(b.Beta, b.E0) + math.Log(b.Sum)
+}
+
+// Free energy -log(Z) / Beta = E0 - T log(Sum), which is finite for all
+// Beta > 0 and equal to E0 at Beta = Inf.
+func (b *Boltzmann) FreeEnergy() float64 {
+	return b.E0 - math.Log(b.Sum)/b.Beta
+}
+
+// Return beta * x, taking Inf * 0 as 0 so that quantities which vanish at
+// T = 0 (such as E - E0 for a ground state, or a vanishing covariance) do not
+// give NaN.
+func ScaleBeta(beta, x float64) float64 {
+	if x == 0.0 {
+		return 0.0
+	}
+	return beta * x
+}
+
+// Return log(1 + exp(x)) without overflow for large x.
+func LogOnePlusExp(x float64) float64 {
+	return math.Max(x, 0.0) + math.Log1p(math.Exp(-math.Abs(x)))
+}
@@ -0,0 +1,284 @@
+package meanfield
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+)
+import (
+	"github.com/tflovorn/scExplorer/serialize"
+)
+
+// Maximum number of Newton iterations used to find the single-site field
+// which produces given values of the order parameters.
+const landau_max_iter = 100
+
+// Maximum number of times a Runge-Kutta step is halved in Relax.
+const relax_max_subdivide = 20
+
+// Time-dependent value of the Environment variable Var (e.g. "Beta" or
+// "Filling"): linearly interpolated between Values at the given Times (in
+// increasing order), and constant outside of them.
+type Schedule struct {
+	Var    string
+	Times  []float64
+	Values []float64
+}
+
+// Trajectory of the order parameters Vars under relaxational dynamics.
+type Trajectory struct {
+	Vars []string
+	// Names of the variables set by schedules.
+	Inputs []string
+	Points []TrajectoryPoint
+}
+
+type TrajectoryPoint struct {
+	Time float64
+	// Values of Vars.
+	Values []float64
+	// Values of Inputs.
+	Inputs []float64
+	Mu     float64
+	// Landau free energy (see LandauFunc).
+	FreeEnergy float64
+}
+
+// Return the gradient of the Landau free energy G with respect to the order
+// parameters x, G itself and the chemical potential, with the scheduled
+// variables set to inputs.
+type LandauFunc func(inputs, x []float64) ([]float64, float64, float64, error)
+
+// Return the value of the schedule at time t.
+func (s *Schedule) At(t float64) float64 {
+	n := len(s.Times)
+	if t <= s.Times[0] {
+		return s.Values[0]
+	}
+	for i := 1; i < n; i++ {
+		if t <= s.Times[i] {
+			frac := (t - s.Times[i-1]) / (s.Times[i] - s.Times[i-1])
+			return s.Values[i-1] + frac*(s.Values[i]-s.Values[i-1])
+		}
+	}
+	return s.Values[n-1]
+}
+
+// Return an error if the schedule has no times, different numbers of times
+// and values, or times which are not increasing.
+func (s *Schedule) Validate() error {
+	if len(s.Times) == 0 || len(s.Times) != len(s.Values) {
+		return fmt.Errorf("Schedule for %v needs equal, nonzero numbers of Times and Values", s.Var)
+	}
+	for i := 1; i < len(s.Times); i++ {
+		if s.Times[i] <= s.Times[i-1] {
+			return fmt.Errorf("Schedule for %v has Times which are not increasing", s.Var)
+		}
+	}
+	return nil
+}
+
+// Load a list of schedules from the JSON file at path. The variable names
+// are checked against the Environment by the models' Relax.
+func LoadSchedules(path string) ([]Schedule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	schedules := []Schedule{}
+	err = json.Unmarshal(data, &schedules)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range schedules {
+		err = s.Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return schedules, nil
+}
+
+// Integrate the relaxational dynamics dx/dt = -rate dG/dx, where x are the
+// order parameters vars (starting from x0) and G is the Landau free energy
+// given by landau, for the given number of steps of length dt using the
+// fourth-order Runge-Kutta method. The variables in schedules are set to
+// their scheduled values at each time. The trajectory is recorded at t = 0,
+// every 'every' steps and at the last step.
+//
+// The Landau free energy has a large curvature when the order parameters are
+// close to saturation, so a step is subdivided (up to relax_max_subdivide
+// times) if landau fails, as it does when the step overshoots the range of x
+// which can be produced.
+func Relax(vars []string, x0 []float64, schedules []Schedule, landau LandauFunc, rate, dt float64, steps, every int) (*Trajectory, error) {
+	if every <= 0 {
+		return nil, fmt.Errorf("Trajectory must be recorded every EVERY > 0 steps; got %v", every)
+	}
+	if steps < 0 || dt <= 0.0 {
+		return nil, fmt.Errorf("Need steps >= 0 and dt > 0; got steps = %v and dt = %v", steps, dt)
+	}
+	inputs := make([]string, len(schedules))
+	for i, s := range schedules {
+		err := s.Validate()
+		if err != nil {
+			return nil, err
+		}
+		inputs[i] = s.Var
+	}
+	traj := Trajectory{vars, inputs, []TrajectoryPoint{}}
+	n := len(vars)
+
+	// Return -rate dG/dx at time t and order parameters x, along with the
+	// trajectory point there.
+	deriv := func(t float64, x []float64) ([]float64, TrajectoryPoint, error) {
+		input_vals := make([]float64, len(schedules))
+		for i, s := range schedules {
+			input_vals[i] = s.At(t)
+		}
+		grad, G, Mu, err := landau(input_vals, x)
+		if err != nil {
+			return nil, TrajectoryPoint{}, err
+		}
+		dx := make([]float64, n)
+		for i := 0; i < n; i++ {
+			dx[i] = -rate * grad[i]
+		}
+		xc := make([]float64, n)
+		copy(xc, x)
+		return dx, TrajectoryPoint{t, xc, input_vals, Mu, G}, nil
+	}
+	// Return x + h*dx.
+	shift := func(x, dx []float64, h float64) []float64 {
+		y := make([]float64, n)
+		for i := 0; i < n; i++ {
+			y[i] = x[i] + h*dx[i]
+		}
+		return y
+	}
+	// Return the fourth-order Runge-Kutta step from x with derivative k1
+	// at t to t+h, and the derivative and trajectory point there.
+	rk4 := func(t float64, x, k1 []float64, h float64) ([]float64, []float64, TrajectoryPoint, error) {
+		k2, _, err := deriv(t+0.5*h, shift(x, k1, 0.5*h))
+		if err != nil {
+			return nil, nil, TrajectoryPoint{}, err
+		}
+		k3, _, err := deriv(t+0.5*h, shift(x, k2, 0.5*h))
+		if err != nil {
+			return nil, nil, TrajectoryPoint{}, err
+		}
+		k4, _, err := deriv(t+h, shift(x, k3, h))
+		if err != nil {
+			return nil, nil, TrajectoryPoint{}, err
+		}
+		x_new := make([]float64, n)
+		for i := 0; i < n; i++ {
+			x_new[i] = x[i] + h/6.0*(k1[i]+2.0*k2[i]+2.0*k3[i]+k4[i])
+		}
+		k_new, point, err := deriv(t+h, x_new)
+		if err != nil {
+			return nil, nil, TrajectoryPoint{}, err
+		}
+		return x_new, k_new, point, nil
+	}
+
+	// Advance x with derivative k1 from t to t+h, subdividing the step if
+	// it fails.
+	var advance func(t float64, x, k1 []float64, h float64, depth int) ([]float64, []float64, TrajectoryPoint, error)
+	advance = func(t float64, x, k1 []float64, h float64, depth int) ([]float64, []float64, TrajectoryPoint, error) {
+		x_new, k_new, point, err := rk4(t, x, k1, h)
+		if err == nil {
+			return x_new, k_new, point, nil
+		}
+		if depth >= relax_max_subdivide {
+			return nil, nil, TrajectoryPoint{}, fmt.Errorf("At t = %v: %v", t, err)
+		}
+		x_mid, k_mid, _, err := advance(t, x, k1, 0.5*h, depth+1)
+		if err != nil {
+			return nil, nil, TrajectoryPoint{}, err
+		}
+		return advance(t+0.5*h, x_mid, k_mid, 0.5*h, depth+1)
+	}
+
+	x := make([]float64, n)
+	copy(x, x0)
+	k1, point, err := deriv(0.0, x)
+	if err != nil {
+		return nil, fmt.Errorf("At t = 0: %v", err)
+	}
+	traj.Points = append(traj.Points, point)
+	for step := 1; step <= steps; step++ {
+		t := float64(step-1) * dt
+		x, k1, point, err = advance(t, x, k1, dt, 0)
+		if err != nil {
+			return nil, err
+		}
+		if step%every == 0 || step == steps {
+			traj.Points = append(traj.Points, point)
+		}
+	}
+	return &traj, nil
+}
+
+// Return the single-site fields f for which the single-site averages of the
+// observables conjugate to vars are equal to x, starting from f0, using
+// Newton's method with backtracking. averages(f) returns the averages and
+// their susceptibility d<O>/df at the fields f.
+func SolveField(vars []string, x, f0 []float64, averages func(f []float64) ([]float64, [][]float64), eps float64) ([]float64, error) {
+	n := len(x)
+	f := make([]float64, n)
+	copy(f, f0)
+	resid := func(f []float64) ([]float64, [][]float64, float64) {
+		obs, chi := averages(f)
+		R := make([]float64, n)
+		norm := 0.0
+		for i := 0; i < n; i++ {
+			R[i] = x[i] - obs[i]
+			norm += R[i] * R[i]
+		}
+		return R, chi, math.Sqrt(norm)
+	}
+	R, chi, norm := resid(f)
+	for iter := 0; iter < landau_max_iter; iter++ {
+		if norm < eps {
+			return f, nil
+		}
+		R_col := make([][]float64, n)
+		for i := 0; i < n; i++ {
+			R_col[i] = []float64{R[i]}
+		}
+		step, ok := SolveLinear(chi, R_col)
+		if !ok {
+			return nil, fmt.Errorf("Singular susceptibility at %v = %v", vars, x)
+		}
+		scale := 1.0
+		for {
+			trial := make([]float64, n)
+			for i := 0; i < n; i++ {
+				trial[i] = f[i] + scale*step[i][0]
+			}
+			R_trial, chi_trial, norm_trial := resid(trial)
+			if norm_trial < norm {
+				f, R, chi, norm = trial, R_trial, chi_trial, norm_trial
+				break
+			}
+			scale *= 0.5
+			if scale < 1e-12 {
+				return nil, fmt.Errorf("No field produces %v = %v", vars, x)
+			}
+		}
+	}
+	if norm < eps {
+		return f, nil
+	}
+	return nil, fmt.Errorf("Field for %v = %v not converged", vars, x)
+}
+
+// Convert to string by marshalling to JSON.
+func (traj *Trajectory) Marshal() string {
+	marshalled, err := serialize.MakeJSON(traj)
+	if err != nil {
+		panic(err)
+	}
+	return marshalled
+}
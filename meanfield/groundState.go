@@ -0,0 +1,46 @@
+package meanfield
+
+import (
+	"fmt"
+	"math"
+)
+
+// Maximum number of iterations taken by FixedPoint.
+const fixed_point_max_iter = 500
+
+// Return the free energy -T log(1 + exp(-beta energy)) of a single-particle
+// state with the given energy (measured from the chemical potential) at
+// inverse temperature beta, without overflow at low temperature. At T = 0
+// (beta = Inf) this is the energy of the state if it is occupied
+// (energy < 0) and 0 otherwise.
+func FermionFreeEnergy(beta, energy float64) float64 {
+	if math.IsInf(beta, 1) {
+		return math.Min(energy, 0.0)
+	}
+	return -LogOnePlusExp(-beta*energy) / beta
+}
+
+// Iterate x -> next(x) starting from x0 until no component changes by more
+// than epsAbs + epsRel |x|, and return the final x. Return an error if next
+// does, or if the iteration has not converged after fixed_point_max_iter
+// steps.
+func FixedPoint(x0 []float64, next func(x []float64) ([]float64, error), epsAbs, epsRel float64) ([]float64, error) {
+	x := x0
+	for iter := 0; iter < fixed_point_max_iter; iter++ {
+		x_next, err := next(x)
+		if err != nil {
+			return nil, err
+		}
+		converged := true
+		for i := range x {
+			if math.Abs(x_next[i]-x[i]) > epsAbs+epsRel*math.Abs(x[i]) {
+				converged = false
+			}
+		}
+		x = x_next
+		if converged {
+			return x, nil
+		}
+	}
+	return nil, fmt.Errorf("Mean-field iteration not converged after %v steps", fixed_point_max_iter)
+}
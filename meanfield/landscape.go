@@ -0,0 +1,166 @@
+package meanfield
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+import (
+	"github.com/tflovorn/scExplorer/serialize"
+	vec "github.com/tflovorn/scExplorer/vector"
+)
+
+// Free energy evaluated on a grid of fixed values of some variables, with
+// another set of variables solved for self-consistently at each grid point
+// and all others frozen to their values in the base Environment.
+type Landscape struct {
+	// Names of the variables fixed on the grid.
+	GridVars []string
+	// Names of the variables solved for at each grid point.
+	RelaxedVars []string
+	Points      []LandscapePoint
+}
+
+type LandscapePoint struct {
+	// Values of GridVars at this point.
+	Grid []float64
+	// Solved values of RelaxedVars at this point.
+	Relaxed    []float64
+	FreeEnergy float64
+	// False if RelaxedVars could not be solved for at this point (in which
+	// case Relaxed and FreeEnergy are not meaningful).
+	Converged bool
+}
+
+// Return the relaxed variables, free energy and convergence of the point with
+// the grid variables set to x. An error means the landscape cannot be
+// evaluated at all (as opposed to a point which does not converge).
+type LandscapeFunc func(x []float64) ([]float64, float64, bool, error)
+
+// Evaluate the free energy on the grid given by the outer product of
+// grid_vals (grid_vals[i] is the list of values taken by grid_vars[i]), with
+// the variables in relax solved for at each point by evaluate.
+// Return an error if grid_vars and relax have a variable in common, since it
+// cannot be both fixed and solved for.
+func NewLandscape(grid_vars []string, grid_vals [][]float64, relax []string, evaluate LandscapeFunc) (*Landscape, error) {
+	if len(grid_vars) != len(grid_vals) {
+		return nil, fmt.Errorf("Got %d grid variables but %d lists of grid values", len(grid_vars), len(grid_vals))
+	}
+	for _, g := range grid_vars {
+		for _, r := range relax {
+			if g == r {
+				return nil, fmt.Errorf("Variable %v is both fixed on the grid and relaxed", g)
+			}
+		}
+	}
+	ls := Landscape{grid_vars, relax, []LandscapePoint{}}
+	for _, x := range gridPoints(grid_vals) {
+		relaxed, F, converged, err := evaluate(x)
+		if err != nil {
+			return nil, err
+		}
+		if !converged {
+			relaxed, F = make([]float64, len(relax)), 0.0
+		}
+		ls.Points = append(ls.Points, LandscapePoint{x, relaxed, F, converged})
+	}
+	return &ls, nil
+}
+
+// Return all points in the outer product of the lists in grid_vals, with the
+// last variable varying fastest.
+func gridPoints(grid_vals [][]float64) []vec.Vector {
+	points := []vec.Vector{vec.Vector{}}
+	for _, vals := range grid_vals {
+		next := []vec.Vector{}
+		for _, p := range points {
+			for _, v := range vals {
+				q := make(vec.Vector, len(p), len(p)+1)
+				copy(q, p)
+				next = append(next, append(q, v))
+			}
+		}
+		points = next
+	}
+	return points
+}
+
+// Split a comma-separated list, ignoring empty elements.
+func SplitList(list string) []string {
+	elems := []string{}
+	for _, e := range strings.Split(list, ",") {
+		e = strings.TrimSpace(e)
+		if e != "" {
+			elems = append(elems, e)
+		}
+	}
+	return elems
+}
+
+// Return evenly-spaced values for each of num_vars grid variables from the
+// comma-separated minimum, maximum and number of values.
+func GridValues(num_vars int, mins, maxs, nums string) ([][]float64, error) {
+	min_l, max_l, num_l := SplitList(mins), SplitList(maxs), SplitList(nums)
+	if len(min_l) != num_vars || len(max_l) != num_vars || len(num_l) != num_vars {
+		return nil, fmt.Errorf("Need %d values each for min, max and num", num_vars)
+	}
+	grid_vals := [][]float64{}
+	for i := 0; i < num_vars; i++ {
+		min, err := strconv.ParseFloat(min_l[i], 64)
+		if err != nil {
+			return nil, err
+		}
+		max, err := strconv.ParseFloat(max_l[i], 64)
+		if err != nil {
+			return nil, err
+		}
+		num, err := strconv.Atoi(num_l[i])
+		if err != nil {
+			return nil, err
+		}
+		if num < 1 {
+			return nil, fmt.Errorf("Need at least one grid value; got num = %v", num)
+		}
+		vals := make([]float64, num)
+		for j := 0; j < num; j++ {
+			if num == 1 {
+				vals[j] = min
+			} else {
+				vals[j] = min + float64(j)*(max-min)/float64(num-1)
+			}
+		}
+		grid_vals = append(grid_vals, vals)
+	}
+	return grid_vals, nil
+}
+
+// Convert to string by marshalling to JSON.
+func (ls *Landscape) Marshal() string {
+	marshalled, err := serialize.MakeJSON(ls)
+	if err != nil {
+		panic(err)
+	}
+	return marshalled
+}
+
+// Convert to CSV with one row per grid point: the grid variables, the relaxed
+// variables, FreeEnergy and Converged.
+func (ls *Landscape) CSV() string {
+	var buf bytes.Buffer
+	header := append(append([]string{}, ls.GridVars...), ls.RelaxedVars...)
+	header = append(header, "FreeEnergy", "Converged")
+	buf.WriteString(strings.Join(header, ",") + "\n")
+	for _, point := range ls.Points {
+		row := []string{}
+		for _, v := range point.Grid {
+			row = append(row, fmt.Sprintf("%.10g", v))
+		}
+		for _, v := range point.Relaxed {
+			row = append(row, fmt.Sprintf("%.10g", v))
+		}
+		row = append(row, fmt.Sprintf("%.10g", point.FreeEnergy), fmt.Sprintf("%t", point.Converged))
+		buf.WriteString(strings.Join(row, ",") + "\n")
+	}
+	return buf.String()
+}
@@ -0,0 +1,101 @@
+package meanfield
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// Relaxation in G = a(t) x^2 / 2, with a scheduled as the input "a".
+func TestRelax(t *testing.T) {
+	landau := func(inputs, x []float64) ([]float64, float64, float64, error) {
+		a := inputs[0]
+		return []float64{a * x[0]}, 0.5 * a * x[0] * x[0], 0.0, nil
+	}
+	schedules := []Schedule{Schedule{Var: "a", Times: []float64{0.0}, Values: []float64{2.0}}}
+	rate, dt, steps := 0.5, 0.01, 100
+	traj, err := Relax([]string{"x"}, []float64{1.0}, schedules, landau, rate, dt, steps, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Recorded at t = 0, every 30 steps and at the last step.
+	if len(traj.Points) != 5 {
+		t.Fatalf("Trajectory has %d points; expected 5", len(traj.Points))
+	}
+	last := traj.Points[len(traj.Points)-1]
+	expected := math.Exp(-rate * 2.0 * float64(steps) * dt)
+	if math.Abs(last.Values[0]-expected) > 1e-9 || math.Abs(last.Time-float64(steps)*dt) > 1e-12 {
+		t.Fatalf("Relaxation ended at x(%v) = %v; expected x(%v) = %v", last.Time, last.Values[0], float64(steps)*dt, expected)
+	}
+
+	_, err = Relax([]string{"x"}, []float64{1.0}, schedules, landau, rate, dt, steps, 0)
+	if err == nil {
+		t.Fatalf("Expected error recording every 0 steps")
+	}
+	bad := []Schedule{Schedule{Var: "a", Times: []float64{1.0, 0.0}, Values: []float64{2.0, 1.0}}}
+	_, err = Relax([]string{"x"}, []float64{1.0}, bad, landau, rate, dt, steps, 10)
+	if err == nil {
+		t.Fatalf("Expected error for a schedule with decreasing times")
+	}
+
+	// A step which cannot be completed, however far it is subdivided, reports
+	// the error from the Landau free energy.
+	failing := func(inputs, x []float64) ([]float64, float64, float64, error) {
+		if x[0] < 0.99 {
+			return nil, 0.0, 0.0, fmt.Errorf("x = %v out of range", x[0])
+		}
+		return landau(inputs, x)
+	}
+	_, err = Relax([]string{"x"}, []float64{1.0}, schedules, failing, rate, dt, steps, 10)
+	if err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Fatalf("Got error %v; expected the out of range error", err)
+	}
+}
+
+// With a singular chi, the label is given by the eigenvalues of J, which may
+// differ in sign from those of its symmetric part.
+func TestHessianSingularChi(t *testing.T) {
+	chi := [][]float64{[]float64{0.0, 0.0}, []float64{0.0, 0.0}}
+	cases := []struct {
+		jac      [][]float64
+		expected string
+	}{
+		// Eigenvalues 1, 1; the symmetric part has eigenvalues -4, 6.
+		{[][]float64{[]float64{1.0, 10.0}, []float64{0.0, 1.0}}, Stable},
+		{[][]float64{[]float64{1.0, 3.0}, []float64{0.0, -2.0}}, Unstable},
+		// Eigenvalues +/- i.
+		{[][]float64{[]float64{0.0, -1.0}, []float64{1.0, 0.0}}, Unknown},
+	}
+	for _, c := range cases {
+		hess, evals, label := Hessian(chi, c.jac, 1.0)
+		if hess != nil || evals != nil || label != c.expected {
+			t.Fatalf("Hessian with singular chi and J = %v gave %v, %v, %v; expected nil, nil, %v", c.jac, hess, evals, label, c.expected)
+		}
+	}
+	// A non-symmetric matrix with eigenvalues -1, 2, 3.
+	A := [][]float64{[]float64{2.0, 0.0, 0.0}, []float64{1.0, 3.0, 0.0}, []float64{4.0, 5.0, -1.0}}
+	B := [][]float64{[]float64{1.0, 2.0, 0.0}, []float64{0.0, 1.0, 1.0}, []float64{1.0, 0.0, 1.0}}
+	B_A, _ := SolveLinear(B, A)
+	BAB_inv := make([][]float64, 3)
+	for i := range BAB_inv {
+		BAB_inv[i] = make([]float64, 3)
+		for j := range BAB_inv[i] {
+			for k := range B {
+				BAB_inv[i][j] += B_A[i][k] * B[k][j]
+			}
+		}
+	}
+	expected := []float64{-1.0, 2.0, 3.0}
+	for _, M := range [][][]float64{A, BAB_inv} {
+		evals, ok := RealEigenvalues(M)
+		if !ok {
+			t.Fatalf("RealEigenvalues(%v) failed", M)
+		}
+		for i := range expected {
+			if math.Abs(evals[i]-expected[i]) > 1e-9 {
+				t.Fatalf("RealEigenvalues(%v) = %v; expected %v", M, evals, expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package meanfield
+
+import (
+	"math"
+	"sort"
+)
+import (
+	"github.com/tflovorn/cmatrix"
+)
+
+// Step in the order parameters used to take the finite-difference Jacobian of
+// the self-consistency equations.
+const hessian_h = 1e-6
+
+// Hessian eigenvalues below -hessian_tol indicate an unstable direction.
+const hessian_tol = 1e-6
+
+// Largest relative subdiagonal element treated as zero by RealEigenvalues.
+const eigen_tol = 1e-12
+
+// Stability labels. A solution is labeled Stable if it is a local minimum of
+// the free energy and Unstable if it is a saddle point or maximum.
+// Metastable is assigned by minimization routines to Stable solutions which
+// do not have the lowest free energy. Unknown is assigned if the curvature
+// cannot be determined (see Hessian).
+const (
+	Stable     = "stable"
+	Metastable = "metastable"
+	Unstable   = "unstable"
+	Unknown    = "unknown"
+)
+
+// Return the Hessian of the Landau free energy G with respect to the order
+// parameters x, its eigenvalues (in ascending order) and the corresponding
+// stability label, given the single-site susceptibility chi and the Jacobian
+// jac of the self-consistency residuals x - <O> with respect to x.
+//
+// The Landau free energy G(x) is the mean-field free energy expressed as a
+// function of the order parameters x, with the single-site fields chosen to
+// produce x. Its Hessian at a solution is sites chi^{-1} J, where sites is
+// the number of single-site problems per unit of G.
+// If chi is singular, the Hessian diverges and nil is returned for it and its
+// eigenvalues. The label is then given by the eigenvalues of J itself, which
+// are real since J is similar to chi^{1/2} G'' chi^{1/2} / sites (the
+// symmetric part of J does not have the same inertia); it is Unknown if they
+// cannot be found.
+func Hessian(chi, jac [][]float64, sites float64) ([][]float64, []float64, string) {
+	chi_inv_jac, ok := SolveLinear(chi, jac)
+	if !ok {
+		// chi is singular if the probability is concentrated on a single
+		// configuration.
+		jac_evals, ok := RealEigenvalues(jac)
+		if !ok {
+			return nil, nil, Unknown
+		}
+		return nil, nil, StabilityLabel(jac_evals)
+	}
+	hess := symmetrize(chi_inv_jac, sites)
+	evals := SymmetricEigenvalues(hess)
+	return hess, evals, StabilityLabel(evals)
+}
+
+// Return Stable if all the Hessian eigenvalues evals are non-negative
+// (within hessian_tol), or Unstable otherwise.
+func StabilityLabel(evals []float64) string {
+	for _, ev := range evals {
+		if ev < -hessian_tol {
+			return Unstable
+		}
+	}
+	return Stable
+}
+
+// Return the Jacobian of residuals with respect to x at x0, using central
+// differences with step hessian_h.
+func Jacobian(x0 []float64, residuals func(x []float64) ([]float64, error)) ([][]float64, error) {
+	n := len(x0)
+	jac := make([][]float64, n)
+	for i := 0; i < n; i++ {
+		jac[i] = make([]float64, n)
+	}
+	for j := 0; j < n; j++ {
+		xp := make([]float64, n)
+		xm := make([]float64, n)
+		copy(xp, x0)
+		copy(xm, x0)
+		xp[j] += hessian_h
+		xm[j] -= hessian_h
+		Rp, err := residuals(xp)
+		if err != nil {
+			return nil, err
+		}
+		Rm, err := residuals(xm)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < n; i++ {
+			jac[i][j] = (Rp[i] - Rm[i]) / (2.0 * hessian_h)
+		}
+	}
+	return jac, nil
+}
+
+// Return scale * (A + A^T) / 2.
+func symmetrize(A [][]float64, scale float64) [][]float64 {
+	n := len(A)
+	S := make([][]float64, n)
+	for i := 0; i < n; i++ {
+		S[i] = make([]float64, n)
+		for j := 0; j < n; j++ {
+			S[i][j] = 0.5 * scale * (A[i][j] + A[j][i])
+		}
+	}
+	return S
+}
+
+// Return X = A^{-1} B for the square matrix A and the matrix B with the same
+// number of rows, using Gaussian elimination with partial pivoting. If A is
+// singular, return false.
+func SolveLinear(A, B [][]float64) ([][]float64, bool) {
+	n := len(A)
+	m := len(B[0])
+	a := make([][]float64, n)
+	for i := 0; i < n; i++ {
+		a[i] = make([]float64, n+m)
+		copy(a[i][:n], A[i])
+		copy(a[i][n:], B[i])
+	}
+	for c := 0; c < n; c++ {
+		p := c
+		for r := c + 1; r < n; r++ {
+			if math.Abs(a[r][c]) > math.Abs(a[p][c]) {
+				p = r
+			}
+		}
+		if a[p][c] == 0.0 {
+			return nil, false
+		}
+		a[c], a[p] = a[p], a[c]
+		for r := 0; r < n; r++ {
+			if r == c {
+				continue
+			}
+			f := a[r][c] / a[c][c]
+			for k := c; k < n+m; k++ {
+				a[r][k] -= f * a[c][k]
+			}
+		}
+	}
+	X := make([][]float64, n)
+	for i := 0; i < n; i++ {
+		X[i] = make([]float64, m)
+		for j := 0; j < m; j++ {
+			X[i][j] = a[i][n+j] / a[i][i]
+		}
+	}
+	return X, true
+}
+
+// Return the eigenvalues of the real square matrix A in ascending order, found
+// by the QR algorithm with Wilkinson shifts. Return false if A has complex
+// eigenvalues or the iteration does not converge.
+func RealEigenvalues(A [][]float64) ([]float64, bool) {
+	n := len(A)
+	a := make([][]float64, n)
+	for i := 0; i < n; i++ {
+		a[i] = make([]float64, n)
+		copy(a[i], A[i])
+	}
+	evals := []float64{}
+	// a[:m][:m] is the block which has not been deflated yet.
+	m := n
+	for iter := 0; m > 0; iter++ {
+		if iter > 100*n {
+			return nil, false
+		}
+		if m == 1 {
+			evals = append(evals, a[0][0])
+			m--
+			continue
+		}
+		if math.Abs(a[m-1][m-2]) <= eigen_tol*(math.Abs(a[m-1][m-1])+math.Abs(a[m-2][m-2])) {
+			evals = append(evals, a[m-1][m-1])
+			m--
+			continue
+		}
+		// Eigenvalues of the trailing 2x2 block.
+		p, q, r, s := a[m-2][m-2], a[m-2][m-1], a[m-1][m-2], a[m-1][m-1]
+		disc := 0.25*(p-s)*(p-s) + q*r
+		if disc < 0.0 {
+			return nil, false
+		}
+		l1, l2 := 0.5*(p+s)+math.Sqrt(disc), 0.5*(p+s)-math.Sqrt(disc)
+		if m == 2 {
+			evals = append(evals, l1, l2)
+			m -= 2
+			continue
+		}
+		shift := l1
+		if math.Abs(l2-s) < math.Abs(l1-s) {
+			shift = l2
+		}
+		qrStep(a, m, shift)
+	}
+	sort.Float64s(evals)
+	return evals, true
+}
+
+// Replace a[:m][:m] by R Q + shift, where Q R = a[:m][:m] - shift is found
+// with Givens rotations.
+func qrStep(a [][]float64, m int, shift float64) {
+	for i := 0; i < m; i++ {
+		a[i][i] -= shift
+	}
+	type rotation struct {
+		i, j int
+		c, s float64
+	}
+	rots := []rotation{}
+	for j := 0; j < m; j++ {
+		for i := j + 1; i < m; i++ {
+			r := math.Hypot(a[j][j], a[i][j])
+			if r == 0.0 {
+				continue
+			}
+			c, s := a[j][j]/r, a[i][j]/r
+			for k := 0; k < m; k++ {
+				x, y := a[j][k], a[i][k]
+				a[j][k], a[i][k] = c*x+s*y, -s*x+c*y
+			}
+			rots = append(rots, rotation{j, i, c, s})
+		}
+	}
+	for _, rot := range rots {
+		for k := 0; k < m; k++ {
+			x, y := a[k][rot.i], a[k][rot.j]
+			a[k][rot.i], a[k][rot.j] = rot.c*x+rot.s*y, -rot.s*x+rot.c*y
+		}
+	}
+	for i := 0; i < m; i++ {
+		a[i][i] += shift
+	}
+}
+
+// Return the eigenvalues of the real symmetric matrix A in ascending order.
+func SymmetricEigenvalues(A [][]float64) []float64 {
+	n := len(A)
+	M := cmatrix.InitSliceCMatrix(n, n)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			M[i][j] = complex(A[i][j], 0.0)
+		}
+	}
+	evals, _ := cmatrix.Eigensystem(M)
+	sort.Float64s(evals)
+	return evals
+}
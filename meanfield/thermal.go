@@ -0,0 +1,71 @@
+package meanfield
+
+import (
+	"math"
+)
+
+// Energies below this separation are treated as degenerate when evaluating
+// the susceptibility.
+const degenerate_tol = 1e-10
+
+// Return the Boltzmann distribution over the levels evals, the thermal
+// averages of the observables obs and their static (Kubo) susceptibility
+// chi_ab = d<O_a>/dh_b, where h_b is the field coupling to O_b as -h_b O_b.
+// The observables are diagonal in the original basis: obs[a][i] is the value
+// of O_a in basis state i. evecs[n][i] gives the eigenvectors in that basis.
+func ThermalAverages(evals []float64, evecs [][]float64, obs [][]float64, beta float64) (*Boltzmann, []float64, [][]float64) {
+	n, nobs := len(evals), len(obs)
+	dist := NewBoltzmann(evals, beta)
+	probs := dist.Probs
+
+	// Matrix elements of the observables between eigenstates.
+	elems := make([][][]float64, nobs)
+	for a := 0; a < nobs; a++ {
+		elems[a] = make([][]float64, n)
+		for m := 0; m < n; m++ {
+			elems[a][m] = make([]float64, n)
+			for l := 0; l < n; l++ {
+				for i := range obs[a] {
+					elems[a][m][l] += evecs[m][i] * obs[a][i] * evecs[l][i]
+				}
+			}
+		}
+	}
+	avgs := make([]float64, nobs)
+	for a := 0; a < nobs; a++ {
+		for m := 0; m < n; m++ {
+			avgs[a] += probs[m] * elems[a][m][m]
+		}
+	}
+
+	// The terms between degenerate levels are proportional to beta; their
+	// coefficients are collected in chi_beta so that chi stays finite at
+	// beta = Inf when they cancel.
+	chi := make([][]float64, nobs)
+	chi_beta := make([][]float64, nobs)
+	for a := 0; a < nobs; a++ {
+		chi[a] = make([]float64, nobs)
+		chi_beta[a] = make([]float64, nobs)
+	}
+	for m := 0; m < n; m++ {
+		for l := 0; l < n; l++ {
+			degenerate := math.Abs(evals[m]-evals[l]) < degenerate_tol
+			for a := 0; a < nobs; a++ {
+				for b := 0; b < nobs; b++ {
+					elem := elems[a][m][l] * elems[b][l][m]
+					if degenerate {
+						chi_beta[a][b] += 0.5 * (probs[m] + probs[l]) * elem
+					} else {
+						chi[a][b] += (probs[m] - probs[l]) / (evals[l] - evals[m]) * elem
+					}
+				}
+			}
+		}
+	}
+	for a := 0; a < nobs; a++ {
+		for b := 0; b < nobs; b++ {
+			chi[a][b] += ScaleBeta(beta, chi_beta[a][b]-avgs[a]*avgs[b])
+		}
+	}
+	return dist, avgs, chi
+}
@@ -0,0 +1,195 @@
+package tetra
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+import (
+	"github.com/tflovorn/scExplorer/serialize"
+)
+
+// High-symmetry points of the simple cubic Brillouin zone, in the reciprocal
+// lattice basis.
+var CubicPoints = map[string][3]float64{
+	"G": [3]float64{0.0, 0.0, 0.0},
+	"X": [3]float64{0.0, 0.5, 0.0},
+	"M": [3]float64{0.5, 0.5, 0.0},
+	"R": [3]float64{0.5, 0.5, 0.5},
+}
+
+// Default k-path through the simple cubic Brillouin zone.
+const CubicPath = "G-X-M-G-R-X-M-R"
+
+// Band energies (and optionally weights on the basis states) along a k-path.
+type Bands struct {
+	// k-points in the reciprocal lattice basis, and the Cartesian distance
+	// along the path to each.
+	K        [][3]float64
+	Distance []float64
+	// Energies[i][b] is the energy of band b at K[i], in ascending order.
+	Energies [][]float64
+	// Weights[i][b][s] is the weight of band b at K[i] on basis state
+	// Basis[s]; nil if weights were not requested.
+	Basis   []string
+	Weights [][][]float64
+	// Distances and labels of the path vertices.
+	TickDistances []float64
+	TickLabels    []string
+}
+
+// Parse a k-path given as vertices separated by '-'. Each vertex is either
+// the name of a point in CubicPoints or reciprocal lattice coordinates
+// "k1,k2,k3", optionally labelled as "label=k1,k2,k3" (otherwise the label
+// is "k1 k2 k3"). Return the vertices and their labels.
+func ParsePath(path string) ([][3]float64, []string, error) {
+	vertices, labels := [][3]float64{}, []string{}
+	for _, v := range strings.Split(path, "-") {
+		v = strings.TrimSpace(v)
+		if k, ok := CubicPoints[v]; ok {
+			vertices = append(vertices, k)
+			labels = append(labels, v)
+			continue
+		}
+		label, coords := strings.Replace(v, ",", " ", -1), v
+		if i := strings.Index(v, "="); i != -1 {
+			label, coords = v[:i], v[i+1:]
+		}
+		split := strings.Split(coords, ",")
+		if len(split) != 3 {
+			return nil, nil, fmt.Errorf("Unknown k-path vertex %v; expected one of %v or k1,k2,k3", v, cubicPointNames())
+		}
+		var k [3]float64
+		for x := 0; x < 3; x++ {
+			val, err := strconv.ParseFloat(strings.TrimSpace(split[x]), 64)
+			if err != nil {
+				return nil, nil, err
+			}
+			k[x] = val
+		}
+		vertices = append(vertices, k)
+		labels = append(labels, label)
+	}
+	if len(vertices) < 2 {
+		return nil, nil, fmt.Errorf("k-path %v needs at least two vertices", path)
+	}
+	return vertices, labels, nil
+}
+
+// Return the names of the points in CubicPoints.
+func cubicPointNames() []string {
+	return []string{"G", "X", "M", "R"}
+}
+
+// Return the bands given by Pfn along the path through the given vertices
+// (with the given labels), using per_panel evenly-spaced k-points (including
+// the ends) between each pair of consecutive vertices. R gives the
+// reciprocal lattice vectors as rows. If weights is false, the band weights
+// are not stored.
+func BandsAlongPath(Pfn ProjectedEnergyFunc, vertices [][3]float64, labels []string, per_panel int, R [3][3]float64, basis []string, weights bool) *Bands {
+	bands := Bands{}
+	if weights {
+		bands.Basis = basis
+	}
+	dist := 0.0
+	var last [3]float64
+	add := func(k [3]float64) {
+		if len(bands.K) > 0 {
+			dist += cartesianDistance(last, k, R)
+		}
+		last = k
+		Es, ws := Pfn(k[:])
+		bands.K = append(bands.K, k)
+		bands.Distance = append(bands.Distance, dist)
+		bands.Energies = append(bands.Energies, Es)
+		if weights {
+			bands.Weights = append(bands.Weights, ws)
+		}
+	}
+	add(vertices[0])
+	bands.TickDistances = append(bands.TickDistances, 0.0)
+	for p := 1; p < len(vertices); p++ {
+		start, stop := vertices[p-1], vertices[p]
+		// Panel start point is the previous panel's end point.
+		for i := 1; i < per_panel; i++ {
+			var k [3]float64
+			for x := 0; x < 3; x++ {
+				k[x] = start[x] + float64(i)*(stop[x]-start[x])/float64(per_panel-1)
+			}
+			add(k)
+		}
+		bands.TickDistances = append(bands.TickDistances, dist)
+	}
+	bands.TickLabels = labels
+	return &bands
+}
+
+// Return the Cartesian distance between the k-points ka and kb, given in the
+// reciprocal lattice basis.
+func cartesianDistance(ka, kb [3]float64, R [3][3]float64) float64 {
+	d2 := 0.0
+	for x := 0; x < 3; x++ {
+		d := 0.0
+		for i := 0; i < 3; i++ {
+			d += (kb[i] - ka[i]) * R[i][x]
+		}
+		d2 += d * d
+	}
+	return math.Sqrt(d2)
+}
+
+// Convert to string by marshalling to JSON.
+func (bands *Bands) Marshal() string {
+	marshalled, err := serialize.MakeJSON(bands)
+	if err != nil {
+		panic(err)
+	}
+	return marshalled
+}
+
+// Convert to comma-separated values with a header line. Each row gives one
+// k-point: the path vertex label (empty except at vertices), distance, k,
+// band energies and, if present, the weights W<b>_<s> of band b on basis
+// state s.
+func (bands *Bands) CSV() string {
+	var buf bytes.Buffer
+	header := []string{"Label", "Distance", "k1", "k2", "k3"}
+	for b := range bands.Energies[0] {
+		header = append(header, "E"+strconv.Itoa(b))
+	}
+	if bands.Weights != nil {
+		for b := range bands.Energies[0] {
+			for s := range bands.Basis {
+				header = append(header, fmt.Sprintf("W%d_%d", b, s))
+			}
+		}
+	}
+	buf.WriteString(strings.Join(header, ",") + "\n")
+
+	tick := 0
+	for i, k := range bands.K {
+		label := ""
+		if tick < len(bands.TickDistances) && bands.Distance[i] == bands.TickDistances[tick] {
+			label = bands.TickLabels[tick]
+			tick++
+		}
+		row := []string{label, fmt.Sprintf("%.10f", bands.Distance[i])}
+		for x := 0; x < 3; x++ {
+			row = append(row, fmt.Sprintf("%.10f", k[x]))
+		}
+		for _, E := range bands.Energies[i] {
+			row = append(row, fmt.Sprintf("%.10f", E))
+		}
+		if bands.Weights != nil {
+			for _, ws := range bands.Weights[i] {
+				for _, w := range ws {
+					row = append(row, fmt.Sprintf("%.10f", w))
+				}
+			}
+		}
+		buf.WriteString(strings.Join(row, ",") + "\n")
+	}
+	return buf.String()
+}
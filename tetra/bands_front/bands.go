@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+import (
+	"github.com/tflovorn/vo2mft/tetra"
+	"github.com/tflovorn/vo2mft/twodof"
+	"github.com/tflovorn/vo2mft/vo2solve"
+)
+
+var path = flag.String("path", tetra.CubicPath, "k-path: '-'-separated vertices, each a point name (G, X, M, R) or k1,k2,k3 (optionally label=k1,k2,k3) in the reciprocal lattice basis")
+var num = flag.Int("num", 50, "Number of k-points in each panel of the path, including both ends")
+var use_twodof = flag.Bool("twodof", false, "Use the twodof model instead of vo2solve")
+var weights = flag.Bool("weights", false, "Include the weights of each band on the basis states")
+var csv = flag.Bool("csv", false, "Write CSV instead of JSON")
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 2 {
+		fmt.Println("Usage: bands_front [--path PATH] [--num NUM] [--twodof] [--weights] [--csv] in_path out_path")
+		fmt.Println("For flag descriptions, use: bands_front --help")
+		os.Exit(2)
+	}
+	in_path := args[0]
+	out_path := args[1]
+
+	vertices, labels, err := tetra.ParsePath(*path)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if *num < 2 {
+		fmt.Println("Need at least 2 k-points per panel")
+		os.Exit(1)
+	}
+
+	var bands *tetra.Bands
+	if !*use_twodof {
+		env, err := vo2solve.LoadEnv(in_path)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		bands = env.Bands(vertices, labels, *num, *weights)
+	} else {
+		env, err := twodof.LoadEnv(in_path)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		bands = env.Bands(vertices, labels, *num, *weights)
+	}
+
+	// Write output.
+	if *csv {
+		bands_out_buf := bytes.NewBufferString(bands.CSV())
+		ioutil.WriteFile(out_path+"_bands.csv", bands_out_buf.Bytes(), 0644) // u=rw;go=r
+	} else {
+		bands_out_buf := bytes.NewBufferString(bands.Marshal())
+		ioutil.WriteFile(out_path+"_bands.json", bands_out_buf.Bytes(), 0644) // u=rw;go=r
+	}
+}
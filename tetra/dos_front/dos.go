@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+import (
+	"github.com/tflovorn/vo2mft/twodof"
+	"github.com/tflovorn/vo2mft/vo2solve"
+)
+
+var n = flag.Int("n", 8, "Number of k-points along each reciprocal lattice direction")
+var num_dos = flag.Int("num_dos", 500, "Number of energies at which to evaluate the DOS")
+var use_twodof = flag.Bool("twodof", false, "Use the twodof model instead of vo2solve")
+var tsv = flag.Bool("tsv", false, "Write tab-separated values instead of JSON")
+var projected = flag.Bool("projected", false, "Include the DOS projected onto each basis state")
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 2 {
+		fmt.Println("Usage: dos_front [--n N] [--num_dos NUM_DOS] [--twodof] [--tsv] [--projected] in_path out_path")
+		fmt.Println("For flag descriptions, use: dos_front --help")
+		os.Exit(2)
+	}
+	in_path := args[0]
+	out_path := args[1]
+
+	// Both Dos and ProjectedDos provide TSV and Marshal.
+	var dos interface {
+		TSV() string
+		Marshal() string
+	}
+	if !*use_twodof {
+		env, err := vo2solve.LoadEnv(in_path)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if *projected {
+			dos = env.ProjectedDos(*n, *num_dos)
+		} else {
+			dos = env.Dos(*n, *num_dos)
+		}
+	} else {
+		env, err := twodof.LoadEnv(in_path)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if *projected {
+			dos = env.ProjectedDos(*n, *num_dos)
+		} else {
+			dos = env.Dos(*n, *num_dos)
+		}
+	}
+
+	// Write output.
+	if *tsv {
+		dos_out_buf := bytes.NewBufferString(dos.TSV())
+		ioutil.WriteFile(out_path+"_dos.tsv", dos_out_buf.Bytes(), 0644) // u=rw;go=r
+	} else {
+		dos_out_buf := bytes.NewBufferString(dos.Marshal())
+		ioutil.WriteFile(out_path+"_dos.json", dos_out_buf.Bytes(), 0644) // u=rw;go=r
+	}
+}
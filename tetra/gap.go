@@ -0,0 +1,119 @@
+package tetra
+
+import (
+	"math"
+)
+
+// Maximum number of steps taken when refining a band edge.
+const edge_max_iter = 10000
+
+// Band edges around E = 0 (the chemical potential, for Hamiltonians which
+// include it). For metals, all fields are 0 and the k-points are nil.
+type BandEdges struct {
+	// Indirect gap (conduction band minimum minus valence band maximum) and
+	// smallest direct gap.
+	Gap, DirectGap float64
+	IsInsulator    bool
+	// k-points (in the reciprocal lattice basis, reduced to [0, 1)) of the
+	// valence band maximum, the conduction band minimum and the smallest
+	// direct gap.
+	ValenceMaxK, ConductionMinK, DirectGapK []float64
+}
+
+// Return the band edges around E = 0 of the bands given by Efn.
+// The extrema are found on an n x n x n grid of k-points and then refined by
+// a local search in k, stopping when the step in k is below tol.
+// The bands are metallic if a band crosses E = 0. If all bands lie on one side
+// of E = 0, there is no gap and the zero value is returned.
+func FindBandEdges(Efn EnergyFunc, n int, tol float64) BandEdges {
+	Eks := EnergyGrid(Efn, n)
+	num_bands := len(Eks[0])
+	// Bands are in ascending order at each k, so those with maximum below 0
+	// are the lowest bands.
+	v := -1
+	for b := 0; b < num_bands; b++ {
+		max := math.Inf(-1)
+		for _, Ek := range Eks {
+			max = math.Max(max, Ek[b])
+		}
+		if max < 0.0 {
+			v = b
+		}
+	}
+	c := v + 1
+	if v == -1 || c == num_bands {
+		return BandEdges{}
+	}
+	for _, Ek := range Eks {
+		if Ek[c] <= 0.0 {
+			// Band c crosses 0.
+			return BandEdges{}
+		}
+	}
+
+	// Start from the extrema on the grid.
+	var i_v, i_c, i_d int
+	for i, Ek := range Eks {
+		if Ek[v] > Eks[i_v][v] {
+			i_v = i
+		}
+		if Ek[c] < Eks[i_c][c] {
+			i_c = i
+		}
+		if Ek[c]-Ek[v] < Eks[i_d][c]-Eks[i_d][v] {
+			i_d = i
+		}
+	}
+	h := 1.0 / float64(n)
+	k_v, E_v := minimizeCompass(func(k []float64) float64 {
+		return -Efn(k)[v]
+	}, gridPoint(n, i_v), h, tol)
+	k_c, E_c := minimizeCompass(func(k []float64) float64 {
+		return Efn(k)[c]
+	}, gridPoint(n, i_c), h, tol)
+	k_d, direct := minimizeCompass(func(k []float64) float64 {
+		Ek := Efn(k)
+		return Ek[c] - Ek[v]
+	}, gridPoint(n, i_d), h, tol)
+	E_v = -E_v
+	if E_v >= 0.0 || E_c <= 0.0 {
+		// Refinement found a band crossing 0.
+		return BandEdges{}
+	}
+	return BandEdges{E_c - E_v, direct, true, k_v, k_c, k_d}
+}
+
+// Return the k-point of the grid point with index i (see GridIndex).
+func gridPoint(n, i int) []float64 {
+	return []float64{float64(i%n) / float64(n), float64((i/n)%n) / float64(n), float64(i/(n*n)) / float64(n)}
+}
+
+// Minimize f by compass search from k0: step by h along each axis while this
+// lowers f, halving h when no step does, until h is below tol.
+// Return the minimum point (reduced to [0, 1)) and the value of f there.
+func minimizeCompass(f func(k []float64) float64, k0 []float64, h, tol float64) ([]float64, float64) {
+	k := make([]float64, len(k0))
+	copy(k, k0)
+	fk := f(k)
+	for iter := 0; h > tol && iter < edge_max_iter; iter++ {
+		improved := false
+		for x := range k {
+			for _, sign := range []float64{1.0, -1.0} {
+				trial := make([]float64, len(k))
+				copy(trial, k)
+				trial[x] += sign * h
+				if ft := f(trial); ft < fk {
+					k, fk = trial, ft
+					improved = true
+				}
+			}
+		}
+		if !improved {
+			h *= 0.5
+		}
+	}
+	for x := range k {
+		k[x] -= math.Floor(k[x])
+	}
+	return k, fk
+}
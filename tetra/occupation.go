@@ -0,0 +1,130 @@
+package tetra
+
+import (
+	"fmt"
+	"math"
+)
+
+// Maximum number of bisection steps taken when finding the Fermi level.
+const fermi_max_iter = 200
+
+// Return the number of states per unit cell (without spin degeneracy) with
+// energy below E, given the band energies Eks on the n x n x n grid: the
+// integral of the density of states of DosAt up to E.
+func NumStates(Eks [][]float64, n int, R [3][3]float64, E float64) float64 {
+	N := 0.0
+	forEachTetraOccupied(Eks, n, R, E, func(w [4]float64) {
+		N += w[0] + w[1] + w[2] + w[3]
+	})
+	return N
+}
+
+// Return the Fermi level at which there are N states per unit cell (without
+// spin degeneracy) below it, as for NumStates. If N fills the bands up to a
+// gap, the Fermi level is in the middle of the gap. Return an error if N is
+// outside [0, number of bands].
+func FermiLevel(Eks [][]float64, n int, R [3][3]float64, N float64) (float64, error) {
+	num_bands := float64(len(Eks[0]))
+	if N < 0.0 || N > num_bands {
+		return 0.0, fmt.Errorf("Cannot place %v states per cell in %v bands", N, num_bands)
+	}
+	// The linearly interpolated bands have their extrema on the grid.
+	b := int(math.Floor(N + 0.5))
+	if math.Abs(N-float64(b)) < 1e-10 && b > 0 && b < len(Eks[0]) {
+		top, bottom := math.Inf(-1), math.Inf(1)
+		for _, Ek := range Eks {
+			top = math.Max(top, Ek[b-1])
+			bottom = math.Min(bottom, Ek[b])
+		}
+		if top < bottom {
+			return 0.5 * (top + bottom), nil
+		}
+	}
+	Emin, Emax := EnergyRange(Eks)
+	tol := 1e-12 * math.Max(1.0, Emax-Emin)
+	return bisectEnergy(Emin, Emax, tol, func(E float64) bool {
+		return NumStates(Eks, n, R, E) >= N
+	}), nil
+}
+
+// Return the lowest E in [Emin, Emax] (to within tol) for which above(E) is
+// true, given that above is false below some energy and true above it.
+// Return Emax if above is false everywhere.
+func bisectEnergy(Emin, Emax, tol float64, above func(E float64) bool) float64 {
+	lo, hi := Emin, Emax
+	if above(lo) {
+		return lo
+	}
+	for i := 0; i < fermi_max_iter && hi-lo > tol; i++ {
+		mid := 0.5 * (lo + hi)
+		if above(mid) {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return hi
+}
+
+// Call add(w) for each band in each tetrahedron which has states below E,
+// with the corner weights w which integrate linearly interpolated quantities
+// over the states below E (Blochl, Jepsen and Andersen, Eqs. (B1)-(B15),
+// without the curvature correction).
+func forEachTetraOccupied(Eks [][]float64, n int, R [3][3]float64, E float64, add func(w [4]float64)) {
+	num_bands := len(Eks[0])
+	// Each tetrahedron has volume 1/(6 n^3) of the Brillouin zone.
+	volume := 1.0 / float64(6*n*n*n)
+	for _, tet := range Tetrahedra(n, R) {
+		for b := 0; b < num_bands; b++ {
+			e := [4]float64{Eks[tet[0]][b], Eks[tet[1]][b], Eks[tet[2]][b], Eks[tet[3]][b]}
+			sort4(&e)
+			if E <= e[0] {
+				continue
+			}
+			add(tetraWeights(e, E, volume))
+		}
+	}
+}
+
+// Return the integration weights of the corners of a tetrahedron of the
+// given volume with corner energies e (in ascending order) for the states
+// below E. The weights sum to the occupied volume.
+func tetraWeights(e [4]float64, E, volume float64) [4]float64 {
+	e1, e2, e3, e4 := e[0], e[1], e[2], e[3]
+	V := 0.25 * volume
+	if E <= e1 {
+		return [4]float64{}
+	}
+	if E >= e4 {
+		return [4]float64{V, V, V, V}
+	}
+	if E < e2 {
+		d := E - e1
+		C := V * d * d * d / ((e2 - e1) * (e3 - e1) * (e4 - e1))
+		return [4]float64{
+			C * (4.0 - d*(1.0/(e2-e1)+1.0/(e3-e1)+1.0/(e4-e1))),
+			C * d / (e2 - e1),
+			C * d / (e3 - e1),
+			C * d / (e4 - e1),
+		}
+	}
+	if E < e3 {
+		C1 := V * (E - e1) * (E - e1) / ((e4 - e1) * (e3 - e1))
+		C2 := V * (E - e1) * (E - e2) * (e3 - E) / ((e4 - e1) * (e3 - e2) * (e3 - e1))
+		C3 := V * (E - e2) * (E - e2) * (e4 - E) / ((e4 - e2) * (e3 - e2) * (e4 - e1))
+		return [4]float64{
+			C1 + (C1+C2)*(e3-E)/(e3-e1) + (C1+C2+C3)*(e4-E)/(e4-e1),
+			C1 + C2 + C3 + (C2+C3)*(e3-E)/(e3-e2) + C3*(e4-E)/(e4-e2),
+			(C1+C2)*(E-e1)/(e3-e1) + (C2+C3)*(E-e2)/(e3-e2),
+			(C1+C2+C3)*(E-e1)/(e4-e1) + C3*(E-e2)/(e4-e2),
+		}
+	}
+	d := e4 - E
+	C := V * d * d * d / ((e4 - e1) * (e4 - e2) * (e4 - e3))
+	return [4]float64{
+		V - C*d/(e4-e1),
+		V - C*d/(e4-e2),
+		V - C*d/(e4-e3),
+		V - C*(4.0-d*(1.0/(e4-e1)+1.0/(e4-e2)+1.0/(e4-e3))),
+	}
+}
@@ -0,0 +1,90 @@
+package tetra
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+import (
+	"github.com/tflovorn/scExplorer/serialize"
+)
+
+// Band energies at k (as for EnergyFunc) and the weight of each band on each
+// basis state: weights[b][s] = |<s|b>|^2 for band b and basis state s.
+type ProjectedEnergyFunc func(k []float64) ([]float64, [][]float64)
+
+// Density of states DOS[i] at energy E[i], and its projection Projected[s][i]
+// onto each basis state s, labelled by Basis[s]. The projections sum to DOS.
+type ProjectedDos struct {
+	E         []float64
+	DOS       []float64
+	Basis     []string
+	Projected [][]float64
+}
+
+// Return the density of states and its projections onto the basis states
+// (labelled by basis) at num_dos evenly-spaced energies from the minimum to
+// the maximum band energy, using an n x n x n grid of k-points.
+// The weight of a band in a tetrahedron is taken to be the average of its
+// weights at the corners.
+func ProjectedDosValues(Pfn ProjectedEnergyFunc, n, num_dos int, R [3][3]float64, basis []string) *ProjectedDos {
+	weights := make([][][]float64, n*n*n)
+	Eks := EnergyGrid(func(k []float64) []float64 {
+		Es, ws := Pfn(k)
+		weights[gridIndexOf(n, k)] = ws
+		return Es
+	}, n)
+	Es := energyList(Eks, num_dos)
+
+	num_basis := len(basis)
+	dos := make([]float64, num_dos)
+	projected := make([][]float64, num_basis)
+	for s := 0; s < num_basis; s++ {
+		projected[s] = make([]float64, num_dos)
+	}
+	forEachTetraDos(Eks, n, R, Es, func(tet [4]int, b, i int, D float64) {
+		dos[i] += D
+		for s := 0; s < num_basis; s++ {
+			w := 0.0
+			for _, corner := range tet {
+				w += weights[corner][b][s]
+			}
+			projected[s][i] += 0.25 * w * D
+		}
+	})
+	return &ProjectedDos{Es, dos, basis, projected}
+}
+
+// Return the grid index of the point k = (i/n, j/n, l/n) given by
+// EnergyGrid.
+func gridIndexOf(n int, k []float64) int {
+	var idx [3]int
+	for x := 0; x < 3; x++ {
+		idx[x] = int(k[x]*float64(n) + 0.5)
+	}
+	return GridIndex(n, idx[0], idx[1], idx[2])
+}
+
+// Convert to string by marshalling to JSON.
+func (dos *ProjectedDos) Marshal() string {
+	marshalled, err := serialize.MakeJSON(dos)
+	if err != nil {
+		panic(err)
+	}
+	return marshalled
+}
+
+// Convert to tab-separated values with a header line. The first two columns
+// are as in Dos.TSV, followed by one column per basis state.
+func (dos *ProjectedDos) TSV() string {
+	var buf bytes.Buffer
+	buf.WriteString("E\tDOS\t" + strings.Join(dos.Basis, "\t") + "\n")
+	for i, E := range dos.E {
+		buf.WriteString(fmt.Sprintf("%.10f\t%.10f", E, dos.DOS[i]))
+		for s := range dos.Basis {
+			buf.WriteString(fmt.Sprintf("\t%.10f", dos.Projected[s][i]))
+		}
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
@@ -0,0 +1,242 @@
+// Linear tetrahedron method for the density of states of a band structure
+// sampled on a uniform grid of k-points (Blochl, Jepsen and Andersen,
+// Phys. Rev. B 49, 16223 (1994), without the curvature correction).
+//
+// Each cell of the k-point grid is divided into six tetrahedra sharing the
+// shortest of the cell's main diagonals, and the band energies are linearly
+// interpolated inside each tetrahedron.
+package tetra
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+)
+import (
+	"github.com/tflovorn/scExplorer/serialize"
+)
+
+// Band energies at k, where k is in the reciprocal lattice basis
+// (k = (k_1, k_2, k_3) with Cartesian representation
+// k_1 b_1 + k_2 b_2 + k_3 b_3). Must return the same number of energies at
+// each k, in ascending order.
+type EnergyFunc func(k []float64) []float64
+
+// Density of states DOS[i] at energy E[i]. The DOS is per unit cell and does
+// not include spin degeneracy, so that it integrates to the number of bands.
+type Dos struct {
+	E   []float64
+	DOS []float64
+}
+
+// Return the reciprocal lattice vectors (as rows) of the simple cubic lattice
+// with lattice constant a.
+func CubicR(a float64) [3][3]float64 {
+	var R [3][3]float64
+	for i := 0; i < 3; i++ {
+		R[i][i] = 2.0 * math.Pi / a
+	}
+	return R
+}
+
+// Return the density of states at num_dos evenly-spaced energies from the
+// minimum to the maximum band energy, using an n x n x n grid of k-points
+// over the Brillouin zone. R gives the reciprocal lattice vectors as rows.
+func DosValues(Efn EnergyFunc, n, num_dos int, R [3][3]float64) *Dos {
+	Eks := EnergyGrid(Efn, n)
+	Es := energyList(Eks, num_dos)
+	return &Dos{Es, DosAt(Eks, n, R, Es)}
+}
+
+// Return num_dos evenly-spaced energies from the minimum to the maximum of
+// the band energies Eks.
+func energyList(Eks [][]float64, num_dos int) []float64 {
+	Emin, Emax := EnergyRange(Eks)
+	Es := make([]float64, num_dos)
+	for i := 0; i < num_dos; i++ {
+		if num_dos == 1 {
+			Es[i] = Emin
+		} else {
+			Es[i] = Emin + float64(i)*(Emax-Emin)/float64(num_dos-1)
+		}
+	}
+	return Es
+}
+
+// Return the band energies at each point of the n x n x n grid of k-points
+// k = (i/n, j/n, l/n), indexed by GridIndex.
+func EnergyGrid(Efn EnergyFunc, n int) [][]float64 {
+	Eks := make([][]float64, n*n*n)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			for l := 0; l < n; l++ {
+				k := []float64{float64(i) / float64(n), float64(j) / float64(n), float64(l) / float64(n)}
+				Eks[GridIndex(n, i, j, l)] = Efn(k)
+			}
+		}
+	}
+	return Eks
+}
+
+// Return the index of grid point (i, j, l), with periodic boundaries.
+func GridIndex(n, i, j, l int) int {
+	i, j, l = (i%n+n)%n, (j%n+n)%n, (l%n+n)%n
+	return i + n*(j+n*l)
+}
+
+// Return the minimum and maximum of the band energies Eks.
+func EnergyRange(Eks [][]float64) (float64, float64) {
+	Emin, Emax := math.Inf(1), math.Inf(-1)
+	for _, Ek := range Eks {
+		for _, E := range Ek {
+			Emin = math.Min(Emin, E)
+			Emax = math.Max(Emax, E)
+		}
+	}
+	return Emin, Emax
+}
+
+// Return the density of states at each of the energies Es (in ascending
+// order), given the band energies Eks on the n x n x n grid.
+func DosAt(Eks [][]float64, n int, R [3][3]float64, Es []float64) []float64 {
+	dos := make([]float64, len(Es))
+	forEachTetraDos(Eks, n, R, Es, func(tet [4]int, b, i int, D float64) {
+		dos[i] += D
+	})
+	return dos
+}
+
+// Call add(tet, b, i, D) with the contribution D to the density of states at
+// Es[i] of band b in each tetrahedron tet (given by its corner grid indices),
+// for each nonzero contribution.
+func forEachTetraDos(Eks [][]float64, n int, R [3][3]float64, Es []float64, add func(tet [4]int, b, i int, D float64)) {
+	num_bands := len(Eks[0])
+	// Each tetrahedron has volume 1/(6 n^3) of the Brillouin zone.
+	weight := 1.0 / float64(6*n*n*n)
+	for _, tet := range Tetrahedra(n, R) {
+		for b := 0; b < num_bands; b++ {
+			e := [4]float64{Eks[tet[0]][b], Eks[tet[1]][b], Eks[tet[2]][b], Eks[tet[3]][b]}
+			sort4(&e)
+			for i := firstAtLeast(Es, e[0]); i < len(Es) && Es[i] < e[3]; i++ {
+				add(tet, b, i, weight*tetraDos(e, Es[i]))
+			}
+		}
+	}
+}
+
+// Return the grid indices of the corners of the tetrahedra filling the
+// n x n x n grid.
+func Tetrahedra(n int, R [3][3]float64) [][4]int {
+	corners := cellTetrahedra(n, R)
+	tets := make([][4]int, 0, 6*n*n*n)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			for l := 0; l < n; l++ {
+				for _, c := range corners {
+					var tet [4]int
+					for m, corner := range c {
+						tet[m] = GridIndex(n, i+(corner&1), j+((corner>>1)&1), l+((corner>>2)&1))
+					}
+					tets = append(tets, tet)
+				}
+			}
+		}
+	}
+	return tets
+}
+
+// Return the six tetrahedra dividing a grid cell, as lists of cell corners.
+// Corner c is at offset (c&1, (c>>1)&1, (c>>2)&1) from the cell origin.
+// The tetrahedra share the shortest main diagonal of the cell, running from
+// corner a to corner 7^a.
+func cellTetrahedra(n int, R [3][3]float64) [6][4]int {
+	start, min_len := 0, math.Inf(1)
+	for a := 0; a < 4; a++ {
+		// Diagonal from corner a to corner 7^a.
+		length := 0.0
+		for x := 0; x < 3; x++ {
+			d := 0.0
+			for i := 0; i < 3; i++ {
+				sign := 1.0
+				if (a>>uint(i))&1 == 1 {
+					sign = -1.0
+				}
+				d += sign * R[i][x] / float64(n)
+			}
+			length += d * d
+		}
+		if length < min_len-1e-12 {
+			start, min_len = a, length
+		}
+	}
+	// Paths from corner 0 to corner 7 stepping along one axis at a time,
+	// reflected to start at corner 'start'.
+	axes := [6][3]int{{1, 2, 4}, {1, 4, 2}, {2, 1, 4}, {2, 4, 1}, {4, 1, 2}, {4, 2, 1}}
+	var tets [6][4]int
+	for t, p := range axes {
+		tets[t] = [4]int{start, start ^ p[0], start ^ (p[0] | p[1]), start ^ 7}
+	}
+	return tets
+}
+
+// Return the contribution to the density of states at E of one band in a
+// tetrahedron with corner energies e (in ascending order), per unit volume of
+// the tetrahedron.
+func tetraDos(e [4]float64, E float64) float64 {
+	e1, e2, e3, e4 := e[0], e[1], e[2], e[3]
+	if E < e1 || E >= e4 {
+		return 0.0
+	}
+	if E < e2 {
+		return 3.0 * (E - e1) * (E - e1) / ((e2 - e1) * (e3 - e1) * (e4 - e1))
+	}
+	if E < e3 {
+		d := E - e2
+		return (3.0*(e2-e1) + 6.0*d - 3.0*(e3-e1+e4-e2)*d*d/((e3-e2)*(e4-e2))) / ((e3 - e1) * (e4 - e1))
+	}
+	return 3.0 * (e4 - E) * (e4 - E) / ((e4 - e1) * (e4 - e2) * (e4 - e3))
+}
+
+// Sort the four values in e in ascending order.
+func sort4(e *[4]float64) {
+	for i := 1; i < 4; i++ {
+		for j := i; j > 0 && e[j] < e[j-1]; j-- {
+			e[j], e[j-1] = e[j-1], e[j]
+		}
+	}
+}
+
+// Return the index of the first element of the ascending list Es which is at
+// least E (len(Es) if there is none).
+func firstAtLeast(Es []float64, E float64) int {
+	lo, hi := 0, len(Es)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if Es[mid] < E {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+// Convert to string by marshalling to JSON.
+func (dos *Dos) Marshal() string {
+	marshalled, err := serialize.MakeJSON(dos)
+	if err != nil {
+		panic(err)
+	}
+	return marshalled
+}
+
+// Convert to tab-separated values with a header line, in the format written
+// by tetra_dos/RunDosValues.
+func (dos *Dos) TSV() string {
+	var buf bytes.Buffer
+	buf.WriteString("E\tDOS\n")
+	for i, E := range dos.E {
+		buf.WriteString(fmt.Sprintf("%.10f\t%.10f\n", E, dos.DOS[i]))
+	}
+	return buf.String()
+}
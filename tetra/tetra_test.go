@@ -0,0 +1,178 @@
+package tetra
+
+import (
+	"math"
+	"testing"
+)
+
+// Nearest-neighbor tight-binding band on the simple cubic lattice.
+func cubicBand(k []float64) []float64 {
+	E := 0.0
+	for _, ki := range k {
+		E -= 2.0 * math.Cos(2.0*math.Pi*ki)
+	}
+	return []float64{E}
+}
+
+func TestCubicBand(t *testing.T) {
+	n, num_dos := 16, 401
+	dos := DosValues(cubicBand, n, num_dos, CubicR(1.0))
+	if math.Abs(dos.E[0]+6.0) > 1e-12 || math.Abs(dos.E[num_dos-1]-6.0) > 1e-12 {
+		t.Fatalf("Energy range [%v, %v]; expected [-6, 6]", dos.E[0], dos.E[num_dos-1])
+	}
+	// The DOS integrates to the number of bands and is symmetric about 0.
+	dE := dos.E[1] - dos.E[0]
+	total := 0.0
+	for i, D := range dos.DOS {
+		total += D * dE
+		if math.Abs(D-dos.DOS[num_dos-1-i]) > 1e-9 {
+			t.Fatalf("D(%v) = %v differs from D(%v) = %v", dos.E[i], D, -dos.E[i], dos.DOS[num_dos-1-i])
+		}
+	}
+	if math.Abs(total-1.0) > 1e-3 {
+		t.Fatalf("DOS integrates to %v; expected 1", total)
+	}
+}
+
+func TestProjectedDos(t *testing.T) {
+	// Two copies of the cubic band, shifted apart and mixed by a constant
+	// hopping, with projections onto the two unmixed bands.
+	Pfn := func(k []float64) ([]float64, [][]float64) {
+		e := cubicBand(k)[0]
+		a, b, v := e-1.0, e+1.0, 0.5
+		root := math.Sqrt(0.25*(a-b)*(a-b) + v*v)
+		Es := []float64{0.5*(a+b) - root, 0.5*(a+b) + root}
+		weights := make([][]float64, 2)
+		for n, E := range Es {
+			// Eigenvector (v, E - a), normalized.
+			norm := v*v + (E-a)*(E-a)
+			weights[n] = []float64{v * v / norm, (E - a) * (E - a) / norm}
+		}
+		return Es, weights
+	}
+	n, num_dos := 12, 301
+	pdos := ProjectedDosValues(Pfn, n, num_dos, CubicR(1.0), []string{"a", "b"})
+	dos := DosValues(func(k []float64) []float64 {
+		Es, _ := Pfn(k)
+		return Es
+	}, n, num_dos, CubicR(1.0))
+	dE := pdos.E[1] - pdos.E[0]
+	totals := []float64{0.0, 0.0}
+	for i, D := range pdos.DOS {
+		if math.Abs(D-dos.DOS[i]) > 1e-12 || math.Abs(pdos.Projected[0][i]+pdos.Projected[1][i]-D) > 1e-9 {
+			t.Fatalf("Projections at E = %v do not sum to the DOS", pdos.E[i])
+		}
+		totals[0] += pdos.Projected[0][i] * dE
+		totals[1] += pdos.Projected[1][i] * dE
+	}
+	// Each basis state holds one state per cell.
+	if math.Abs(totals[0]-1.0) > 1e-2 || math.Abs(totals[1]-1.0) > 1e-2 {
+		t.Fatalf("Projected DOS integrates to %v; expected [1, 1]", totals)
+	}
+}
+
+func TestFindBandEdges(t *testing.T) {
+	// Valence band maximum -0.4 at k = (0.13, 0.13, 0.13), off the grid;
+	// conduction band minimum 0.4 at k = (0.5, 0.5, 0.5).
+	Efn := func(k []float64) []float64 {
+		Ev, Ec := -1.0, 1.0
+		for _, ki := range k {
+			Ev += 0.2 * math.Cos(2.0*math.Pi*(ki-0.13))
+			Ec += 0.2 * math.Cos(2.0*math.Pi*ki)
+		}
+		return []float64{Ev, Ec}
+	}
+	edges := FindBandEdges(Efn, 8, 1e-9)
+	if !edges.IsInsulator || math.Abs(edges.Gap-0.8) > 1e-9 {
+		t.Fatalf("Found gap %v (insulator = %v); expected 0.8", edges.Gap, edges.IsInsulator)
+	}
+	for x := 0; x < 3; x++ {
+		if math.Abs(edges.ValenceMaxK[x]-0.13) > 1e-6 || math.Abs(edges.ConductionMinK[x]-0.5) > 1e-6 {
+			t.Fatalf("Band edges at %v, %v; expected 0.13 and 0.5", edges.ValenceMaxK, edges.ConductionMinK)
+		}
+	}
+	if edges.DirectGap < edges.Gap {
+		t.Fatalf("Direct gap %v smaller than indirect gap %v", edges.DirectGap, edges.Gap)
+	}
+
+	metal := FindBandEdges(cubicBand, 8, 1e-9)
+	if metal.IsInsulator || metal.Gap != 0.0 {
+		t.Fatalf("Half-filled cubic band found to be insulating")
+	}
+}
+
+func TestBandsAlongPath(t *testing.T) {
+	vertices, labels, err := ParsePath("G-X-A=0.5,0.5,0.25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if labels[1] != "X" || labels[2] != "A" || vertices[2][2] != 0.25 {
+		t.Fatalf("Parsed path %v with labels %v", vertices, labels)
+	}
+	if _, _, err = ParsePath("G-Y"); err == nil {
+		t.Fatalf("Accepted unknown point Y")
+	}
+	Pfn := func(k []float64) ([]float64, [][]float64) {
+		return cubicBand(k), [][]float64{[]float64{1.0}}
+	}
+	bands := BandsAlongPath(Pfn, vertices, labels, 5, CubicR(1.0), []string{"s"}, false)
+	// 5 points on the first panel and 4 more on the second.
+	if len(bands.K) != 9 || bands.Weights != nil {
+		t.Fatalf("Got %d k-points (weights %v); expected 9 without weights", len(bands.K), bands.Weights)
+	}
+	// G-X has length pi and X-A has length sqrt(pi^2 + (pi/2)^2).
+	expected := []float64{0.0, math.Pi, math.Pi + math.Sqrt(1.25)*math.Pi}
+	for i, d := range bands.TickDistances {
+		if math.Abs(d-expected[i]) > 1e-12 {
+			t.Fatalf("Tick distances %v; expected %v", bands.TickDistances, expected)
+		}
+	}
+	if math.Abs(bands.Energies[4][0]-cubicBand([]float64{0.0, 0.5, 0.0})[0]) > 1e-12 {
+		t.Fatalf("Energy at X is %v", bands.Energies[4][0])
+	}
+}
+
+func TestOccupation(t *testing.T) {
+	n := 12
+	R := CubicR(1.0)
+	Eks := EnergyGrid(cubicBand, n)
+	// The number of states below E has the derivative D(E), and counts the
+	// whole band above its maximum.
+	h := 1e-5
+	for _, E := range []float64{-4.5, -1.3, 0.3, 2.2} {
+		D := DosAt(Eks, n, R, []float64{E})[0]
+		dN := (NumStates(Eks, n, R, E+h) - NumStates(Eks, n, R, E-h)) / (2.0 * h)
+		if math.Abs(dN-D) > 1e-7 {
+			t.Fatalf("At E = %v got dN/dE = %v; expected %v", E, dN, D)
+		}
+	}
+	if N := NumStates(Eks, n, R, 6.0); math.Abs(N-1.0) > 1e-12 {
+		t.Fatalf("Filled band has N = %v; expected 1", N)
+	}
+	if N := NumStates(Eks, n, R, -6.0); N != 0.0 {
+		t.Fatalf("Empty band has N = %v; expected 0", N)
+	}
+	// Half filling of the symmetric band.
+	EF, err := FermiLevel(Eks, n, R, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(EF) > 1e-9 {
+		t.Fatalf("Half-filled cubic band has Fermi level %v; expected 0", EF)
+	}
+	// A filled band below a gap puts the Fermi level mid-gap.
+	gapped := EnergyGrid(func(k []float64) []float64 {
+		E := cubicBand(k)[0]
+		return []float64{E - 7.0, E + 9.0}
+	}, n)
+	EF, err = FermiLevel(gapped, n, R, 1.0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(EF-1.0) > 1e-9 {
+		t.Fatalf("Fermi level %v in the gap (-1, 3); expected 1", EF)
+	}
+	if _, err = FermiLevel(gapped, n, R, 2.5); err == nil {
+		t.Fatalf("Accepted 2.5 states in 2 bands")
+	}
+}
@@ -0,0 +1,176 @@
+package tightbinding
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+)
+
+// Largest order of a commensurate modulation wavevector accepted by Fold.
+const max_fold_order = 12
+
+// Tolerance for N Q to be a reciprocal lattice vector (see FoldOrder).
+const fold_tol = 1e-9
+
+// Lattice model before folding, with hoppings modulated by an order
+// parameter profile cos(2 pi Q.r) (see Fold).
+type UnfoldedModel struct {
+	// Label prefixes of the orbitals in one cell, and the sublattice (0 or
+	// 1) of each. Both sublattices must have the same number of orbitals.
+	// The state of orbital o at k + jQ is labelled Labels[o] + "k+jQ,s" with
+	// s its sublattice (see Fold).
+	Labels     []string
+	Sublattice []int
+	// Lattice vectors (as rows) in Cartesian coordinates.
+	Lattice [3][3]float64
+	// Terms (R, I, J, t), each contributing t c^dagger_{r,I} c_{r+R,J} for
+	// every cell r (I and J index Labels). Both a term and its Hermitian
+	// conjugate must be listed.
+	Terms []Term
+	// Terms modulated by the order parameter profile.
+	Modulated []ModulatedTerm
+}
+
+// Term contributing t cos(2 pi Q.(r + Shift)) c^dagger_{r,I} c_{r+R,J} for
+// every cell r.
+type ModulatedTerm struct {
+	Term
+	Shift [3]int
+}
+
+// Return the order N of the modulation wavevector Q (in the reciprocal
+// lattice basis): the smallest N such that N Q is a reciprocal lattice
+// vector. Return an error if there is none up to max_fold_order.
+func FoldOrder(Q [3]float64) (int, error) {
+	for N := 1; N <= max_fold_order; N++ {
+		integer := true
+		for d := 0; d < 3; d++ {
+			x := float64(N) * Q[d]
+			if math.Abs(x-math.Floor(x+0.5)) > fold_tol {
+				integer = false
+			}
+		}
+		if integer {
+			return N, nil
+		}
+	}
+	return 0, fmt.Errorf("Modulation wavevector %v is not commensurate with order up to %v", Q, max_fold_order)
+}
+
+// Return the label of the momentum k + jQ.
+func foldLabel(j int) string {
+	switch j {
+	case 0:
+		return "k"
+	case 1:
+		return "k+Q"
+	}
+	return fmt.Sprintf("k+%dQ", j)
+}
+
+// Return the model u folded with the modulation wavevector Q (in the
+// reciprocal lattice basis), which has order N (see FoldOrder).
+//
+// Each orbital gives N states, at k + jQ for j = 0, ..., N-1. The states
+// are ordered in 2N blocks, (k, 0), (k+Q, 0), ..., (k, 1), (k+Q, 1), ...,
+// where 0 and 1 are the sublattices; within each block the orbitals of that
+// sublattice are in their original order. For Q with components 0 or 1/2
+// (N = 2), these are the four blocks (k, 0), (k+Q, 0), (k, 1), (k+Q, 1).
+// Unmodulated terms act within the states at each k + jQ; modulated terms
+// couple k + jQ to k + (j+1)Q and k + (j-1)Q.
+func Fold(u *UnfoldedModel, Q [3]float64) (*Model, error) {
+	no := len(u.Labels)
+	if len(u.Sublattice) != no {
+		return nil, fmt.Errorf("Unfolded model gives sublattices for %v orbitals; expected %v", len(u.Sublattice), no)
+	}
+	N, err := FoldOrder(Q)
+	if err != nil {
+		return nil, err
+	}
+	per_sub := [2]int{}
+	within := make([]int, no)
+	for o, s := range u.Sublattice {
+		if s != 0 && s != 1 {
+			return nil, fmt.Errorf("Sublattice of orbital %v must be 0 or 1; got %v", o, s)
+		}
+		within[o] = per_sub[s]
+		per_sub[s]++
+	}
+	if per_sub[0] != per_sub[1] {
+		return nil, fmt.Errorf("Sublattices have %v and %v orbitals; expected equal numbers", per_sub[0], per_sub[1])
+	}
+	nb := per_sub[0]
+	// Index of the state of orbital o at k + jQ.
+	state := func(o, j int) int {
+		j = ((j % N) + N) % N
+		return (u.Sublattice[o]*N+j)*nb + within[o]
+	}
+	for _, t := range u.Terms {
+		if t.I < 0 || t.I >= no || t.J < 0 || t.J >= no {
+			return nil, fmt.Errorf("Term %v refers to orbitals outside [0, %v)", t, no)
+		}
+	}
+	for _, t := range u.Modulated {
+		if t.I < 0 || t.I >= no || t.J < 0 || t.J >= no {
+			return nil, fmt.Errorf("Modulated term %v refers to orbitals outside [0, %v)", t, no)
+		}
+	}
+
+	m := &Model{make([]Orbital, 2*N*nb), u.Lattice, nil}
+	for o := 0; o < no; o++ {
+		for j := 0; j < N; j++ {
+			var offset [3]float64
+			for d := 0; d < 3; d++ {
+				x := float64(j) * Q[d]
+				offset[d] = x - math.Floor(x)
+			}
+			label := fmt.Sprintf("%v%v,%d", u.Labels[o], foldLabel(j), u.Sublattice[o])
+			m.Orbitals[state(o, j)] = Orbital{label, offset}
+		}
+	}
+	for _, t := range u.Terms {
+		for j := 0; j < N; j++ {
+			m.Terms = append(m.Terms, Term{t.R, state(t.I, j), state(t.J, j), t.Re, t.Im, t.Factors})
+		}
+	}
+	// cos(2 pi Q.(r + Shift)) = (exp(i phi) exp(2 pi i Q.r) + c.c.) / 2 with
+	// phi = 2 pi Q.Shift; the component exp(2 pi i Q.r) c^dagger_{r,I}
+	// c_{r+R,J} couples c^dagger_{I,k+Q} to c_{J,k}.
+	for _, t := range u.Modulated {
+		phi := 0.0
+		for d := 0; d < 3; d++ {
+			phi += 2.0 * math.Pi * Q[d] * float64(t.Shift[d])
+		}
+		amp := complex(t.Re, t.Im)
+		plus := 0.5 * amp * cmplx.Exp(complex(0.0, phi))
+		minus := 0.5 * amp * cmplx.Exp(complex(0.0, -phi))
+		for j := 0; j < N; j++ {
+			m.Terms = append(m.Terms, Term{t.R, state(t.I, j+1), state(t.J, j), real(plus), imag(plus), t.Factors})
+			m.Terms = append(m.Terms, Term{t.R, state(t.I, j-1), state(t.J, j), real(minus), imag(minus), t.Factors})
+		}
+	}
+	err = m.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Add the term (R, I, J, re * product of factors) to u.
+func (u *UnfoldedModel) add(R [3]int, I, J int, re float64, factors ...string) {
+	u.Terms = append(u.Terms, Term{R, I, J, re, 0.0, factors})
+}
+
+// Add the hopping re * (S(r) - S(r+R)) * product of factors from orbital J
+// in cell r+R to orbital I in cell r, and its Hermitian conjugate, where
+// S(r) = cos(2 pi Q.r) is the order parameter profile. This is the hopping
+// which is odd in the order parameter: for Q with components 0 or 1/2, it
+// alternates in sign along R if Q.R is half-integer and vanishes otherwise.
+func (u *UnfoldedModel) addOddBond(R [3]int, I, J int, re float64, factors ...string) {
+	negR := [3]int{-R[0], -R[1], -R[2]}
+	u.Modulated = append(u.Modulated, ModulatedTerm{Term{R, I, J, re, 0.0, factors}, [3]int{}})
+	u.Modulated = append(u.Modulated, ModulatedTerm{Term{R, I, J, -re, 0.0, factors}, R})
+	// Conjugate: re * (S(r-R) - S(r)) c^dagger_{r,J} c_{r-R,I}.
+	u.Modulated = append(u.Modulated, ModulatedTerm{Term{negR, J, I, re, 0.0, factors}, negR})
+	u.Modulated = append(u.Modulated, ModulatedTerm{Term{negR, J, I, -re, 0.0, factors}, [3]int{}})
+}
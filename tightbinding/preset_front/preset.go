@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+import (
+	"github.com/tflovorn/vo2mft/tightbinding"
+)
+
+var q = flag.String("q", "", "Fold the preset with the modulation wavevector Q given as qa,qb,qc in the reciprocal lattice basis (default: the preset's own Q)")
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 2 {
+		fmt.Printf("Usage: preset_front [--q qa,qb,qc] name out_path\nname is one of %v\n", tightbinding.PresetNames())
+		os.Exit(2)
+	}
+	name := args[0]
+	out_path := args[1]
+
+	var m *tightbinding.Model
+	var err error
+	if *q == "" {
+		m, err = tightbinding.Preset(name)
+	} else {
+		var Q [3]float64
+		Q, err = parseQ(*q)
+		if err == nil {
+			m, err = tightbinding.PresetQ(name, Q)
+		}
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	err = ioutil.WriteFile(out_path, []byte(m.Marshal()), 0644) // u=rw;go=r
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// Parse a wavevector given as "qa,qb,qc".
+func parseQ(s string) ([3]float64, error) {
+	var Q [3]float64
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return Q, fmt.Errorf("Expected Q as qa,qb,qc; got %v", s)
+	}
+	for d, part := range parts {
+		x, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return Q, err
+		}
+		Q[d] = x
+	}
+	return Q, nil
+}
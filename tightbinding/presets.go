@@ -0,0 +1,290 @@
+package tightbinding
+
+import (
+	"fmt"
+)
+
+// Built-in models, by name.
+var presets = map[string]func() *Model{
+	"vo2solve":     VO2SolveModel,
+	"vo2solve_t2g": VO2SolveT2gModel,
+	"twodof":       TwoDofModel,
+}
+
+// Return the built-in model with the given name.
+func Preset(name string) (*Model, error) {
+	f, ok := presets[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown tight-binding preset %v; expected one of %v", name, PresetNames())
+	}
+	return f(), nil
+}
+
+// Return the built-in model named source if there is one, or else the model
+// loaded from the JSON file at the path source.
+func Load(source string) (*Model, error) {
+	if _, ok := presets[source]; ok {
+		return Preset(source)
+	}
+	return LoadModel(source)
+}
+
+// Return the names of the built-in models.
+func PresetNames() []string {
+	return []string{"vo2solve", "vo2solve_t2g", "twodof"}
+}
+
+// Cubic lattice vectors with lattice constant 1.
+var cubic_lattice = [3][3]float64{[3]float64{1.0, 0.0, 0.0}, [3]float64{0.0, 1.0, 0.0}, [3]float64{0.0, 0.0, 1.0}}
+
+// Nearest neighbours along the cubic axes.
+var pos_x, neg_x = [3]int{1, 0, 0}, [3]int{-1, 0, 0}
+var pos_y, neg_y = [3]int{0, 1, 0}, [3]int{0, -1, 0}
+var pos_z, neg_z = [3]int{0, 0, 1}, [3]int{0, 0, -1}
+
+// Add the term (R, I, J, re * product of factors) to m.
+func (m *Model) add(R [3]int, I, J int, re float64, factors ...string) {
+	m.Terms = append(m.Terms, Term{R, I, J, re, 0.0, factors})
+}
+
+// Names of the parameters of one orbital per V in the vo2solve geometry.
+type vo2Orbital struct {
+	// Prefix of the state labels; "" for a single orbital.
+	Label string
+	// On-site energies in the R and M phases.
+	EpsilonR, EpsilonM string
+	// Hoppings along the a axes, the c axis and the body diagonals.
+	Tae, Tce, Tbe string
+	// Hoppings modulated by M; "" for orbitals which do not dimerise.
+	Tao, Tco, Tbo string
+}
+
+// The four-band model of the vo2solve package (see vo2solve.ElHamiltonian),
+// in the basis (k, 0), (k+Q, 0), (k, 1), (k+Q, 1) with Q = (pi, pi, pi).
+// Sublattice 1 is displaced from sublattice 0 by (1/2, 1/2, 1/2); the
+// sublattice positions are absorbed into the terms, so that the body
+// diagonal bonds connect cells separated by R in {0, 1}^3.
+// Parameters: the hoppings Tae, Tce, Tbe, Tao, Tco and Tbo, the order
+// parameters M and W, the on-site energies EpsilonR and EpsilonM and Mu.
+func VO2SolveModel() *Model {
+	return vo2SolveBlocks([]vo2Orbital{vo2Orbital{"", "EpsilonR", "EpsilonM", "Tae", "Tce", "Tbe", "Tao", "Tco", "Tbo"}})
+}
+
+// The vo2solve model with the three t2g orbitals of each V: d_par, which
+// dimerises as in VO2SolveModel, and the two pi* orbitals pi1 and pi2,
+// which do not. The 12 states form four blocks (k, 0), (k+Q, 0), (k, 1),
+// (k+Q, 1), each with the orbitals in the order d_par, pi1, pi2.
+// Parameters: those of VO2SolveModel (for d_par), and for pi1 and pi2 the
+// hoppings Tae_pi, Tce_pi and Tbe_pi and the on-site energies EpsilonPiR
+// and EpsilonPiM, which give the crystal-field splitting from d_par in the
+// R (W = 0) and M (W = 1) phases.
+func VO2SolveT2gModel() *Model {
+	return vo2SolveBlocks([]vo2Orbital{vo2Orbital{"d_par,", "EpsilonR", "EpsilonM", "Tae", "Tce", "Tbe", "Tao", "Tco", "Tbo"},
+		vo2Orbital{"pi1,", "EpsilonPiR", "EpsilonPiM", "Tae_pi", "Tce_pi", "Tbe_pi", "", "", ""},
+		vo2Orbital{"pi2,", "EpsilonPiR", "EpsilonPiM", "Tae_pi", "Tce_pi", "Tbe_pi", "", "", ""}})
+}
+
+// Return the vo2solve model with the given orbitals on each V, without
+// hybridisation between orbitals.
+func vo2SolveBlocks(orbs []vo2Orbital) *Model {
+	nb := len(orbs)
+	half := [3]float64{0.5, 0.5, 0.5}
+	block_labels := []string{"k,0", "k+Q,0", "k,1", "k+Q,1"}
+	block_offsets := [][3]float64{[3]float64{}, half, [3]float64{}, half}
+	m := &Model{make([]Orbital, 4*nb), cubic_lattice, nil}
+	for b := 0; b < 4; b++ {
+		for a, orb := range orbs {
+			m.Orbitals[b*nb+a] = Orbital{orb.Label + block_labels[b], block_offsets[b]}
+		}
+	}
+	for a, orb := range orbs {
+		// Index of orbital a in block b.
+		s := func(b int) int {
+			return b*nb + a
+		}
+		for b := 0; b < 4; b++ {
+			p := s(b)
+			// On-site: (1 - W) EpsilonR + W EpsilonM - Mu.
+			zero := [3]int{}
+			m.add(zero, p, p, 1.0, orb.EpsilonR)
+			m.add(zero, p, p, -1.0, orb.EpsilonR, "W")
+			m.add(zero, p, p, 1.0, orb.EpsilonM, "W")
+			m.add(zero, p, p, -1.0, "Mu")
+			// Cubic axes, even symmetry.
+			for _, R := range [][3]int{pos_x, neg_x, pos_y, neg_y} {
+				m.add(R, p, p, -1.0, orb.Tae)
+			}
+			for _, R := range [][3]int{pos_z, neg_z} {
+				m.add(R, p, p, -1.0, orb.Tce)
+			}
+		}
+		// Cubic axes, odd symmetry: couples k and k+Q on the same
+		// sublattice.
+		if orb.Tao != "" {
+			for _, IJ := range [][2]int{{1, 0}, {0, 1}, {3, 2}, {2, 3}} {
+				I, J := s(IJ[0]), s(IJ[1])
+				m.add(pos_x, I, J, -2.0, orb.Tao, "M")
+				m.add(neg_x, I, J, 2.0, orb.Tao, "M")
+				m.add(pos_y, I, J, -2.0, orb.Tao, "M")
+				m.add(neg_y, I, J, 2.0, orb.Tao, "M")
+				m.add(pos_z, I, J, -2.0, orb.Tco, "M")
+				m.add(neg_z, I, J, 2.0, orb.Tco, "M")
+			}
+		}
+		// Body diagonals: sublattice 1 at cell R sits at
+		// R + (1/2, 1/2, 1/2).
+		for R0 := 0; R0 < 2; R0++ {
+			for R1 := 0; R1 < 2; R1++ {
+				for R2 := 0; R2 < 2; R2++ {
+					R := [3]int{R0, R1, R2}
+					negR := [3]int{-R0, -R1, -R2}
+					// Even symmetry.
+					m.add(R, s(0), s(2), -1.0, orb.Tbe)
+					m.add(negR, s(2), s(0), -1.0, orb.Tbe)
+					m.add(R, s(1), s(3), -1.0, orb.Tbe)
+					m.add(negR, s(3), s(1), -1.0, orb.Tbe)
+					// Odd symmetry: only the four diagonals with an odd
+					// number of unit steps contribute.
+					if orb.Tbo != "" && (R0+R1+R2)%2 == 1 {
+						m.add(R, s(0), s(3), -2.0, orb.Tbo, "M")
+						m.add(negR, s(3), s(0), 2.0, orb.Tbo, "M")
+						m.add(R, s(1), s(2), -2.0, orb.Tbo, "M")
+						m.add(negR, s(2), s(1), 2.0, orb.Tbo, "M")
+					}
+				}
+			}
+		}
+	}
+	return m
+}
+
+// The four-band model of the twodof package (see twodof.ElHamiltonian), in
+// the basis (k, 0), (k+Q, 0), (k, 1), (k+Q, 1) with Q = (0, pi, pi).
+// Parameters: the hoppings Tce, Tco and Tbe0, ..., Tbe3 (along the body
+// diagonals 0, x, y and z), the order parameters M01 and M12 and Mu.
+func TwoDofModel() *Model {
+	offset := [3]float64{0.0, 0.5, 0.5}
+	m := &Model{[]Orbital{Orbital{"k,0", [3]float64{}}, Orbital{"k+Q,0", offset}, Orbital{"k,1", [3]float64{}}, Orbital{"k+Q,1", offset}}, cubic_lattice, nil}
+	for p := 0; p < 4; p++ {
+		m.add([3]int{}, p, p, -0.5, "Mu")
+		m.add(pos_z, p, p, -0.5, "Tce")
+		m.add(neg_z, p, p, -0.5, "Tce")
+	}
+	// Cubic axes, odd symmetry, with the order parameter of each sublattice.
+	for _, IJM := range []struct {
+		I, J int
+		M    string
+	}{{1, 0, "M01"}, {0, 1, "M01"}, {3, 2, "M12"}, {2, 3, "M12"}} {
+		m.add(pos_z, IJM.I, IJM.J, 1.0, "Tco", IJM.M)
+		m.add(neg_z, IJM.I, IJM.J, -1.0, "Tco", IJM.M)
+	}
+	// Body diagonals, one hopping per diagonal.
+	diag_R := [][3]int{[3]int{}, pos_x, pos_y, pos_z}
+	for i, R := range diag_R {
+		negR := [3]int{-R[0], -R[1], -R[2]}
+		t := fmt.Sprintf("Tbe%d", i)
+		m.add(R, 2, 0, -1.0, t)
+		m.add(negR, 0, 2, -1.0, t)
+		m.add(R, 3, 1, -1.0, t)
+		m.add(negR, 1, 3, -1.0, t)
+	}
+	return m
+}
+
+// Built-in models with a modulation wavevector Q other than the default, by
+// name (see PresetQ).
+var unfolded_presets = map[string]func() *UnfoldedModel{
+	"vo2solve": func() *UnfoldedModel {
+		return vo2SolveUnfolded([]vo2Orbital{vo2Orbital{"", "EpsilonR", "EpsilonM", "Tae", "Tce", "Tbe", "Tao", "Tco", "Tbo"}})
+	},
+	"vo2solve_t2g": func() *UnfoldedModel {
+		return vo2SolveUnfolded([]vo2Orbital{vo2Orbital{"d_par,", "EpsilonR", "EpsilonM", "Tae", "Tce", "Tbe", "Tao", "Tco", "Tbo"},
+			vo2Orbital{"pi1,", "EpsilonPiR", "EpsilonPiM", "Tae_pi", "Tce_pi", "Tbe_pi", "", "", ""},
+			vo2Orbital{"pi2,", "EpsilonPiR", "EpsilonPiM", "Tae_pi", "Tce_pi", "Tbe_pi", "", "", ""}})
+	},
+	"twodof": twoDofUnfolded,
+}
+
+// Return the built-in model with the given name, with the order parameters
+// modulated by cos(2 pi Q.r) in place of the default Q (see Fold). Q is in
+// the reciprocal lattice basis: the default Q = (1/2, 1/2, 1/2) of vo2solve
+// and Q = (0, 1/2, 1/2) of twodof give the models returned by Preset.
+// The odd hoppings of each cell r are scaled by S(r) - S(r+R), where
+// S(r) = cos(2 pi Q.r) and R is the bond; sublattice 1 takes S from the cell
+// it belongs to.
+func PresetQ(name string, Q [3]float64) (*Model, error) {
+	f, ok := unfolded_presets[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown tight-binding preset %v; expected one of %v", name, PresetNames())
+	}
+	return Fold(f(), Q)
+}
+
+// Return the unfolded form of vo2SolveBlocks: one cell with the orbitals
+// orbs on each of the two V sublattices.
+func vo2SolveUnfolded(orbs []vo2Orbital) *UnfoldedModel {
+	nb := len(orbs)
+	u := &UnfoldedModel{make([]string, 2*nb), make([]int, 2*nb), cubic_lattice, nil, nil}
+	for s := 0; s < 2; s++ {
+		for a, orb := range orbs {
+			u.Labels[s*nb+a] = orb.Label
+			u.Sublattice[s*nb+a] = s
+		}
+	}
+	for a, orb := range orbs {
+		for s := 0; s < 2; s++ {
+			p := s*nb + a
+			zero := [3]int{}
+			u.add(zero, p, p, 1.0, orb.EpsilonR)
+			u.add(zero, p, p, -1.0, orb.EpsilonR, "W")
+			u.add(zero, p, p, 1.0, orb.EpsilonM, "W")
+			u.add(zero, p, p, -1.0, "Mu")
+			for _, R := range [][3]int{pos_x, neg_x, pos_y, neg_y} {
+				u.add(R, p, p, -1.0, orb.Tae)
+			}
+			for _, R := range [][3]int{pos_z, neg_z} {
+				u.add(R, p, p, -1.0, orb.Tce)
+			}
+			if orb.Tao != "" {
+				u.addOddBond(pos_x, p, p, -1.0, orb.Tao, "M")
+				u.addOddBond(pos_y, p, p, -1.0, orb.Tao, "M")
+				u.addOddBond(pos_z, p, p, -1.0, orb.Tco, "M")
+			}
+		}
+		p0, p1 := a, nb+a
+		for R0 := 0; R0 < 2; R0++ {
+			for R1 := 0; R1 < 2; R1++ {
+				for R2 := 0; R2 < 2; R2++ {
+					R := [3]int{R0, R1, R2}
+					negR := [3]int{-R0, -R1, -R2}
+					u.add(R, p0, p1, -1.0, orb.Tbe)
+					u.add(negR, p1, p0, -1.0, orb.Tbe)
+					if orb.Tbo != "" && R != [3]int{} {
+						u.addOddBond(R, p0, p1, -1.0, orb.Tbo, "M")
+					}
+				}
+			}
+		}
+	}
+	return u
+}
+
+// Return the unfolded form of TwoDofModel, with M01 on sublattice 0 and M12
+// on sublattice 1.
+func twoDofUnfolded() *UnfoldedModel {
+	u := &UnfoldedModel{[]string{"", ""}, []int{0, 1}, cubic_lattice, nil, nil}
+	for p, M := range []string{"M01", "M12"} {
+		u.add([3]int{}, p, p, -0.5, "Mu")
+		u.add(pos_z, p, p, -0.5, "Tce")
+		u.add(neg_z, p, p, -0.5, "Tce")
+		u.addOddBond(pos_z, p, p, 0.5, "Tco", M)
+	}
+	diag_R := [][3]int{[3]int{}, pos_x, pos_y, pos_z}
+	for i, R := range diag_R {
+		negR := [3]int{-R[0], -R[1], -R[2]}
+		t := fmt.Sprintf("Tbe%d", i)
+		u.add(R, 1, 0, -1.0, t)
+		u.add(negR, 0, 1, -1.0, t)
+	}
+	return u
+}
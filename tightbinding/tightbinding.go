@@ -0,0 +1,279 @@
+// Tight-binding Hamiltonians built from a table of hopping terms.
+//
+// A model is given by its orbitals, lattice vectors and a list of terms
+// (R, I, J, t), each contributing t exp(2 pi i (k + q_J).R) to H_IJ(k), where
+// k is in the reciprocal lattice basis, R is a lattice vector in the lattice
+// basis and q_J is the momentum offset of orbital J. The amplitude t is a
+// constant times a product of named parameters (hoppings, order parameters,
+// the chemical potential) supplied when H(k) is evaluated.
+//
+// Momentum offsets allow orbitals in a folded basis: an order parameter
+// modulated with wavevector Q couples the states at k and k+Q, which are
+// represented by two orbitals with offsets 0 and Q.
+package tightbinding
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/cmplx"
+	"sort"
+)
+import (
+	"github.com/tflovorn/scExplorer/serialize"
+)
+
+// Largest allowed deviation of H(k) from Hermiticity (see checkHermitian).
+const hermitian_tol = 1e-10
+
+type Orbital struct {
+	Label string
+	// Momentum offset q of the orbital in the reciprocal lattice basis: the
+	// orbital's basis state at k has crystal momentum k + q. Zero for
+	// ordinary orbitals.
+	Offset [3]float64
+}
+
+type Term struct {
+	// Lattice vector in the lattice basis.
+	R [3]int
+	// Row and column of the Hamiltonian matrix element H_IJ (indices into
+	// Model.Orbitals).
+	I, J int
+	// Amplitude of the term: (Re + i Im) times the product of the values of
+	// the parameters named in Factors (1 if Factors is empty).
+	Re, Im  float64
+	Factors []string
+}
+
+type Model struct {
+	Orbitals []Orbital
+	// Lattice vectors (as rows) in Cartesian coordinates.
+	Lattice [3][3]float64
+	// All terms contributing to H(k): both H_IJ and H_JI must be listed, so
+	// that H(k) is Hermitian.
+	Terms []Term
+}
+
+// Value of the parameter with the given name.
+type ParameterFunc func(name string) float64
+
+// Create a Model from the given serialized data and check that it is valid.
+func NewModel(jsonData string) (*Model, error) {
+	m := new(Model)
+	err := json.Unmarshal([]byte(jsonData), m)
+	if err != nil {
+		return nil, err
+	}
+	err = m.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Load a Model from the JSON file at modelFilePath.
+func LoadModel(modelFilePath string) (*Model, error) {
+	data, err := ioutil.ReadFile(modelFilePath)
+	if err != nil {
+		return nil, err
+	}
+	return NewModel(string(data))
+}
+
+// Return an error if the model has no orbitals, a term refers to an orbital
+// which does not exist, the lattice vectors are linearly dependent or H(k)
+// is not Hermitian.
+func (m *Model) Validate() error {
+	if len(m.Orbitals) == 0 {
+		return fmt.Errorf("Tight-binding model has no orbitals")
+	}
+	for i, term := range m.Terms {
+		if term.I < 0 || term.I >= len(m.Orbitals) || term.J < 0 || term.J >= len(m.Orbitals) {
+			return fmt.Errorf("Term %v refers to orbitals (%v, %v) but there are %v orbitals", i, term.I, term.J, len(m.Orbitals))
+		}
+	}
+	if det3(m.Lattice) == 0.0 {
+		return fmt.Errorf("Tight-binding model lattice vectors are linearly dependent")
+	}
+	return m.checkHermitian()
+}
+
+// Return the number of orbitals (the dimension of H(k)).
+func (m *Model) NumOrbitals() int {
+	return len(m.Orbitals)
+}
+
+// Return the orbital labels.
+func (m *Model) Labels() []string {
+	labels := make([]string, len(m.Orbitals))
+	for i, orb := range m.Orbitals {
+		labels[i] = orb.Label
+	}
+	return labels
+}
+
+// Return the names of the parameters appearing in the terms, in ascending
+// order.
+func (m *Model) Parameters() []string {
+	seen := make(map[string]bool)
+	names := []string{}
+	for _, term := range m.Terms {
+		for _, name := range term.Factors {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Return H(k), with k in the reciprocal lattice basis and parameter values
+// given by value.
+func (m *Model) Hamiltonian(k []float64, value ParameterFunc) [][]complex128 {
+	// Evaluate each parameter once.
+	values := make(map[string]float64)
+	dim := len(m.Orbitals)
+	H := make([][]complex128, dim)
+	for i := 0; i < dim; i++ {
+		H[i] = make([]complex128, dim)
+	}
+	for _, term := range m.Terms {
+		amp := complex(term.Re, term.Im)
+		for _, name := range term.Factors {
+			v, ok := values[name]
+			if !ok {
+				v = value(name)
+				values[name] = v
+			}
+			amp *= complex(v, 0.0)
+		}
+		if amp == 0.0 {
+			continue
+		}
+		q := m.Orbitals[term.J].Offset
+		phase := 0.0
+		for d := 0; d < 3; d++ {
+			phase += (k[d] + q[d]) * float64(term.R[d])
+		}
+		H[term.I][term.J] += amp * cmplx.Exp(complex(0.0, 2.0*math.Pi*phase))
+	}
+	return H
+}
+
+// Return the derivative of H(k) with respect to the parameter with the given
+// name, with k in the reciprocal lattice basis and parameter values given by
+// value.
+func (m *Model) Derivative(k []float64, value ParameterFunc, name string) [][]complex128 {
+	dim := len(m.Orbitals)
+	dH := make([][]complex128, dim)
+	for i := 0; i < dim; i++ {
+		dH[i] = make([]complex128, dim)
+	}
+	for _, term := range m.Terms {
+		// Product rule over the occurrences of name in the factors.
+		amp := complex(0.0, 0.0)
+		for i, f := range term.Factors {
+			if f != name {
+				continue
+			}
+			d := complex(term.Re, term.Im)
+			for j, g := range term.Factors {
+				if j != i {
+					d *= complex(value(g), 0.0)
+				}
+			}
+			amp += d
+		}
+		if amp == 0.0 {
+			continue
+		}
+		q := m.Orbitals[term.J].Offset
+		phase := 0.0
+		for d := 0; d < 3; d++ {
+			phase += (k[d] + q[d]) * float64(term.R[d])
+		}
+		dH[term.I][term.J] += amp * cmplx.Exp(complex(0.0, 2.0*math.Pi*phase))
+	}
+	return dH
+}
+
+// Return the indices of the orbitals which appear in a term with a factor
+// in names.
+func (m *Model) OrbitalsWith(names []string) []int {
+	has := make([]bool, len(m.Orbitals))
+	for _, term := range m.Terms {
+		for _, f := range term.Factors {
+			for _, name := range names {
+				if f == name {
+					has[term.I], has[term.J] = true, true
+				}
+			}
+		}
+	}
+	orbs := []int{}
+	for i, h := range has {
+		if h {
+			orbs = append(orbs, i)
+		}
+	}
+	return orbs
+}
+
+// Return the reciprocal lattice vectors (as rows) in Cartesian coordinates.
+func (m *Model) RecipLattice() [3][3]float64 {
+	A := m.Lattice
+	det := det3(A)
+	var B [3][3]float64
+	// b_i = 2 pi (a_j x a_k) / (a_1 . (a_2 x a_3)) for cyclic (i, j, k).
+	for i := 0; i < 3; i++ {
+		a, b := A[(i+1)%3], A[(i+2)%3]
+		c := [3]float64{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+		for d := 0; d < 3; d++ {
+			B[i][d] = 2.0 * math.Pi * c[d] / det
+		}
+	}
+	return B
+}
+
+// Return an error if H(k) is not Hermitian at a few k-points, with each
+// parameter set to a distinct nonzero value.
+func (m *Model) checkHermitian() error {
+	names := m.Parameters()
+	values := make(map[string]float64)
+	for i, name := range names {
+		values[name] = 1.0 + 0.1*float64(i+1)
+	}
+	value := func(name string) float64 {
+		return values[name]
+	}
+	ks := [][]float64{[]float64{0.0, 0.0, 0.0}, []float64{0.13, 0.29, 0.41}, []float64{-0.37, 0.21, 0.07}}
+	for _, k := range ks {
+		H := m.Hamiltonian(k, value)
+		for i := range H {
+			for j := range H {
+				if cmplx.Abs(H[i][j]-cmplx.Conj(H[j][i])) > hermitian_tol {
+					return fmt.Errorf("Tight-binding Hamiltonian is not Hermitian: H[%v][%v] = %v but H[%v][%v] = %v at k = %v", i, j, H[i][j], j, i, H[j][i], k)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Return the determinant of the 3x3 matrix A.
+func det3(A [3][3]float64) float64 {
+	return A[0][0]*(A[1][1]*A[2][2]-A[1][2]*A[2][1]) - A[0][1]*(A[1][0]*A[2][2]-A[1][2]*A[2][0]) + A[0][2]*(A[1][0]*A[2][1]-A[1][1]*A[2][0])
+}
+
+// Convert to string by marshalling to JSON.
+func (m *Model) Marshal() string {
+	marshalled, err := serialize.MakeJSON(m)
+	if err != nil {
+		panic(err)
+	}
+	return marshalled
+}
@@ -0,0 +1,257 @@
+package tightbinding
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"testing"
+)
+
+// Nearest-neighbour simple cubic band E(k) = -2 t sum_i cos(2 pi k_i) - mu.
+func TestCubicBand(t *testing.T) {
+	m := &Model{[]Orbital{Orbital{"s", [3]float64{}}}, cubic_lattice, nil}
+	for _, R := range [][3]int{pos_x, neg_x, pos_y, neg_y, pos_z, neg_z} {
+		m.add(R, 0, 0, -1.0, "t")
+	}
+	m.add([3]int{}, 0, 0, -1.0, "mu")
+	err := m.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	model, err := NewModel(m.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := func(name string) float64 {
+		return map[string]float64{"t": 0.5, "mu": 0.2}[name]
+	}
+	k := []float64{0.1, -0.3, 0.45}
+	expected := -0.2
+	for _, ki := range k {
+		expected -= math.Cos(2.0 * math.Pi * ki)
+	}
+	H := model.Hamiltonian(k, value)
+	if cmplx.Abs(H[0][0]-complex(expected, 0.0)) > 1e-12 {
+		t.Fatalf("H(k) = %v; expected %v", H[0][0], expected)
+	}
+	// Without its conjugate term, the hopping along +x is not Hermitian.
+	m.Terms = m.Terms[1:]
+	if m.Validate() == nil {
+		t.Fatalf("expected error for non-Hermitian model")
+	}
+}
+
+func TestPresets(t *testing.T) {
+	for _, name := range PresetNames() {
+		m, err := Preset(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m.Validate()
+		if err != nil {
+			t.Fatalf("preset %v: %v", name, err)
+		}
+	}
+	R := VO2SolveModel().RecipLattice()
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			expected := 0.0
+			if i == j {
+				expected = 2.0 * math.Pi
+			}
+			if math.Abs(R[i][j]-expected) > 1e-12 {
+				t.Fatalf("cubic reciprocal lattice R[%d][%d] = %v; expected %v", i, j, R[i][j], expected)
+			}
+		}
+	}
+}
+
+// Synthetic seedname_hr.dat for the unfolded vo2solve model with one Wannier
+// function per sublattice, with hoppings tae, tce and tbe and on-site energy
+// eps. The R = 0 matrix elements are given with degeneracy 2 (and so are
+// doubled).
+func vo2SolveHr(tae, tce, tbe, eps float64) string {
+	type elem struct {
+		m, n int
+		h    float64
+	}
+	Rs := [][3]int{}
+	elems := make(map[[3]int][]elem)
+	add := func(R [3]int, m, n int, h float64) {
+		if _, ok := elems[R]; !ok {
+			Rs = append(Rs, R)
+		}
+		elems[R] = append(elems[R], elem{m, n, h})
+	}
+	add([3]int{}, 1, 1, eps)
+	add([3]int{}, 2, 2, eps)
+	for _, R := range [][3]int{pos_x, neg_x, pos_y, neg_y} {
+		add(R, 1, 1, -tae)
+		add(R, 2, 2, -tae)
+	}
+	for _, R := range [][3]int{pos_z, neg_z} {
+		add(R, 1, 1, -tce)
+		add(R, 2, 2, -tce)
+	}
+	for R0 := 0; R0 < 2; R0++ {
+		for R1 := 0; R1 < 2; R1++ {
+			for R2 := 0; R2 < 2; R2++ {
+				add([3]int{R0, R1, R2}, 1, 2, -tbe)
+				add([3]int{-R0, -R1, -R2}, 2, 1, -tbe)
+			}
+		}
+	}
+	deg := func(R [3]int) int {
+		if R == [3]int{} {
+			return 2
+		}
+		return 1
+	}
+	data := fmt.Sprintf("synthetic vo2solve model\n2\n%d\n", len(Rs))
+	for i, R := range Rs {
+		data += fmt.Sprintf("%d ", deg(R))
+		if i%15 == 14 {
+			data += "\n"
+		}
+	}
+	data += "\n"
+	for _, R := range Rs {
+		for _, e := range elems[R] {
+			data += fmt.Sprintf("%d %d %d %d %d %.12f %.12f\n", R[0], R[1], R[2], e.m, e.n, float64(deg(R))*e.h, 0.0)
+		}
+	}
+	return data
+}
+
+func TestWannier90(t *testing.T) {
+	tae, tce, tbe, tao, tco, tbo, eps := 0.5, 0.3, 0.4, 0.2, 0.25, 0.1, 0.07
+	hr, err := ParseHr(vo2SolveHr(tae, tce, tbe, eps))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The odd hoppings modulate the a-axis, c-axis and odd body diagonal
+	// bonds.
+	mods := []ModulatedHopping{}
+	for m := 1; m <= 2; m++ {
+		mods = append(mods, ModulatedHopping{pos_x, m, m, "M", 2.0 * tao / tae})
+		mods = append(mods, ModulatedHopping{pos_y, m, m, "M", 2.0 * tao / tae})
+		mods = append(mods, ModulatedHopping{pos_z, m, m, "M", 2.0 * tco / tce})
+	}
+	for _, R := range [][3]int{[3]int{1, 0, 0}, [3]int{0, 1, 0}, [3]int{0, 0, 1}, [3]int{1, 1, 1}} {
+		mods = append(mods, ModulatedHopping{R, 1, 2, "M", 2.0 * tbo / tbe})
+	}
+	mapping := &Wannier90Mapping{cubic_lattice, []int{0, 1}, []string{"V0", "V1"}, [3]float64{0.5, 0.5, 0.5}, -1.0, mods}
+	model, err := Wannier90Model(hr, mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]float64{"Tae": tae, "Tce": tce, "Tbe": tbe, "Tao": tao, "Tco": tco, "Tbo": tbo,
+		"M": 0.6, "W": 0.0, "Mu": 0.15, "EpsilonR": eps, "EpsilonM": 0.0}
+	value := func(name string) float64 {
+		return values[name]
+	}
+	preset := VO2SolveModel()
+	for _, k := range [][]float64{[]float64{0.0, 0.0, 0.0}, []float64{0.12, -0.31, 0.27}, []float64{0.5, 0.2, -0.45}} {
+		H := model.Hamiltonian(k, value)
+		H_preset := preset.Hamiltonian(k, value)
+		for i := 0; i < 4; i++ {
+			for j := 0; j < 4; j++ {
+				if cmplx.Abs(H[i][j]-H_preset[i][j]) > 1e-9 {
+					t.Fatalf("Wannier90 H[%d][%d] = %v at k = %v; expected %v", i, j, H[i][j], k, H_preset[i][j])
+				}
+			}
+		}
+	}
+	// Listing a bond and its Hermitian conjugate is an error.
+	mapping.Modulated = append(mapping.Modulated, ModulatedHopping{neg_x, 1, 1, "M", 1.0})
+	_, err = Wannier90Model(hr, mapping)
+	if err == nil {
+		t.Fatalf("expected error for modulated hopping listed twice")
+	}
+}
+
+func TestFold(t *testing.T) {
+	values := map[string]float64{"Tae": 0.5, "Tce": 0.3, "Tbe": 0.4, "Tao": 0.2, "Tco": 0.25, "Tbo": 0.1,
+		"M": 0.6, "W": 0.3, "Mu": 0.15, "EpsilonR": 0.07, "EpsilonM": -0.05,
+		"Tae_pi": 0.35, "Tce_pi": 0.15, "Tbe_pi": 0.2, "EpsilonPiR": 0.4, "EpsilonPiM": 0.6,
+		"Tbe0": 0.4, "Tbe1": 0.3, "Tbe2": 0.2, "Tbe3": 0.1, "M01": 0.7, "M12": -0.4}
+	value := func(name string) float64 {
+		return values[name]
+	}
+	ks := [][]float64{[]float64{0.0, 0.0, 0.0}, []float64{0.12, -0.31, 0.27}, []float64{0.5, 0.2, -0.45}}
+	// At the default Q, folding reproduces the presets.
+	default_Q := map[string][3]float64{"vo2solve": [3]float64{0.5, 0.5, 0.5}, "vo2solve_t2g": [3]float64{0.5, 0.5, 0.5}, "twodof": [3]float64{0.0, 0.5, 0.5}}
+	for _, name := range PresetNames() {
+		preset, err := Preset(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		folded, err := PresetQ(name, default_Q[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+		dim := preset.NumOrbitals()
+		if folded.NumOrbitals() != dim {
+			t.Fatalf("folded %v has %v orbitals; expected %v", name, folded.NumOrbitals(), dim)
+		}
+		for i, label := range preset.Labels() {
+			if folded.Labels()[i] != label {
+				t.Fatalf("folded %v orbital %d has label %v; expected %v", name, i, folded.Labels()[i], label)
+			}
+		}
+		for _, k := range ks {
+			H := folded.Hamiltonian(k, value)
+			H_preset := preset.Hamiltonian(k, value)
+			for i := 0; i < dim; i++ {
+				for j := 0; j < dim; j++ {
+					if cmplx.Abs(H[i][j]-H_preset[i][j]) > 1e-12 {
+						t.Fatalf("folded %v H[%d][%d] = %v at k = %v; expected %v", name, i, j, H[i][j], k, H_preset[i][j])
+					}
+				}
+			}
+		}
+	}
+	// With Q of order 4 and no modulation, the folded Hamiltonian at k is
+	// the unfolded one at k, k+Q, k+2Q and k+3Q.
+	Q := [3]float64{0.25, 0.0, 0.5}
+	N, err := FoldOrder(Q)
+	if err != nil || N != 4 {
+		t.Fatalf("FoldOrder(%v) = %v, %v; expected 4", Q, N, err)
+	}
+	folded, err := PresetQ("vo2solve", Q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unfolded, err := PresetQ("vo2solve", [3]float64{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if folded.NumOrbitals() != 8 {
+		t.Fatalf("folded vo2solve with Q = %v has %v orbitals; expected 8", Q, folded.NumOrbitals())
+	}
+	values["M"] = 0.0
+	for _, k := range ks {
+		H := folded.Hamiltonian(k, value)
+		for j := 0; j < N; j++ {
+			kj := []float64{k[0] + float64(j)*Q[0], k[1] + float64(j)*Q[1], k[2] + float64(j)*Q[2]}
+			H_unfolded := unfolded.Hamiltonian(kj, value)
+			for s := 0; s < 2; s++ {
+				for r := 0; r < 2; r++ {
+					got, expected := H[s*N+j][r*N+j], H_unfolded[s][r]
+					if cmplx.Abs(got-expected) > 1e-12 {
+						t.Fatalf("folded H at k + %dQ, sublattices (%d, %d) = %v; expected %v", j, s, r, got, expected)
+					}
+				}
+			}
+			for i := 0; i < 2*N; i++ {
+				if i != j && i != N+j && cmplx.Abs(H[j][i]) > 1e-12 {
+					t.Fatalf("folded H[%d][%d] = %v without modulation; expected 0", j, i, H[j][i])
+				}
+			}
+		}
+	}
+	_, err = FoldOrder([3]float64{0.1234567, 0.0, 0.0})
+	if err == nil {
+		t.Fatalf("expected error for incommensurate Q")
+	}
+}
@@ -0,0 +1,290 @@
+package tightbinding
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/cmplx"
+	"strconv"
+	"strings"
+)
+
+// Real-space Hamiltonian read from a Wannier90 seedname_hr.dat file.
+type Hr struct {
+	// Number of Wannier functions.
+	NumWann int
+	// Matrix elements H_mn(R) = <m, 0|H|n, R>, already divided by the
+	// degeneracy of R.
+	Entries []HrEntry
+}
+
+type HrEntry struct {
+	R    [3]int
+	M, N int
+	H    complex128
+}
+
+// Lattice vector and Wannier function indices of a matrix element.
+type hrKey struct {
+	R    [3]int
+	M, N int
+}
+
+// Hoppings H_mn(R) modulated by an order parameter with wavevector Q: the
+// hopping between cells r and r + R becomes
+// H_mn(R) (1 + Scale * Order * exp(2 pi i Q.r)).
+type ModulatedHopping struct {
+	// Lattice vector and Wannier function indices (counting from 1, as in
+	// seedname_hr.dat). Each bond is listed once: the Hermitian conjugate
+	// (-R, N, M) is included automatically.
+	R    [3]int
+	M, N int
+	// Name of the order parameter and relative change in the hopping per
+	// unit order parameter.
+	Order string
+	Scale float64
+}
+
+// Interpretation of a Wannier90 model (see Wannier90Model).
+type Wannier90Mapping struct {
+	// Lattice vectors (as rows) in Cartesian coordinates.
+	Lattice [3][3]float64
+	// Sublattice (0 or 1) of each Wannier function. Both sublattices must
+	// have the same number of Wannier functions.
+	Sublattice []int
+	// Labels of the Wannier functions; "wf1", "wf2", ... if not given.
+	Labels []string
+	// Modulation wavevector of the order parameters in the reciprocal
+	// lattice basis; each component must be 0 or 1/2.
+	Q [3]float64
+	// Coefficient of the parameter Mu in the on-site energy of each state
+	// (-1 in the vo2solve model, -1/2 in the twodof model).
+	MuScale float64
+	// Hoppings modulated by the order parameters.
+	Modulated []ModulatedHopping
+}
+
+// Parse the contents of a Wannier90 seedname_hr.dat file.
+// The format is: a comment line, the number of Wannier functions, the number
+// of R-points nrpts, nrpts degeneracies (15 per line) and lines
+// "R1 R2 R3 m n Re(H_mn(R)) Im(H_mn(R))" grouped by R.
+func ParseHr(data string) (*Hr, error) {
+	lines := strings.Split(data, "\n")
+	if len(lines) < 3 {
+		return nil, fmt.Errorf("Wannier90 hr data too short")
+	}
+	num_wann, err := strconv.Atoi(strings.TrimSpace(lines[1]))
+	if err != nil {
+		return nil, fmt.Errorf("Bad number of Wannier functions in hr data: %v", err)
+	}
+	nrpts, err := strconv.Atoi(strings.TrimSpace(lines[2]))
+	if err != nil {
+		return nil, fmt.Errorf("Bad number of R-points in hr data: %v", err)
+	}
+	// Degeneracies.
+	deg := []int{}
+	line := 3
+	for ; len(deg) < nrpts && line < len(lines); line++ {
+		for _, field := range strings.Fields(lines[line]) {
+			d, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, fmt.Errorf("Bad degeneracy on line %v of hr data: %v", line+1, err)
+			}
+			deg = append(deg, d)
+		}
+	}
+	if len(deg) != nrpts {
+		return nil, fmt.Errorf("Expected %v degeneracies in hr data; got %v", nrpts, len(deg))
+	}
+	// Matrix elements; the R-points appear in the order of the
+	// degeneracies.
+	hr := &Hr{num_wann, []HrEntry{}}
+	Ridx := -1
+	var lastR [3]int
+	for ; line < len(lines); line++ {
+		fields := strings.Fields(lines[line])
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("Expected 7 fields on line %v of hr data; got %v", line+1, len(fields))
+		}
+		var e HrEntry
+		ints := make([]int, 5)
+		for i := 0; i < 5; i++ {
+			ints[i], err = strconv.Atoi(fields[i])
+			if err != nil {
+				return nil, fmt.Errorf("Bad integer on line %v of hr data: %v", line+1, err)
+			}
+		}
+		re, err := strconv.ParseFloat(fields[5], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Bad matrix element on line %v of hr data: %v", line+1, err)
+		}
+		im, err := strconv.ParseFloat(fields[6], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Bad matrix element on line %v of hr data: %v", line+1, err)
+		}
+		e.R = [3]int{ints[0], ints[1], ints[2]}
+		e.M, e.N = ints[3], ints[4]
+		if e.M < 1 || e.M > num_wann || e.N < 1 || e.N > num_wann {
+			return nil, fmt.Errorf("Wannier function index out of range on line %v of hr data", line+1)
+		}
+		if Ridx < 0 || e.R != lastR {
+			Ridx++
+			lastR = e.R
+			if Ridx >= nrpts {
+				return nil, fmt.Errorf("More than %v R-points in hr data", nrpts)
+			}
+		}
+		e.H = complex(re, im) / complex(float64(deg[Ridx]), 0.0)
+		hr.Entries = append(hr.Entries, e)
+	}
+	if Ridx+1 != nrpts {
+		return nil, fmt.Errorf("Expected %v R-points in hr data; got %v", nrpts, Ridx+1)
+	}
+	return hr, nil
+}
+
+// Return the folded tight-binding model given by the Wannier90 Hamiltonian
+// hr interpreted according to mapping.
+//
+// Each Wannier function gives two states, at k and k+Q. The states are
+// ordered in four blocks, (k, 0), (k+Q, 0), (k, 1), (k+Q, 1), where 0 and 1
+// are the sublattices; within each block the Wannier functions are in their
+// original order. The hoppings H_mn(R) act within the k and k+Q states and
+// the modulated hoppings couple them. The model parameters are Mu and the
+// order parameters named in mapping.Modulated.
+func Wannier90Model(hr *Hr, mapping *Wannier90Mapping) (*Model, error) {
+	nw := hr.NumWann
+	if len(mapping.Sublattice) != nw {
+		return nil, fmt.Errorf("Mapping gives sublattices for %v Wannier functions; expected %v", len(mapping.Sublattice), nw)
+	}
+	if mapping.Labels != nil && len(mapping.Labels) != nw {
+		return nil, fmt.Errorf("Mapping gives labels for %v Wannier functions; expected %v", len(mapping.Labels), nw)
+	}
+	for _, q := range mapping.Q {
+		if q != 0.0 && q != 0.5 {
+			return nil, fmt.Errorf("Modulation wavevector components must be 0 or 1/2; got %v", mapping.Q)
+		}
+	}
+	// Position of each Wannier function within its sublattice block.
+	per_sub := [2]int{}
+	within := make([]int, nw)
+	for m, s := range mapping.Sublattice {
+		if s != 0 && s != 1 {
+			return nil, fmt.Errorf("Sublattice of Wannier function %v must be 0 or 1; got %v", m+1, s)
+		}
+		within[m] = per_sub[s]
+		per_sub[s]++
+	}
+	if per_sub[0] != per_sub[1] {
+		return nil, fmt.Errorf("Sublattices have %v and %v Wannier functions; expected equal numbers", per_sub[0], per_sub[1])
+	}
+	nb := per_sub[0]
+	// Index of the state of Wannier function m (counting from 1) at k
+	// (shifted = false) or k+Q (shifted = true).
+	state := func(m int, shifted bool) int {
+		block := 2 * mapping.Sublattice[m-1]
+		if shifted {
+			block++
+		}
+		return block*nb + within[m-1]
+	}
+
+	model := &Model{make([]Orbital, 2*nw), mapping.Lattice, nil}
+	for m := 1; m <= nw; m++ {
+		label := fmt.Sprintf("wf%d", m)
+		if mapping.Labels != nil {
+			label = mapping.Labels[m-1]
+		}
+		model.Orbitals[state(m, false)] = Orbital{label + ",k", [3]float64{}}
+		model.Orbitals[state(m, true)] = Orbital{label + ",k+Q", mapping.Q}
+		if mapping.MuScale != 0.0 {
+			model.add([3]int{}, state(m, false), state(m, false), mapping.MuScale, "Mu")
+			model.add([3]int{}, state(m, true), state(m, true), mapping.MuScale, "Mu")
+		}
+	}
+	// Unmodulated hoppings.
+	hopping := make(map[hrKey]complex128)
+	for _, e := range hr.Entries {
+		key := hrKey{e.R, e.M, e.N}
+		hopping[key] += e.H
+		if e.H == 0.0 {
+			continue
+		}
+		for _, shifted := range []bool{false, true} {
+			model.Terms = append(model.Terms, Term{e.R, state(e.M, shifted), state(e.N, shifted), real(e.H), imag(e.H), nil})
+		}
+	}
+	// Modulated hoppings: a term delta exp(2 pi i Q.r) c^dagger_{m,r}
+	// c_{n,r+R} couples c^dagger_{m,k+Q} to c_{n,k} with amplitude
+	// delta exp(2 pi i k.R), and c^dagger_{m,k} to c_{n,k+Q} with
+	// delta exp(2 pi i (k+Q).R). Its Hermitian conjugate is the term for
+	// (-R, n, m) with amplitude conj(delta) exp(-2 pi i Q.R).
+	listed := make(map[hrKey]bool)
+	for i, mod := range mapping.Modulated {
+		if mod.M < 1 || mod.M > nw || mod.N < 1 || mod.N > nw {
+			return nil, fmt.Errorf("Modulated hopping %v refers to Wannier functions (%v, %v) but there are %v", i, mod.M, mod.N, nw)
+		}
+		key := hrKey{mod.R, mod.M, mod.N}
+		negR := [3]int{-mod.R[0], -mod.R[1], -mod.R[2]}
+		conj_key := hrKey{negR, mod.N, mod.M}
+		if listed[key] || listed[conj_key] {
+			return nil, fmt.Errorf("Modulated hopping %v (R = %v, m = %v, n = %v) is listed twice; list each bond once", i, mod.R, mod.M, mod.N)
+		}
+		listed[key] = true
+		t, ok := hopping[key]
+		if !ok {
+			return nil, fmt.Errorf("Modulated hopping %v (R = %v, m = %v, n = %v) is not in the hr data", i, mod.R, mod.M, mod.N)
+		}
+		delta := complex(mod.Scale, 0.0) * t
+		model.addModulated(mod.R, state(mod.M, true), state(mod.N, false), state(mod.M, false), state(mod.N, true), delta, mod.Order)
+		if key == conj_key {
+			continue
+		}
+		QR := 0.0
+		for d := 0; d < 3; d++ {
+			QR += mapping.Q[d] * float64(mod.R[d])
+		}
+		conj_delta := cmplx.Conj(delta) * cmplx.Exp(complex(0.0, -2.0*math.Pi*QR))
+		model.addModulated(negR, state(mod.N, true), state(mod.M, false), state(mod.N, false), state(mod.M, true), conj_delta, mod.Order)
+	}
+	err := model.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+// Add the terms coupling (I1, J1) and (I2, J2) with amplitude
+// delta * order at lattice vector R.
+func (m *Model) addModulated(R [3]int, I1, J1, I2, J2 int, delta complex128, order string) {
+	m.Terms = append(m.Terms, Term{R, I1, J1, real(delta), imag(delta), []string{order}})
+	m.Terms = append(m.Terms, Term{R, I2, J2, real(delta), imag(delta), []string{order}})
+}
+
+// Load the Wannier90 Hamiltonian from the seedname_hr.dat file at hrFilePath
+// and the mapping from the JSON file at mappingFilePath, and return the
+// corresponding model (see Wannier90Model).
+func LoadWannier90(hrFilePath, mappingFilePath string) (*Model, error) {
+	hr_data, err := ioutil.ReadFile(hrFilePath)
+	if err != nil {
+		return nil, err
+	}
+	hr, err := ParseHr(string(hr_data))
+	if err != nil {
+		return nil, err
+	}
+	mapping_data, err := ioutil.ReadFile(mappingFilePath)
+	if err != nil {
+		return nil, err
+	}
+	mapping := new(Wannier90Mapping)
+	err = json.Unmarshal(mapping_data, mapping)
+	if err != nil {
+		return nil, err
+	}
+	return Wannier90Model(hr, mapping)
+}
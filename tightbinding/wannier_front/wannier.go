@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+import (
+	"github.com/tflovorn/vo2mft/tightbinding"
+)
+
+func main() {
+	args := os.Args[1:]
+	if len(args) < 3 {
+		fmt.Println("Usage: wannier_front hr_path mapping_path out_path")
+		fmt.Println("hr_path is a Wannier90 seedname_hr.dat file; mapping_path is a JSON file with the fields of tightbinding.Wannier90Mapping")
+		os.Exit(2)
+	}
+	hr_path := args[0]
+	mapping_path := args[1]
+	out_path := args[2]
+
+	m, err := tightbinding.LoadWannier90(hr_path, mapping_path)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	err = ioutil.WriteFile(out_path, []byte(m.Marshal()), 0644) // u=rw;go=r
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,134 @@
+package twodof
+
+import (
+	"fmt"
+)
+import (
+	"github.com/tflovorn/vo2mft/meanfield"
+)
+
+// Clusters treated exactly in the ionic problem: "" for single sites, or
+// "dimer" for pairs of sites along c, coupled exactly by Jc and the Kc terms
+// (see H_Bond), with mean fields from the remaining neighbors.
+var clusters = []string{"", "dimer"}
+
+// Return an error if Cluster does not name a known cluster, or if the
+// cluster cannot be combined with the other parameters.
+func (env *Environment) checkCluster() error {
+	for _, c := range clusters {
+		if env.Cluster == c {
+			if c != "" && env.Gamma != 0.0 {
+				return fmt.Errorf("Cluster %v is not supported with nonzero Gamma", c)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown Cluster %v; expected one of %v", env.Cluster, clusters)
+}
+
+// Number of neighbors along c of each site which are treated in mean field.
+func (env *Environment) meanFieldCNeighbors() float64 {
+	if env.Cluster == "dimer" {
+		return 1.0
+	}
+	return 2.0
+}
+
+// Intersite term of the ionic model along c, with coefficient t: exchange
+// -t S_A S_B if exchange is set, and quartic t S_A^2 S_B^2 otherwise.
+type ionBond struct {
+	exchange bool
+	A, B     int
+	t        float64
+}
+
+// Return the intersite terms of the ionic model along c.
+func (env *Environment) cBonds() []ionBond {
+	bonds := []ionBond{}
+	for _, t := range env.ionModel().Couplings {
+		if t.intersite() && t.Axis == "c" {
+			bonds = append(bonds, ionBond{t.Kind == "exchange", t.A, t.B, env.ionCoefficient(t)})
+		}
+	}
+	return bonds
+}
+
+// Ionic interaction along c between neighboring sites with displacements
+// Sa and Sb: the intersite terms of the ionic model along c (the Jc and Kc
+// terms for the twodof model), symmetrized between the two sites. The
+// mean-field decoupling of this term gives the corresponding parts of H_Ion.
+func (env *Environment) H_Bond(Sa, Sb []int) float64 {
+	return bondEnergy(env.cBonds(), Sa, Sb)
+}
+
+// Return the energy of the bond terms bonds between sites with displacements
+// Sa and Sb (see H_Bond).
+func bondEnergy(bonds []ionBond, Sa, Sb []int) float64 {
+	sq := func(S []int, c int) float64 {
+		return float64(S[c] * S[c])
+	}
+	E := 0.0
+	for _, b := range bonds {
+		if b.exchange {
+			E -= 0.5 * b.t * float64(Sa[b.A]*Sb[b.B]+Sa[b.B]*Sb[b.A])
+		} else {
+			E += 0.5 * b.t * (sq(Sa, b.A)*sq(Sb, b.B) + sq(Sa, b.B)*sq(Sb, b.A))
+		}
+	}
+	return E
+}
+
+// Return the ionic averages of the dimer with single-site Hamiltonian site
+// and bond terms bonds (see cBonds), per site: the logarithm of Z_dimer^(1/2)
+// and the corresponding free energy, the expectation values of
+// O = (S_c, S_c^2) in the order of IonVars and their susceptibility per site
+// with respect to uniform fields coupling to O (see IonSusceptibility).
+func dimerIon(site *ionSite, bonds []ionBond, beta float64) *IonAverages {
+	nc := len(site.lin)
+	all_S := all_S_configs(nc)
+	n := len(all_S)
+	H_site := make([]float64, n)
+	for i, S := range all_S {
+		H_site[i] = site.energy(S)
+	}
+	energies := make([]float64, n*n)
+	for i, Sa := range all_S {
+		for j, Sb := range all_S {
+			energies[i*n+j] = H_site[i] + H_site[j] + bondEnergy(bonds, Sa, Sb)
+		}
+	}
+	dist := meanfield.NewBoltzmann(energies, beta)
+	probs := dist.Probs
+
+	// Observables summed over both sites of the dimer.
+	nobs := 2 * nc
+	obs := func(k, a int) float64 {
+		return ionObservable(all_S[k/n], a) + ionObservable(all_S[k%n], a)
+	}
+	totals := make([]float64, nobs)
+	for k := range probs {
+		for a := 0; a < nobs; a++ {
+			totals[a] += probs[k] * obs(k, a)
+		}
+	}
+	chi := make([][]float64, nobs)
+	for a := 0; a < nobs; a++ {
+		chi[a] = make([]float64, nobs)
+	}
+	for k := range probs {
+		for a := 0; a < nobs; a++ {
+			da := obs(k, a) - totals[a]
+			for b := 0; b < nobs; b++ {
+				chi[a][b] += 0.5 * probs[k] * da * (obs(k, b) - totals[b])
+			}
+		}
+	}
+	avgs := make([]float64, nobs)
+	for a := 0; a < nobs; a++ {
+		avgs[a] = 0.5 * totals[a]
+		for b := 0; b < nobs; b++ {
+			chi[a][b] = meanfield.ScaleBeta(beta, chi[a][b])
+		}
+	}
+	return &IonAverages{0.5 * dist.LogZ(), 0.5 * dist.FreeEnergy(), avgs, chi}
+}
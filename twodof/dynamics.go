@@ -0,0 +1,118 @@
+package twodof
+
+import (
+	"github.com/tflovorn/scExplorer/solve"
+	"github.com/tflovorn/vo2mft/meanfield"
+)
+
+// Time-dependent value of an Environment variable (e.g. "Beta", "Filling" or
+// "Fac_xy"; see meanfield.Schedule).
+type Schedule = meanfield.Schedule
+
+// Trajectory of the order parameters IonVars under relaxational dynamics
+// (see meanfield.Trajectory).
+type Trajectory = meanfield.Trajectory
+
+// Load a list of schedules from the JSON file at path.
+func LoadSchedules(path string) ([]Schedule, error) {
+	return meanfield.LoadSchedules(path)
+}
+
+// Integrate the relaxational dynamics dx/dt = -rate dG/dx, where x are the
+// order parameters IonVars and G is the Landau free energy (see
+// LandauGradient), for the given number of steps of length dt (see
+// meanfield.Relax). The variables in schedules are set to their scheduled
+// values at each time. x starts from its values in env, and the trajectory is
+// recorded at t = 0 and every 'every' steps. env is not modified.
+// Return an error if a schedule is for a variable which is not in env.
+func Relax(env *Environment, schedules []Schedule, rate, dt float64, steps, every int, epsAbs, epsRel float64) (*Trajectory, error) {
+	base := *env
+	vars := env.IonVars()
+	inputs := make([]string, len(schedules))
+	for i, s := range schedules {
+		inputs[i] = s.Var
+	}
+	err := env.CheckVariables(inputs)
+	if err != nil {
+		return nil, err
+	}
+	landau := func(input_vals, x []float64) ([]float64, float64, float64, error) {
+		e := base
+		e.Set(input_vals, inputs)
+		e.Set(x, vars)
+		// Ds caches depend on the scheduled variables, so use a new one.
+		grad, G, Mu, err := LandauGradient(&e, NewHoppingEV(), epsAbs, epsRel)
+		if err != nil {
+			return nil, 0.0, 0.0, err
+		}
+		// Start the next Mu solution from this one.
+		base.Mu = Mu
+		return grad, G, Mu, nil
+	}
+	x := make([]float64, len(vars))
+	for i, name := range vars {
+		x[i] = env.GetFloat(name)
+	}
+	return meanfield.Relax(vars, x, schedules, landau, rate, dt, steps, every)
+}
+
+// Return the gradient of the Landau free energy per site G with respect to
+// IonVars, G itself and the chemical potential, at the order parameters
+// given in env. Unless env.IonsOnly is set, Mu is solved for first.
+// env is not modified.
+//
+// G(x) is the mean-field free energy with the ionic fields chosen to produce
+// x (see Stability). If x is not self-consistent, this requires an additional
+// single-site field f; then dG/dx = f and G = FreeEnergy + f x, with
+// FreeEnergy evaluated including f. At a self-consistent solution
+// f = 0, so G = FreeEnergy.
+func LandauGradient(env *Environment, Ds *HoppingEV, epsAbs, epsRel float64) ([]float64, float64, float64, error) {
+	e := *env
+	e.ion_field = nil
+	if !e.IonsOnly {
+		system, start := MuSystem(&e)
+		_, err := solve.MultiDim(system, start, epsAbs, epsRel)
+		if err != nil {
+			return nil, 0.0, 0.0, err
+		}
+	}
+	err := e.solveIonField(Ds, epsAbs)
+	if err != nil {
+		return nil, 0.0, 0.0, err
+	}
+	vars := e.IonVars()
+	grad := make([]float64, len(vars))
+	G := e.FreeEnergy(Ds)
+	for i, name := range vars {
+		grad[i] = e.ion_field[i]
+		G += e.ion_field[i] * e.GetFloat(name)
+	}
+	return grad, G, e.Mu, nil
+}
+
+// Set ion_field so that the single-site expectation values of O are equal to
+// the values of IonVars (see meanfield.SolveField).
+func (env *Environment) solveIonField(Ds *HoppingEV, eps float64) error {
+	vars := env.IonVars()
+	x := make([]float64, len(vars))
+	for i, name := range vars {
+		x[i] = env.GetFloat(name)
+	}
+	f0 := env.ion_field
+	if len(f0) != len(vars) {
+		f0 = make([]float64, len(vars))
+	}
+	averages := func(f []float64) ([]float64, [][]float64) {
+		// ion_field is replaced rather than modified, since it may be
+		// shared with copies of env.
+		env.ion_field = f
+		avgs := env.IonAverages(Ds)
+		return avgs.Avgs, avgs.Chi
+	}
+	f, err := meanfield.SolveField(vars, x, f0, averages, eps)
+	if err != nil {
+		return err
+	}
+	env.ion_field = f
+	return nil
+}
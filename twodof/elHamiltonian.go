@@ -1,19 +1,62 @@
 package twodof
 
 import (
+	"fmt"
 	"math"
 	"math/cmplx"
+	"reflect"
 )
 import (
 	"github.com/tflovorn/cmatrix"
 	vec "github.com/tflovorn/scExplorer/vector"
+	"github.com/tflovorn/vo2mft/tightbinding"
 )
 
 // Calculate 4x4 electronic Hamiltonian.
 // k is in the Cartesian basis, with each component scaled by the corresponding
 // lattice constant; i.e. k = (a kx, a ky, c kz) and a kx, a ky, c kz range
 // over [-pi, pi) and periodic copies of this interval.
+// If env.TightBinding is set, H(k) is given by the tight-binding model.
+// With a Zeeman field, H(k) has a block for each spin (up, then down), with
+// the energies shifted by -Zeeman and +Zeeman.
 func ElHamiltonian(env *Environment, k vec.Vector, H *cmatrix.CMatrixGSL) {
+	n := env.numSpinlessStates()
+	for sigma := 0; sigma < env.spinBlocks(); sigma++ {
+		spinlessHamiltonian(env, k, sigma*n, H)
+		if sigma == 1 {
+			// No spin flips: the off-diagonal spin blocks vanish.
+			for i := 0; i < n; i++ {
+				for j := 0; j < n; j++ {
+					H.Set(i, n+j, 0.0)
+					H.Set(n+i, j, 0.0)
+				}
+			}
+		}
+		if env.spinful() {
+			zeeman := complex(env.Zeeman*spinSign(sigma), 0.0)
+			for i := sigma * n; i < (sigma+1)*n; i++ {
+				H.Set(i, i, H.At(i, i)-zeeman)
+			}
+		}
+	}
+}
+
+// Set the block of H starting at (offset, offset) to the electronic
+// Hamiltonian for one spin.
+func spinlessHamiltonian(env *Environment, k vec.Vector, offset int, H *cmatrix.CMatrixGSL) {
+	set := func(i, j int, v complex128) {
+		H.Set(offset+i, offset+j, v)
+	}
+	if env.tb != nil {
+		kr := []float64{k[0] / (2.0 * math.Pi), k[1] / (2.0 * math.Pi), k[2] / (2.0 * math.Pi)}
+		Hk := env.tb.Hamiltonian(kr, env.tbParameter)
+		for i := range Hk {
+			for j := range Hk[i] {
+				set(i, j, Hk[i][j])
+			}
+		}
+		return
+	}
 	KQ := vec.Vector{0.0, math.Pi, math.Pi}
 	k.Add(&KQ) // now KQ = k + Q
 
@@ -26,30 +69,30 @@ func ElHamiltonian(env *Environment, k vec.Vector, H *cmatrix.CMatrixGSL) {
 	m01 := complex(env.M01, 0.0)
 	m12 := complex(env.M12, 0.0)
 
-	H.Set(0, 0, EpsAE+ident_part)
-	H.Set(1, 0, -m01*EpsAO)
-	H.Set(2, 0, cmplx.Conj(EpsBE))
-	H.Set(3, 0, 0.0)
-
-	H.Set(0, 1, m01*EpsAO)
-	H.Set(1, 1, -EpsAE+ident_part)
-	H.Set(2, 1, 0.0)
-	H.Set(3, 1, cmplx.Conj(EpsBE_KQ))
-
-	H.Set(0, 2, EpsBE)
-	H.Set(1, 2, 0.0)
-	H.Set(2, 2, EpsAE+ident_part)
-	H.Set(3, 2, -m12*EpsAO)
-
-	H.Set(0, 3, 0.0)
-	H.Set(1, 3, EpsBE_KQ)
-	H.Set(2, 3, m12*EpsAO)
-	H.Set(3, 3, -EpsAE+ident_part)
+	set(0, 0, EpsAE+ident_part)
+	set(1, 0, -m01*EpsAO)
+	set(2, 0, cmplx.Conj(EpsBE))
+	set(3, 0, 0.0)
+
+	set(0, 1, m01*EpsAO)
+	set(1, 1, -EpsAE+ident_part)
+	set(2, 1, 0.0)
+	set(3, 1, cmplx.Conj(EpsBE_KQ))
+
+	set(0, 2, EpsBE)
+	set(1, 2, 0.0)
+	set(2, 2, EpsAE+ident_part)
+	set(3, 2, -m12*EpsAO)
+
+	set(0, 3, 0.0)
+	set(1, 3, EpsBE_KQ)
+	set(2, 3, m12*EpsAO)
+	set(3, 3, -EpsAE+ident_part)
 }
 
 // Cubic axes, even symmetry (k, p; k, p)
 func EpsilonAE(env *Environment, k vec.Vector) complex128 {
-	rp := -env.Tce * math.Cos(k[2])
+	rp := -env.Tce_eff() * math.Cos(k[2])
 	return complex(rp, 0.0)
 }
 
@@ -59,11 +102,102 @@ func EpsilonBE(env *Environment, k vec.Vector) complex128 {
 	e2 := cmplx.Exp(complex(0.0, -k[0]))
 	e3 := cmplx.Exp(complex(0.0, -k[1]))
 	e4 := cmplx.Exp(complex(0.0, -k[2]))
-	return -complex(env.Tbe, 0.0) * (e1 + e2 + e3 + e4)
+	t1, t2 := complex(env.Tbe_eff(0), 0.0), complex(env.Tbe_eff(1), 0.0)
+	t3, t4 := complex(env.Tbe_eff(2), 0.0), complex(env.Tbe_eff(3), 0.0)
+	return -(t1*e1 + t2*e2 + t3*e3 + t4*e4)
 }
 
 // Cubic axes, odd symmetry (k, p; k+Q, p)
 func EpsilonAO(env *Environment, k vec.Vector) complex128 {
-	ip := -2.0 * env.Tco * math.Sin(k[2])
+	ip := -2.0 * env.Tco_eff() * math.Sin(k[2])
 	return complex(0.0, ip)
 }
+
+// Return the number of states in the electronic Hamiltonian.
+func (env *Environment) numStates() int {
+	return env.numSpinlessStates() * env.spinBlocks()
+}
+
+// Return the number of states in the electronic Hamiltonian for one spin.
+func (env *Environment) numSpinlessStates() int {
+	if env.tb != nil {
+		return env.tb.NumOrbitals()
+	}
+	return len(el_basis)
+}
+
+// Return true if name is one of the tight-binding presets.
+func isPreset(name string) bool {
+	for _, preset := range tightbinding.PresetNames() {
+		if name == preset {
+			return true
+		}
+	}
+	return false
+}
+
+// Hoppings which enter tight-binding models with their strained values.
+// Tbe0, ..., Tbe3 are the hoppings along the body diagonals (see Tbe_eff).
+var tb_strained = map[string]func(env *Environment) float64{
+	"Tce":  (*Environment).Tce_eff,
+	"Tco":  (*Environment).Tco_eff,
+	"Tbe0": func(env *Environment) float64 { return env.Tbe_eff(0) },
+	"Tbe1": func(env *Environment) float64 { return env.Tbe_eff(1) },
+	"Tbe2": func(env *Environment) float64 { return env.Tbe_eff(2) },
+	"Tbe3": func(env *Environment) float64 { return env.Tbe_eff(3) },
+}
+
+// Return the value of the tight-binding model parameter with the given name:
+// the strained hopping for the hopping parameters, and otherwise the
+// Environment field with that name.
+func (env *Environment) tbParameter(name string) float64 {
+	if f, ok := tb_strained[name]; ok {
+		return f(env)
+	}
+	return env.GetFloat(name)
+}
+
+// Load the tight-binding model given by env.TightBinding, if any, folded
+// with env.Q if it is set (see wavevector.go). Return an error if its states
+// do not form blocks in the basis of ElHamiltonian (see evalEV) or it has a
+// parameter which is neither a hopping nor a float64 Environment field.
+func (env *Environment) loadTightBinding() error {
+	env.tb = nil
+	if env.TightBinding == "" && !env.folded() {
+		return nil
+	}
+	var m *tightbinding.Model
+	var err error
+	if env.folded() {
+		name := env.TightBinding
+		if name == "" {
+			name = "twodof"
+		}
+		if !isPreset(name) {
+			// A model loaded from a file has its own modulation
+			// wavevector, which Q cannot change.
+			return fmt.Errorf("Q requires TightBinding to be one of the presets %v; got %v (leave Q unset for a model loaded from a file)", tightbinding.PresetNames(), name)
+		}
+		m, err = tightbinding.PresetQ(name, *env.Q)
+	} else {
+		m, err = tightbinding.Load(env.TightBinding)
+	}
+	if err != nil {
+		return err
+	}
+	if !env.folded() && m.NumOrbitals()%len(el_basis) != 0 {
+		return fmt.Errorf("Tight-binding model has %v orbitals; expected a multiple of %v (blocks %v)", m.NumOrbitals(), len(el_basis), el_basis)
+	}
+	ev := reflect.ValueOf(env).Elem()
+	for _, name := range m.Parameters() {
+		if _, ok := tb_strained[name]; ok {
+			continue
+		}
+		field := ev.FieldByName(name)
+		if !field.IsValid() || field.Kind() != reflect.Float64 {
+			return fmt.Errorf("Unknown tight-binding model parameter %v", name)
+		}
+	}
+	env.tb = m
+	return nil
+}
@@ -11,6 +11,9 @@ import (
 	"github.com/tflovorn/scExplorer/bzone"
 	"github.com/tflovorn/scExplorer/serialize"
 	vec "github.com/tflovorn/scExplorer/vector"
+	"github.com/tflovorn/vo2mft/meanfield"
+	"github.com/tflovorn/vo2mft/tetra"
+	"github.com/tflovorn/vo2mft/tightbinding"
 )
 
 // Contains parameters necessary to characterize electronic and ionic systems.
@@ -20,12 +23,26 @@ type Environment struct {
 	// Size of k mesh.
 	BZPointsPerDim int
 
-	// Order parameter <S_{p,alpha}>.
+	// Order parameter <S_{p,alpha}> of the twodof ionic model.
 	M01, M11, M02, M12 float64
-	// Order parameter <S^2_{p,alpha}>.
+	// Order parameter <S^2_{p,alpha}> of the twodof ionic model.
 	W01, W11, W02, W12 float64
-	// Inverse temperature, 1 / (k_B * T).
+	// Ionic model: the name of a preset (see IonPreset) or the path to an
+	// ionic model JSON file (see IonModel); "twodof" if not set.
+	Ions string
+	// Order parameters of the ionic model which are not fields above, by
+	// name (see IonModel.Vars). The map is replaced rather than modified by
+	// Set, so that copies of an Environment do not share its values.
+	OrderParameters map[string]float64
+	// Inverse temperature, 1 / (k_B * T). Inf (written as the largest float
+	// in JSON) gives T = 0, for which the solvers find the ground state (see
+	// ZeroTemperature).
 	Beta float64
+	// Separate inverse temperatures of the electrons (Fermi function and
+	// electronic free energy) and the ions (Boltzmann weights of the ionic
+	// problem), for quasi-equilibrium states in which the two subsystems are
+	// not in equilibrium with each other. 0 (the default) means equal to Beta.
+	BetaEl, BetaIon float64
 
 	// One-spin term for BEG model: coefficient for (S_i)^2.
 	Bxy0, Bzz0, Bxz0 float64
@@ -36,43 +53,116 @@ type Environment struct {
 	Kb0 float64
 	// Quartic contributions along dimers.
 	Kcxx0, Kczz0, Kcxz0 float64
+	// Transverse (tunnelling) term: coefficient of -S^x_{p,alpha} in the
+	// single-site ionic Hamiltonian, mixing S_{p,alpha} = -1, 0, 1. If
+	// nonzero, the single-site problem is solved by diagonalization.
+	Gamma float64
+	// Cluster treated exactly in the ionic problem: "" (default) for single
+	// sites or "dimer" for pairs of sites along c (see clusters).
+	Cluster string
+	// Strain tensor (Cartesian components, with z along the rutile c axis).
+	Strain_xx, Strain_yy, Strain_zz, Strain_xy, Strain_xz, Strain_yz float64
 	// Poisson's ratio (approx 0.3 for VO2)
 	Poisson float64
-	// Scaling factor for [110] strain:
+	// Uniaxial strain along [110], with Poisson contraction along [1-10]
+	// and [001]; added to the strain tensor. With the default couplings:
 	// Bxy_02 *= (1 - Fac_xy)
 	// Bxy_11 *= (1 + Poisson*Fac_xy)
 	// Bzz *= (1 + Poisson*Fac_xy)
 	Fac_xy float64
-
-	// Hopping along c axis. TODO - strain dependence.
+	// Epitaxial film preset, added to the strain tensor: substrate
+	// orientation ("001", "100" or "110"; "" for none) and misfit strains
+	// along the in-plane substrate directions perpendicular to c (Misfit_a)
+	// and along c (Misfit_c; unused for "001").
+	// The out-of-plane strain is obtained from Poisson.
+	Substrate          string
+	Misfit_a, Misfit_c float64
+	// Linear coupling of the BEG parameters to strain: each parameter X is
+	// scaled by 1 + X_par*e_par + X_perp*e_perp + X_zz*e_zz, where e_par
+	// (e_perp) is the strain along (perpendicular to) the in-plane
+	// displacement direction of the sublattice, and e_zz the strain along c.
+	// Jb couples the two sublattices and uses the average of their e_par and
+	// e_perp. Defaults: Bxy_par = Bzz_zz = -1, all others 0.
+	Bxy_par, Bxy_perp, Bxy_zz float64
+	Bzz_par, Bzz_perp, Bzz_zz float64
+	Bxz_par, Bxz_perp, Bxz_zz float64
+	Jb_par, Jb_perp, Jb_zz    float64
+	Jc_par, Jc_perp, Jc_zz    float64
+
+	// Hopping along c axis.
 	Tce, Tco float64
 	// Hopping along body diagonal.
 	Tbe float64
+	// Bond length dependence of the hoppings: "power" (default) or
+	// "exponential" (see hopping_scalings), with exponents for the c axis
+	// and body diagonal bonds. The hopping parameters above give the
+	// unstrained values. With both exponents 0 (the default), the hoppings
+	// are independent of strain.
+	HoppingScaling string
+	Eta_c, Eta_b   float64
 	// Electron chemical potential.
 	Mu float64
+	// Electron filling: number of electrons per V (1 for undoped VO2).
+	// Defaults to 1 if not specified.
+	Filling float64
+	// Zeeman energy (g mu_B B / 2 for the magnetic field B): the energies of
+	// spin up and down electrons are shifted by -Zeeman and +Zeeman. If
+	// nonzero, ElHamiltonian includes both spins.
+	Zeeman float64
 	// Only do ionic part of calculation (all electronic quantities --> 0)
 	IonsOnly bool
+	// Electronic Hamiltonian: "" (default) for ElHamiltonian as written,
+	// the name of a tight-binding preset (see tightbinding.Preset) or the
+	// path to a tight-binding model JSON file. Model parameters are
+	// Environment fields, with the hoppings strained (see tbParameter).
+	// The model states must form blocks in the basis of ElHamiltonian (see
+	// evalEV), as for models imported from Wannier90 (see
+	// tightbinding.Wannier90Model).
+	TightBinding string
+	// Modulation wavevector of the order parameters, in the reciprocal
+	// lattice basis: the dimerisation of the c-axis bonds in cell r is
+	// proportional to cos(2 pi Q.r). If not set (nil), Q = (0, 1/2, 1/2)
+	// and ElHamiltonian is as written. If set, Q must be commensurate (zero
+	// gives a uniform dimerisation) and ElHamiltonian is the tight-binding
+	// preset TightBinding ("twodof" if not set) folded with Q (see
+	// wavevector.go); TightBinding must then be a preset.
+	Q *[3]float64
+	// Tight-binding model loaded from TightBinding (or folded with Q); nil if
+	// neither is set.
+	tb *tightbinding.Model
+	// Ionic model loaded from Ions.
+	ions *IonModel
+	// Additional fields coupling to the ionic components S_c and their
+	// squares in H_Ion as -ion_field[c] S_c - ion_field[c+n] S_c^2, for n
+	// components. Used to evaluate the Landau free energy away from
+	// self-consistency (see LandauGradient); nil otherwise.
+	ion_field []float64
 }
 
-func (env *Environment) Bxy() float64 {
-	// TODO - add strain dependence
-	return env.Bxy0
+// One-spin term for the in-plane displacement of sublattice p.
+func (env *Environment) Bxy(p int) float64 {
+	return env.Bxy0 * env.strainFactor(p, env.Bxy_par, env.Bxy_perp, env.Bxy_zz)
 }
 
-func (env *Environment) Bzz() float64 {
-	return env.Bzz0
+// One-spin term for the c-axis displacement of sublattice p.
+func (env *Environment) Bzz(p int) float64 {
+	return env.Bzz0 * env.strainFactor(p, env.Bzz_par, env.Bzz_perp, env.Bzz_zz)
 }
 
-func (env *Environment) Bxz() float64 {
-	return env.Bxz0
+// Coupling between the in-plane and c-axis displacements of sublattice p.
+func (env *Environment) Bxz(p int) float64 {
+	return env.Bxz0 * env.strainFactor(p, env.Bxz_par, env.Bxz_perp, env.Bxz_zz)
 }
 
 func (env *Environment) Jb() float64 {
-	return env.Jb0
+	f0 := env.strainFactor(0, env.Jb_par, env.Jb_perp, env.Jb_zz)
+	f1 := env.strainFactor(1, env.Jb_par, env.Jb_perp, env.Jb_zz)
+	return env.Jb0 * 0.5 * (f0 + f1)
 }
 
-func (env *Environment) Jc() float64 {
-	return env.Jc0
+// Exchange along c for sublattice p.
+func (env *Environment) Jc(p int) float64 {
+	return env.Jc0 * env.strainFactor(p, env.Jc_par, env.Jc_perp, env.Jc_zz)
 }
 
 func (env *Environment) Kb() float64 {
@@ -92,29 +182,56 @@ func (env *Environment) Kcxz() float64 {
 }
 
 // Are electronic hopping finite?
-// If not, don't need to calculate D's.
+// If not, don't need to calculate D's. The hoppings of a tight-binding model
+// (such as a Wannier90 model) need not be given by the Environment, so are
+// taken to be finite.
 func (env *Environment) FiniteHoppings() bool {
+	if env.tb != nil {
+		return true
+	}
 	eps := 1e-9
 	even := (math.Abs(env.Tce) > eps) || (math.Abs(env.Tbe) > eps)
 	odd := math.Abs(env.Tco) > eps
 	return even || odd
 }
 
+// Inverse temperature of the electrons: BetaEl if set, Beta otherwise.
+func (env *Environment) BetaElectrons() float64 {
+	if env.BetaEl == 0.0 {
+		return env.Beta
+	}
+	return env.BetaEl
+}
+
+// Inverse temperature of the ions: BetaIon if set, Beta otherwise.
+func (env *Environment) BetaIons() float64 {
+	if env.BetaIon == 0.0 {
+		return env.Beta
+	}
+	return env.BetaIon
+}
+
+// Are the electrons and ions at the same temperature?
+func (env *Environment) SingleTemperature() bool {
+	return env.BetaElectrons() == env.Beta && env.BetaIons() == env.Beta
+}
+
 // Fermi distribution function.
 func (env *Environment) Fermi(energy float64) float64 {
 	// Need to make this check to be sure we're dividing by a nonzero energy in the next step.
 	if energy == 0.0 {
 		return 0.5
 	}
+	beta := env.BetaElectrons()
 	// Temperature is 0 or e^(Beta*energy) is too big to calculate
-	if env.Beta == math.Inf(1) || env.Beta >= math.Abs(math.MaxFloat64/energy) || math.Abs(env.Beta*energy) >= math.Log(math.MaxFloat64) {
+	if beta == math.Inf(1) || beta >= math.Abs(math.MaxFloat64/energy) || math.Abs(beta*energy) >= math.Log(math.MaxFloat64) {
 		if energy <= 0 {
 			return 1.0
 		}
 		return 0.0
 	}
 	// nonzero temperature
-	return 1.0 / (math.Exp(energy*env.Beta) + 1.0)
+	return 1.0 / (math.Exp(energy*beta) + 1.0)
 }
 
 // Environment with all self-consistent values converged.
@@ -123,12 +240,41 @@ type FinalEnvironment struct {
 	Environment
 	Dco        float64
 	FreeEnergy float64
+	// Ionic and electronic parts of FreeEnergy (the remainder of FreeEnergy
+	// is the constant mean-field part).
+	FreeEnergyIons, FreeEnergyElectrons float64
+	// Entropy and heat capacity per cell.
+	// Only calculated when requested (see Thermodynamics); 0 otherwise.
+	Entropy, SpecificHeat float64
+	// Hessian of the free energy with respect to IonVars, its
+	// eigenvalues and the resulting stability label (see Stability); empty
+	// at T = 0 or if the stability analysis failed.
+	Hessian            [][]float64
+	HessianEigenvalues []float64
+	Stability          string
+	// Band gap around Mu and band edge k-points (see BandEdges).
+	// Only calculated when requested, since the band extrema are refined by
+	// a local search in k; zero otherwise and if IonsOnly is set.
+	tetra.BandEdges
+	// Magnetisation n_up - n_down (see Magnetization); 0 if IonsOnly is set.
+	Magnetization float64
+	// Spin susceptibility dMagnetization/dZeeman.
+	// Only calculated when requested (see SpinSusceptibility); 0 otherwise.
+	SpinSusceptibility float64
+	// Dco of each spin channel (up, then down; see HoppingEV.SpinResolved);
+	// nil if ElHamiltonian does not include both spins.
+	SpinDs map[string][]float64
 }
 
 // Free energy per cell value (Ncell = 2Nsite).
 // Points on the phase diagram include the state with minimum free energy
 // (may not reach this state, depending on initial conditions - need to
 // consider a set of initial conditions and look for minimum).
+// If the electrons and ions are at different temperatures, this is the
+// quasi-equilibrium free energy: the ionic and electronic parts are evaluated
+// at BetaIons and BetaElectrons respectively. Its stationary points are still
+// the self-consistent solutions.
+// At T = 0 (see ZeroTemperature), this is the ground-state energy.
 func (env *Environment) FreeEnergy(Ds *HoppingEV) float64 {
 	ion_part := env.FreeEnergyIons(Ds)
 	// avg_avg_part includes <S><S> terms.
@@ -142,13 +288,15 @@ func (env *Environment) FreeEnergy(Ds *HoppingEV) float64 {
 	}
 }
 
+// Ionic free energy per site, -T log(Z1); at T = 0, the ground-state energy of
+// the single-site (or per-site cluster) ionic Hamiltonian.
 func (env *Environment) FreeEnergyIons(Ds *HoppingEV) float64 {
-	T := 1.0 / env.Beta
-	return -T * math.Log(env.Z1(Ds))
+	return env.IonAverages(Ds).F1
 }
 
 func (env *Environment) FreeEnergyElectrons() float64 {
-	H := cmatrix.NewCMatrixGSL(4, 4)
+	beta := env.BetaElectrons()
+	H := cmatrix.NewCMatrixGSL(env.numStates(), env.numStates())
 	work, evals, evecs := cmatrix.HermEigensystemSetup(H)
 	inner := func(k vec.Vector) float64 {
 		ElHamiltonian(env, k, H)
@@ -157,31 +305,57 @@ func (env *Environment) FreeEnergyElectrons() float64 {
 		sum := 0.0
 		for alpha := 0; alpha < dim; alpha++ {
 			eps_ka := evals.At(alpha)
-			// Mu excluded from exp argument here since it is
-			// included in H.
-			val := 1.0 + math.Exp(-env.Beta*eps_ka)
-			// Factor of 2 for spins.
-			sum += 2.0 * math.Log(val)
+			// Mu is included in H, so eps_ka is measured from it.
+			// Factor of 2 for spins, unless H includes both spins.
+			sum += env.spinDegeneracy() * meanfield.FermionFreeEnergy(beta, eps_ka)
 		}
 		return sum
 	}
 	L := env.BZPointsPerDim
-	T := 1.0 / env.Beta
-	band_part := -T * bzone.Avg(L, 3, inner)
+	band_part := env.foldScale() * bzone.Avg(L, 3, inner)
+	// Mu enters H as -Mu/2, so the electron number term is Mu/2 times the
+	// filling; as in vo2solve, FreeEnergy is the free energy at the given
+	// filling.
+	mu_part := 0.5 * env.Mu * env.Filling
 
 	H.Destroy()
 	cmatrix.HermEigensystemCleanup(work, evals, evecs)
-	return band_part
+	return band_part + mu_part
 }
 
 // Create an Environment from the given serialized data.
 func NewEnvironment(jsonData string) (*Environment, error) {
-	// initialize env with input data
+	// initialize env with defaults, then input data
 	env := new(Environment)
+	env.Filling = 1.0
+	env.Bxy_par = -1.0
+	env.Bzz_zz = -1.0
 	err := serialize.CopyFromJSON(jsonData, env)
 	if err != nil {
 		return nil, err
 	}
+	// T = 0 is written as the largest float (see Marshal).
+	env.replaceInfBetas(math.MaxFloat64, math.Inf(1))
+	err = env.checkSubstrate()
+	if err != nil {
+		return nil, err
+	}
+	err = env.checkHoppingScaling()
+	if err != nil {
+		return nil, err
+	}
+	err = env.loadIons()
+	if err != nil {
+		return nil, err
+	}
+	err = env.loadTightBinding()
+	if err != nil {
+		return nil, err
+	}
+	err = env.checkCluster()
+	if err != nil {
+		return nil, err
+	}
 
 	return env, nil
 }
@@ -191,7 +365,15 @@ func NewEnvironment(jsonData string) (*Environment, error) {
 func NewFinalEnvironment(env *Environment, Ds *HoppingEV) *FinalEnvironment {
 	Dco := Ds.Dco(env)
 	FreeEnergy := env.FreeEnergy(Ds)
-	fenv := FinalEnvironment{*env, Dco, FreeEnergy}
+	FreeEnergyIons := env.FreeEnergyIons(Ds)
+	FreeEnergyElectrons := 0.0
+	if !env.IonsOnly {
+		FreeEnergyElectrons = env.FreeEnergyElectrons()
+	}
+	fenv := FinalEnvironment{*env, Dco, FreeEnergy, FreeEnergyIons, FreeEnergyElectrons, 0.0, 0.0, nil, nil, "", tetra.BandEdges{}, 0.0, 0.0, Ds.SpinResolved(env)}
+	if !env.IonsOnly {
+		fenv.Magnetization = env.Magnetization()
+	}
 	return &fenv
 }
 
@@ -230,17 +412,13 @@ func (env *Environment) String() string {
 }
 
 func (env *Environment) Marshal() string {
-	if env.Beta == math.Inf(1) {
-		// hack to get around JSON's choice to not allow Inf
-		env.Beta = math.MaxFloat64
-	}
+	// hack to get around JSON's choice to not allow Inf
+	env.replaceInfBetas(math.Inf(1), math.MaxFloat64)
 	marshalled, err := serialize.MakeJSON(env)
 	if err != nil {
 		panic(err)
 	}
-	if env.Beta == math.MaxFloat64 {
-		env.Beta = math.Inf(1)
-	}
+	env.replaceInfBetas(math.MaxFloat64, math.Inf(1))
 	return marshalled
 }
 
@@ -250,40 +428,81 @@ func (env *FinalEnvironment) String() string {
 }
 
 func (env *FinalEnvironment) Marshal() string {
-	if env.Beta == math.Inf(1) {
-		// hack to get around JSON's choice to not allow Inf
-		env.Beta = math.MaxFloat64
-	}
+	// hack to get around JSON's choice to not allow Inf
+	env.Environment.replaceInfBetas(math.Inf(1), math.MaxFloat64)
 	marshalled, err := serialize.MakeJSON(env)
 	if err != nil {
 		panic(err)
 	}
-	if env.Beta == math.MaxFloat64 {
-		env.Beta = math.Inf(1)
-	}
+	env.Environment.replaceInfBetas(math.MaxFloat64, math.Inf(1))
 	return marshalled
 }
 
+// Set each of Beta, BetaEl and BetaIon which is equal to from to to.
+func (env *Environment) replaceInfBetas(from, to float64) {
+	for _, beta := range []*float64{&env.Beta, &env.BetaEl, &env.BetaIon} {
+		if *beta == from {
+			*beta = to
+		}
+	}
+}
+
 // Iterate through v and vars simultaneously. vars specifies the names of
-// fields to change in env (they are set to the values given in v).
+// fields to change in env (they are set to the values given in v), or of
+// order parameters kept in OrderParameters.
 // Panics if vars specifies a field not contained in env (or a field of
-// non-float type).
+// non-float type); see CheckVariables.
 func (env *Environment) Set(v vec.Vector, vars []string) {
 	ev := reflect.ValueOf(env).Elem()
+	copied := false
 	for i := 0; i < len(vars); i++ {
 		field := ev.FieldByName(vars[i])
-		if field == reflect.Zero(reflect.TypeOf(env)) {
-			panic(fmt.Sprintf("Field %v not present in Environment", vars[i]))
+		if !field.IsValid() && env.isOrderParameter(vars[i]) {
+			if !copied {
+				params := make(map[string]float64, len(env.OrderParameters)+len(vars))
+				for name, x := range env.OrderParameters {
+					params[name] = x
+				}
+				env.OrderParameters = params
+				copied = true
+			}
+			env.OrderParameters[vars[i]] = v[i]
+			continue
 		}
-		if field.Type().Kind() != reflect.Float64 {
-			panic(fmt.Sprintf("Field %v is non-float", vars[i]))
+		err := env.CheckVariables(vars[i : i+1])
+		if err != nil {
+			panic(err)
 		}
 		field.SetFloat(v[i])
 	}
 }
 
-// Return the value of the env variable with type float64 with the given name.
+// Return an error if any of vars is not a variable which can be given to Set:
+// a float field of env or an order parameter of the ionic model.
+func (env *Environment) CheckVariables(vars []string) error {
+	ev := reflect.ValueOf(env).Elem()
+	for _, name := range vars {
+		field := ev.FieldByName(name)
+		if !field.IsValid() && env.isOrderParameter(name) {
+			continue
+		}
+		if !field.IsValid() {
+			return fmt.Errorf("Field %v not present in Environment", name)
+		}
+		if field.Type().Kind() != reflect.Float64 {
+			return fmt.Errorf("Field %v is non-float", name)
+		}
+	}
+	return nil
+}
+
+// Return the value of the env variable with type float64 with the given name,
+// which may be an order parameter kept in OrderParameters.
 func (env *Environment) GetFloat(var_name string) float64 {
 	ev := reflect.ValueOf(env).Elem()
-	return ev.FieldByName(var_name).Float()
+	field := ev.FieldByName(var_name)
+	if !field.IsValid() && env.isOrderParameter(var_name) {
+		return env.OrderParameters[var_name]
+	}
+	return field.Float()
 }
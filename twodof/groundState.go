@@ -0,0 +1,87 @@
+package twodof
+
+import (
+	"math"
+)
+import (
+	vec "github.com/tflovorn/scExplorer/vector"
+	"github.com/tflovorn/vo2mft/meanfield"
+	"github.com/tflovorn/vo2mft/tetra"
+)
+
+// Are the electrons and ions both at T = 0 (BetaElectrons and BetaIons
+// infinite)? If so, the solvers find the ground state (see groundStateSolve)
+// and FreeEnergy is the ground-state energy.
+func (env *Environment) ZeroTemperature() bool {
+	return math.IsInf(env.BetaElectrons(), 1) && math.IsInf(env.BetaIons(), 1)
+}
+
+// Return the Mu at which the bands of ElHamiltonian hold Filling electrons
+// per V at T = 0, with the Fermi level found by the tetrahedron method on the
+// BZPointsPerDim k-point grid. If the filled bands end at a gap, Mu is in the
+// middle of the gap.
+func (env *Environment) FermiLevel() (float64, error) {
+	L := env.BZPointsPerDim
+	Efn, cleanup := env.BandEnergies()
+	Eks := tetra.EnergyGrid(Efn, L)
+	cleanup()
+	// Each state holds spinDegeneracy electrons (see AbsErrorMu).
+	N := env.Filling / (env.spinDegeneracy() * env.foldScale())
+	// The band energies are measured from Mu/2, where Mu enters the
+	// Hamiltonian.
+	EF, err := tetra.FermiLevel(Eks, L, env.recipLattice(), N)
+	if err != nil {
+		return 0.0, err
+	}
+	return env.Mu + 2.0*EF, nil
+}
+
+// Solve for the ground state of env in-place by iterating the mean-field
+// equations at T = 0, with the M's named in fixed held at their values in
+// env. At each step, if withMu is set (and IonsOnly is not), Mu is set to the
+// Fermi level; then the M's and W's are set to their averages in the ground
+// state of the single-site (or cluster) ionic Hamiltonian, which for
+// Gamma = 0 is the configuration minimising it (with degenerate
+// configurations weighted equally). The electrons have step occupations.
+// The iteration stops when no variable changes by more than
+// epsAbs + epsRel |x| (see meanfield.FixedPoint). As for the finite
+// temperature solutions, the state reached depends on the starting point.
+// Return the solution in the order of the variables of MWMuSystem if withMu
+// is set, or MWSystem otherwise.
+func groundStateSolve(env *Environment, Ds *HoppingEV, epsAbs, epsRel float64, fixed []string, withMu bool) (vec.Vector, error) {
+	n := env.numIonComponents()
+	vars := env.IonVars()
+	// Index in IonVars of each variable other than Mu.
+	variables, indices := []string{}, []int{}
+	for a, name := range vars {
+		if a >= n || !containsName(fixed, name) {
+			variables = append(variables, name)
+			indices = append(indices, a)
+		}
+	}
+	electrons := withMu && !env.IonsOnly
+	if electrons {
+		variables = append(variables, "Mu")
+	}
+	x0 := make([]float64, len(variables))
+	for i, name := range variables {
+		x0[i] = env.GetFloat(name)
+	}
+	return meanfield.FixedPoint(x0, func(x []float64) ([]float64, error) {
+		next := make([]float64, len(x))
+		if electrons {
+			Mu, err := env.FermiLevel()
+			if err != nil {
+				return nil, err
+			}
+			env.Mu = Mu
+			next[len(next)-1] = Mu
+		}
+		avgs := env.IonAverages(Ds).Avgs
+		for i, a := range indices {
+			next[i] = avgs[a]
+		}
+		env.Set(next, variables)
+		return next, nil
+	}, epsAbs, epsRel)
+}
@@ -22,10 +22,22 @@ type HoppingEV struct {
 	m12_cached map[string]float64
 	// Value of Mu for which the contained hopping e.v.'s have been calculated.
 	mu_cached map[string]float64
+	// Value of Zeeman for which the contained hopping e.v.'s have been
+	// calculated.
+	zeeman_cached map[string]float64
+	// Hoppings (see Environment.Hoppings) for which the contained hopping
+	// e.v.'s have been calculated.
+	t_cached map[string][6]float64
 	// If hopping e.v.'s have not been calculated yet, init = false.
 	init map[string]bool
 	// Hopping e.v.'s for odd symmetry (pre-calculated).
 	dco float64
+	// Hopping e.v.'s of each spin channel (see SpinResolved).
+	spin map[string][]float64
+	// Single-site ionic averages and the inputs for which they have been
+	// calculated (see Environment.IonAverages); nil if not calculated yet.
+	ion_avgs *IonAverages
+	ion_key  ionKey
 }
 
 func NewHoppingEV() *HoppingEV {
@@ -35,7 +47,10 @@ func NewHoppingEV() *HoppingEV {
 	Ds.m01_cached = make(map[string]float64)
 	Ds.m12_cached = make(map[string]float64)
 	Ds.mu_cached = make(map[string]float64)
+	Ds.zeeman_cached = make(map[string]float64)
+	Ds.t_cached = make(map[string][6]float64)
 	Ds.init = make(map[string]bool)
+	Ds.spin = make(map[string][]float64)
 
 	for _, name := range names {
 		Ds.init[name] = false
@@ -51,24 +66,31 @@ func (Ds *HoppingEV) Dco(env *Environment) float64 {
 	if !env.FiniteHoppings() {
 		return 0.0
 	}
+	if env.tb != nil {
+		Ds.dco = Ds.perSpin(env, "dco", env.tbDco)
+		Ds.store(env, "dco")
+		return Ds.dco
+	}
 
-	H := cmatrix.NewCMatrixGSL(4, 4)
+	H := cmatrix.NewCMatrixGSL(env.numStates(), env.numStates())
 	work, evals, evecs := cmatrix.HermEigensystemSetup(H)
-	inner := func(k vec.Vector) float64 {
-		ev := GetEV_K0_KQ0(env, k, H, work, evals, evecs)
-		// make sure that ev is pure imaginary
-		//if math.Abs(real(ev)) > zero_threshold {
-		//	panic("Expected pure imaginary value for <c_{k+Q,0}^{\\dagger} c_{k,0}>, got finite real part")
-		//}
-		// -2i * ev = 2 * imag(ev)
-		return 2.0 * math.Sin(k[2]) * imag(ev)
-	}
-	dco := bzone.Avg(env.BZPointsPerDim, 3, inner)
+	dco := Ds.perSpin(env, "dco", func(sigma int) float64 {
+		inner := func(k vec.Vector) float64 {
+			ev := GetEV_K0_KQ0(env, k, sigma, H, work, evals, evecs)
+			// make sure that ev is pure imaginary
+			//if math.Abs(real(ev)) > zero_threshold {
+			//	panic("Expected pure imaginary value for <c_{k+Q,0}^{\\dagger} c_{k,0}>, got finite real part")
+			//}
+			// -2i * ev = 2 * imag(ev)
+			return 2.0 * math.Sin(k[2]) * imag(ev)
+		}
+		return bzone.Avg(env.BZPointsPerDim, 3, inner)
+	})
 
 	// Uncomment to verify that Dco is real.
 	/*
 		inner_re := func(k vec.Vector) float64 {
-			ev := GetEV_K0_KQ0(env, k, H, work, evals, evecs)
+			ev := GetEV_K0_KQ0(env, k, 0, H, work, evals, evecs)
 			return -2.0 * math.Sin(k[2]) * real(ev)
 		}
 		dco_re := bzone.Avg(env.BZPointsPerDim, 3, inner_re)
@@ -77,10 +99,7 @@ func (Ds *HoppingEV) Dco(env *Environment) float64 {
 		}
 	*/
 
-	Ds.init["dco"] = true
-	Ds.m01_cached["dco"] = env.M01
-	Ds.m12_cached["dco"] = env.M12
-	Ds.mu_cached["dco"] = env.Mu
+	Ds.store(env, "dco")
 	Ds.dco = dco
 
 	H.Destroy()
@@ -88,6 +107,85 @@ func (Ds *HoppingEV) Dco(env *Environment) float64 {
 	return dco
 }
 
+// Evaluate eval for each spin channel of ElHamiltonian (only sigma = 0 if it
+// does not include both spins), keep the values in Ds.spin[dname] (see
+// SpinResolved) and return their average.
+func (Ds *HoppingEV) perSpin(env *Environment, dname string, eval func(sigma int) float64) float64 {
+	vals := make([]float64, env.spinBlocks())
+	avg := 0.0
+	for sigma := range vals {
+		vals[sigma] = eval(sigma)
+		avg += vals[sigma] / float64(len(vals))
+	}
+	Ds.spin[dname] = vals
+	return avg
+}
+
+// Record the inputs for which the given D value has been calculated.
+func (Ds *HoppingEV) store(env *Environment, dname string) {
+	Ds.init[dname] = true
+	Ds.m01_cached[dname] = env.M01
+	Ds.m12_cached[dname] = env.M12
+	Ds.mu_cached[dname] = env.Mu
+	Ds.zeeman_cached[dname] = env.Zeeman
+	Ds.t_cached[dname] = env.Hoppings()
+}
+
+// Return Dco of each spin channel (up, then down) if ElHamiltonian includes
+// both spins, keyed by "Dco"; nil otherwise. Dco is the average of the two.
+func (Ds *HoppingEV) SpinResolved(env *Environment) map[string][]float64 {
+	if !env.spinful() {
+		return nil
+	}
+	Ds.Dco(env)
+	vals := make([]float64, env.spinBlocks())
+	if env.FiniteHoppings() {
+		copy(vals, Ds.spin["dco"])
+	}
+	return map[string][]float64{"Dco": vals}
+}
+
+// Return Dco for spin channel sigma of a tight-binding model (see
+// Environment.TightBinding). By Hellmann-Feynman, <dH/dM01> is 2 Tco Dco per
+// cell, so that Dco includes all of the hoppings modulated by M01, including
+// those of a Wannier90 model (see tightbinding.Wannier90Mapping), in units of
+// Tco. For the twodof preset, this is Dco of ElHamiltonian as written.
+func (env *Environment) tbDco(sigma int) float64 {
+	if env.Tco_eff() == 0.0 {
+		return 0.0
+	}
+	n := env.numSpinlessStates()
+	H := cmatrix.NewCMatrixGSL(env.numStates(), env.numStates())
+	work, evals, evecs := cmatrix.HermEigensystemSetup(H)
+	inner := func(k vec.Vector) float64 {
+		kr := []float64{k[0] / (2.0 * math.Pi), k[1] / (2.0 * math.Pi), k[2] / (2.0 * math.Pi)}
+		dH := env.tb.Derivative(kr, env.tbParameter, "M01")
+		ElHamiltonian(env, k, H)
+		cmatrix.HermEigensystem(H, work, evals, evecs)
+		sum := 0.0
+		for alpha := 0; alpha < env.numStates(); alpha++ {
+			// Eigenvectors are in columns; the states of spin sigma start
+			// at sigma*n.
+			ev := complex(0.0, 0.0)
+			for i := range dH {
+				for j := range dH {
+					if dH[i][j] == 0.0 {
+						continue
+					}
+					ev += cmplx.Conj(evecs.At(sigma*n+i, alpha)) * dH[i][j] * evecs.At(sigma*n+j, alpha)
+				}
+			}
+			sum += env.Fermi(evals.At(alpha)) * real(ev)
+		}
+		return sum
+	}
+	dco := 0.5 * env.foldScale() * bzone.Avg(env.BZPointsPerDim, 3, inner) / env.Tco_eff()
+
+	H.Destroy()
+	cmatrix.HermEigensystemCleanup(work, evals, evecs)
+	return dco
+}
+
 // Return true iff the cached evaluation of the given D value is still
 // OK to use.
 func (Ds *HoppingEV) cacheOk(env *Environment, dname string) bool {
@@ -97,8 +195,10 @@ func (Ds *HoppingEV) cacheOk(env *Environment, dname string) bool {
 	M01_ok := env.M01 == Ds.m01_cached[dname]
 	M12_ok := env.M12 == Ds.m12_cached[dname]
 	Mu_ok := env.Mu == Ds.mu_cached[dname]
+	Zeeman_ok := env.Zeeman == Ds.zeeman_cached[dname]
+	T_ok := env.Hoppings() == Ds.t_cached[dname]
 
-	return M01_ok && M12_ok && Mu_ok
+	return M01_ok && M12_ok && Mu_ok && Zeeman_ok && T_ok
 }
 
 // Convert to string by marshalling to JSON.
@@ -118,33 +218,38 @@ func (Ds *HoppingEV) MarshalEnv(env *Environment) string {
 	return string(marshalled)
 }
 
-// Evaluate <c^{\dagger}_{k,0} c_{k+Q,0}>.
-func GetEV_K0_KQ0(env *Environment, k vec.Vector, H *cmatrix.CMatrixGSL, work *cmatrix.HermWorkGSL, evals *cmatrix.VectorGSL, evecs *cmatrix.CMatrixGSL) complex128 {
-	return evalEV(env, k, 1, 2, H, work, evals, evecs)
+// Evaluate <c^{\dagger}_{k,0} c_{k+Q,0}> for spin channel sigma (see evalEV).
+func GetEV_K0_KQ0(env *Environment, k vec.Vector, sigma int, H *cmatrix.CMatrixGSL, work *cmatrix.HermWorkGSL, evals *cmatrix.VectorGSL, evecs *cmatrix.CMatrixGSL) complex128 {
+	return evalEV(env, k, 1, 2, sigma, H, work, evals, evecs)
 }
 
 // Evaluate <c^{\dagger}_{indexL} c_{indexR}> where the index values have
 // the following correspondence:
 // 	1 <--> k, 0 ; 2 <--> k+Q, 0 ; 3 <--> k, 1 ; 4 <--> k+Q, 1
-func evalEV(env *Environment, k vec.Vector, indexL, indexR int, H *cmatrix.CMatrixGSL, work *cmatrix.HermWorkGSL, evals *cmatrix.VectorGSL, evecs *cmatrix.CMatrixGSL) complex128 {
+// If the Hamiltonian has more than 4 states (a tight-binding model with
+// several orbitals per site), the states form 4 equal blocks in this order
+// and the expectation value is summed over the orbitals in the block.
+// The result is for spin channel sigma (0 up, 1 down); sigma must be 0 if
+// ElHamiltonian does not include both spins.
+func evalEV(env *Environment, k vec.Vector, indexL, indexR, sigma int, H *cmatrix.CMatrixGSL, work *cmatrix.HermWorkGSL, evals *cmatrix.VectorGSL, evecs *cmatrix.CMatrixGSL) complex128 {
 	ElHamiltonian(env, k, H)
 	dim, _ := H.Dims()
+	n := env.numSpinlessStates()
+	nb := n / len(el_basis)
 	cmatrix.HermEigensystem(H, work, evals, evecs)
 	sum := complex(0.0, 0.0)
 	for alpha := 0; alpha < dim; alpha++ {
-		// Coefficients psi^*_{alpha} psi_{alpha}.
-		// Indices reversed relative to matrix since Eigensystem returns
-		// a slice of eigenvectors (i.e. eigenvectors in rows instead of columns).
-		// Shifted by 1 since the slice is zero-indexed.
-		//left := cmplx.Conj(evecs[alpha][indexL-1])
-		//right := evecs[alpha][indexR-1]
-		left := cmplx.Conj(evecs.At(indexL-1, alpha))
-		right := evecs.At(indexR-1, alpha)
 		// Fermi-Dirac occupation.
 		// Mu is included in H, so not included here.
 		occ := env.Fermi(evals.At(alpha))
-		// alpha'th eigenvector contribution to EV.
-		sum += left * right * complex(occ, 0.0)
+		for a := 0; a < nb; a++ {
+			// Coefficients psi^*_{alpha} psi_{alpha}.
+			// Eigenvectors are in columns.
+			left := cmplx.Conj(evecs.At(sigma*n+(indexL-1)*nb+a, alpha))
+			right := evecs.At(sigma*n+(indexR-1)*nb+a, alpha)
+			// alpha'th eigenvector contribution to EV.
+			sum += left * right * complex(occ, 0.0)
+		}
 	}
 	return sum
 }
@@ -0,0 +1,317 @@
+package twodof
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+)
+
+// Ionic model: the displacement components S_c in {-1, 0, 1} of each site
+// and the terms of the ionic Hamiltonian coupling them. The single-site
+// Hamiltonian H_Ion, the constant EConst_Ion, the bond Hamiltonian H_Bond of
+// the dimer cluster, the order parameters and their self-consistency
+// equations are all generated from the model.
+type IonModel struct {
+	Components []IonComponent
+	Couplings  []IonCoupling
+}
+
+// Displacement of sublattice Sublattice in direction Direction. Its order
+// parameters are M = <S> and W = <S^2>, named "M" + Name and "W" + Name.
+type IonComponent struct {
+	// Defaults to the sublattice followed by the direction (e.g. "01").
+	Name                  string
+	Sublattice, Direction int
+	// Component giving the dimerisation of the c-axis bonds, which couples
+	// to the electrons through Dco. Only the components named "01" and "12"
+	// can be set, since these are the dimerisations of sublattices 0 and 1
+	// in the electronic Hamiltonian.
+	Dimer bool
+}
+
+// Term of the ionic Hamiltonian (see ion_coupling_kinds). The coefficient is
+// Value times the Environment coupling named Coupling for sublattice
+// Sublattice (see ion_couplings; Value = 0 is taken as 1), or Value alone
+// if Coupling is empty.
+type IonCoupling struct {
+	Kind string
+	// Indices in Components of the coupled components; B is unused for
+	// the single-component kind "onsite".
+	A, B       int
+	Coupling   string
+	Sublattice int
+	Value      float64
+	// Number of bonds per site for the intersite kinds, or "c" in Axis for
+	// the bonds along c (one per site), which the dimer cluster treats
+	// exactly (see H_Bond).
+	Bonds float64
+	Axis  string
+}
+
+// Kinds of ionic terms, with coefficient t:
+// "onsite": t S_A^2;
+// "onsite_quartic": t S_A^2 S_B^2;
+// "exchange": -t S_A(i) S_B(j) for each bond (i, j);
+// "quartic": t S_A(i)^2 S_B(j)^2 for each bond (i, j).
+// Intersite terms are decoupled in mean field: a bond term t X_A(i) X_B(j)
+// gives the fields t X_A <X_B> and t <X_A> X_B on each site, and the constant
+// -t <X_A> <X_B>.
+var ion_coupling_kinds = []string{"onsite", "onsite_quartic", "exchange", "quartic"}
+
+// Environment couplings which may be used in ionic models, as functions of
+// the sublattice.
+var ion_couplings = map[string]func(env *Environment, p int) float64{
+	"Bxy":  (*Environment).Bxy,
+	"Bzz":  (*Environment).Bzz,
+	"Bxz":  (*Environment).Bxz,
+	"Jb":   func(env *Environment, p int) float64 { return env.Jb() },
+	"Jc":   (*Environment).Jc,
+	"Kb":   func(env *Environment, p int) float64 { return env.Kb() },
+	"Kcxx": func(env *Environment, p int) float64 { return env.Kcxx() },
+	"Kczz": func(env *Environment, p int) float64 { return env.Kczz() },
+	"Kcxz": func(env *Environment, p int) float64 { return env.Kcxz() },
+}
+
+// Built-in ionic models, by name.
+var ion_presets = map[string]func() *IonModel{
+	"twodof": TwoDofIons,
+}
+
+// Return the built-in ionic model with the given name.
+func IonPreset(name string) (*IonModel, error) {
+	f, ok := ion_presets[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown ionic model preset %v; expected one of %v", name, IonPresetNames())
+	}
+	return f(), nil
+}
+
+// Return the names of the built-in ionic models.
+func IonPresetNames() []string {
+	return []string{"twodof"}
+}
+
+// Return the ionic model given by JSON data, with default component names
+// filled in.
+func NewIonModel(jsonData string) (*IonModel, error) {
+	m := new(IonModel)
+	err := json.Unmarshal([]byte(jsonData), m)
+	if err != nil {
+		return nil, err
+	}
+	err = m.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Load the built-in ionic model named source if there is one, or else the
+// model in the JSON file at the path source.
+func LoadIonModel(source string) (*IonModel, error) {
+	if _, ok := ion_presets[source]; ok {
+		return IonPreset(source)
+	}
+	data, err := ioutil.ReadFile(source)
+	if err != nil {
+		return nil, err
+	}
+	return NewIonModel(string(data))
+}
+
+// Fill in default component names, and return an error if two components
+// have the same name, a coupling refers to a component, kind or Environment
+// coupling which does not exist, or a component which cannot be dimerised
+// is marked as Dimer.
+func (m *IonModel) Validate() error {
+	if len(m.Components) == 0 {
+		return fmt.Errorf("Ionic model has no components")
+	}
+	seen := map[string]bool{}
+	for c := range m.Components {
+		comp := &m.Components[c]
+		if comp.Name == "" {
+			comp.Name = fmt.Sprintf("%d%d", comp.Sublattice, comp.Direction)
+		}
+		if seen[comp.Name] {
+			return fmt.Errorf("Ionic model has more than one component named %v", comp.Name)
+		}
+		seen[comp.Name] = true
+		if comp.Dimer && comp.Name != "01" && comp.Name != "12" {
+			return fmt.Errorf("Ionic component %v cannot be a dimerisation; expected 01 or 12", comp.Name)
+		}
+	}
+	n := len(m.Components)
+	for _, t := range m.Couplings {
+		known := false
+		for _, kind := range ion_coupling_kinds {
+			if t.Kind == kind {
+				known = true
+			}
+		}
+		if !known {
+			return fmt.Errorf("Unknown ionic coupling kind %v; expected one of %v", t.Kind, ion_coupling_kinds)
+		}
+		if t.A < 0 || t.A >= n || (t.Kind != "onsite" && (t.B < 0 || t.B >= n)) {
+			return fmt.Errorf("Ionic coupling %v refers to components outside [0, %v)", t, n)
+		}
+		if t.Coupling != "" {
+			if _, ok := ion_couplings[t.Coupling]; !ok {
+				return fmt.Errorf("Unknown ionic coupling %v", t.Coupling)
+			}
+			if t.Sublattice != 0 && t.Sublattice != 1 {
+				return fmt.Errorf("Sublattice of ionic coupling %v must be 0 or 1; got %v", t.Coupling, t.Sublattice)
+			}
+		}
+		if t.Axis != "" && t.Axis != "c" {
+			return fmt.Errorf("Unknown bond axis %v for ionic coupling %v; expected \"c\" or none", t.Axis, t)
+		}
+	}
+	return nil
+}
+
+// Return the names of the order parameters: the M's followed by the W's, in
+// the order of Components.
+func (m *IonModel) Vars() []string {
+	n := len(m.Components)
+	vars := make([]string, 2*n)
+	for c, comp := range m.Components {
+		vars[c] = "M" + comp.Name
+		vars[c+n] = "W" + comp.Name
+	}
+	return vars
+}
+
+// Return true if the coupling is an intersite (bond) term.
+func (t IonCoupling) intersite() bool {
+	return t.Kind == "exchange" || t.Kind == "quartic"
+}
+
+// Return the coefficient of the coupling t in env.
+func (env *Environment) ionCoefficient(t IonCoupling) float64 {
+	if t.Coupling == "" {
+		return t.Value
+	}
+	scale := t.Value
+	if scale == 0.0 {
+		scale = 1.0
+	}
+	return scale * ion_couplings[t.Coupling](env, t.Sublattice)
+}
+
+// Return the number of bonds per site of the intersite coupling t which are
+// treated in mean field.
+func (env *Environment) meanFieldBonds(t IonCoupling) float64 {
+	if t.Axis == "c" {
+		return 0.5 * env.meanFieldCNeighbors()
+	}
+	return t.Bonds
+}
+
+// The twodof ionic model: two sublattices, each with displacements in two
+// directions, ordered as S = [S01, S11, S02, S12]. S01 and S12 are along c
+// and dimerise the c-axis bonds; S11 and S02 are in-plane.
+func TwoDofIons() *IonModel {
+	m := &IonModel{}
+	for _, pa := range [][2]int{{0, 1}, {1, 1}, {0, 2}, {1, 2}} {
+		name := fmt.Sprintf("%d%d", pa[0], pa[1])
+		m.Components = append(m.Components, IonComponent{name, pa[0], pa[1], name == "01" || name == "12"})
+	}
+	add := func(kind string, A, B int, coupling string, p int, value float64, bonds float64, axis string) {
+		m.Couplings = append(m.Couplings, IonCoupling{kind, A, B, coupling, p, value, bonds, axis})
+	}
+	// One-site terms.
+	add("onsite", 0, 0, "Bzz", 0, 1.0, 0.0, "")
+	add("onsite", 1, 1, "Bxy", 1, 1.0, 0.0, "")
+	add("onsite", 2, 2, "Bxy", 0, 1.0, 0.0, "")
+	add("onsite", 3, 3, "Bzz", 1, 1.0, 0.0, "")
+	add("onsite_quartic", 2, 0, "Bxz", 0, 1.0, 0.0, "")
+	add("onsite_quartic", 1, 3, "Bxz", 1, 1.0, 0.0, "")
+	// Body diagonal bonds between the sublattices.
+	add("exchange", 0, 1, "Jb", 0, 1.0, 4.0, "")
+	add("exchange", 2, 3, "Jb", 0, 1.0, 4.0, "")
+	add("quartic", 0, 1, "Kb", 0, 1.0, 4.0, "")
+	add("quartic", 2, 3, "Kb", 0, 1.0, 4.0, "")
+	// Bonds along c.
+	add("exchange", 0, 0, "Jc", 0, 1.0, 0.0, "c")
+	add("exchange", 3, 3, "Jc", 1, 1.0, 0.0, "c")
+	add("quartic", 0, 0, "Kczz", 0, 1.0, 0.0, "c")
+	add("quartic", 3, 3, "Kczz", 0, 1.0, 0.0, "c")
+	add("quartic", 1, 1, "Kcxx", 0, 1.0, 0.0, "c")
+	add("quartic", 2, 2, "Kcxx", 0, 1.0, 0.0, "c")
+	for _, AB := range [][2]int{{0, 2}, {2, 0}, {1, 3}, {3, 1}} {
+		add("quartic", AB[0], AB[1], "Kcxz", 0, 0.5, 0.0, "c")
+	}
+	return m
+}
+
+// Ionic model used when Environment.ions is not loaded.
+var default_ions = TwoDofIons()
+
+// Load the ionic model given by env.Ions ("twodof" if not set). Return an
+// error if an order parameter of the model has the name of an Environment
+// field other than the order parameters of the twodof model.
+func (env *Environment) loadIons() error {
+	source := env.Ions
+	if source == "" {
+		source = "twodof"
+	}
+	m, err := LoadIonModel(source)
+	if err != nil {
+		return err
+	}
+	default_vars := map[string]bool{}
+	for _, name := range default_ions.Vars() {
+		default_vars[name] = true
+	}
+	t := reflect.TypeOf(*env)
+	for _, name := range m.Vars() {
+		if _, ok := t.FieldByName(name); ok && !default_vars[name] {
+			return fmt.Errorf("Ionic order parameter %v has the name of an Environment field", name)
+		}
+	}
+	env.ions = m
+	return nil
+}
+
+// Return the ionic model of env.
+func (env *Environment) ionModel() *IonModel {
+	if env.ions == nil {
+		return default_ions
+	}
+	return env.ions
+}
+
+// Return the number of ionic components per site.
+func (env *Environment) numIonComponents() int {
+	return len(env.ionModel().Components)
+}
+
+// Return the names of the ionic order parameters: the M's followed by the
+// W's, in the order of the components of the ionic model.
+func (env *Environment) IonVars() []string {
+	return env.ionModel().Vars()
+}
+
+// Return the index of the ionic component of sublattice p and direction
+// alpha. Panics if there is none.
+func (env *Environment) componentIndex(p, alpha int) int {
+	for c, comp := range env.ionModel().Components {
+		if comp.Sublattice == p && comp.Direction == alpha {
+			return c
+		}
+	}
+	panic(fmt.Sprintf("Ionic model has no component with sublattice %v and direction %v", p, alpha))
+}
+
+// Return true if name is an order parameter of the ionic model. Those which
+// are not Environment fields are kept in OrderParameters.
+func (env *Environment) isOrderParameter(name string) bool {
+	for _, v := range env.IonVars() {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
@@ -3,88 +3,286 @@ package twodof
 import (
 	"math"
 )
+import (
+	"github.com/tflovorn/vo2mft/meanfield"
+)
 
-var cached_all_S = [][]int{}
+// Configurations of n components, by n (see all_S_configs).
+var cached_all_S = map[int][][]int{}
 
-func (env *Environment) Z1(Ds *HoppingEV) float64 {
-	all_S := all_S_configs()
-	val := 0.0
-	for _, S := range all_S {
-		val += math.Exp(-env.Beta * env.H_Ion(S, Ds))
-		//fmt.Println(env.Beta*env.H_Ion(S, Ds), math.Exp(-env.Beta*env.H_Ion(S, Ds)), val)
+// Single-site ionic Hamiltonian in mean field: the coefficients of the
+// terms quad[c] S_c^2 - lin[c] S_c and quartic t S_A^2 S_B^2.
+type ionSite struct {
+	lin, quad []float64
+	quartic   []ionPair
+}
+
+type ionPair struct {
+	A, B int
+	t    float64
+}
+
+// Return the energy of the configuration S.
+func (site *ionSite) energy(S []int) float64 {
+	H := 0.0
+	for c, s := range S {
+		sf := float64(s)
+		H += site.quad[c]*sf*sf - site.lin[c]*sf
 	}
-	return val
+	for _, q := range site.quartic {
+		H += q.t * float64(S[q.A]*S[q.A]*S[q.B]*S[q.B])
+	}
+	return H
 }
 
-func (env *Environment) Mpa(p, alpha int, Ds *HoppingEV) float64 {
-	S_index := p + 2*(alpha-1)
-	all_S := all_S_configs()
-	val := 0.0
-	for _, S := range all_S {
-		S_part := float64(S[S_index])
-		val += S_part * math.Exp(-env.Beta*env.H_Ion(S, Ds))
+// Return the mean-field single-site ionic Hamiltonian given by the ionic
+// model (see ion_coupling_kinds), the coupling of the dimerisations to the
+// electrons and ion_field.
+func (env *Environment) ionSite(Ds *HoppingEV) *ionSite {
+	m := env.ionModel()
+	n := len(m.Components)
+	vars := m.Vars()
+	site := &ionSite{make([]float64, n), make([]float64, n), []ionPair{}}
+	for _, t := range m.Couplings {
+		coeff := env.ionCoefficient(t)
+		switch t.Kind {
+		case "onsite":
+			site.quad[t.A] += coeff
+		case "onsite_quartic":
+			site.quartic = append(site.quartic, ionPair{t.A, t.B, coeff})
+		case "exchange":
+			z := env.meanFieldBonds(t)
+			site.lin[t.A] += z * coeff * env.GetFloat(vars[t.B])
+			site.lin[t.B] += z * coeff * env.GetFloat(vars[t.A])
+		case "quartic":
+			z := env.meanFieldBonds(t)
+			site.quad[t.A] += z * coeff * env.GetFloat(vars[t.B+n])
+			site.quad[t.B] += z * coeff * env.GetFloat(vars[t.A+n])
+		}
+	}
+	Dco := Ds.Dco(env)
+	for c, comp := range m.Components {
+		if comp.Dimer {
+			site.lin[c] += 2.0 * Dco
+		}
 	}
-	return val / env.Z1(Ds)
+	if len(env.ion_field) > 0 {
+		for c := 0; c < n; c++ {
+			site.lin[c] += env.ion_field[c]
+			site.quad[c] -= env.ion_field[c+n]
+		}
+	}
+	return site
 }
 
-func (env *Environment) Wpa(p, alpha int, Ds *HoppingEV) float64 {
-	S_index := p + 2*(alpha-1)
-	all_S := all_S_configs()
-	val := 0.0
-	for _, S := range all_S {
-		S_part := float64(S[S_index] * S[S_index])
-		val += S_part * math.Exp(-env.Beta*env.H_Ion(S, Ds))
+// Single-site ionic averages, all evaluated from one pass over the
+// configurations (see Environment.IonAverages).
+type IonAverages struct {
+	// Logarithm of the single-site partition function; for clusters, of the
+	// partition function per site, Z_cluster^(1/N_cluster). Infinite at
+	// T = 0 unless the ground-state energy vanishes.
+	LogZ1 float64
+	// Free energy per site, -log(Z1) / BetaIons, evaluated so that it stays
+	// finite down to T = 0, where it is the ground-state energy per site.
+	F1 float64
+	// Expectation values of O = (S_c, S_c^2) in the order of IonVars.
+	Avgs []float64
+	// Susceptibility chi_ab = d<O_a>/dh_b with respect to fields coupling to
+	// O as -h_b O_b: for Gamma = 0, BetaIons times the connected correlator
+	// <O_a O_b> - <O_a><O_b> (per site for clusters).
+	Chi [][]float64
+}
+
+// Return the single-site ionic averages at env. The result is cached in Ds
+// and shared by all evaluations with the same single-site Hamiltonian and
+// temperature, such as the M and W residuals of one point of a system; it
+// must not be modified.
+func (env *Environment) IonAverages(Ds *HoppingEV) *IonAverages {
+	key := ionKey{env.ionSite(Ds), env.BetaIons(), env.Gamma, env.Cluster, nil}
+	if env.Cluster == "dimer" {
+		key.bonds = env.cBonds()
+	}
+	if Ds.ion_avgs != nil && Ds.ion_key.equal(&key) {
+		return Ds.ion_avgs
+	}
+	var avgs *IonAverages
+	if env.Cluster == "dimer" {
+		avgs = dimerIon(key.site, key.bonds, key.beta)
+	} else if env.Gamma != 0.0 {
+		avgs = env.quantumIon(key.site)
+	} else {
+		avgs = classicalIon(key.site, key.beta)
 	}
-	return val / env.Z1(Ds)
+	Ds.ion_key, Ds.ion_avgs = key, avgs
+	return avgs
 }
 
-// Single-site ionic Hamiltonian (local and ion-ion parts).
-// S = [S01, S11, S02, S12].
-func (env *Environment) H_Ion(S []int, Ds *HoppingEV) float64 {
-	S01, S11, S02, S12 := float64(S[0]), float64(S[1]), float64(S[2]), float64(S[3])
-	Bxy, Bzz, Bxz, Jb, Jc := env.Bxy(), env.Bzz(), env.Bxz(), env.Jb(), env.Jc()
-	Kbe := 4.0 * env.Kb()
-	Kcxxe, Kczze, Kcxz := 2.0*env.Kcxx(), 2.0*env.Kczz(), env.Kcxz()
-	Dco := Ds.Dco(env)
+// Inputs which determine the single-site ionic averages.
+type ionKey struct {
+	site        *ionSite
+	beta, gamma float64
+	cluster     string
+	// Bond terms along c, for the dimer cluster.
+	bonds []ionBond
+}
 
-	Fac_xy, Poisson := env.Fac_xy, env.Poisson
-	Bxy_11_F := (1 + Poisson*Fac_xy) * Bxy
-	Bxy_02_F := (1 - Fac_xy) * Bxy
-	Bzz_F := (1 + Poisson*Fac_xy) * Bzz
+// Return true if k and other give the same ionic averages.
+func (k *ionKey) equal(other *ionKey) bool {
+	if k.beta != other.beta || k.gamma != other.gamma || k.cluster != other.cluster {
+		return false
+	}
+	if len(k.bonds) != len(other.bonds) || len(k.site.quartic) != len(other.site.quartic) || len(k.site.lin) != len(other.site.lin) {
+		return false
+	}
+	for i, b := range k.bonds {
+		if b != other.bonds[i] {
+			return false
+		}
+	}
+	for i, q := range k.site.quartic {
+		if q != other.site.quartic[i] {
+			return false
+		}
+	}
+	for c := range k.site.lin {
+		if k.site.lin[c] != other.site.lin[c] || k.site.quad[c] != other.site.quad[c] {
+			return false
+		}
+	}
+	return true
+}
 
-	S01_part := (Bzz_F+Kbe*env.W11+Kczze*env.W01+Kcxz*env.W02)*S01*S01 - (4.0*Jb*env.M11+2.0*Jc*env.M01+2.0*Dco)*S01
-	S11_part := (Bxy_11_F+Kbe*env.W01+Kcxxe*env.W11+Kcxz*env.W12)*S11*S11 - 4.0*Jb*env.M01*S11
-	S02_part := (Bxy_02_F+Kbe*env.W12+Kcxxe*env.W02+Kcxz*env.W01)*S02*S02 - 4.0*Jb*env.M12*S02
-	S12_part := (Bzz_F+Kbe*env.W02+Kczze*env.W12+Kcxz*env.W11)*S12*S12 - (4.0*Jb*env.M02+2.0*Jc*env.M12+2.0*Dco)*S12
-	S02_S01_part := Bxz * S02 * S02 * S01 * S01
-	S11_S12_part := Bxz * S11 * S11 * S12 * S12
-	return S01_part + S11_part + S02_part + S12_part + S02_S01_part + S11_S12_part
+// Return the value of the observable O_a = (S_c, S_c^2)_a (in the order of
+// IonVars) in the configuration S.
+func ionObservable(S []int, a int) float64 {
+	nc := len(S)
+	if a < nc {
+		return float64(S[a])
+	}
+	return float64(S[a-nc] * S[a-nc])
+}
+
+// Return the ionic averages of the single-site Hamiltonian site at inverse
+// temperature beta (see boltzmann), which may be Inf.
+func classicalIon(site *ionSite, beta float64) *IonAverages {
+	nc := len(site.lin)
+	all_S := all_S_configs(nc)
+	energies := make([]float64, len(all_S))
+	for i, S := range all_S {
+		energies[i] = site.energy(S)
+	}
+	dist := meanfield.NewBoltzmann(energies, beta)
+	n := 2 * nc
+	avgs := make([]float64, n)
+	for i, S := range all_S {
+		for a := 0; a < n; a++ {
+			avgs[a] += dist.Probs[i] * ionObservable(S, a)
+		}
+	}
+	// Centered sum to avoid cancellation when one configuration dominates.
+	chi := make([][]float64, n)
+	for a := 0; a < n; a++ {
+		chi[a] = make([]float64, n)
+	}
+	for i, S := range all_S {
+		for a := 0; a < n; a++ {
+			da := ionObservable(S, a) - avgs[a]
+			for b := 0; b < n; b++ {
+				chi[a][b] += dist.Probs[i] * da * (ionObservable(S, b) - avgs[b])
+			}
+		}
+	}
+	for a := 0; a < n; a++ {
+		for b := 0; b < n; b++ {
+			chi[a][b] = meanfield.ScaleBeta(beta, chi[a][b])
+		}
+	}
+	return &IonAverages{dist.LogZ(), dist.FreeEnergy(), avgs, chi}
+}
+
+// Single-site partition function. For clusters, the partition function per
+// site, Z_cluster^(1/N_cluster).
+func (env *Environment) Z1(Ds *HoppingEV) float64 {
+	return math.Exp(env.IonAverages(Ds).LogZ1)
+}
+
+// Return <S_c> for the component of sublattice p and direction alpha.
+func (env *Environment) Mpa(p, alpha int, Ds *HoppingEV) float64 {
+	return env.ionAverage(env.componentIndex(p, alpha), false, Ds)
+}
+
+// Return <S_c^2> for the component of sublattice p and direction alpha.
+func (env *Environment) Wpa(p, alpha int, Ds *HoppingEV) float64 {
+	return env.ionAverage(env.componentIndex(p, alpha), true, Ds)
+}
+
+// Return <S_c^2> if square is set, or <S_c> otherwise, for the ionic
+// component c.
+func (env *Environment) ionAverage(c int, square bool, Ds *HoppingEV) float64 {
+	if square {
+		c += env.numIonComponents()
+	}
+	return env.IonAverages(Ds).Avgs[c]
+}
+
+// Single-site ionic Hamiltonian (local and ion-ion parts) for the
+// configuration S of the components of the ionic model; for the twodof
+// model, S = [S01, S11, S02, S12].
+// For clusters, only the neighbors outside the cluster contribute mean fields.
+func (env *Environment) H_Ion(S []int, Ds *HoppingEV) float64 {
+	return env.ionSite(Ds).energy(S)
 }
 
 // Constant part of the ionic Hamiltonian (no S dependence).
+// For clusters, only the bonds between clusters contribute.
+// Each quartic term has the constant -t <W_A><W_B>, matching its mean field
+// in H_Ion; in the twodof model, the Kczz and Kcxz constants therefore have
+// the same sign as the Kcxx constant (the original code gave them the
+// opposite sign).
 func (env *Environment) EConst_Ion() float64 {
-	dimer_quad := env.Jc() * (math.Pow(env.M01, 2.0) + math.Pow(env.M12, 2.0))
-	dimer_quart := -env.Kcxx()*(env.W02*env.W02+env.W11*env.W11) + env.Kczz()*(env.W01*env.W01+env.W12*env.W12) + env.Kcxz()*(env.W02*env.W01+env.W11*env.W12)
-	cb := 4.0 * env.Jb() * (env.M01*env.M11 + env.M02*env.M12)
-	ccbb := -4.0 * env.Kb() * (env.W01*env.W11 + env.W02*env.W12)
-	return dimer_quad + dimer_quart + cb + ccbb
+	m := env.ionModel()
+	n := len(m.Components)
+	vars := m.Vars()
+	E := 0.0
+	for _, t := range m.Couplings {
+		if !t.intersite() {
+			continue
+		}
+		zt := env.meanFieldBonds(t) * env.ionCoefficient(t)
+		if t.Kind == "exchange" {
+			// The bond energy is -t S_A S_B.
+			E += zt * env.GetFloat(vars[t.A]) * env.GetFloat(vars[t.B])
+		} else {
+			E -= zt * env.GetFloat(vars[t.A+n]) * env.GetFloat(vars[t.B+n])
+		}
+	}
+	return E
 }
 
 // Constant part of the electron-ion Hamiltonian.
 func (env *Environment) EConst_IonEl(Ds *HoppingEV) float64 {
-	return 2.0 * env.Tco * (env.M01 + env.M12) * Ds.Dco(env)
+	M_dimer := 0.0
+	m := env.ionModel()
+	for c, comp := range m.Components {
+		if comp.Dimer {
+			M_dimer += env.GetFloat(m.Vars()[c])
+		}
+	}
+	return 2.0 * env.Tco_eff() * M_dimer * Ds.Dco(env)
 }
 
-func all_S_configs() [][]int {
-	if len(cached_all_S) > 0 {
-		return cached_all_S
+// Return all 3^n configurations of n components S_c in {-1, 0, 1}, with the
+// last component varying fastest (see configIndex).
+func all_S_configs(n int) [][]int {
+	if all_S, ok := cached_all_S[n]; ok {
+		return all_S
 	}
 
 	all_S := [][]int{}
-	for i := 0; i < 4; i++ {
+	for i := 0; i < n; i++ {
 		add_S_elems(&all_S)
 	}
-	cached_all_S = all_S
+	cached_all_S[n] = all_S
 	return all_S
 }
 
@@ -0,0 +1,299 @@
+// Classical Monte Carlo for the twodof ionic model, for comparison with the
+// mean-field solution.
+//
+// The lattice is a periodic L x L x L simple cubic lattice of sites, each
+// holding the four displacements S = (S01, S11, S02, S12) of twodof.H_Ion.
+// The lattice Hamiltonian is the one whose mean-field decoupling gives
+// twodof.H_Ion:
+//
+//	H = sum_i [ Bzz(0) S01^2 + Bxy(1) S11^2 + Bxy(0) S02^2 + Bzz(1) S12^2
+//	           + Bxz(0) S02^2 S01^2 + Bxz(1) S11^2 S12^2 ]
+//	  - sum_i [ Jc(0) S01_i S01_{i+z} + Jc(1) S12_i S12_{i+z} ]
+//	  + sum_i [ Kczz (S01_i^2 S01_{i+z}^2 + S12_i^2 S12_{i+z}^2)
+//	           + Kcxx (S11_i^2 S11_{i+z}^2 + S02_i^2 S02_{i+z}^2) ]
+//	  + sum_i Kcxz/2 [ S01_i^2 S02_{i+z}^2 + S02_i^2 S01_{i+z}^2
+//	           + S11_i^2 S12_{i+z}^2 + S12_i^2 S11_{i+z}^2 ]
+//	  - sum_i sum_R Jb [ S01_i S11_{i-R} + S02_i S12_{i-R} ]
+//	  + sum_i sum_R Kb [ S01_i^2 S11_{i-R}^2 + S02_i^2 S12_{i-R}^2 ]
+//
+// where R runs over 0, x, y, z (the body diagonal bonds of EpsilonBE).
+package montecarlo
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+import (
+	"github.com/tflovorn/scExplorer/serialize"
+	"github.com/tflovorn/vo2mft/twodof"
+)
+
+// Number of displacement components per site, in the order of twodof.H_Ion.
+const num_comp = 4
+
+// Body diagonal bond vectors R.
+var diag_R = [4][3]int{[3]int{0, 0, 0}, [3]int{1, 0, 0}, [3]int{0, 1, 0}, [3]int{0, 0, 1}}
+
+// Component coupled to component c by Jb and Kb (0 <-> 1, 2 <-> 3), and by
+// Bxz and Kcxz (0 <-> 2, 1 <-> 3).
+var b_partner = [num_comp]int{1, 0, 3, 2}
+var xz_partner = [num_comp]int{2, 3, 0, 1}
+
+// Parameters controlling the simulation.
+type Params struct {
+	// Number of sites along each edge of the lattice.
+	L int
+	// Number of sweeps discarded at each temperature before measuring.
+	Thermalize int
+	// Number of measurement sweeps at each temperature.
+	Sweeps int
+	// Seed for the random number generator.
+	Seed int64
+}
+
+// Averages at one temperature. Arrays are indexed by component in the order
+// (S01, S11, S02, S12); m denotes the lattice average of one component of S
+// in a single configuration.
+type Result struct {
+	T float64
+	// <m> and <|m|>.
+	M, MAbs [num_comp]float64
+	// Lattice average of S^2.
+	W [num_comp]float64
+	// Binder cumulant 1 - <m^4>/(3 <m^2>^2).
+	Binder [num_comp]float64
+	// Energy and heat capacity per site.
+	Energy, SpecificHeat float64
+	// Fraction of accepted single-component updates.
+	Acceptance float64
+}
+
+type Sweep struct {
+	Params
+	Results []Result
+}
+
+// Couplings of the lattice Hamiltonian, taken from an Environment.
+type couplings struct {
+	B, Jc, Kc [num_comp]float64
+	Bxz       [num_comp]float64
+	Jb, Kb    float64
+	Kcxz      float64
+}
+
+type lattice struct {
+	L int
+	S [][num_comp]int
+	c couplings
+}
+
+// Run the simulation at each temperature in Ts (in the given order, starting
+// each temperature from the final configuration of the previous one) for the
+// ionic couplings in env. env must have IonsOnly set, Gamma = 0 and the
+// twodof ionic model.
+func Run(env *twodof.Environment, Ts []float64, params Params) (*Sweep, error) {
+	if !env.IonsOnly {
+		return nil, fmt.Errorf("Monte Carlo requires IonsOnly")
+	}
+	if env.Ions != "" && env.Ions != "twodof" {
+		return nil, fmt.Errorf("Monte Carlo requires the twodof ionic model; got %v", env.Ions)
+	}
+	if env.Gamma != 0.0 {
+		return nil, fmt.Errorf("Monte Carlo requires Gamma = 0")
+	}
+	if params.L < 2 {
+		return nil, fmt.Errorf("Lattice size L = %d too small", params.L)
+	}
+	rng := rand.New(rand.NewSource(params.Seed))
+	lat := newLattice(env, params.L, rng)
+	sweep := Sweep{params, []Result{}}
+	for _, T := range Ts {
+		sweep.Results = append(sweep.Results, lat.measure(T, params, rng))
+	}
+	return &sweep, nil
+}
+
+func newCouplings(env *twodof.Environment) couplings {
+	c := couplings{}
+	c.B = [num_comp]float64{env.Bzz(0), env.Bxy(1), env.Bxy(0), env.Bzz(1)}
+	c.Jc = [num_comp]float64{env.Jc(0), 0.0, 0.0, env.Jc(1)}
+	c.Kc = [num_comp]float64{env.Kczz(), env.Kcxx(), env.Kcxx(), env.Kczz()}
+	c.Bxz = [num_comp]float64{env.Bxz(0), env.Bxz(1), env.Bxz(0), env.Bxz(1)}
+	c.Jb, c.Kb, c.Kcxz = env.Jb(), env.Kb(), env.Kcxz()
+	return c
+}
+
+// Create a lattice with random initial configuration.
+func newLattice(env *twodof.Environment, L int, rng *rand.Rand) *lattice {
+	lat := lattice{L, make([][num_comp]int, L*L*L), newCouplings(env)}
+	for i := range lat.S {
+		for c := 0; c < num_comp; c++ {
+			lat.S[i][c] = rng.Intn(3) - 1
+		}
+	}
+	return &lat
+}
+
+// Return the index of the site at (x, y, z), with periodic boundaries.
+func (lat *lattice) index(x, y, z int) int {
+	L := lat.L
+	x, y, z = (x%L+L)%L, (y%L+L)%L, (z%L+L)%L
+	return x + L*(y+L*z)
+}
+
+// Return the coordinates of site i.
+func (lat *lattice) coords(i int) (int, int, int) {
+	L := lat.L
+	return i % L, (i / L) % L, i / (L * L)
+}
+
+// Return the sites j coupled to site i by the body diagonal bonds: i - R for
+// components on p = 0 and i + R for components on p = 1.
+func (lat *lattice) diagNeighbors(i, c int) [4]int {
+	x, y, z := lat.coords(i)
+	sign := -1
+	if c == 1 || c == 3 {
+		sign = 1
+	}
+	var nbrs [4]int
+	for n, R := range diag_R {
+		nbrs[n] = lat.index(x+sign*R[0], y+sign*R[1], z+sign*R[2])
+	}
+	return nbrs
+}
+
+// Return the sites above and below site i along c.
+func (lat *lattice) cNeighbors(i int) (int, int) {
+	x, y, z := lat.coords(i)
+	return lat.index(x, y, z+1), lat.index(x, y, z-1)
+}
+
+// Return the sum of all terms in H involving component c of site i, with
+// that component set to s.
+func (lat *lattice) localEnergy(i, c, s int) float64 {
+	cp := lat.c
+	sf := float64(s)
+	s2 := sf * sf
+	sq := func(j, d int) float64 {
+		return float64(lat.S[j][d] * lat.S[j][d])
+	}
+	E := cp.B[c]*s2 + cp.Bxz[c]*s2*sq(i, xz_partner[c])
+
+	up, down := lat.cNeighbors(i)
+	E -= cp.Jc[c] * sf * float64(lat.S[up][c]+lat.S[down][c])
+	E += cp.Kc[c] * s2 * (sq(up, c) + sq(down, c))
+	E += 0.5 * cp.Kcxz * s2 * (sq(up, xz_partner[c]) + sq(down, xz_partner[c]))
+
+	for _, j := range lat.diagNeighbors(i, c) {
+		E -= cp.Jb * sf * float64(lat.S[j][b_partner[c]])
+		E += cp.Kb * s2 * sq(j, b_partner[c])
+	}
+	return E
+}
+
+// Return the total energy of the lattice.
+func (lat *lattice) energy() float64 {
+	cp := lat.c
+	E := 0.0
+	for i, S := range lat.S {
+		up, _ := lat.cNeighbors(i)
+		for c := 0; c < num_comp; c++ {
+			s := float64(S[c])
+			s2 := s * s
+			E += cp.B[c] * s2
+			E -= cp.Jc[c] * s * float64(lat.S[up][c])
+			E += cp.Kc[c] * s2 * float64(lat.S[up][c]*lat.S[up][c])
+			E += 0.5 * cp.Kcxz * s2 * float64(lat.S[up][xz_partner[c]]*lat.S[up][xz_partner[c]])
+		}
+		E += cp.Bxz[0] * float64(S[0]*S[0]*S[2]*S[2])
+		E += cp.Bxz[1] * float64(S[1]*S[1]*S[3]*S[3])
+		// Body diagonal bonds, counted once from the p = 0 components.
+		for _, c := range []int{0, 2} {
+			s := float64(S[c])
+			for _, j := range lat.diagNeighbors(i, c) {
+				t := float64(lat.S[j][b_partner[c]])
+				E += -cp.Jb*s*t + cp.Kb*s*s*t*t
+			}
+		}
+	}
+	return E
+}
+
+// Perform one Metropolis sweep at inverse temperature beta, proposing a new
+// value for each component of each site. Return the number of accepted
+// updates.
+func (lat *lattice) sweep(beta float64, rng *rand.Rand) int {
+	accepted := 0
+	for i := range lat.S {
+		for c := 0; c < num_comp; c++ {
+			old := lat.S[i][c]
+			// Choose uniformly among the two other values.
+			s := (old+1+1+rng.Intn(2))%3 - 1
+			dE := lat.localEnergy(i, c, s) - lat.localEnergy(i, c, old)
+			if dE <= 0.0 || rng.Float64() < math.Exp(-beta*dE) {
+				lat.S[i][c] = s
+				accepted++
+			}
+		}
+	}
+	return accepted
+}
+
+// Equilibrate at temperature T and return the measured averages.
+func (lat *lattice) measure(T float64, params Params, rng *rand.Rand) Result {
+	beta := 1.0 / T
+	N := float64(len(lat.S))
+	for n := 0; n < params.Thermalize; n++ {
+		lat.sweep(beta, rng)
+	}
+
+	var m1, mabs, m2, m4, w [num_comp]float64
+	e1, e2 := 0.0, 0.0
+	accepted := 0
+	for n := 0; n < params.Sweeps; n++ {
+		accepted += lat.sweep(beta, rng)
+		var m, ws [num_comp]float64
+		for _, S := range lat.S {
+			for c := 0; c < num_comp; c++ {
+				m[c] += float64(S[c])
+				ws[c] += float64(S[c] * S[c])
+			}
+		}
+		for c := 0; c < num_comp; c++ {
+			mc := m[c] / N
+			m1[c] += mc
+			mabs[c] += math.Abs(mc)
+			m2[c] += mc * mc
+			m4[c] += mc * mc * mc * mc
+			w[c] += ws[c] / N
+		}
+		e := lat.energy() / N
+		e1 += e
+		e2 += e * e
+	}
+
+	num := float64(params.Sweeps)
+	result := Result{T: T}
+	for c := 0; c < num_comp; c++ {
+		result.M[c] = m1[c] / num
+		result.MAbs[c] = mabs[c] / num
+		result.W[c] = w[c] / num
+		if m2[c] > 0.0 {
+			result.Binder[c] = 1.0 - (m4[c]/num)/(3.0*math.Pow(m2[c]/num, 2.0))
+		}
+	}
+	result.Energy = e1 / num
+	result.SpecificHeat = N * beta * beta * (e2/num - math.Pow(e1/num, 2.0))
+	result.Acceptance = float64(accepted) / (num * N * num_comp)
+	return result
+}
+
+// Convert to string by marshalling to JSON.
+func (sweep *Sweep) Marshal() string {
+	marshalled, err := serialize.MakeJSON(sweep)
+	if err != nil {
+		panic(err)
+	}
+	return marshalled
+}
@@ -0,0 +1,55 @@
+package montecarlo
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+import (
+	"github.com/tflovorn/vo2mft/twodof"
+)
+
+func loadEnv(t *testing.T) *twodof.Environment {
+	env, err := twodof.LoadIonEnv("../system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Kb0, env.Kcxz0, env.Bxz0 = 0.2, 0.1, 0.3
+	return env
+}
+
+func TestLocalEnergy(t *testing.T) {
+	// Changes in the total energy must match changes in the local energy.
+	env := loadEnv(t)
+	rng := rand.New(rand.NewSource(1))
+	lat := newLattice(env, 3, rng)
+	for n := 0; n < 100; n++ {
+		i, c := rng.Intn(len(lat.S)), rng.Intn(num_comp)
+		old, s := lat.S[i][c], rng.Intn(3)-1
+		E_old, dE := lat.energy(), lat.localEnergy(i, c, s)-lat.localEnergy(i, c, old)
+		lat.S[i][c] = s
+		if math.Abs(lat.energy()-E_old-dE) > 1e-9 {
+			t.Fatalf("Local energy change %v does not match total energy change %v", dE, lat.energy()-E_old)
+		}
+	}
+}
+
+func TestNoninteracting(t *testing.T) {
+	// Without intersite couplings, mean-field theory is exact.
+	env := loadEnv(t)
+	env.Jb0, env.Jc0, env.Kb0, env.Kcxx0, env.Kczz0, env.Kcxz0 = 0.0, 0.0, 0.0, 0.0, 0.0, 0.0
+	T := 0.5
+	env.Beta = 1.0 / T
+	env.M01, env.M11, env.M02, env.M12 = 0.0, 0.0, 0.0, 0.0
+	sweep, err := Run(env, []float64{T}, Params{4, 200, 2000, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	Ds := twodof.NewHoppingEV()
+	W01 := env.Wpa(0, 1, Ds)
+	W02 := env.Wpa(0, 2, Ds)
+	result := sweep.Results[0]
+	if math.Abs(result.W[0]-W01) > 0.01 || math.Abs(result.W[2]-W02) > 0.01 {
+		t.Fatalf("Monte Carlo (W01, W02) = (%v, %v); expected (%v, %v)", result.W[0], result.W[2], W01, W02)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+import (
+	"github.com/tflovorn/vo2mft/twodof"
+	"github.com/tflovorn/vo2mft/twodof/montecarlo"
+)
+
+var L = flag.Int("L", 8, "Number of sites along each edge of the lattice")
+var therm = flag.Int("therm", 1000, "Number of sweeps discarded at each temperature")
+var sweeps = flag.Int("sweeps", 10000, "Number of measurement sweeps at each temperature")
+var seed = flag.Int64("seed", 1, "Random number generator seed")
+var Tmax = flag.Float64("Tmax", 2.0, "Starting (highest) temperature")
+var Tmin = flag.Float64("Tmin", 0.1, "Final (lowest) temperature")
+var numT = flag.Int("numT", 20, "Number of temperatures")
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 2 {
+		fmt.Println("Usage: montecarlo_front [--L L] [--therm THERM] [--sweeps SWEEPS] [--seed SEED] [--Tmax TMAX] [--Tmin TMIN] [--numT NUMT] in_path out_path")
+		fmt.Println("For flag descriptions, use: montecarlo_front --help")
+		os.Exit(2)
+	}
+	in_path := args[0]
+	out_path := args[1]
+
+	// Electrons are not included in the Monte Carlo calculation.
+	env, err := twodof.LoadIonEnv(in_path)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// Cool from Tmax to Tmin.
+	Ts := make([]float64, *numT)
+	for i := 0; i < *numT; i++ {
+		if *numT == 1 {
+			Ts[i] = *Tmax
+		} else {
+			Ts[i] = *Tmax - float64(i)*(*Tmax-*Tmin)/float64(*numT-1)
+		}
+	}
+	params := montecarlo.Params{L: *L, Thermalize: *therm, Sweeps: *sweeps, Seed: *seed}
+	sweep, err := montecarlo.Run(env, Ts, params)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// Write output.
+	mc_out_buf := bytes.NewBufferString(sweep.Marshal())
+	ioutil.WriteFile(out_path+"_montecarlo.json", mc_out_buf.Bytes(), 0644) // u=rw;go=r
+}
@@ -0,0 +1,108 @@
+package twodof
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+)
+import (
+	"github.com/tflovorn/cmatrix"
+	"github.com/tflovorn/scExplorer/bzone"
+	vec "github.com/tflovorn/scExplorer/vector"
+)
+
+// Step in Zeeman used to take the finite-difference spin susceptibility.
+const spin_dh = 1e-3
+
+// Return true if ElHamiltonian includes both spins: with a Zeeman field.
+func (env *Environment) spinful() bool {
+	return env.Zeeman != 0.0
+}
+
+// Number of spin blocks in ElHamiltonian: 2 if it includes both spins and 1
+// otherwise.
+func (env *Environment) spinBlocks() int {
+	if env.spinful() {
+		return 2
+	}
+	return 1
+}
+
+// Number of spin states per eigenstate of ElHamiltonian: 1 if it includes
+// both spins and 2 (the spin degeneracy) otherwise.
+func (env *Environment) spinDegeneracy() float64 {
+	return 2.0 / float64(env.spinBlocks())
+}
+
+// Return 1 for spin up (sigma = 0) and -1 for spin down (sigma = 1).
+func spinSign(sigma int) float64 {
+	if sigma == 0 {
+		return 1.0
+	}
+	return -1.0
+}
+
+// Return the magnetisation n_up - n_down, in the units of Filling (so that
+// n_up + n_down = Filling when Mu is solved). It is 0 if ElHamiltonian does
+// not include both spins.
+func (env *Environment) Magnetization() float64 {
+	if !env.spinful() {
+		return 0.0
+	}
+	n := env.numSpinlessStates()
+	H := cmatrix.NewCMatrixGSL(env.numStates(), env.numStates())
+	work, evals, evecs := cmatrix.HermEigensystemSetup(H)
+	inner := func(k vec.Vector) float64 {
+		ElHamiltonian(env, k, H)
+		cmatrix.HermEigensystem(H, work, evals, evecs)
+		sum := 0.0
+		for alpha := 0; alpha < 2*n; alpha++ {
+			// Eigenvectors are in columns (see evalEV).
+			w := 0.0
+			for i := 0; i < n; i++ {
+				w += math.Pow(cmplx.Abs(evecs.At(i, alpha)), 2.0) - math.Pow(cmplx.Abs(evecs.At(n+i, alpha)), 2.0)
+			}
+			sum += env.Fermi(evals.At(alpha)) * w
+		}
+		return sum
+	}
+	// Weighted as in the Mu equation.
+	m := env.foldScale() * bzone.Avg(env.BZPointsPerDim, 3, inner)
+
+	H.Destroy()
+	cmatrix.HermEigensystemCleanup(work, evals, evecs)
+	return m
+}
+
+// Return the spin susceptibility dm/dZeeman at the solved env, where m is
+// the Magnetization, with the M's named in fixed held at their values in env.
+// m is evaluated at Zeeman +/- spin_dh by solving the system again, starting
+// from the solution in env, so the derivative includes the response of the
+// order parameters and Mu. env is not modified.
+func SpinSusceptibility(env *Environment, epsAbs, epsRel float64, fixed []string) (float64, error) {
+	if env.IonsOnly {
+		return 0.0, fmt.Errorf("SpinSusceptibility requires the electrons (IonsOnly is set)")
+	}
+	mp, err := solvedMagnetization(env, env.Zeeman+spin_dh, epsAbs, epsRel, fixed)
+	if err != nil {
+		return 0.0, err
+	}
+	mm, err := solvedMagnetization(env, env.Zeeman-spin_dh, epsAbs, epsRel, fixed)
+	if err != nil {
+		return 0.0, err
+	}
+	return (mp - mm) / (2.0 * spin_dh), nil
+}
+
+// Solve a copy of env with the given Zeeman energy and return its
+// magnetisation.
+func solvedMagnetization(env *Environment, zeeman, epsAbs, epsRel float64, fixed []string) (float64, error) {
+	shifted := *env
+	shifted.Zeeman = zeeman
+	Ds := NewHoppingEV()
+	_, err := Solve(&shifted, Ds, epsAbs, epsRel, fixed)
+	if err != nil {
+		return 0.0, err
+	}
+	return shifted.Magnetization(), nil
+}
@@ -0,0 +1,104 @@
+package twodof
+
+import (
+	"github.com/tflovorn/scExplorer/solve"
+	vec "github.com/tflovorn/scExplorer/vector"
+	"github.com/tflovorn/vo2mft/meanfield"
+)
+
+// Stability labels (see meanfield.StabilityLabel).
+const (
+	Stable     = meanfield.Stable
+	Metastable = meanfield.Metastable
+	Unstable   = meanfield.Unstable
+	Unknown    = meanfield.Unknown
+)
+
+// Names of the order parameters of the default (twodof) ionic model; in
+// general these are given by Environment.IonVars. The free energy Hessian is
+// taken with respect to all of them. This includes M's which were held fixed
+// at 0 while solving, so that such solutions are labeled Unstable if finite
+// M lowers the free energy.
+var HessianVars = default_ions.Vars()
+
+// Return the Hessian of the Landau free energy per cell with respect to
+// IonVars, its eigenvalues (in ascending order) and the corresponding
+// stability label, evaluated at the solved env (see meanfield.Hessian).
+// env is not modified. If chi is singular, the Hessian diverges and nil is
+// returned for it and its eigenvalues.
+//
+// The Landau free energy G(x) is the mean-field free energy expressed as a
+// function of the order parameters x (IonVars), with the ionic fields chosen
+// to produce x. It is equal to FreeEnergy at a solution. Its Hessian there is
+// chi^{-1} J, where chi is the single-site susceptibility
+// d<(S_c, S^2_c)>/d(field) and J is the Jacobian of the self-consistency
+// residuals x - <(S_c, S^2_c)> with respect to x. Unlike vo2solve, which has
+// a single-site problem for each of the two sites of a cell, the single-site
+// problem here holds the components of both sublattices, so it covers a
+// whole cell and there is no factor of 2.
+// Unless env.IonsOnly is set, Mu is solved again at each displaced value of x
+// so that the Hessian is taken at fixed electron number.
+func Stability(env *Environment, epsAbs, epsRel float64) ([][]float64, []float64, string, error) {
+	Ds := NewHoppingEV()
+	jac, err := ResidualJacobian(env, Ds, env.IonVars(), epsAbs, epsRel)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	hess, evals, label := meanfield.Hessian(env.IonSusceptibility(Ds), jac, 1.0)
+	return hess, evals, label, nil
+}
+
+// Return the single-site susceptibility chi = BetaIons * Cov(O), where
+// O = (S_c, S_c^2) in the order of IonVars: the derivative of <O> with
+// respect to the fields coupling to O in the single-site ionic Hamiltonian.
+// If Gamma is nonzero, chi is the static (Kubo) susceptibility instead.
+// For clusters, chi is the susceptibility per site to fields applied to all
+// sites of the cluster.
+func (env *Environment) IonSusceptibility(Ds *HoppingEV) [][]float64 {
+	return env.IonAverages(Ds).Chi
+}
+
+// Return the Jacobian of the M and W self-consistency residuals (in the
+// order of IonVars) with respect to variables at env (see
+// meanfield.Jacobian).
+// Unless env.IonsOnly is set, Mu is solved for at each displaced point.
+func ResidualJacobian(env *Environment, Ds *HoppingEV, variables []string, epsAbs, epsRel float64) ([][]float64, error) {
+	x0 := make([]float64, len(variables))
+	for i, name := range variables {
+		x0[i] = env.GetFloat(name)
+	}
+	return meanfield.Jacobian(x0, func(x []float64) ([]float64, error) {
+		return fixedResiduals(env, Ds, x, variables, epsAbs, epsRel)
+	})
+}
+
+// Return the M and W self-consistency residuals of a copy of env with
+// variables set to the values in x. Unless env.IonsOnly is set, Mu is solved
+// for first.
+func fixedResiduals(env *Environment, Ds *HoppingEV, x vec.Vector, variables []string, epsAbs, epsRel float64) ([]float64, error) {
+	fixed := *env
+	fixed.Set(x, variables)
+	if !fixed.IonsOnly {
+		system, start := MuSystem(&fixed)
+		_, err := solve.MultiDim(system, start, epsAbs, epsRel)
+		if err != nil {
+			return nil, err
+		}
+	}
+	diffs := []solve.Diffable{}
+	for c := 0; c < fixed.numIonComponents(); c++ {
+		diffs = append(diffs, AbsErrorM(&fixed, Ds, variables, c))
+	}
+	for c := 0; c < fixed.numIonComponents(); c++ {
+		diffs = append(diffs, AbsErrorW(&fixed, Ds, variables, c))
+	}
+	R := make([]float64, len(diffs))
+	for i, diff := range diffs {
+		val, err := diff.F(x)
+		if err != nil {
+			return nil, err
+		}
+		R[i] = val
+	}
+	return R, nil
+}
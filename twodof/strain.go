@@ -0,0 +1,155 @@
+package twodof
+
+import (
+	"fmt"
+	"math"
+)
+
+// Unit vectors along the in-plane displacement directions of the two
+// sublattices: [110] for p = 0 and [1-10] for p = 1.
+var disp_dir = [2][3]float64{[3]float64{1.0 / math.Sqrt2, 1.0 / math.Sqrt2, 0.0},
+	[3]float64{1.0 / math.Sqrt2, -1.0 / math.Sqrt2, 0.0}}
+
+// Directions of the body diagonal bonds from sublattice 0 to sublattice 1,
+// in the order of the terms in EpsilonBE (the lattice is treated as cubic).
+var diag_dir = [4][3]float64{[3]float64{1.0, 1.0, 1.0}, [3]float64{-1.0, 1.0, 1.0},
+	[3]float64{1.0, -1.0, 1.0}, [3]float64{1.0, 1.0, -1.0}}
+
+// Substrate orientations for which an epitaxial strain preset is available.
+var substrates = []string{"", "001", "100", "110"}
+
+// Dependence of the hoppings on bond length d (with unstrained length d0):
+// "power" gives t = t0 (d/d0)^(-Eta) and "exponential" gives
+// t = t0 exp(-Eta (d-d0)/d0). The two agree to linear order in the strain.
+var hopping_scalings = []string{"power", "exponential"}
+
+// Return the total strain tensor: the sum of the explicitly given components,
+// the uniaxial [110] strain specified by Fac_xy and Poisson, and the
+// epitaxial strain specified by Substrate, Misfit_a and Misfit_c.
+func (env *Environment) Strain() [3][3]float64 {
+	var e [3][3]float64
+	e[0][0], e[1][1], e[2][2] = env.Strain_xx, env.Strain_yy, env.Strain_zz
+	e[0][1], e[0][2], e[1][2] = env.Strain_xy, env.Strain_xz, env.Strain_yz
+
+	// Uniaxial strain Fac_xy along [110], with Poisson contraction along
+	// [1-10] and [001].
+	F, nu := env.Fac_xy, env.Poisson
+	e[0][0] += 0.5 * (1.0 - nu) * F
+	e[1][1] += 0.5 * (1.0 - nu) * F
+	e[0][1] += 0.5 * (1.0 + nu) * F
+	e[2][2] += -nu * F
+
+	// Epitaxial film: in-plane strain fixed by the substrate, out-of-plane
+	// strain from the Poisson relaxation of a free surface.
+	ea, ec := env.Misfit_a, env.Misfit_c
+	relax := -nu / (1.0 - nu)
+	switch env.Substrate {
+	case "001":
+		// In-plane [100] and [010]; normal [001].
+		e[0][0] += ea
+		e[1][1] += ea
+		e[2][2] += relax * 2.0 * ea
+	case "100":
+		// In-plane [010] and [001]; normal [100].
+		e[1][1] += ea
+		e[2][2] += ec
+		e[0][0] += relax * (ea + ec)
+	case "110":
+		// In-plane [1-10] and [001]; normal [110].
+		e_n := relax * (ea + ec)
+		e[0][0] += 0.5 * (e_n + ea)
+		e[1][1] += 0.5 * (e_n + ea)
+		e[0][1] += 0.5 * (e_n - ea)
+		e[2][2] += ec
+	}
+
+	e[1][0], e[2][0], e[2][1] = e[0][1], e[0][2], e[1][2]
+	return e
+}
+
+// Return the strain components (along, perpendicular to) the in-plane
+// displacement direction of sublattice p, and along c.
+func (env *Environment) StrainProjections(p int) (float64, float64, float64) {
+	e := env.Strain()
+	d := disp_dir[p]
+	perp := disp_dir[1-p]
+	return strainAlong(e, d), strainAlong(e, perp), e[2][2]
+}
+
+// Return the linear strain scaling factor
+// 1 + c_par*e_par + c_perp*e_perp + c_zz*e_zz for sublattice p.
+func (env *Environment) strainFactor(p int, c_par, c_perp, c_zz float64) float64 {
+	e_par, e_perp, e_zz := env.StrainProjections(p)
+	return 1.0 + c_par*e_par + c_perp*e_perp + c_zz*e_zz
+}
+
+// Return the normal strain n.e.n along the unit vector n.
+func strainAlong(e [3][3]float64, n [3]float64) float64 {
+	val := 0.0
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			val += n[i] * e[i][j] * n[j]
+		}
+	}
+	return val
+}
+
+// Return an error if Substrate does not name a known preset.
+func (env *Environment) checkSubstrate() error {
+	for _, s := range substrates {
+		if env.Substrate == s {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown Substrate %v; expected one of %v", env.Substrate, substrates)
+}
+
+// Hoppings along c for the strained lattice.
+func (env *Environment) Tce_eff() float64 {
+	e := env.Strain()
+	return env.Tce * hoppingScale(env.HoppingScaling, env.Eta_c, e[2][2])
+}
+
+func (env *Environment) Tco_eff() float64 {
+	e := env.Strain()
+	return env.Tco * hoppingScale(env.HoppingScaling, env.Eta_c, e[2][2])
+}
+
+// Hopping along body diagonal i (see diag_dir) for the strained lattice.
+// Shear strain splits the four diagonals.
+func (env *Environment) Tbe_eff(i int) float64 {
+	e := env.Strain()
+	d := diag_dir[i]
+	n := [3]float64{d[0] / math.Sqrt(3.0), d[1] / math.Sqrt(3.0), d[2] / math.Sqrt(3.0)}
+	return env.Tbe * hoppingScale(env.HoppingScaling, env.Eta_b, strainAlong(e, n))
+}
+
+// Return the strained hoppings (Tce, Tco, Tbe along each diagonal).
+func (env *Environment) Hoppings() [6]float64 {
+	return [6]float64{env.Tce_eff(), env.Tco_eff(), env.Tbe_eff(0), env.Tbe_eff(1), env.Tbe_eff(2), env.Tbe_eff(3)}
+}
+
+// Return the factor t/t0 for a bond with relative change in length e, using
+// the given scaling ("" is equivalent to "power") and exponent eta.
+func hoppingScale(scaling string, eta, e float64) float64 {
+	if eta == 0.0 {
+		return 1.0
+	}
+	if scaling == "exponential" {
+		return math.Exp(-eta * e)
+	}
+	return math.Pow(1.0+e, -eta)
+}
+
+// Return an error if HoppingScaling does not name a known scaling.
+func (env *Environment) checkHoppingScaling() error {
+	if env.HoppingScaling == "" {
+		return nil
+	}
+	for _, s := range hopping_scalings {
+		if env.HoppingScaling == s {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown HoppingScaling %v; expected one of %v", env.HoppingScaling, hopping_scalings)
+}
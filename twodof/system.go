@@ -1,138 +1,87 @@
 package twodof
 
+import (
+	"fmt"
+)
 import (
 	"github.com/tflovorn/scExplorer/solve"
 	vec "github.com/tflovorn/scExplorer/vector"
 )
 
-func MWSystem(env *Environment, Ds *HoppingEV, m01_0, m11_0, m02_0, m12_0 bool) (solve.DiffSystem, []float64) {
-	variables, start := []string{}, []float64{}
-	diff_list := []solve.Diffable{}
+// Return the system of self-consistency equations for the M's (other than
+// those named in fixed, which are held at their values in env) and W's of
+// the ionic model, and its starting point.
+func MWSystem(env *Environment, Ds *HoppingEV, fixed []string) (solve.DiffSystem, []float64) {
+	return ionSystem(env, Ds, fixed, false)
+}
 
-	if !m01_0 {
-		variables = append(variables, "M01")
-		start = append(start, env.M01)
-	}
-	if !m11_0 {
-		variables = append(variables, "M11")
-		start = append(start, env.M11)
-	}
-	if !m02_0 {
-		variables = append(variables, "M02")
-		start = append(start, env.M02)
-	}
-	if !m12_0 {
-		variables = append(variables, "M12")
-		start = append(start, env.M12)
-	}
-	variables = append(variables, "W01")
-	start = append(start, env.W01)
-	variables = append(variables, "W11")
-	start = append(start, env.W11)
-	variables = append(variables, "W02")
-	start = append(start, env.W02)
-	variables = append(variables, "W12")
-	start = append(start, env.W12)
+// Return the system of MWSystem together with the equation for Mu.
+func MWMuSystem(env *Environment, Ds *HoppingEV, fixed []string) (solve.DiffSystem, []float64) {
+	return ionSystem(env, Ds, fixed, true)
+}
 
-	if !m01_0 {
-		diffM01 := AbsErrorM(env, Ds, variables, 0, 1)
-		diff_list = append(diff_list, diffM01)
+// Return the system of self-consistency equations for the M's not in fixed,
+// the W's and, if withMu is set, Mu.
+func ionSystem(env *Environment, Ds *HoppingEV, fixed []string, withMu bool) (solve.DiffSystem, []float64) {
+	n := env.numIonComponents()
+	vars := env.IonVars()
+	free := []int{}
+	for c := 0; c < n; c++ {
+		if !containsName(fixed, vars[c]) {
+			free = append(free, c)
+		}
 	}
-	if !m11_0 {
-		diffM11 := AbsErrorM(env, Ds, variables, 1, 1)
-		diff_list = append(diff_list, diffM11)
 
+	variables := []string{}
+	for _, c := range free {
+		variables = append(variables, vars[c])
+	}
+	variables = append(variables, vars[n:]...)
+	if withMu {
+		variables = append(variables, "Mu")
+	}
+	start := make([]float64, len(variables))
+	for i, name := range variables {
+		start[i] = env.GetFloat(name)
+	}
+
+	diff_list := []solve.Diffable{}
+	for _, c := range free {
+		diff_list = append(diff_list, AbsErrorM(env, Ds, variables, c))
+	}
+	for c := 0; c < n; c++ {
+		diff_list = append(diff_list, AbsErrorW(env, Ds, variables, c))
+	}
+	if withMu {
+		diff_list = append(diff_list, AbsErrorMu(env, variables))
 	}
-	if !m02_0 {
-		diffM02 := AbsErrorM(env, Ds, variables, 0, 2)
-		diff_list = append(diff_list, diffM02)
-	}
-	if !m12_0 {
-		diffM12 := AbsErrorM(env, Ds, variables, 1, 2)
-		diff_list = append(diff_list, diffM12)
-	}
-	diffW01 := AbsErrorW(env, Ds, variables, 0, 1)
-	diff_list = append(diff_list, diffW01)
-	diffW11 := AbsErrorW(env, Ds, variables, 1, 1)
-	diff_list = append(diff_list, diffW11)
-	diffW02 := AbsErrorW(env, Ds, variables, 0, 2)
-	diff_list = append(diff_list, diffW02)
-	diffW12 := AbsErrorW(env, Ds, variables, 1, 2)
-	diff_list = append(diff_list, diffW12)
 
 	system := solve.Combine(diff_list)
 	return system, start
 }
 
-func MWMuSystem(env *Environment, Ds *HoppingEV, m01_0, m11_0, m02_0, m12_0 bool) (solve.DiffSystem, []float64) {
-	variables, start := []string{}, []float64{}
-	diff_list := []solve.Diffable{}
-
-	if !m01_0 {
-		variables = append(variables, "M01")
-		start = append(start, env.M01)
-	}
-	if !m11_0 {
-		variables = append(variables, "M11")
-		start = append(start, env.M11)
-	}
-	if !m02_0 {
-		variables = append(variables, "M02")
-		start = append(start, env.M02)
-	}
-	if !m12_0 {
-		variables = append(variables, "M12")
-		start = append(start, env.M12)
-	}
-	variables = append(variables, "W01")
-	start = append(start, env.W01)
-	variables = append(variables, "W11")
-	start = append(start, env.W11)
-	variables = append(variables, "W02")
-	start = append(start, env.W02)
-	variables = append(variables, "W12")
-	start = append(start, env.W12)
-
-	variables = append(variables, "Mu")
-	start = append(start, env.Mu)
-
-	if !m01_0 {
-		diffM01 := AbsErrorM(env, Ds, variables, 0, 1)
-		diff_list = append(diff_list, diffM01)
-	}
-	if !m11_0 {
-		diffM11 := AbsErrorM(env, Ds, variables, 1, 1)
-		diff_list = append(diff_list, diffM11)
-	}
-	if !m02_0 {
-		diffM02 := AbsErrorM(env, Ds, variables, 0, 2)
-		diff_list = append(diff_list, diffM02)
-	}
-	if !m12_0 {
-		diffM12 := AbsErrorM(env, Ds, variables, 1, 2)
-		diff_list = append(diff_list, diffM12)
-	}
-	diffW01 := AbsErrorW(env, Ds, variables, 0, 1)
-	diff_list = append(diff_list, diffW01)
-	diffW11 := AbsErrorW(env, Ds, variables, 1, 1)
-	diff_list = append(diff_list, diffW11)
-	diffW02 := AbsErrorW(env, Ds, variables, 0, 2)
-	diff_list = append(diff_list, diffW02)
-	diffW12 := AbsErrorW(env, Ds, variables, 1, 2)
-	diff_list = append(diff_list, diffW12)
-
+func MuSystem(env *Environment) (solve.DiffSystem, []float64) {
+	variables := []string{"Mu"}
 	diffMu := AbsErrorMu(env, variables)
-	diff_list = append(diff_list, diffMu)
-
-	system := solve.Combine(diff_list)
+	system := solve.Combine([]solve.Diffable{diffMu})
+	start := []float64{env.Mu}
 	return system, start
 }
 
-func MWSolve(env *Environment, Ds *HoppingEV, epsAbs, epsRel float64, m01_0, m11_0, m02_0, m12_0 bool) (vec.Vector, error) {
-	if m01_0 && m11_0 && m02_0 && m12_0 {
+// Solve for the variables of MWSystem in-place with Mu fixed; at T = 0, by
+// the ground-state iteration (see groundStateSolve).
+func MWSolve(env *Environment, Ds *HoppingEV, epsAbs, epsRel float64, fixed []string) (vec.Vector, error) {
+	err := env.CheckFixed(fixed)
+	if err != nil {
+		return nil, err
+	}
+	if len(fixed) == env.numIonComponents() {
 		return []float64{}, nil
 	}
-	system, start := MWSystem(env, Ds, m01_0, m11_0, m02_0, m12_0)
+	if env.ZeroTemperature() {
+		return groundStateSolve(env, Ds, epsAbs, epsRel, fixed, false)
+	}
+	system, start := MWSystem(env, Ds, fixed)
 	solution, err := solve.MultiDim(system, start, epsAbs, epsRel)
 	if err != nil {
 		return nil, err
@@ -140,11 +89,55 @@ func MWSolve(env *Environment, Ds *HoppingEV, epsAbs, epsRel float64, m01_0, m11
 	return solution, nil
 }
 
-func MWMuSolve(env *Environment, Ds *HoppingEV, epsAbs, epsRel float64, m01_0, m11_0, m02_0, m12_0 bool) (vec.Vector, error) {
-	system, start := MWMuSystem(env, Ds, m01_0, m11_0, m02_0, m12_0)
+// Solve for the variables of MWMuSystem in-place; at T = 0, by the
+// ground-state iteration (see groundStateSolve).
+func MWMuSolve(env *Environment, Ds *HoppingEV, epsAbs, epsRel float64, fixed []string) (vec.Vector, error) {
+	err := env.CheckFixed(fixed)
+	if err != nil {
+		return nil, err
+	}
+	if env.ZeroTemperature() {
+		return groundStateSolve(env, Ds, epsAbs, epsRel, fixed, true)
+	}
+	system, start := MWMuSystem(env, Ds, fixed)
 	solution, err := solve.MultiDim(system, start, epsAbs, epsRel)
 	if err != nil {
 		return nil, err
 	}
 	return solution, nil
 }
+
+// Solve for the self-consistent variables of env in-place: the M's and W's if
+// env.IonsOnly is set, or the M's, W's and Mu otherwise. The M's named in
+// fixed are held at their values in env.
+func Solve(env *Environment, Ds *HoppingEV, epsAbs, epsRel float64, fixed []string) (vec.Vector, error) {
+	if env.IonsOnly {
+		return MWSolve(env, Ds, epsAbs, epsRel, fixed)
+	}
+	return MWMuSolve(env, Ds, epsAbs, epsRel, fixed)
+}
+
+// Return an error if fixed contains a name which is not an M of the ionic
+// model, or contains a name more than once.
+func (env *Environment) CheckFixed(fixed []string) error {
+	Ms := env.IonVars()[:env.numIonComponents()]
+	for i, name := range fixed {
+		if !containsName(Ms, name) {
+			return fmt.Errorf("Cannot fix %v; expected one of %v", name, Ms)
+		}
+		if containsName(fixed[:i], name) {
+			return fmt.Errorf("%v is fixed more than once", name)
+		}
+	}
+	return nil
+}
+
+// Return true if names contains name.
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -8,17 +8,16 @@ import (
 	vec "github.com/tflovorn/scExplorer/vector"
 )
 
-// Return the absolute error and gradient of the M_{p, alpha} equation w.r.t.
-// the given variables.
-func AbsErrorM(env *Environment, Ds *HoppingEV, variables []string, p, alpha int) solve.Diffable {
-	M_name := []string{"M01", "M11", "M02", "M12"}
-	name_index := p + 2*(alpha-1)
+// Return the absolute error and gradient of the M equation of the ionic
+// component c w.r.t. the given variables.
+func AbsErrorM(env *Environment, Ds *HoppingEV, variables []string, c int) solve.Diffable {
+	M_name := env.IonVars()[c]
 
 	F := func(v vec.Vector) (float64, error) {
 		env.Set(v, variables)
-		M_env := env.GetFloat(M_name[name_index])
-		M_eq := env.Mpa(p, alpha, Ds)
-		//fmt.Printf("p = %d, alpha = %d, M_env = %f, M_eq = %f\n", p, alpha, M_env, M_eq)
+		M_env := env.GetFloat(M_name)
+		M_eq := env.ionAverage(c, false, Ds)
+		//fmt.Printf("c = %d, M_env = %f, M_eq = %f\n", c, M_env, M_eq)
 		return M_env - M_eq, nil
 	}
 	h := 1e-6
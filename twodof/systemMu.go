@@ -13,13 +13,14 @@ func AbsErrorMu(env *Environment, variables []string) solve.Diffable {
 	F := func(v vec.Vector) (float64, error) {
 		env.Set(v, variables)
 		L := env.BZPointsPerDim
-		H := cmatrix.NewCMatrixGSL(4, 4)
+		H := cmatrix.NewCMatrixGSL(env.numStates(), env.numStates())
 		work, evals, evecs := cmatrix.HermEigensystemSetup(H)
 		innerClosure := func(k vec.Vector) float64 {
 			return innerMu(env, k, H, work, evals, evecs)
 		}
-		lhs := 1.0
-		rhs := bzone.Avg(L, 3, innerClosure)
+		lhs := env.Filling
+		// Weighted for Q other than the default (see wavevector.go).
+		rhs := env.foldScale() * bzone.Avg(L, 3, innerClosure)
 
 		H.Destroy()
 		cmatrix.HermEigensystemCleanup(work, evals, evecs)
@@ -39,6 +40,6 @@ func innerMu(env *Environment, k vec.Vector, H *cmatrix.CMatrixGSL, work *cmatri
 		// Mu is included in H, so not included here.
 		sum += env.Fermi(evals.At(alpha))
 	}
-	// Multiply by 2 for spin degeneracy.
-	return 2.0 * sum
+	// Multiply by 2 for spin degeneracy, unless H includes both spins.
+	return env.spinDegeneracy() * sum
 }
@@ -8,17 +8,16 @@ import (
 	vec "github.com/tflovorn/scExplorer/vector"
 )
 
-// Return the absolute error and gradient of the W_{p, alpha} equation w.r.t.
-// the given variables.
-func AbsErrorW(env *Environment, Ds *HoppingEV, variables []string, p, alpha int) solve.Diffable {
-	W_name := []string{"W01", "W11", "W02", "W12"}
-	name_index := p + 2*(alpha-1)
+// Return the absolute error and gradient of the W equation of the ionic
+// component c w.r.t. the given variables.
+func AbsErrorW(env *Environment, Ds *HoppingEV, variables []string, c int) solve.Diffable {
+	W_name := env.IonVars()[c+env.numIonComponents()]
 
 	F := func(v vec.Vector) (float64, error) {
 		env.Set(v, variables)
-		W_env := env.GetFloat(W_name[name_index])
-		W_eq := env.Wpa(p, alpha, Ds)
-		//fmt.Printf("p = %d, alpha = %d, M_env = %f, M_eq = %f\n", p, alpha, M_env, M_eq)
+		W_env := env.GetFloat(W_name)
+		W_eq := env.ionAverage(c, true, Ds)
+		//fmt.Printf("c = %d, W_env = %f, W_eq = %f\n", c, W_env, W_eq)
 		return W_env - W_eq, nil
 	}
 	h := 1e-6
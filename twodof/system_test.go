@@ -1,11 +1,21 @@
 package twodof
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"math/cmplx"
+	"os"
+	"sort"
 	"testing"
 )
 import (
+	"github.com/tflovorn/cmatrix"
 	"github.com/tflovorn/scExplorer/solve"
+	vec "github.com/tflovorn/scExplorer/vector"
+	"github.com/tflovorn/vo2mft/tetra"
+	"github.com/tflovorn/vo2mft/tightbinding"
 )
 
 func TestSolveSystem(t *testing.T) {
@@ -18,7 +28,7 @@ func TestSolveSystem(t *testing.T) {
 	Ds := NewHoppingEV()
 
 	eps := 1e-9
-	result, err := MWMuSolve(env, Ds, eps, eps, false, false, false, false)
+	result, err := MWMuSolve(env, Ds, eps, eps, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -35,9 +45,1125 @@ func TestSolveSystemIons(t *testing.T) {
 	Ds := NewHoppingEV()
 
 	eps := 1e-9
-	result, err := MWSolve(env, Ds, eps, eps, false, false, false, false)
+	result, err := MWSolve(env, Ds, eps, eps, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 	fmt.Println(result)
 }
+
+func TestSolveSystemDoped(t *testing.T) {
+	// Mu must increase with the electron filling.
+	last_Mu := math.Inf(-1)
+	for _, filling := range []float64{0.9, 1.0, 1.1} {
+		env, err := LoadEnv("system_test_env.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if env.Filling != 1.0 {
+			t.Fatalf("Incorrect default Filling = %f; expected 1", env.Filling)
+		}
+		env.Filling = filling
+		Ds := NewHoppingEV()
+
+		eps := 1e-9
+		_, err = MWMuSolve(env, Ds, eps, eps, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Println("Filling = ", filling, "Mu = ", env.Mu)
+		if env.Mu <= last_Mu {
+			t.Fatalf("Mu = %f at Filling = %f does not increase with filling", env.Mu, filling)
+		}
+		last_Mu = env.Mu
+	}
+}
+
+func TestRegressionFreeEnergy(t *testing.T) {
+	env, err := LoadEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.BZPointsPerDim = 4
+	// At Filling = 1, the free energy is the grand potential of the
+	// original model (which has no Mu N term, and with the Kczz and Kcxz
+	// constants of EConst_Ion) plus the Mu N term Mu/2.
+	expected := -14.339825331377696 + 0.5*env.Mu
+	F := env.FreeEnergy(NewHoppingEV())
+	if math.Abs(F-expected) > 1e-9 {
+		t.Fatalf("Incorrect FreeEnergy = %v at Filling = 1; expected %v", F, expected)
+	}
+}
+
+func TestThermodynamicsIons(t *testing.T) {
+	env, err := LoadIonEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without intersite couplings, each component is an independent
+	// three-state system with energies B S^2, so that S and C have a
+	// closed form.
+	env.Jb0, env.Jc0, env.Kcxx0, env.Kczz0 = 0.0, 0.0, 0.0, 0.0
+	env.Bzz0, env.Bxy0 = 0.3, -0.2
+	env.Beta = 2.0
+	eps := 1e-9
+	_, err = MWSolve(env, NewHoppingEV(), eps, eps, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	S, C, err := Thermodynamics(env, eps, eps, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected_S, expected_C := 0.0, 0.0
+	// Two components (01 and 12) have B = Bzz and two (11 and 02) B = Bxy.
+	for _, B := range []float64{env.Bzz0, env.Bzz0, env.Bxy0, env.Bxy0} {
+		x := 2.0 * math.Exp(-env.Beta*B)
+		Z := 1.0 + x
+		E, E2 := B*x/Z, B*B*x/Z
+		expected_S += math.Log(Z) + env.Beta*E
+		expected_C += env.Beta * env.Beta * (E2 - E*E)
+	}
+	fmt.Println("S = ", S, "C = ", C)
+	if math.Abs(S-expected_S) > 1e-3*expected_S || math.Abs(C-expected_C) > 1e-3*expected_C {
+		t.Fatalf("Got S = %v, C = %v; expected S = %v, C = %v", S, C, expected_S, expected_C)
+	}
+	if env.Beta != 2.0 {
+		t.Fatalf("Thermodynamics modified env")
+	}
+
+	// S and C vanish at T = 0.
+	zero_env := *env
+	zero_env.Beta = math.Inf(1)
+	S, C, err = Thermodynamics(&zero_env, eps, eps, nil)
+	if err != nil || S != 0.0 || C != 0.0 {
+		t.Fatalf("Got S = %v, C = %v, error %v at T = 0; expected S = C = 0", S, C, err)
+	}
+	// The temperature derivatives require a single temperature.
+	two_env := *env
+	two_env.BetaIon = 2.0 * env.Beta
+	_, _, err = Thermodynamics(&two_env, eps, eps, nil)
+	if err == nil {
+		t.Fatalf("Expected error for BetaIon different from Beta")
+	}
+}
+
+func TestStrainFacXy(t *testing.T) {
+	env, err := LoadEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Fac_xy, env.Poisson = 0.03, 0.3
+	// The default strain couplings reproduce the uniaxial [110] scaling.
+	expected := []float64{(1 - env.Fac_xy) * env.Bxy0, (1 + env.Poisson*env.Fac_xy) * env.Bxy0,
+		(1 + env.Poisson*env.Fac_xy) * env.Bzz0, (1 + env.Poisson*env.Fac_xy) * env.Bzz0}
+	vals := []float64{env.Bxy(0), env.Bxy(1), env.Bzz(0), env.Bzz(1)}
+	for i, v := range vals {
+		if math.Abs(v-expected[i]) > 1e-12 {
+			t.Fatalf("strain scaled parameter %d = %v; expected %v", i, v, expected[i])
+		}
+	}
+}
+
+func TestSubstratePresets(t *testing.T) {
+	data, err := ioutil.ReadFile("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ea, ec, nu := 0.01, -0.02, 0.3
+	relax := -nu / (1.0 - nu)
+	// Expected strain along [110], along [1-10] and along c for each preset.
+	expected := map[string][3]float64{
+		"":    [3]float64{0.0, 0.0, 0.0},
+		"001": [3]float64{ea, ea, 2.0 * relax * ea},
+		"100": [3]float64{0.5 * (ea + relax*(ea+ec)), 0.5 * (ea + relax*(ea+ec)), ec},
+		"110": [3]float64{relax * (ea + ec), ea, ec},
+	}
+	for _, substrate := range substrates {
+		fields := map[string]interface{}{}
+		err = json.Unmarshal(data, &fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields["Substrate"], fields["Misfit_a"], fields["Misfit_c"], fields["Poisson"] = substrate, ea, ec, nu
+		env_data, err := json.Marshal(fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		env, err := NewEnvironment(string(env_data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		e_par, e_perp, e_zz := env.StrainProjections(0)
+		strains := []float64{e_par, e_perp, e_zz}
+		for i, v := range strains {
+			if math.Abs(v-expected[substrate][i]) > 1e-12 {
+				t.Fatalf("Substrate %q gives strains %v; expected %v", substrate, strains, expected[substrate])
+			}
+		}
+		// With the default couplings, Bxy scales with the strain along the
+		// displacement direction and Bzz with the strain along c.
+		e_par1, _, _ := env.StrainProjections(1)
+		vals := []float64{env.Bxy(0), env.Bxy(1), env.Bzz(0), env.Bzz(1)}
+		params := []float64{(1 - e_par) * env.Bxy0, (1 - e_par1) * env.Bxy0, (1 - e_zz) * env.Bzz0, (1 - e_zz) * env.Bzz0}
+		for i, v := range vals {
+			if math.Abs(v-params[i]) > 1e-12 {
+				t.Fatalf("Substrate %q gives strain scaled parameter %d = %v; expected %v", substrate, i, v, params[i])
+			}
+		}
+	}
+	_, err = NewEnvironment(`{"Substrate": "111"}`)
+	if err == nil {
+		t.Fatalf("Expected error for an unknown Substrate")
+	}
+}
+
+func TestStrainedHoppings(t *testing.T) {
+	env, err := LoadEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.BZPointsPerDim = 4
+	Ds := NewHoppingEV()
+	Dco := Ds.Dco(env)
+
+	env.Strain_zz, env.Eta_c = 0.01, 3.0
+	expected := math.Pow(1.01, -3.0)
+	if math.Abs(env.Tce_eff()-env.Tce*expected) > 1e-12 || math.Abs(env.Tco_eff()-env.Tco*expected) > 1e-12 {
+		t.Fatalf("power law (Tce, Tco) = (%v, %v); expected (%v, %v)", env.Tce_eff(), env.Tco_eff(), env.Tce*expected, env.Tco*expected)
+	}
+	for i := 0; i < 4; i++ {
+		if env.Tbe_eff(i) != env.Tbe {
+			t.Fatalf("Tbe should be independent of strain when Eta_b = 0")
+		}
+	}
+	env.HoppingScaling = "exponential"
+	expected = math.Exp(-0.03)
+	if math.Abs(env.Tce_eff()-env.Tce*expected) > 1e-12 {
+		t.Fatalf("exponential Tce = %v; expected %v", env.Tce_eff(), env.Tce*expected)
+	}
+	// Shear strain lengthens the [111] diagonal and shortens [-111].
+	env.Strain_xy, env.Eta_b = 0.01, 4.0
+	if env.Tbe_eff(0) >= env.Tbe || env.Tbe_eff(1) <= env.Tbe {
+		t.Fatalf("Shear strain gives Tbe = %v, %v; expected below and above %v", env.Tbe_eff(0), env.Tbe_eff(1), env.Tbe)
+	}
+	// Cached hopping expectation values must be recalculated under strain.
+	if Ds.Dco(env) == Dco {
+		t.Fatalf("Dco not recalculated after changing strain")
+	}
+}
+
+func TestTransverseIons(t *testing.T) {
+	env, err := LoadIonEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.M01, env.M11, env.M02, env.M12 = 0.3, 0.1, -0.2, 0.4
+	env.W01, env.W11, env.W02, env.W12 = 0.6, 0.5, 0.4, 0.7
+	Ds := NewHoppingEV()
+	Z1, M01, W12, chi := env.Z1(Ds), env.Mpa(0, 1, Ds), env.Wpa(1, 2, Ds), env.IonSusceptibility(Ds)
+	// A vanishingly small Gamma must reproduce the classical values.
+	env.Gamma = 1e-8
+	q := env.quantumIon(env.ionSite(Ds))
+	Z1_q, avgs, chi_q := math.Exp(q.LogZ1), q.Avgs, q.Chi
+	tol := 1e-6
+	if math.Abs(Z1_q-Z1) > tol*Z1 || math.Abs(avgs[0]-M01) > tol || math.Abs(avgs[7]-W12) > tol {
+		t.Fatalf("Gamma -> 0 gives (Z1, M01, W12) = (%v, %v, %v); expected (%v, %v, %v)", Z1_q, avgs[0], avgs[7], Z1, M01, W12)
+	}
+	for a := range chi {
+		for b := range chi[a] {
+			if math.Abs(chi_q[a][b]-chi[a][b]) > tol*math.Max(1.0, math.Abs(chi[a][b])) {
+				t.Fatalf("Gamma -> 0 gives chi[%d][%d] = %v; expected %v", a, b, chi_q[a][b], chi[a][b])
+			}
+		}
+	}
+	// Tunnelling suppresses the order.
+	eps := 1e-9
+	env.Gamma = 0.0
+	env.Set([]float64{0.9, 0.9, 0.9, 0.9, 1.0, 1.0, 1.0, 1.0}, HessianVars)
+	_, err = MWSolve(env, Ds, eps, eps, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	M_classical := env.M01
+	env.Gamma = 0.5
+	_, err = MWSolve(env, Ds, eps, eps, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("M01 (Gamma = 0) = ", M_classical, "M01 (Gamma = ", env.Gamma, ") = ", env.M01)
+	if math.Abs(env.M01) >= math.Abs(M_classical) {
+		t.Fatalf("Gamma did not reduce M01: %v >= %v", math.Abs(env.M01), math.Abs(M_classical))
+	}
+}
+
+func TestTwoTemperatures(t *testing.T) {
+	env, err := LoadEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.BZPointsPerDim = 4
+	env.Beta = 10.0
+	hot := *env
+	hot.Beta = 1.0
+	Ds := NewHoppingEV()
+	// The ions only see BetaIon and the electrons only see BetaEl.
+	env.BetaIon, env.BetaEl = 1.0, 1.0
+	if env.Mpa(0, 1, Ds) != hot.Mpa(0, 1, Ds) || env.Fermi(0.3) != hot.Fermi(0.3) {
+		t.Fatalf("BetaIon = BetaEl = 1 differs from Beta = 1")
+	}
+	if env.FreeEnergyIons(Ds) != hot.FreeEnergyIons(Ds) || env.FreeEnergyElectrons() != hot.FreeEnergyElectrons() {
+		t.Fatalf("Free energy at BetaIon = BetaEl = 1 differs from Beta = 1")
+	}
+	env.BetaEl = 0.0
+	if env.Fermi(0.3) == hot.Fermi(0.3) || env.Mpa(0, 1, Ds) != hot.Mpa(0, 1, Ds) {
+		t.Fatalf("Unset BetaEl does not fall back to Beta")
+	}
+	_, _, err = Thermodynamics(env, 1e-9, 1e-9, nil)
+	if err == nil {
+		t.Fatalf("Thermodynamics accepted separate electron and ion temperatures")
+	}
+}
+
+func TestDimerCluster(t *testing.T) {
+	env, err := LoadIonEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Ds := NewHoppingEV()
+	// Without couplings along c, the dimer reduces to two independent sites.
+	env.Jc0, env.Kcxx0, env.Kczz0, env.Kcxz0 = 0.0, 0.0, 0.0, 0.0
+	Z1, M01, W12 := env.Z1(Ds), env.Mpa(0, 1, Ds), env.Wpa(1, 2, Ds)
+	env.Cluster = "dimer"
+	Z1_d, M01_d, W12_d := env.Z1(Ds), env.Mpa(0, 1, Ds), env.Wpa(1, 2, Ds)
+	tol := 1e-9
+	if math.Abs(Z1_d-Z1) > tol*Z1 || math.Abs(M01_d-M01) > tol || math.Abs(W12_d-W12) > tol {
+		t.Fatalf("Uncoupled dimer gives (Z1, M01, W12) = (%v, %v, %v); expected (%v, %v, %v)", Z1_d, M01_d, W12_d, Z1, M01, W12)
+	}
+
+	env, err = LoadIonEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Cluster = "dimer"
+	eps := 1e-9
+	result, err := MWSolve(env, Ds, eps, eps, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(result)
+	single, err := LoadIonEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = MWSolve(single, NewHoppingEV(), eps, eps, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The solution is self-consistent for the dimer.
+	avgs := env.IonAverages(NewHoppingEV()).Avgs
+	for i, name := range env.IonVars() {
+		if math.Abs(avgs[i]-env.GetFloat(name)) > 1e-6 {
+			t.Fatalf("Dimer solution has %v = %v but <%v> = %v", name, env.GetFloat(name), name, avgs[i])
+		}
+	}
+	// Treating the c-axis bonds exactly includes fluctuations which the
+	// single-site problem neglects, so the order is reduced.
+	for _, name := range []string{"M01", "M11"} {
+		M_d, M_s := env.GetFloat(name), single.GetFloat(name)
+		if math.Abs(M_d) > math.Abs(M_s) || math.Abs(M_d-M_s) > 1e-2 {
+			t.Fatalf("Dimer gives %v = %v; expected slightly below the single-site %v", name, M_d, M_s)
+		}
+	}
+}
+
+func TestStabilityIons(t *testing.T) {
+	eps := 1e-9
+	env, err := LoadIonEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// At low temperature, the solution with all four components ordered is
+	// a minimum of the free energy.
+	env.M02, env.M12 = 0.9, 0.9
+	_, err = MWSolve(env, NewHoppingEV(), eps, eps, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hess, evals, label, err := Stability(env, eps, eps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("evals = ", evals, label)
+	if label != Stable {
+		t.Fatalf("Incorrect stability %s for the ordered solution; expected %s", label, Stable)
+	}
+	// The Hessian is the derivative of the Landau gradient, both per cell.
+	// The ions are close to saturation, so the finite differences only
+	// agree to within about 1%.
+	vars := env.IonVars()
+	h := 1e-5
+	for j, name := range vars {
+		grads := [][]float64{}
+		for _, sign := range []float64{1.0, -1.0} {
+			e := *env
+			e.Set([]float64{env.GetFloat(name) + sign*h}, []string{name})
+			grad, _, _, err := LandauGradient(&e, NewHoppingEV(), eps, eps)
+			if err != nil {
+				t.Fatal(err)
+			}
+			grads = append(grads, grad)
+		}
+		for i := range vars {
+			expected := (grads[0][i] - grads[1][i]) / (2.0 * h)
+			if math.Abs(hess[i][j]-expected) > 1e-2*math.Max(1.0, math.Abs(expected)) {
+				t.Fatalf("Hessian[%v][%v] = %v; expected %v from the Landau gradient", vars[i], name, hess[i][j], expected)
+			}
+		}
+	}
+
+	// Starting from the default values, the solver reaches a solution with
+	// M02 = M12 = 0 and a higher free energy, which is a saddle point; so is
+	// the disordered solution with the M's held at 0.
+	F_ordered := env.FreeEnergy(NewHoppingEV())
+	for _, fixed := range [][]string{nil, HessianVars[:4]} {
+		env, err = LoadIonEnv("system_test_env.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		env.Set(make([]float64, len(fixed)), fixed)
+		_, err = MWSolve(env, NewHoppingEV(), eps, eps, fixed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, evals, label, err = Stability(env, eps, eps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		F := env.FreeEnergy(NewHoppingEV())
+		fmt.Println("fixed = ", fixed, "evals = ", evals, label, "F = ", F)
+		if label != Unstable || F <= F_ordered {
+			t.Fatalf("Got stability %s and F = %v with %v fixed; expected %s and F above %v", label, F, fixed, Unstable, F_ordered)
+		}
+	}
+}
+
+func TestRelaxIons(t *testing.T) {
+	env, err := LoadIonEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Start away from saturation.
+	env.Beta = 0.5
+	env.Set([]float64{0.3, 0.3, 0.1, 0.1, 0.5, 0.5, 0.5, 0.5}, HessianVars)
+	eps := 1e-10
+	traj, err := Relax(env, []Schedule{}, 1.0, 0.01, 10, 5, eps, eps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(traj.Points) != 3 {
+		t.Fatalf("Trajectory has %d points; expected 3", len(traj.Points))
+	}
+	for i := 1; i < len(traj.Points); i++ {
+		if traj.Points[i].FreeEnergy > traj.Points[i-1].FreeEnergy {
+			t.Fatalf("Relaxation raised G from %v to %v", traj.Points[i-1].FreeEnergy, traj.Points[i].FreeEnergy)
+		}
+	}
+
+	schedules := []Schedule{Schedule{Var: "Beta", Times: []float64{0.0, 0.1}, Values: []float64{0.5, 0.4}}}
+	traj, err = Relax(env, schedules, 1.0, 0.01, 10, 5, eps, eps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(traj.Points[1].Inputs[0]-0.45) > 1e-12 || math.Abs(traj.Points[2].Inputs[0]-0.4) > 1e-12 {
+		t.Fatalf("Trajectory has Beta = %v, %v; expected 0.45, 0.4", traj.Points[1].Inputs[0], traj.Points[2].Inputs[0])
+	}
+
+	schedules = []Schedule{Schedule{Var: "Bogus", Times: []float64{0.0}, Values: []float64{1.0}}}
+	_, err = Relax(env, schedules, 1.0, 0.01, 10, 5, eps, eps)
+	if err == nil {
+		t.Fatalf("Expected error for a schedule of an unknown variable")
+	}
+	_, err = Relax(env, []Schedule{}, 1.0, 0.01, 10, 0, eps, eps)
+	if err == nil {
+		t.Fatalf("Expected error recording every 0 steps")
+	}
+}
+
+func TestTightBindingPreset(t *testing.T) {
+	env, err := LoadEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.BZPointsPerDim = 4
+	env.M01, env.M12, env.Mu = 0.4, -0.2, 0.1
+	env.Strain_xy, env.Strain_zz, env.Eta_c, env.Eta_b = 0.01, -0.02, 3.0, 4.0
+	tb_env := *env
+	tb_env.TightBinding = "twodof"
+	err = tb_env.loadTightBinding()
+	if err != nil {
+		t.Fatal(err)
+	}
+	H := cmatrix.NewCMatrixGSL(4, 4)
+	H_tb := cmatrix.NewCMatrixGSL(4, 4)
+	defer H.Destroy()
+	defer H_tb.Destroy()
+	ks := []vec.Vector{vec.Vector{0.0, 0.0, 0.0}, vec.Vector{0.3, -1.2, 2.5}, vec.Vector{-2.9, 0.7, -0.4}, vec.Vector{math.Pi, 1.1, -math.Pi / 2.0}}
+	for _, k := range ks {
+		ElHamiltonian(env, k, H)
+		ElHamiltonian(&tb_env, k, H_tb)
+		for i := 0; i < 4; i++ {
+			for j := 0; j < 4; j++ {
+				if cmplx.Abs(H.At(i, j)-H_tb.At(i, j)) > 1e-12 {
+					t.Fatalf("preset H[%d][%d] = %v at k = %v; expected %v", i, j, H_tb.At(i, j), k, H.At(i, j))
+				}
+			}
+		}
+	}
+	// HoppingEV and the free energy go through the preset.
+	Ds, Ds_tb := NewHoppingEV(), NewHoppingEV()
+	if math.Abs(Ds.Dco(env)-Ds_tb.Dco(&tb_env)) > 1e-12 {
+		t.Fatalf("preset Dco = %v; expected %v", Ds_tb.Dco(&tb_env), Ds.Dco(env))
+	}
+	if math.Abs(env.FreeEnergyElectrons()-tb_env.FreeEnergyElectrons()) > 1e-12 {
+		t.Fatalf("preset electronic free energy = %v; expected %v", tb_env.FreeEnergyElectrons(), env.FreeEnergyElectrons())
+	}
+}
+
+func TestZeeman(t *testing.T) {
+	env, err := LoadEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.BZPointsPerDim = 6
+	env.M01, env.M12, env.Mu = 0.4, -0.2, 0.1
+	// The spin bands are the bands without the field shifted by
+	// -Zeeman and +Zeeman.
+	field_env := *env
+	field_env.Zeeman = 0.05
+	k := vec.Vector{0.3, -1.2, 2.1}
+	H := cmatrix.NewCMatrixGSL(env.numStates(), env.numStates())
+	work, evals, evecs := cmatrix.HermEigensystemSetup(H)
+	ElHamiltonian(env, k, H)
+	cmatrix.HermEigensystem(H, work, evals, evecs)
+	expected := []float64{}
+	for i := 0; i < env.numStates(); i++ {
+		expected = append(expected, evals.At(i)-field_env.Zeeman, evals.At(i)+field_env.Zeeman)
+	}
+	H.Destroy()
+	cmatrix.HermEigensystemCleanup(work, evals, evecs)
+	H = cmatrix.NewCMatrixGSL(field_env.numStates(), field_env.numStates())
+	work, evals, evecs = cmatrix.HermEigensystemSetup(H)
+	ElHamiltonian(&field_env, k, H)
+	cmatrix.HermEigensystem(H, work, evals, evecs)
+	field_evals := []float64{}
+	for i := 0; i < field_env.numStates(); i++ {
+		field_evals = append(field_evals, evals.At(i))
+	}
+	H.Destroy()
+	cmatrix.HermEigensystemCleanup(work, evals, evecs)
+	sort.Float64s(expected)
+	sort.Float64s(field_evals)
+	if len(field_evals) != len(expected) {
+		t.Fatalf("%d bands with Zeeman field; expected %d", len(field_evals), len(expected))
+	}
+	for i := range expected {
+		if math.Abs(field_evals[i]-expected[i]) > 1e-9 {
+			t.Fatalf("bands with Zeeman field = %v; expected %v", field_evals, expected)
+		}
+	}
+	// The magnetisation is the derivative of the free energy with respect
+	// to the field.
+	h := 1e-4
+	shifted := field_env
+	shifted.Zeeman += h
+	Fp := shifted.FreeEnergyElectrons()
+	shifted.Zeeman -= 2.0 * h
+	Fm := shifted.FreeEnergyElectrons()
+	m := field_env.Magnetization()
+	if m <= 0.0 || math.Abs((Fp-Fm)/(2.0*h)+m) > 1e-6 {
+		t.Fatalf("Magnetization = %v; expected positive with dF/dZeeman = %v = -m", m, (Fp-Fm)/(2.0*h))
+	}
+	// In a finite field, the spin channels have different Dco; their
+	// average couples to the ions, and gives the derivative of the
+	// electronic free energy as without the field: dF/dM01 = 4 Tco Dco.
+	Ds, Ds_field := NewHoppingEV(), NewHoppingEV()
+	field_env.Zeeman = 0.5
+	dco := Ds_field.SpinResolved(&field_env)["Dco"]
+	if len(dco) != 2 || math.Abs(dco[0]-dco[1]) < 1e-4 || math.Abs(0.5*(dco[0]+dco[1])-Ds_field.Dco(&field_env)) > 1e-12 {
+		t.Fatalf("spin-resolved Dco = %v with Dco = %v; expected distinct values with average Dco", dco, Ds_field.Dco(&field_env))
+	}
+	m01_env := field_env
+	m01_env.M01 += h
+	Fp = m01_env.FreeEnergyElectrons()
+	m01_env.M01 -= 2.0 * h
+	Fm = m01_env.FreeEnergyElectrons()
+	expected_dF := 4.0 * field_env.Tco_eff() * Ds_field.Dco(&field_env)
+	if dF := (Fp - Fm) / (2.0 * h); math.Abs(dF-expected_dF) > 1e-6 {
+		t.Fatalf("dF/dM01 with Zeeman field = %v; expected %v", dF, expected_dF)
+	}
+	if Ds.SpinResolved(env) != nil {
+		t.Fatalf("expected no spin-resolved D values without the field")
+	}
+	// The susceptibility gives the linear response of the solved system.
+	eps := 1e-8
+	_, err = Solve(env, NewHoppingEV(), eps, eps, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chi, err := SpinSusceptibility(env, eps, eps, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err = solvedMagnetization(env, 0.01, eps, eps, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chi <= 0.0 || math.Abs(m/0.01-chi) > 1e-2*chi {
+		t.Fatalf("spin susceptibility = %v; expected positive, close to m(h)/h = %v", chi, m/0.01)
+	}
+}
+
+func TestWavevector(t *testing.T) {
+	env, err := LoadEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.BZPointsPerDim = 4
+	env.M01, env.M12, env.Mu = 0.4, -0.2, 0.1
+	// The default Q given explicitly reproduces ElHamiltonian as written.
+	q_env := *env
+	q_env.Q = &[3]float64{0.0, 0.5, 0.5}
+	err = q_env.loadTightBinding()
+	if err != nil {
+		t.Fatal(err)
+	}
+	Ds, Ds_q := NewHoppingEV(), NewHoppingEV()
+	if math.Abs(Ds.Dco(env)-Ds_q.Dco(&q_env)) > 1e-12 {
+		t.Fatalf("Dco with explicit Q = %v; expected %v", Ds_q.Dco(&q_env), Ds.Dco(env))
+	}
+	if math.Abs(env.FreeEnergyElectrons()-q_env.FreeEnergyElectrons()) > 1e-12 {
+		t.Fatalf("electronic free energy with explicit Q = %v; expected %v", q_env.FreeEnergyElectrons(), env.FreeEnergyElectrons())
+	}
+	// Q of order 4 doubles the basis; without dimerisation, the folded bands
+	// are those of the default Q.
+	q_env.Q = &[3]float64{0.0, 0.25, 0.5}
+	err = q_env.loadTightBinding()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q_env.numStates() != 8 {
+		t.Fatalf("Q = %v gives %v states; expected 8", *q_env.Q, q_env.numStates())
+	}
+	env.M01, env.M12 = 0.0, 0.0
+	q_env.M01, q_env.M12 = 0.0, 0.0
+	if math.Abs(env.FreeEnergyElectrons()-q_env.FreeEnergyElectrons()) > 1e-12 {
+		t.Fatalf("electronic free energy with Q = %v and no dimerisation = %v; expected %v", *q_env.Q, q_env.FreeEnergyElectrons(), env.FreeEnergyElectrons())
+	}
+	q_env.Q = &[3]float64{0.1234567, 0.0, 0.0}
+	if q_env.loadTightBinding() == nil {
+		t.Fatalf("expected error for incommensurate Q")
+	}
+	// Q = 0 can be given explicitly: a uniform dimerisation, as for
+	// Q = (1, 0, 0).
+	q_env.M01, q_env.M12 = 0.4, -0.2
+	q_env.Q = &[3]float64{0.0, 0.0, 0.0}
+	err = q_env.loadTightBinding()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q_env.foldOrder() != 1 {
+		t.Fatalf("Q = 0 has order %v; expected 1", q_env.foldOrder())
+	}
+	uniform_env := q_env
+	uniform_env.Q = &[3]float64{1.0, 0.0, 0.0}
+	err = uniform_env.loadTightBinding()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(q_env.FreeEnergyElectrons()-uniform_env.FreeEnergyElectrons()) > 1e-12 {
+		t.Fatalf("electronic free energy with Q = 0 is %v; expected %v", q_env.FreeEnergyElectrons(), uniform_env.FreeEnergyElectrons())
+	}
+	// Q cannot fold a model loaded from a file.
+	m, err := tightbinding.Preset("twodof")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", "twodof_tb_model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(m.Marshal())
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q_env.TightBinding = f.Name()
+	if q_env.loadTightBinding() == nil {
+		t.Fatalf("expected error for Q with a tight-binding model loaded from a file")
+	}
+}
+
+func TestIonModel(t *testing.T) {
+	env, err := LoadEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Bxz0, env.Kb0, env.Kcxz0 = 0.3, 0.2, -0.15
+	env.Set([]float64{0.4, -0.1, 0.2, 0.3, 0.6, 0.5, 0.4, 0.7}, HessianVars)
+	env.ion_field = []float64{0.1, -0.2, 0.05, 0.0, 0.3, -0.1, 0.2, 0.15}
+	Ds := NewHoppingEV()
+	Dco := Ds.Dco(env)
+	if names := fmt.Sprint(env.IonVars()); names != fmt.Sprint(HessianVars) {
+		t.Fatalf("Default ionic model has order parameters %v; expected %v", names, HessianVars)
+	}
+	// The twodof preset gives the mean-field Hamiltonian written out for
+	// the four components.
+	nc := env.meanFieldCNeighbors()
+	H_Ion := func(S []int) float64 {
+		S01, S11, S02, S12 := float64(S[0]), float64(S[1]), float64(S[2]), float64(S[3])
+		Kbe, Kcxz := 4.0*env.Kb(), 0.5*nc*env.Kcxz()
+		H := (env.Bzz(0)+Kbe*env.W11+nc*env.Kczz()*env.W01+Kcxz*env.W02)*S01*S01 - (4.0*env.Jb()*env.M11+nc*env.Jc(0)*env.M01+2.0*Dco)*S01
+		H += (env.Bxy(1)+Kbe*env.W01+nc*env.Kcxx()*env.W11+Kcxz*env.W12)*S11*S11 - 4.0*env.Jb()*env.M01*S11
+		H += (env.Bxy(0)+Kbe*env.W12+nc*env.Kcxx()*env.W02+Kcxz*env.W01)*S02*S02 - 4.0*env.Jb()*env.M12*S02
+		H += (env.Bzz(1)+Kbe*env.W02+nc*env.Kczz()*env.W12+Kcxz*env.W11)*S12*S12 - (4.0*env.Jb()*env.M02+nc*env.Jc(1)*env.M12+2.0*Dco)*S12
+		H += env.Bxz(0)*S02*S02*S01*S01 + env.Bxz(1)*S11*S11*S12*S12
+		for c, s := range []float64{S01, S11, S02, S12} {
+			H -= env.ion_field[c]*s + env.ion_field[c+4]*s*s
+		}
+		return H
+	}
+	H_Bond := func(Sa, Sb []int) float64 {
+		sq := func(S []int, c int) float64 {
+			return float64(S[c] * S[c])
+		}
+		H := -env.Jc(0)*float64(Sa[0]*Sb[0]) - env.Jc(1)*float64(Sa[3]*Sb[3])
+		H += env.Kczz()*(sq(Sa, 0)*sq(Sb, 0)+sq(Sa, 3)*sq(Sb, 3)) + env.Kcxx()*(sq(Sa, 1)*sq(Sb, 1)+sq(Sa, 2)*sq(Sb, 2))
+		H += 0.5 * env.Kcxz() * (sq(Sa, 0)*sq(Sb, 2) + sq(Sa, 2)*sq(Sb, 0) + sq(Sa, 1)*sq(Sb, 3) + sq(Sa, 3)*sq(Sb, 1))
+		return H
+	}
+	all_S := all_S_configs(4)
+	if len(all_S) != 81 {
+		t.Fatalf("Got %d configurations of 4 components; expected 81", len(all_S))
+	}
+	tol := 1e-12
+	for _, Sa := range all_S {
+		if H, expected := env.H_Ion(Sa, Ds), H_Ion(Sa); math.Abs(H-expected) > tol {
+			t.Fatalf("H_Ion(%v) = %v; expected %v", Sa, H, expected)
+		}
+		for _, Sb := range all_S {
+			if H, expected := env.H_Bond(Sa, Sb), H_Bond(Sa, Sb); math.Abs(H-expected) > tol {
+				t.Fatalf("H_Bond(%v, %v) = %v; expected %v", Sa, Sb, H, expected)
+			}
+		}
+	}
+	M01, M11, M02, M12 := env.GetFloat("M01"), env.GetFloat("M11"), env.GetFloat("M02"), env.GetFloat("M12")
+	W01, W11, W02, W12 := env.GetFloat("W01"), env.GetFloat("W11"), env.GetFloat("W02"), env.GetFloat("W12")
+	EConst := env.Jc(0)*M01*M01 + env.Jc(1)*M12*M12
+	EConst += -env.Kcxx()*(W02*W02+W11*W11) - env.Kczz()*(W01*W01+W12*W12) - env.Kcxz()*(W02*W01+W11*W12)
+	EConst += 4.0*env.Jb()*(M01*M11+M02*M12) - 4.0*env.Kb()*(W01*W11+W02*W12)
+	if math.Abs(env.EConst_Ion()-EConst) > tol {
+		t.Fatalf("EConst_Ion = %v; expected %v", env.EConst_Ion(), EConst)
+	}
+
+	// A single component with exchange J over z bonds has the mean-field
+	// equations M = 2 sinh(2 z J Beta M) e^(-Beta B) / Z1 and
+	// W = 2 cosh(2 z J Beta M) e^(-Beta B) / Z1.
+	model := `{"Components": [{"Name": "x"}],
+		"Couplings": [{"Kind": "onsite", "A": 0, "Value": 0.2},
+			{"Kind": "exchange", "A": 0, "B": 0, "Value": 0.5, "Bonds": 2}]}`
+	f, err := ioutil.TempFile("", "ions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(model)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ion_env, err := LoadIonEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ion_env.Ions = f.Name()
+	err = ion_env.loadIons()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := fmt.Sprint(ion_env.IonVars()); names != "[Mx Wx]" {
+		t.Fatalf("Ionic model has order parameters %v; expected [Mx Wx]", names)
+	}
+	ion_env.Set([]float64{0.9, 0.9}, ion_env.IonVars())
+	copied := *ion_env
+	copied.Set([]float64{0.1}, []string{"Mx"})
+	if ion_env.GetFloat("Mx") != 0.9 {
+		t.Fatalf("Setting Mx in a copy changed the original to %v", ion_env.GetFloat("Mx"))
+	}
+	eps := 1e-10
+	_, err = Solve(ion_env, NewHoppingEV(), eps, eps, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	M, W := ion_env.GetFloat("Mx"), ion_env.GetFloat("Wx")
+	x := 2.0 * 2.0 * 0.5 * ion_env.Beta * M
+	weight := 2.0 * math.Exp(-ion_env.Beta*0.2)
+	Z1 := 1.0 + weight*math.Cosh(x)
+	if math.Abs(M-weight*math.Sinh(x)/Z1) > 1e-8 || math.Abs(W-weight*math.Cosh(x)/Z1) > 1e-8 || M < 0.1 {
+		t.Fatalf("Single-component model gives (M, W) = (%v, %v); expected a nonzero solution of the mean-field equations", M, W)
+	}
+	_, err = Solve(ion_env, NewHoppingEV(), eps, eps, []string{"M01"})
+	if err == nil {
+		t.Fatalf("Expected error fixing M01, which is not in the ionic model")
+	}
+}
+
+func TestIonAverages(t *testing.T) {
+	env, err := LoadIonEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Set([]float64{0.4, -0.1, 0.2, 0.3, 0.6, 0.5, 0.4, 0.7}, HessianVars)
+	Ds := NewHoppingEV()
+	avgs := env.IonAverages(Ds)
+	// Direct sums over the configurations.
+	Z1 := 0.0
+	direct := make([]float64, 8)
+	for _, S := range all_S_configs(4) {
+		w := math.Exp(-env.Beta * env.H_Ion(S, Ds))
+		Z1 += w
+		for a := 0; a < 8; a++ {
+			direct[a] += w * ionObservable(S, a)
+		}
+	}
+	tol := 1e-12
+	if math.Abs(avgs.LogZ1-math.Log(Z1)) > tol {
+		t.Fatalf("Got log Z1 = %v; expected %v", avgs.LogZ1, math.Log(Z1))
+	}
+	for a := 0; a < 8; a++ {
+		if math.Abs(avgs.Avgs[a]-direct[a]/Z1) > tol {
+			t.Fatalf("Got <%v> = %v; expected %v", HessianVars[a], avgs.Avgs[a], direct[a]/Z1)
+		}
+	}
+	// The residuals at one point share the averages; a new point needs new
+	// ones.
+	if env.IonAverages(Ds) != avgs {
+		t.Fatalf("Ionic averages recalculated at the same point")
+	}
+	env.Set([]float64{0.5}, []string{"M01"})
+	if env.IonAverages(Ds) == avgs {
+		t.Fatalf("Ionic averages not recalculated after changing M01")
+	}
+	// Large Beta, for which exp(-Beta H_Ion) overflows.
+	env.Beta = 1e4
+	avgs = env.IonAverages(Ds)
+	if math.IsInf(avgs.LogZ1, 0) || math.IsNaN(avgs.LogZ1) || math.IsNaN(avgs.F1) {
+		t.Fatalf("Got (log Z1, F1) = (%v, %v) at Beta = %v; expected finite values", avgs.LogZ1, avgs.F1, env.Beta)
+	}
+	for a := 0; a < 8; a++ {
+		if math.IsNaN(avgs.Avgs[a]) || math.Abs(avgs.Avgs[a]) > 1.0 {
+			t.Fatalf("Got <%v> = %v at Beta = %v; expected a value in [-1, 1]", HessianVars[a], avgs.Avgs[a], env.Beta)
+		}
+	}
+}
+
+func TestLowTemperatureIons(t *testing.T) {
+	env, err := LoadIonEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := []float64{0.4, -0.1, 0.2, 0.3, 0.6, 0.5, 0.4, 0.7}
+	env.Set(x, HessianVars)
+	Ds := NewHoppingEV()
+	// At T = 0 the ions are in the single-site ground states, here the
+	// degenerate pair S01 = +/-1, with equal probability.
+	E0, ground := math.Inf(1), [][]int{}
+	for _, S := range all_S_configs(4) {
+		E := env.H_Ion(S, Ds)
+		if E < E0 {
+			E0, ground = E, [][]int{}
+		}
+		if E == E0 {
+			ground = append(ground, S)
+		}
+	}
+	env.Beta = math.Inf(1)
+	avgs := env.IonAverages(Ds)
+	if env.FreeEnergyIons(Ds) != E0 {
+		t.Fatalf("Got F_ion = %v at T = 0; expected the ground-state energy %v", env.FreeEnergyIons(Ds), E0)
+	}
+	for a := 0; a < 8; a++ {
+		expected := 0.0
+		for _, S := range ground {
+			expected += ionObservable(S, a) / float64(len(ground))
+		}
+		if math.Abs(avgs.Avgs[a]-expected) > 1e-12 {
+			t.Fatalf("Got <%v> = %v at T = 0; expected %v", HessianVars[a], avgs.Avgs[a], expected)
+		}
+	}
+	// Large Beta approaches T = 0, with the ground-state entropy.
+	env.Beta = 1e3
+	expected := E0 - math.Log(float64(len(ground)))/env.Beta
+	if F := env.FreeEnergyIons(Ds); math.Abs(F-expected) > 1e-9 {
+		t.Fatalf("Got F_ion = %v at Beta = %v; expected %v", F, env.Beta, expected)
+	}
+	// The residuals, the susceptibility, the dimer cluster and the transverse
+	// model stay finite once a field lifts the degeneracy, without which
+	// chi diverges at T = 0.
+	finite := func(values []float64) bool {
+		for _, v := range values {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return false
+			}
+		}
+		return true
+	}
+	env.Beta = math.Inf(1)
+	env.ion_field = []float64{0.1, 0.0, 0.0, 0.0, 0.0, 0.0, 0.0, 0.0}
+	for _, cluster := range []string{"", "dimer"} {
+		for _, gamma := range []float64{0.0, 0.1} {
+			if cluster == "dimer" && gamma != 0.0 {
+				continue
+			}
+			env.Cluster, env.Gamma = cluster, gamma
+			avgs = env.IonAverages(Ds)
+			values := append([]float64{env.FreeEnergyIons(Ds)}, avgs.Avgs...)
+			for _, row := range avgs.Chi {
+				values = append(values, row...)
+			}
+			for c := 0; c < 4; c++ {
+				R, err := AbsErrorM(env, Ds, HessianVars, c).F(x)
+				if err != nil {
+					t.Fatal(err)
+				}
+				values = append(values, R)
+			}
+			if !finite(values) {
+				t.Fatalf("Got non-finite ionic values %v at T = 0 (cluster %q, Gamma = %v)", values, cluster, gamma)
+			}
+		}
+	}
+}
+
+func TestGroundState(t *testing.T) {
+	eps := 1e-9
+	env, err := LoadIonEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Beta = math.Inf(1)
+	Ds := NewHoppingEV()
+	_, err = Solve(env, Ds, eps, eps, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The order parameters are those of the configuration minimising H_Ion.
+	x := make([]float64, 8)
+	for a, name := range HessianVars {
+		x[a] = env.GetFloat(name)
+	}
+	fmt.Println("Ionic ground state", x, "E = ", env.FreeEnergy(Ds))
+	E0, S0 := math.Inf(1), []int{}
+	for _, S := range all_S_configs(4) {
+		if E := env.H_Ion(S, Ds); E < E0 {
+			E0, S0 = E, S
+		}
+	}
+	for a := 0; a < 8; a++ {
+		if x[a] != ionObservable(S0, a) {
+			t.Fatalf("Ionic ground state has %v = %v; expected %v", HessianVars, x, S0)
+		}
+	}
+	if math.Abs(env.FreeEnergy(Ds)-(E0+env.EConst_Ion())) > 1e-12 {
+		t.Fatalf("Ionic ground-state energy %v; expected %v", env.FreeEnergy(Ds), E0+env.EConst_Ion())
+	}
+	reloaded, err := NewEnvironment(env.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.ZeroTemperature() {
+		t.Fatalf("Beta = %v after reloading the ground state; expected Inf", reloaded.Beta)
+	}
+	// Fixed M's are held, and the dimer cluster also converges.
+	env.Cluster = "dimer"
+	env.M11 = 0.0
+	_, err = MWSolve(env, Ds, eps, eps, []string{"M11"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.M11 != 0.0 {
+		t.Fatalf("Fixed M11 changed to %v", env.M11)
+	}
+
+	// With electrons, Mu is the tetrahedron Fermi level.
+	env, err = LoadEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Beta = math.Inf(1)
+	Ds = NewHoppingEV()
+	_, err = MWMuSolve(env, Ds, eps, eps, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("Ground state M01 = ", env.M01, "Mu = ", env.Mu, "E = ", env.FreeEnergy(Ds))
+	avgs := env.IonAverages(Ds).Avgs
+	for a, name := range HessianVars {
+		if env.GetFloat(name) != avgs[a] {
+			t.Fatalf("%v = %v is not its ground-state average %v", name, env.GetFloat(name), avgs[a])
+		}
+	}
+	Mu, err := env.FermiLevel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(Mu-env.Mu) > 1e-8 {
+		t.Fatalf("Mu = %v differs from the Fermi level %v", env.Mu, Mu)
+	}
+	cold := *env
+	cold.Beta = 100.0
+	if F := cold.FreeEnergyElectrons(); math.Abs(F-env.FreeEnergyElectrons()) > 1e-3 {
+		t.Fatalf("Electronic free energy %v at Beta = 100; expected close to the ground-state energy %v", F, env.FreeEnergyElectrons())
+	}
+}
+
+func TestLandscape(t *testing.T) {
+	env, err := LoadEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.BZPointsPerDim = 4
+	grid_vars := []string{"M01", "M02"}
+	grid_vals := [][]float64{[]float64{0.0, 0.5}, []float64{0.2, 0.8}}
+	eps := 1e-9
+	for _, relax := range [][]string{[]string{}, []string{"Mu"}} {
+		ls, err := NewLandscape(env, grid_vars, grid_vals, relax, eps, eps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ls.Points) != 4 {
+			t.Fatalf("Landscape has %d points; expected 4", len(ls.Points))
+		}
+		for _, point := range ls.Points {
+			if !point.Converged {
+				t.Fatalf("Landscape point %v not converged", point.Grid)
+			}
+			point_env := *env
+			point_env.Set(point.Grid, grid_vars)
+			point_env.Set(point.Relaxed, relax)
+			F := point_env.FreeEnergy(NewHoppingEV())
+			if math.Abs(point.FreeEnergy-F) > 1e-9 {
+				t.Fatalf("Landscape at %v, %v = %v has F = %v; expected %v", point.Grid, relax, point.Relaxed, point.FreeEnergy, F)
+			}
+		}
+	}
+	if env.M01 != 0.9 || env.M02 != 0.3 {
+		t.Fatalf("NewLandscape modified env")
+	}
+	// The hopping e.v.'s depend on grid variables other than the order
+	// parameters, such as Beta: each point agrees with a separate evaluation.
+	ls, err := NewLandscape(env, []string{"Beta"}, [][]float64{[]float64{20.0, 5.0}}, []string{}, eps, eps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, point := range ls.Points {
+		point_env := *env
+		point_env.Beta = point.Grid[0]
+		F := point_env.FreeEnergy(NewHoppingEV())
+		if math.Abs(point.FreeEnergy-F) > 1e-9 {
+			t.Fatalf("Landscape at Beta = %v has F = %v; expected %v", point.Grid[0], point.FreeEnergy, F)
+		}
+	}
+
+	_, err = NewLandscape(env, []string{"Bogus"}, grid_vals[:1], []string{"Mu"}, eps, eps)
+	if err == nil {
+		t.Fatalf("Expected error for an unknown grid variable")
+	}
+	_, err = NewLandscape(env, grid_vars, grid_vals, []string{"M02", "Mu"}, eps, eps)
+	if err == nil {
+		t.Fatalf("Expected error for a variable both fixed on the grid and relaxed")
+	}
+	_, err = NewLandscape(env, grid_vars, grid_vals, []string{"Bogus"}, eps, eps)
+	if err == nil {
+		t.Fatalf("Expected error relaxing an unknown variable")
+	}
+}
+
+func TestProjectedDos(t *testing.T) {
+	env, err := LoadEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.M01, env.M12 = 0.5, -0.3
+	// Each band has unit weight in total, and the band energies are those
+	// of BandEnergies.
+	Efn, cleanup := env.BandEnergies()
+	defer cleanup()
+	Pfn, cleanup_p := env.BandProjections()
+	defer cleanup_p()
+	k := []float64{0.1, 0.2, 0.3}
+	Es, weights := Pfn(k)
+	Es_b := Efn(k)
+	if len(weights) != len(env.basisLabels()) {
+		t.Fatalf("Got %d bands; expected one per basis state %v", len(weights), env.basisLabels())
+	}
+	for b, ws := range weights {
+		sum := 0.0
+		for _, w := range ws {
+			sum += w
+		}
+		if math.Abs(sum-1.0) > 1e-12 || math.Abs(Es[b]-Es_b[b]) > 1e-12 {
+			t.Fatalf("Band %d has E = %v and total weight %v; expected E = %v and weight 1", b, Es[b], sum, Es_b[b])
+		}
+	}
+	// The projected DOS summed over the basis states is the total DOS.
+	n, num_dos := 6, 50
+	dos := env.Dos(n, num_dos)
+	pdos := env.ProjectedDos(n, num_dos)
+	for i := range dos.DOS {
+		sum := 0.0
+		for s := range pdos.Basis {
+			sum += pdos.Projected[s][i]
+		}
+		tol := 1e-9 * math.Max(1.0, dos.DOS[i])
+		if math.Abs(pdos.E[i]-dos.E[i]) > 1e-12 || math.Abs(pdos.DOS[i]-dos.DOS[i]) > tol || math.Abs(sum-dos.DOS[i]) > tol {
+			t.Fatalf("At E = %v, projected DOS sums to %v; expected total DOS %v", dos.E[i], sum, dos.DOS[i])
+		}
+	}
+}
+
+func TestBandEdges(t *testing.T) {
+	env, err := LoadEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Dimerized phase with Mu/2 in the gap between the second and third
+	// bands.
+	env.M01, env.M12, env.Mu, env.Tbe = 1.0, 1.0, 0.0, 0.2
+	edges := env.BandEdges()
+	if !edges.IsInsulator || edges.Gap <= 0.0 || edges.DirectGap < edges.Gap {
+		t.Fatalf("Expected insulator; got %+v", edges)
+	}
+	// The refined gap is no larger than the gap on the mesh.
+	Efn, cleanup := env.BandEnergies()
+	defer cleanup()
+	Eks := tetra.EnergyGrid(Efn, env.BZPointsPerDim)
+	mesh_vbm, mesh_cbm := math.Inf(-1), math.Inf(1)
+	for _, Ek := range Eks {
+		mesh_vbm = math.Max(mesh_vbm, Ek[1])
+		mesh_cbm = math.Min(mesh_cbm, Ek[2])
+	}
+	if edges.Gap > mesh_cbm-mesh_vbm+1e-12 {
+		t.Fatalf("Refined gap %v larger than mesh gap %v", edges.Gap, mesh_cbm-mesh_vbm)
+	}
+	// The band edges are only calculated when requested.
+	if fenv := NewFinalEnvironment(env, NewHoppingEV()); fenv.Gap != 0.0 || fenv.IsInsulator {
+		t.Fatalf("NewFinalEnvironment calculated the band edges")
+	}
+
+	env.Mu = -2.0
+	if env.BandEdges().IsInsulator {
+		t.Fatalf("Expected metal with Mu inside the bands")
+	}
+}
@@ -0,0 +1,56 @@
+package twodof
+
+import (
+	"fmt"
+	"math"
+)
+
+// Relative temperature step used to take finite-difference temperature
+// derivatives of the free energy.
+const thermo_dT = 1e-2
+
+// Return the entropy per cell S = -dF/dT and the heat capacity per cell
+// C = -T d^2F/dT^2 at the solved env.
+// F is evaluated at T +/- dT by solving the system again, starting from the
+// solution in env and holding fixed the same M's (fixed) as the original
+// solution. env is not modified.
+// The electrons and ions must be at the same temperature (see
+// SingleTemperature).
+func Thermodynamics(env *Environment, epsAbs, epsRel float64, fixed []string) (float64, float64, error) {
+	if !env.SingleTemperature() {
+		return 0.0, 0.0, fmt.Errorf("Thermodynamics requires BetaEl and BetaIon equal to Beta")
+	}
+	if env.Beta == math.Inf(1) {
+		// Both S and C vanish at T = 0.
+		return 0.0, 0.0, nil
+	}
+	T := 1.0 / env.Beta
+	dT := thermo_dT * T
+
+	F0 := env.FreeEnergy(NewHoppingEV())
+	Fp, err := solvedFreeEnergy(env, T+dT, epsAbs, epsRel, fixed)
+	if err != nil {
+		return 0.0, 0.0, err
+	}
+	Fm, err := solvedFreeEnergy(env, T-dT, epsAbs, epsRel, fixed)
+	if err != nil {
+		return 0.0, 0.0, err
+	}
+
+	S := -(Fp - Fm) / (2.0 * dT)
+	C := -T * (Fp - 2.0*F0 + Fm) / (dT * dT)
+	return S, C, nil
+}
+
+// Solve a copy of env at temperature T and return its free energy.
+func solvedFreeEnergy(env *Environment, T, epsAbs, epsRel float64, fixed []string) (float64, error) {
+	shifted := *env
+	shifted.Beta = 1.0 / T
+	// Ds only tracks (M01, M12, Mu), so a new cache is needed when Beta changes.
+	Ds := NewHoppingEV()
+	_, err := Solve(&shifted, Ds, epsAbs, epsRel, fixed)
+	if err != nil {
+		return 0.0, err
+	}
+	return shifted.FreeEnergy(Ds), nil
+}
@@ -0,0 +1,84 @@
+package twodof
+
+import (
+	"math"
+)
+import (
+	"github.com/tflovorn/cmatrix"
+	"github.com/tflovorn/vo2mft/meanfield"
+)
+
+// Matrix element of S^x between S = 0 and S = +/-1 for spin 1.
+var sx_elem = 1.0 / math.Sqrt2
+
+// Return the eigenvalues and (real) eigenvectors of the single-site ionic
+// Hamiltonian H_Ion - Gamma sum_c S^x_c, with eigenvector components in the
+// basis all_S_configs, for the single-site Hamiltonian site.
+// evecs[n] is the n'th eigenvector.
+func (env *Environment) ionEigensystem(site *ionSite) ([]float64, [][]float64) {
+	all_S := all_S_configs(len(site.lin))
+	n := len(all_S)
+	H := cmatrix.NewCMatrixGSL(n, n)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			H.Set(i, j, 0.0)
+		}
+	}
+	g := complex(-env.Gamma*sx_elem, 0.0)
+	for i, S := range all_S {
+		H.Set(i, i, complex(site.energy(S), 0.0))
+		// S^x connects configurations differing by 1 in a single S_c.
+		for c := 0; c < len(S); c++ {
+			if S[c] == 1 {
+				continue
+			}
+			up := make([]int, len(S))
+			copy(up, S)
+			up[c]++
+			j := configIndex(up)
+			H.Set(i, j, g)
+			H.Set(j, i, g)
+		}
+	}
+
+	work, cevals, cevecs := cmatrix.HermEigensystemSetup(H)
+	cmatrix.HermEigensystem(H, work, cevals, cevecs)
+	evals := make([]float64, n)
+	evecs := make([][]float64, n)
+	for m := 0; m < n; m++ {
+		evals[m] = cevals.At(m)
+		evecs[m] = make([]float64, n)
+		for i := 0; i < n; i++ {
+			evecs[m][i] = real(cevecs.At(i, m))
+		}
+	}
+	H.Destroy()
+	cmatrix.HermEigensystemCleanup(work, cevals, cevecs)
+	return evals, evecs
+}
+
+// Return the single-site ionic averages including the transverse term (see
+// IonAverages).
+func (env *Environment) quantumIon(site *ionSite) *IonAverages {
+	evals, evecs := env.ionEigensystem(site)
+	nc := len(site.lin)
+	all_S := all_S_configs(nc)
+	obs := make([][]float64, 2*nc)
+	for a := 0; a < 2*nc; a++ {
+		obs[a] = make([]float64, len(all_S))
+		for i, S := range all_S {
+			obs[a][i] = ionObservable(S, a)
+		}
+	}
+	dist, avgs, chi := meanfield.ThermalAverages(evals, evecs, obs, env.BetaIons())
+	return &IonAverages{dist.LogZ(), dist.FreeEnergy(), avgs, chi}
+}
+
+// Return the index in all_S_configs of the configuration S.
+func configIndex(S []int) int {
+	index := 0
+	for _, s := range S {
+		index = 3*index + (s + 1)
+	}
+	return index
+}
@@ -6,7 +6,9 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
+	"strings"
 )
 
 var eps = flag.Float64("eps", 1e-6, "Converged when error below eps")
@@ -15,6 +17,11 @@ var m01_0 = flag.Bool("m01_0", false, "Fix m_01 = 0")
 var m11_0 = flag.Bool("m11_0", false, "Fix m_11 = 0")
 var m02_0 = flag.Bool("m02_0", false, "Fix m_02 = 0")
 var m12_0 = flag.Bool("m12_0", false, "Fix m_12 = 0")
+var zero = flag.String("zero", "", "Comma-separated names of additional M's of the ionic model to fix at 0")
+var thermo = flag.Bool("thermo", false, "Calculate entropy and specific heat (solves the system at two additional temperatures)")
+var spin = flag.Bool("spin", false, "Calculate spin susceptibility (solves the system at two additional Zeeman fields)")
+var edges = flag.Bool("edges", false, "Calculate the band gap and band edges (refines the band extrema by local search in k)")
+var ground_state = flag.Bool("ground_state", false, "Find the T = 0 ground state (sets Beta to Inf); FreeEnergy is then the ground-state energy, and the stability analysis, --thermo and --spin are skipped")
 
 //var ions = flag.Bool("ions", false, "Solve only ionic system")
 
@@ -31,6 +38,17 @@ func main() {
 
 	Ds := twodof.NewHoppingEV()
 
+	// M's held fixed at 0.
+	fixed := []string{}
+	for i, flag := range []*bool{m01_0, m11_0, m02_0, m12_0} {
+		if *flag {
+			fixed = append(fixed, twodof.HessianVars[i])
+		}
+	}
+	if *zero != "" {
+		fixed = append(fixed, strings.Split(*zero, ",")...)
+	}
+
 	// Load Environment from in_path, then solve system
 	// (throw away result from Solve - env is modified in-place).
 	var solved_env *twodof.Environment
@@ -40,21 +58,18 @@ func main() {
 			fmt.Println(err)
 			os.Exit(1)
 		}
-
-		if *m01_0 {
-			env.M01 = 0.0
+		if *ground_state {
+			env.Beta, env.BetaEl, env.BetaIon = math.Inf(1), 0.0, 0.0
 		}
-		if *m11_0 {
-			env.M11 = 0.0
-		}
-		if *m02_0 {
-			env.M02 = 0.0
-		}
-		if *m12_0 {
-			env.M12 = 0.0
+
+		err = env.CheckFixed(fixed)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
+		env.Set(make([]float64, len(fixed)), fixed)
 
-		_, err = twodof.MWMuSolve(env, Ds, *eps, *eps, *m01_0, *m11_0, *m02_0, *m12_0)
+		_, err = twodof.MWMuSolve(env, Ds, *eps, *eps, fixed)
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
@@ -66,21 +81,18 @@ func main() {
 			fmt.Println(err)
 			os.Exit(1)
 		}
-
-		if *m01_0 {
-			env.M01 = 0.0
-		}
-		if *m11_0 {
-			env.M11 = 0.0
+		if *ground_state {
+			env.Beta, env.BetaEl, env.BetaIon = math.Inf(1), 0.0, 0.0
 		}
-		if *m02_0 {
-			env.M02 = 0.0
-		}
-		if *m12_0 {
-			env.M12 = 0.0
+
+		err = env.CheckFixed(fixed)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
+		env.Set(make([]float64, len(fixed)), fixed)
 
-		_, err = twodof.MWSolve(env, Ds, *eps, *eps, *m01_0, *m11_0, *m02_0, *m12_0)
+		_, err = twodof.MWSolve(env, Ds, *eps, *eps, fixed)
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
@@ -90,8 +102,44 @@ func main() {
 
 	// Calculate additional data for export from solved Environment.
 	fenv := twodof.NewFinalEnvironment(solved_env, Ds)
+	if *edges && !*ions {
+		fenv.BandEdges = solved_env.BandEdges()
+	}
+	if solved_env.ZeroTemperature() {
+		// The finite temperature analyses do not apply to the ground state.
+		write_fenv(fenv, out_path)
+		return
+	}
+	// The stability analysis is optional: if it fails, keep the solution
+	// and leave Stability empty.
+	hess, hess_evals, stability, err := twodof.Stability(solved_env, *eps, *eps)
+	if err != nil {
+		fmt.Println("Stability analysis failed:", err)
+	} else {
+		fenv.Hessian, fenv.HessianEigenvalues, fenv.Stability = hess, hess_evals, stability
+	}
+	if *thermo {
+		S, C, err := twodof.Thermodynamics(solved_env, *eps, *eps, fixed)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fenv.Entropy, fenv.SpecificHeat = S, C
+	}
+	if *spin && !*ions {
+		chi, err := twodof.SpinSusceptibility(solved_env, *eps, *eps, fixed)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fenv.SpinSusceptibility = chi
+	}
+
+	write_fenv(fenv, out_path)
+}
 
-	// Write output system.
+// Write the output system to out_path + "_fenv.json".
+func write_fenv(fenv *twodof.FinalEnvironment, out_path string) {
 	fenv_out_buf := bytes.NewBufferString(fenv.Marshal())
 	fenv_out_data := fenv_out_buf.Bytes()
 	ioutil.WriteFile(out_path+"_fenv.json", fenv_out_data, 0644) // u=rw;go=r
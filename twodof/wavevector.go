@@ -0,0 +1,44 @@
+package twodof
+
+import (
+	"github.com/tflovorn/vo2mft/tightbinding"
+)
+
+// Modulation wavevector Q of the order parameters given explicitly
+// (see Environment.Q).
+//
+// With Q of order N (see tightbinding.FoldOrder), ElHamiltonian is the
+// tight-binding model folded with Q (see tightbinding.PresetQ), whose states
+// form 2N blocks (k, 0), ..., (k+(N-1)Q, 0), (k, 1), ..., (k+(N-1)Q, 1). The
+// default Q = (0, 1/2, 1/2) has N = 2. The states of each sublattice at
+// k + jQ range over the BZ N times, so that BZ averages over the states are
+// weighted by 2/N relative to the default Q (see foldScale). Dco is given by
+// the derivative of the electronic energy with respect to M01, as for any
+// tight-binding model (see tbDco). The ionic problem (see H_Ion) is
+// unchanged.
+
+// Return true if env.Q is set, so that ElHamiltonian is a folded preset (see
+// wavevector.go).
+func (env *Environment) folded() bool {
+	return env.Q != nil
+}
+
+// Return the order N of the modulation wavevector (see wavevector.go): 2 for
+// the default Q.
+func (env *Environment) foldOrder() int {
+	if !env.folded() {
+		return 2
+	}
+	N, err := tightbinding.FoldOrder(*env.Q)
+	if err != nil {
+		// Checked by loadTightBinding.
+		panic(err)
+	}
+	return N
+}
+
+// Return the weight of BZ averages over the states of ElHamiltonian relative
+// to the default Q: 2/N for Q of order N (see wavevector.go).
+func (env *Environment) foldScale() float64 {
+	return 2.0 / float64(env.foldOrder())
+}
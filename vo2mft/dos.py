@@ -4,27 +4,38 @@ import os
 import numpy as np
 from tetra.dos import DosValues_AllE
 from vo2mft.elHamiltonian import ElHamiltonian_Recip
+from vo2mft.environment import Hoppings
 from vo2mft.lattice import _cubic_R
-from vo2mft.util import _run_dos_path
+from vo2mft.solve import write_env_file
+from vo2mft.util import _run_dos_path, _dos_front_path
 
-def Dos(env, num_dos, n0, use_ctetra=True):
+def Dos(env, num_dos, n0, use_ctetra=False, use_pytetra=False, twodof=False):
     '''Return two lists, dos_vals and E_vals. dos_vals contains the density
     of states D(E) at num_dos energies E between the minimum and maximum energy
     eigenvalues. E_vals contains the E values at which the corresponding element
     of dos_vals was evaluated.
 
     n0 gives the number of k-points to use to obtain D(E).
+
+    By default, D(E) is calculated by dos_front (Go tetrahedron method), which
+    supports both models; set twodof to use the twodof model. use_ctetra
+    selects the C implementation RunDosValues and use_pytetra the Python
+    implementation instead (vo2solve model only).
     '''
-    if not use_ctetra:
+    if use_pytetra:
         def Hk(k):
             return ElHamiltonian_Recip(env, k)
         return PytetraDos(Hk, num_dos, n0)
 
+    if not use_ctetra:
+        return _go_dos(env, num_dos, n0, twodof)
+
     rundos_path = _run_dos_path()
     out_name = str(uuid4())
 
-    rundos_call = [rundos_path, out_name, str(n0), str(num_dos), str(env["Tae"]), str(env["Tce"]),
-            str(env["Tbe"]), str(env["Tao"]), str(env["Tco"]), str(env["Tbo"]), str(env["EpsilonR"]),
+    T = Hoppings(env)
+    rundos_call = [rundos_path, out_name, str(n0), str(num_dos), str(T["Tae"]), str(T["Tce"]),
+            str(T["Tbe"]), str(T["Tao"]), str(T["Tco"]), str(T["Tbo"]), str(env["EpsilonR"]),
             str(env["EpsilonM"]), str(env["M"]), str(env["W"]), str(env["Mu"])]
     subprocess.call(rundos_call)
 
@@ -33,6 +44,61 @@ def Dos(env, num_dos, n0, use_ctetra=True):
 
     return dos_vals, E_vals
 
+def _go_dos(env, num_dos, n0, twodof):
+    in_path, out_path = str(uuid4()), str(uuid4())
+    write_env_file(env, in_path)
+
+    front_call = [_dos_front_path(), "--n", str(n0), "--num_dos", str(num_dos), "--tsv"]
+    if twodof:
+        front_call.append("--twodof")
+    front_call.extend([in_path, out_path])
+    subprocess.call(front_call)
+
+    dos_path = out_path + "_dos.tsv"
+    dos_vals, E_vals = _get_dos_vals(dos_path)
+
+    os.remove(in_path)
+    os.remove(dos_path)
+
+    return dos_vals, E_vals
+
+def ProjectedDos(env, num_dos, n0, twodof=False):
+    '''Return dos_vals, E_vals (as for Dos), the list of basis state labels
+    and a list with one element per basis state, each a list of the DOS
+    projected onto that basis state at the energies E_vals.
+
+    The basis states are (k,0), (k+Q,0), (k,1), (k+Q,1): the two sublattices
+    at k and k+Q.
+    '''
+    in_path, out_path = str(uuid4()), str(uuid4())
+    write_env_file(env, in_path)
+
+    front_call = [_dos_front_path(), "--n", str(n0), "--num_dos", str(num_dos), "--tsv",
+            "--projected"]
+    if twodof:
+        front_call.append("--twodof")
+    front_call.extend([in_path, out_path])
+    subprocess.call(front_call)
+
+    dos_path = out_path + "_dos.tsv"
+    lines = None
+    with open(dos_path, 'r') as fp:
+        lines = fp.readlines()
+
+    basis = lines[0].strip().split('\t')[2:]
+    dos_vals, E_vals, projected = [], [], [[] for b in basis]
+    for line in lines[1:]:
+        split = line.strip().split('\t')
+        E_vals.append(float(split[0]))
+        dos_vals.append(float(split[1]))
+        for i, val in enumerate(split[2:]):
+            projected[i].append(float(val))
+
+    os.remove(in_path)
+    os.remove(dos_path)
+
+    return dos_vals, E_vals, basis, projected
+
 def _get_dos_vals(dos_path):
     dos_vals, E_vals = [], []
     lines = None
@@ -81,6 +147,10 @@ def FindGaps(dos_values, Es):
 
     Return a list with elements (gap_start, gap_stop) for each gap detected.
     If no gaps are detected, return a 0-element list.
+
+    The gap around Mu of a solved env is also given directly (without
+    resolution limits from the DOS energy grid) by its Gap and IsInsulator
+    values.
     '''
     gaps = []
     last_dos_nonzero = False
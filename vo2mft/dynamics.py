@@ -0,0 +1,64 @@
+import subprocess
+import os
+import json
+from uuid import uuid4
+import matplotlib.pyplot as plt
+from vo2mft.solve import write_env_file
+from vo2mft.util import _dynamics_front_path, _twodof_dynamics_front_path
+
+def relax(env, schedules, dt, steps, every=10, rate=1.0, eps=1e-8, ions=False, twodof=False):
+    '''Return the trajectory (as parsed from the JSON output of
+    dynamics_front) of the order parameters in env under relaxational dynamics
+    for steps time steps of length dt, recorded every 'every' steps.
+    schedules is a list of dicts {"Var": name, "Times": [...], "Values": [...]}
+    giving time-dependent values of environment variables (e.g. a temperature
+    ramp on "Beta"); the values are interpolated linearly between Times.
+    '''
+    front_path = _dynamics_front_path()
+    if twodof:
+        front_path = _twodof_dynamics_front_path()
+
+    in_path, out_path, schedule_path = str(uuid4()), str(uuid4()), str(uuid4())
+    write_env_file(env, in_path)
+    with open(schedule_path, 'w') as fp:
+        fp.write(json.dumps(schedules))
+
+    front_call = [front_path, "--eps", str(eps), "--rate", str(rate), "--dt", str(dt),
+            "--steps", str(steps), "--every", str(every), "--schedule", schedule_path]
+    if ions:
+        front_call.append("--ions")
+    front_call.extend([in_path, out_path])
+    subprocess.call(front_call)
+
+    traj_path = out_path + "_dynamics.json"
+    traj = None
+    try:
+        with open(traj_path, 'r') as fp:
+            traj = json.loads(fp.read())
+    except FileNotFoundError:
+        pass
+
+    try:
+        os.remove(in_path)
+        os.remove(schedule_path)
+        os.remove(traj_path)
+    except FileNotFoundError:
+        pass
+
+    return traj
+
+def plot_trajectory(traj, out_path=None):
+    '''Plot the order parameters in the trajectory traj against time.
+    '''
+    ts = [p["Time"] for p in traj["Points"]]
+    for i, name in enumerate(traj["Vars"]):
+        plt.plot(ts, [p["Values"][i] for p in traj["Points"]], label=name)
+
+    plt.xlabel("$t$")
+    plt.legend(loc=0)
+
+    if out_path == None:
+        plt.show()
+    else:
+        plt.savefig(out_path + '.png', bbox_inches='tight', dpi=500)
+    plt.clf()
@@ -1,5 +1,6 @@
 import numpy as np
 from vo2mft.lattice import _cubic_R
+from vo2mft.environment import Hoppings
 
 def ElHamiltonian_Recip(env, k):
     '''Calculate 4x4 electronic Hamiltonian H(k).
@@ -55,24 +56,28 @@ def ElHamiltonian(env, k):
 def EpsilonAE(env, k):
     '''Cubic axes, even symmetry (k, p; k, p)
     '''
-    rp = -2.0 * (env["Tae"]*(np.cos(k[0])+np.cos(k[1])) + env["Tce"]*np.cos(k[2]))
+    T = Hoppings(env)
+    rp = -2.0 * (T["Tae"]*(np.cos(k[0])+np.cos(k[1])) + T["Tce"]*np.cos(k[2]))
     return complex(rp, 0.0)
 
 def EpsilonBE(env, k):
     '''Body diagonal, even symmetry (k, p; k, pbar)
     '''
-    rp = -8.0 * env["Tbe"] * np.cos(k[0]/2.0) * np.cos(k[1]/2.0) * np.cos(k[2]/2.0)
+    T = Hoppings(env)
+    rp = -8.0 * T["Tbe"] * np.cos(k[0]/2.0) * np.cos(k[1]/2.0) * np.cos(k[2]/2.0)
     return complex(rp, 0.0)
 
 def EpsilonAO(env, k):
     '''Cubic axes, odd symmetry (k, p; k+Q, p)
     '''
-    ip = -2.0 * env["M"] * (env["Tao"]*(np.sin(k[0])+np.sin(k[1])) + env["Tco"]*np.sin(k[2]))
+    T = Hoppings(env)
+    ip = -2.0 * env["M"] * (T["Tao"]*(np.sin(k[0])+np.sin(k[1])) + T["Tco"]*np.sin(k[2]))
     return complex(0.0, ip)
 
 def EpsilonBO(env, k):
     '''Body diagonal, odd symmetry (k, p; k+Q, pbar)
     '''
-    rp = -8.0 * env["M"] * env["Tbo"] * np.cos(k[0]/2.0) * np.cos(k[1]/2.0) * np.cos(k[2]/2.0)
-    ip = 8.0 * env["M"] * env["Tbo"] * np.sin(k[0]/2.0) * np.sin(k[1]/2.0) * np.sin(k[2]/2.0)
+    T = Hoppings(env)
+    rp = -8.0 * env["M"] * T["Tbo"] * np.cos(k[0]/2.0) * np.cos(k[1]/2.0) * np.cos(k[2]/2.0)
+    ip = 8.0 * env["M"] * T["Tbo"] * np.sin(k[0]/2.0) * np.sin(k[1]/2.0) * np.sin(k[2]/2.0)
     return complex(rp, ip)
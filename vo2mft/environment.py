@@ -1,3 +1,5 @@
+import math
+
 # Functions that operate on environments and final environments.
 # Those that take 'env' operate on either;
 # those that that 'fenv' operate only on final environments.
@@ -8,9 +10,32 @@ def QJ_ion(env):
     return 4.0*env["Ja"] + 2.0*env["Jc"]
 
 def QJ_el(fenv):
-    return 4.0*fenv["Tao"]*fenv["Dao"] + 2.0*fenv["Tco"]*fenv["Dco"]
+    T = Hoppings(fenv)
+    return 4.0*T["Tao"]*fenv["Dao"] + 2.0*T["Tco"]*fenv["Dco"]
 
 def DeltaS(env):
-    # TODO - generalize away from n = 1?
-    n = 1.0
+    n = env.get("Filling", 1.0)
     return env["B"] + (env["EpsilonM"] - env["EpsilonR"])*n
+
+def Hoppings(env):
+    '''Return a dict with the strained hoppings Tae, Tce, Tbe, Tao, Tco, Tbo
+    (see Environment.Hoppings in vo2solve/strain.go).
+    '''
+    e = [env.get("Strain_" + c, 0.0) for c in ["xx", "yy", "zz"]]
+    bond_strains = {"a": 0.5*(e[0] + e[1]), "c": e[2], "b": (e[0] + e[1] + e[2])/3.0}
+    scaling = env.get("HoppingScaling", "")
+    T = {}
+    for bond in ["a", "c", "b"]:
+        eta = env.get("Eta_" + bond, 0.0)
+        fac = _hopping_scale(scaling, eta, bond_strains[bond])
+        for sym in ["e", "o"]:
+            name = "T" + bond + sym
+            T[name] = env[name]*fac
+    return T
+
+def _hopping_scale(scaling, eta, e):
+    if eta == 0.0:
+        return 1.0
+    if scaling == "exponential":
+        return math.exp(-eta*e)
+    return (1.0 + e)**(-eta)
@@ -0,0 +1,69 @@
+import subprocess
+import os
+import json
+from uuid import uuid4
+import numpy as np
+import matplotlib.pyplot as plt
+from vo2mft.solve import write_env_file
+from vo2mft.util import _landscape_front_path, _twodof_landscape_front_path
+
+def landscape(env, grid_vars, mins, maxs, nums, relax, eps=1e-8, ions=False, twodof=False):
+    '''Return the free energy landscape of env (as parsed from the JSON output
+    of landscape_front) on the grid with len(grid_vars) axes, where axis i
+    has nums[i] points between mins[i] and maxs[i]. The variables named in
+    relax are solved for at each grid point; all others are frozen.
+    '''
+    front_path = _landscape_front_path()
+    if twodof:
+        front_path = _twodof_landscape_front_path()
+
+    in_path, out_path = str(uuid4()), str(uuid4())
+    write_env_file(env, in_path)
+
+    join = lambda xs: ",".join([str(x) for x in xs])
+    front_call = [front_path, "--eps", str(eps), "--vars", join(grid_vars),
+            "--min", join(mins), "--max", join(maxs), "--num", join(nums),
+            "--relax", join(relax)]
+    if ions:
+        front_call.append("--ions")
+    front_call.extend([in_path, out_path])
+    subprocess.call(front_call)
+
+    ls_path = out_path + "_landscape.json"
+    ls = None
+    try:
+        with open(ls_path, 'r') as fp:
+            ls = json.loads(fp.read())
+    except FileNotFoundError:
+        pass
+
+    try:
+        os.remove(in_path)
+        os.remove(ls_path)
+    except FileNotFoundError:
+        pass
+
+    return ls
+
+def plot_landscape(ls, out_path=None, num_levels=40):
+    '''Make a contour plot of the free energy in the 2D landscape ls.
+    Points where the relaxed variables did not converge are left blank.
+    '''
+    xs = sorted(set([p["Grid"][0] for p in ls["Points"]]))
+    ys = sorted(set([p["Grid"][1] for p in ls["Points"]]))
+    F = np.full((len(ys), len(xs)), np.nan)
+    for p in ls["Points"]:
+        if p["Converged"]:
+            F[ys.index(p["Grid"][1]), xs.index(p["Grid"][0])] = p["FreeEnergy"]
+
+    plt.xlabel(ls["GridVars"][0])
+    plt.ylabel(ls["GridVars"][1])
+    plt.title("Relaxed: " + ", ".join(ls["RelaxedVars"]))
+    plt.contourf(xs, ys, F, num_levels, cmap='gnuplot')
+    plt.colorbar()
+
+    if out_path == None:
+        plt.show()
+    else:
+        plt.savefig(out_path + '.png', bbox_inches='tight', dpi=500)
+    plt.clf()
@@ -1,10 +1,12 @@
 from copy import deepcopy
 from vo2mft.solve import solve_set
 
-def minimize_free_energy(env, eps=1e-6, ions=False, twodof=False, twodof_body_indep=False, initial_vals=None):
+def minimize_free_energy(env, eps=1e-6, ions=False, twodof=False, twodof_body_indep=False, initial_vals=None, thermo=False):
     '''Vary the initial conditions of env to find the solution which
     minimizes the free energy. Return the minimum free energy env and
     a list of all the solved envs.
+
+    If thermo is True, the solved envs also include Entropy and SpecificHeat.
     '''
     # Set of initial conditions to consider.
     # TODO - may need to expand this.
@@ -33,6 +35,11 @@ def minimize_free_energy(env, eps=1e-6, ions=False, twodof=False, twodof_body_in
                 {"M01": 1.0, "M02": 1.0}]
         flags = [[], ["--m01_0", "--m02_0"], ["--m02_0"]]
 
+    if thermo:
+        if flags == None:
+            flags = [[] for cond in initial_conds]
+        flags = [this_flags + ["--thermo"] for this_flags in flags]
+
     # Set up envs with specified set of initial conditions.
     initial_envs = []
     for cond in initial_conds:
@@ -46,13 +53,39 @@ def minimize_free_energy(env, eps=1e-6, ions=False, twodof=False, twodof_body_in
     #print(final_envs)
 
     # Find env with minimum free energy.
+    # Unstable solutions (saddle points or maxima of the free energy) are
+    # only considered if no stable solution was found.
     min_env = None
     for final_env in final_envs:
         # May not have found a solution.
         if final_env == None:
             continue
-        free_energy = final_env["FreeEnergy"]
-        if min_env == None or free_energy < min_env["FreeEnergy"]:
+        if min_env == None or _lower_free_energy(final_env, min_env):
             min_env = final_env
 
+    _label_metastable(final_envs, min_env)
+
     return min_env, final_envs
+
+def _is_unstable(final_env):
+    return final_env.get("Stability") == "unstable"
+
+def _lower_free_energy(env_a, env_b):
+    '''Return True if env_a should be preferred over env_b as the minimum
+    free energy solution.
+    '''
+    if _is_unstable(env_a) != _is_unstable(env_b):
+        return _is_unstable(env_b)
+    return env_a["FreeEnergy"] < env_b["FreeEnergy"]
+
+def _label_metastable(final_envs, min_env, eps=1e-9):
+    '''Label stable solutions with free energy above that of min_env as
+    metastable.
+    '''
+    if min_env == None:
+        return
+    for final_env in final_envs:
+        if final_env == None or final_env.get("Stability") != "stable":
+            continue
+        if final_env["FreeEnergy"] > min_env["FreeEnergy"] + eps:
+            final_env["Stability"] = "metastable"
@@ -0,0 +1,44 @@
+import subprocess
+import os
+import json
+from uuid import uuid4
+from vo2mft.solve import write_env_file
+from vo2mft.util import _twodof_montecarlo_front_path
+from vo2mft.thermo import temperature_sweep
+
+def monte_carlo(env, Tmax, Tmin, numT, L=8, therm=1000, sweeps=10000, seed=1):
+    '''Return the Monte Carlo results (as parsed from the JSON output of
+    montecarlo_front) for the twodof ionic model in env, cooling from Tmax to
+    Tmin in numT steps on an L x L x L lattice.
+    '''
+    in_path, out_path = str(uuid4()), str(uuid4())
+    write_env_file(env, in_path)
+
+    front_call = [_twodof_montecarlo_front_path(), "--L", str(L), "--therm", str(therm),
+            "--sweeps", str(sweeps), "--seed", str(seed), "--Tmax", str(Tmax),
+            "--Tmin", str(Tmin), "--numT", str(numT), in_path, out_path]
+    subprocess.call(front_call)
+
+    mc_path = out_path + "_montecarlo.json"
+    mc = None
+    try:
+        with open(mc_path, 'r') as fp:
+            mc = json.loads(fp.read())
+    except FileNotFoundError:
+        pass
+
+    try:
+        os.remove(in_path)
+        os.remove(mc_path)
+    except FileNotFoundError:
+        pass
+
+    return mc
+
+def mean_field_comparison(env, mc, eps=1e-6):
+    '''Return a list with elements (T, MC result, minimum free energy
+    mean-field env) at each temperature of the Monte Carlo results mc.
+    '''
+    Ts = [r["T"] for r in mc["Results"]]
+    min_envs, _ = temperature_sweep(env, Ts, eps, ions=True, twodof=True)
+    return list(zip(Ts, mc["Results"], min_envs))
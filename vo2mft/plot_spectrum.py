@@ -1,93 +1,71 @@
-import numpy as np
+import subprocess
+import os
+import json
+from uuid import uuid4
 import matplotlib.pyplot as plt
-from vo2mft.elHamiltonian import ElHamiltonian
+from vo2mft.solve import write_env_file
+from vo2mft.util import _bands_front_path
 
-def _sc_kpath(alat):
-    # k-path runs over simple cubic Brillouin zone:
-    # G-X-M-G-R-X-M-R.
-    labels = ["$\\Gamma$", "$X$", "$M$", "$\\Gamma$", "$R$", "$X$", "$M$", "$R$"]
+def bands(env, path="G-X-M-G-R-X-M-R", kpoints_per_panel=50, twodof=False, weights=False):
+    '''Return the band structure of env along path (as parsed from the JSON
+    output of bands_front). path is a '-'-separated list of vertices, each the
+    name of a simple cubic high-symmetry point (G, X, M, R) or reciprocal
+    lattice coordinates "k1,k2,k3", optionally labelled as "label=k1,k2,k3".
+    If weights is True, include the weights of each band on the basis states.
+    Raise RuntimeError, including the output of bands_front, if it does not
+    produce the band structure.
+    '''
+    in_path, out_path = str(uuid4()), str(uuid4())
+    write_env_file(env, in_path)
 
-    # High-symmetry points in reciprocal lattice coordinates.
-    G = (0.0, 0.0, 0.0)
-    M = (0.5, 0.5, 0.0)
-    R = (0.5, 0.5, 0.5)
-    X = (0.0, 0.5, 0.0)
-    # Columns of Dlat = direct lattice vectors.
-    Dlat = np.array([[alat, 0.0, 0.0], [0.0, alat, 0.0], [0.0, 0.0, alat]])
-    # Rows of Rlat = reciprocal lattice vectors.
-    Rlat = 2.0 * np.pi * np.linalg.inv(Dlat)
+    front_call = [_bands_front_path(), "--path", path, "--num", str(kpoints_per_panel)]
+    if twodof:
+        front_call.append("--twodof")
+    if weights:
+        front_call.append("--weights")
+    front_call.extend([in_path, out_path])
+    # The Go fronts report errors on stdout, so keep both streams.
+    proc = subprocess.Popen(front_call, stdout=subprocess.PIPE, stderr=subprocess.PIPE, universal_newlines=True)
+    front_out, front_err = proc.communicate()
 
-    # High-symmetry points in Cartesian coordinates.
-    Gc = np.dot(G, Rlat)
-    Mc = np.dot(M, Rlat)
-    Rc = np.dot(R, Rlat)
-    Xc = np.dot(X, Rlat)
+    bands_path = out_path + "_bands.json"
+    result = None
+    try:
+        with open(bands_path, 'r') as fp:
+            result = json.loads(fp.read())
+    except FileNotFoundError:
+        pass
 
-    kpath = (Gc, Xc, Mc, Gc, Rc, Xc, Mc, Rc)
-    return kpath, labels
+    try:
+        os.remove(in_path)
+        os.remove(bands_path)
+    except FileNotFoundError:
+        pass
 
-def _interpolate_kpoints(kpath, kpoints_per_panel):
-    interpolated = []
-    for panel_index in range(len(kpath)):
-        # Take panel from kpath[i-1] to kpath[i].
-        if panel_index == 0:
-            continue
-        kstart = kpath[panel_index-1]
-        kstop = kpath[panel_index]
-        step = np.subtract(kstop, kstart) / (kpoints_per_panel - 1)
-        k = kstart
-        for k_index in range(kpoints_per_panel):
-            # To avoid doubling k-points at panel ends, take panel start point
-            # only on first panel.
-            if k_index == 0:
-                if panel_index == 1:
-                    interpolated.append(k)
-                continue
-            # Past panel start point.
-            k = np.add(k, step)
-            interpolated.append(k)
+    if result is None:
+        raise RuntimeError("bands_front failed with exit status {}:\n{}{}".format(proc.returncode, front_err, front_out))
+    return result
 
-    return interpolated
+def _tex_label(label):
+    if label == "G":
+        return "$\\Gamma$"
+    return "${}$".format(label)
 
-def _collect_ys(env, kpath):
-    ys = None
-    for k in kpath:
-        H = ElHamiltonian(env, k)
-        evals = sorted(np.linalg.eigvalsh(H))
-        # Initialize ys to len(evals)-length list of lists.
-        if ys == None:
-            ys = []
-            for i in range(len(evals)):
-                ys.append([])
-        # For each eval, put it in the corresponding list in ys based on its
-        # sorted index.
-        for i, this_eval in enumerate(evals):
-            ys[i].append(this_eval)
-
-    return ys
-
-def plot_spectrum(env, plot_filename=None):
-    alat = 1.0
+def plot_spectrum(env, plot_filename=None, path="G-X-M-G-R-X-M-R", twodof=False):
     kpoints_per_panel = 50
+    result = bands(env, path, kpoints_per_panel, twodof)
 
-    kpath, labels = _sc_kpath(alat)
-    all_ks = _interpolate_kpoints(kpath, kpoints_per_panel)
-    xs = range(len(all_ks)) # TODO - scale by distance between kpoints
-    ys = _collect_ys(env, all_ks)
+    xs = result["Distance"]
+    num_bands = len(result["Energies"][0])
+    ys = [[Es[i] for Es in result["Energies"]] for i in range(num_bands)]
 
     # Set plot boundaries.
     plt.xlim(0, xs[-1])
 
     # Set symmetry point axis markers/lines.
-    sym_xs = [0]
-    for i in range(len(labels)):
-        if i == 0:
-            continue
-        sym_xs.append(sym_xs[-1] + kpoints_per_panel - 1)
-
-    for x in sym_xs:
+    for x in result["TickDistances"]:
         plt.axvline(x, color='k')
-    plt.xticks(sym_xs, labels)
+    plt.xticks(result["TickDistances"], [_tex_label(l) for l in result["TickLabels"]])
 
     # Plot data.
     for y_set in ys:
@@ -19,19 +19,12 @@ def solve(env, eps=1e-8, ions=False, flags=None, twodof=False, twodof_body_indep
     write_env_file(env, in_path)
 
     # Run solver.
-    solver_call = None
-    if twodof:
-        solver_call = [solver_path, "--eps", str(eps)]
-        if ions:
-            solver_call.append("--ions")
-        if flags != None:
-            solver_call.extend(flags)
-        solver_call.extend([in_path, out_path])
-    elif ions and not twodof:
-        solver_call = [solver_path, "--eps", str(eps), "--ions"]
-        if flags != None:
-            solver_call.extend(flags)
-        solver_call.extend([in_path, out_path])
+    solver_call = [solver_path, "--eps", str(eps)]
+    if ions:
+        solver_call.append("--ions")
+    if flags != None:
+        solver_call.extend(flags)
+    solver_call.extend([in_path, out_path])
     subprocess.call(solver_call)
 
     # Read solver output, if it exists.
@@ -0,0 +1,21 @@
+from copy import deepcopy
+from vo2mft.min_free_energy import minimize_free_energy
+
+def strain_sweep(env, strain_vars, vals, eps=1e-6, ions=False, twodof=False, twodof_body_indep=False, initial_vals=None):
+    '''Find the minimum free energy solution of env at each strain value in
+    vals. All of the env keys in strain_vars (e.g. ["Strain_xx", "Strain_yy"]
+    for biaxial strain in the ab plane, or ["Misfit_a"] for a twodof film)
+    are set to each value. Return the list of minimum free energy envs and the
+    list of all solved envs at each strain value.
+    '''
+    min_envs, all_final_envs = [], []
+    for val in vals:
+        this_env = deepcopy(env)
+        for var in strain_vars:
+            this_env[var] = val
+        min_env, final_envs = minimize_free_energy(this_env, eps, ions, twodof,
+                twodof_body_indep, initial_vals)
+        min_envs.append(min_env)
+        all_final_envs.append(final_envs)
+
+    return min_envs, all_final_envs
@@ -0,0 +1,76 @@
+from copy import deepcopy
+from vo2mft.min_free_energy import minimize_free_energy
+
+def temperature_sweep(env, Ts, eps=1e-6, ions=False, twodof=False, twodof_body_indep=False, initial_vals=None):
+    '''Find the minimum free energy solution of env at each temperature in
+    Ts, including entropy and specific heat. Return the list of minimum free
+    energy envs and the list of all solved envs at each temperature.
+    '''
+    min_envs, all_final_envs = [], []
+    for T in Ts:
+        this_env = deepcopy(env)
+        this_env["Beta"] = 1.0/T
+        min_env, final_envs = minimize_free_energy(this_env, eps, ions, twodof,
+                twodof_body_indep, initial_vals, thermo=True)
+        min_envs.append(min_env)
+        all_final_envs.append(final_envs)
+
+    return min_envs, all_final_envs
+
+def electron_temperature_sweep(env, Tels, eps=1e-6, twodof=False, twodof_body_indep=False, initial_vals=None):
+    '''Find the minimum free energy solution of env at each electron
+    temperature in Tels, with the ions held at the temperature given by
+    env["Beta"] (or env["BetaIon"] if set). Return the list of minimum free
+    energy envs and the list of all solved envs at each electron temperature.
+
+    The electrons and ions are not in equilibrium with each other, so entropy
+    and specific heat are not calculated.
+    '''
+    min_envs, all_final_envs = [], []
+    for Tel in Tels:
+        this_env = deepcopy(env)
+        this_env["BetaEl"] = 1.0/Tel
+        min_env, final_envs = minimize_free_energy(this_env, eps, False, twodof,
+                twodof_body_indep, initial_vals)
+        min_envs.append(min_env)
+        all_final_envs.append(final_envs)
+
+    return min_envs, all_final_envs
+
+def latent_heats(min_envs, all_final_envs, val_name="M", jump_tol=0.1):
+    '''Find first-order transitions in a temperature sweep produced by
+    temperature_sweep and return a list with elements (T, L) giving the
+    latent heat per cell L at each transition.
+
+    A transition is detected where val_name jumps by more than jump_tol
+    between consecutive minimum free energy envs. L = T (S_new - S_old) is
+    evaluated at the first temperature after the jump, using the solution on
+    the old branch if it is still present among the solved envs there.
+    '''
+    transitions = []
+    for i in range(1, len(min_envs)):
+        prev_env, this_env = min_envs[i-1], min_envs[i]
+        # May not have found a solution for all temperatures.
+        if prev_env == None or this_env == None:
+            continue
+        if abs(abs(this_env[val_name]) - abs(prev_env[val_name])) < jump_tol:
+            continue
+
+        old_branch = _closest_env(all_final_envs[i], abs(prev_env[val_name]), val_name)
+        if old_branch == None or abs(abs(old_branch[val_name]) - abs(prev_env[val_name])) >= jump_tol:
+            old_branch = prev_env
+
+        T = 1.0/this_env["Beta"]
+        L = T*(this_env["Entropy"] - old_branch["Entropy"])
+        transitions.append((T, L))
+
+    return transitions
+
+def _closest_env(envs, val, val_name):
+    closest = None
+    for env in envs:
+        if env == None:
+            continue
+        if closest == None or abs(abs(env[val_name]) - val) < abs(abs(closest[val_name]) - val):
+            closest = env
+    return closest
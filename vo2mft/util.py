@@ -18,3 +18,24 @@ def _twodof_body_fixed_solve_front_path():
 
 def _run_dos_path():
     return os.path.join(_base_dir(), "tetra_dos", "RunDosValues.out")
+
+def _landscape_front_path():
+    return os.path.join(_base_dir(), "vo2solve", "landscape_front", "landscape_front")
+
+def _twodof_landscape_front_path():
+    return os.path.join(_base_dir(), "twodof", "landscape_front", "landscape_front")
+
+def _twodof_montecarlo_front_path():
+    return os.path.join(_base_dir(), "twodof", "montecarlo_front", "montecarlo_front")
+
+def _dynamics_front_path():
+    return os.path.join(_base_dir(), "vo2solve", "dynamics_front", "dynamics_front")
+
+def _twodof_dynamics_front_path():
+    return os.path.join(_base_dir(), "twodof", "dynamics_front", "dynamics_front")
+
+def _dos_front_path():
+    return os.path.join(_base_dir(), "tetra", "dos_front", "dos_front")
+
+def _bands_front_path():
+    return os.path.join(_base_dir(), "tetra", "bands_front", "bands_front")
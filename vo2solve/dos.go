@@ -0,0 +1,116 @@
+package vo2solve
+
+import (
+	"math"
+	"math/cmplx"
+	"sort"
+)
+import (
+	"github.com/tflovorn/cmatrix"
+	vec "github.com/tflovorn/scExplorer/vector"
+	"github.com/tflovorn/vo2mft/tetra"
+)
+
+// Precision in k (in the reciprocal lattice basis) of the band edges.
+const band_edge_tol = 1e-8
+
+// Labels of the basis states of ElHamiltonian (see evalEV).
+var el_basis = []string{"k,0", "k+Q,0", "k,1", "k+Q,1"}
+
+// Return the reciprocal lattice vectors (as rows): those of the
+// tight-binding model if env.TightBinding is set, and otherwise those of the
+// cubic lattice with lattice constant 1.
+func (env *Environment) recipLattice() [3][3]float64 {
+	if env.tb != nil {
+		return env.tb.RecipLattice()
+	}
+	return tetra.CubicR(1.0)
+}
+
+// Return the labels of the basis states: the orbital labels of the
+// tight-binding model if env.TightBinding is set, and otherwise el_basis.
+// If ElHamiltonian includes both spins, the labels are repeated for each
+// spin.
+func (env *Environment) basisLabels() []string {
+	labels := el_basis
+	if env.tb != nil {
+		labels = env.tb.Labels()
+	}
+	if !env.spinful() {
+		return labels
+	}
+	spin_labels := []string{}
+	for _, spin := range []string{"up", "down"} {
+		for _, label := range labels {
+			spin_labels = append(spin_labels, label+","+spin)
+		}
+	}
+	return spin_labels
+}
+
+// Return the band energies of the electronic Hamiltonian (in ascending
+// order) as a function of k in the reciprocal lattice basis.
+func (env *Environment) BandEnergies() tetra.EnergyFunc {
+	return func(k []float64) []float64 {
+		kc := vec.Vector{2.0 * math.Pi * k[0], 2.0 * math.Pi * k[1], 2.0 * math.Pi * k[2]}
+		evals, _ := cmatrix.Eigensystem(ElHamiltonian(env, kc))
+		sort.Float64s(evals)
+		return evals
+	}
+}
+
+// Return the electronic density of states at num_dos energies between the
+// minimum and maximum band energies, using the tetrahedron method with an
+// n x n x n k-point grid. Energies are measured from Mu.
+func (env *Environment) Dos(n, num_dos int) *tetra.Dos {
+	return tetra.DosValues(env.BandEnergies(), n, num_dos, env.recipLattice())
+}
+
+// Return the band energies of the electronic Hamiltonian (in ascending
+// order) and the weights of each band on the basis states (see
+// basisLabels), as a function of k in the reciprocal lattice basis.
+func (env *Environment) BandProjections() tetra.ProjectedEnergyFunc {
+	return func(k []float64) ([]float64, [][]float64) {
+		kc := vec.Vector{2.0 * math.Pi * k[0], 2.0 * math.Pi * k[1], 2.0 * math.Pi * k[2]}
+		evals, evecs := cmatrix.Eigensystem(ElHamiltonian(env, kc))
+		dim := len(evals)
+		order := make([]int, dim)
+		for alpha := 0; alpha < dim; alpha++ {
+			order[alpha] = alpha
+		}
+		sort.Slice(order, func(a, b int) bool {
+			return evals[order[a]] < evals[order[b]]
+		})
+		Es := make([]float64, dim)
+		weights := make([][]float64, dim)
+		for b, alpha := range order {
+			Es[b] = evals[alpha]
+			weights[b] = make([]float64, dim)
+			for i := 0; i < dim; i++ {
+				// Eigenvectors are in rows (see evalEV).
+				weights[b][i] = math.Pow(cmplx.Abs(evecs[alpha][i]), 2.0)
+			}
+		}
+		return Es, weights
+	}
+}
+
+// Return the electronic density of states and its projections onto the
+// basis states (the sublattices at k and k+Q; see basisLabels), as for Dos.
+func (env *Environment) ProjectedDos(n, num_dos int) *tetra.ProjectedDos {
+	return tetra.ProjectedDosValues(env.BandProjections(), n, num_dos, env.recipLattice(), env.basisLabels())
+}
+
+// Return the band gap around Mu and the band edges, using the BZPointsPerDim
+// k-point mesh refined by local search (see tetra.FindBandEdges).
+func (env *Environment) BandEdges() tetra.BandEdges {
+	return tetra.FindBandEdges(env.BandEnergies(), env.BZPointsPerDim, band_edge_tol)
+}
+
+// Return the band energies along the k-path through vertices (in the
+// reciprocal lattice basis, with the given labels), with per_panel k-points
+// between consecutive vertices. If weights is true, include the weights of
+// each band on the basis states (see basisLabels).
+func (env *Environment) Bands(vertices [][3]float64, labels []string, per_panel int, weights bool) *tetra.Bands {
+	return tetra.BandsAlongPath(env.BandProjections(), vertices, labels, per_panel, env.recipLattice(), env.basisLabels(), weights)
+}
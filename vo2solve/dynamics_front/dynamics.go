@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+import (
+	"github.com/tflovorn/vo2mft/vo2solve"
+)
+
+var eps = flag.Float64("eps", 1e-6, "Converged when error below eps")
+var ions = flag.Bool("ions", false, "Consider only ionic system")
+var rate = flag.Float64("rate", 1.0, "Relaxation rate (kinetic coefficient)")
+var dt = flag.Float64("dt", 0.01, "Time step")
+var steps = flag.Int("steps", 1000, "Number of time steps")
+var every = flag.Int("every", 10, "Record the trajectory every EVERY steps")
+var schedule = flag.String("schedule", "", "Path to JSON list of schedules for time-dependent variables")
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 2 {
+		fmt.Println("Usage: dynamics_front [--eps EPS] [--ions] [--rate RATE] [--dt DT] [--steps STEPS] [--every EVERY] [--schedule SCHEDULE] in_path out_path")
+		fmt.Println("For flag descriptions, use: dynamics_front --help")
+		os.Exit(2)
+	}
+	in_path := args[0]
+	out_path := args[1]
+
+	var env *vo2solve.Environment
+	var err error
+	if !*ions {
+		env, err = vo2solve.LoadEnv(in_path)
+	} else {
+		env, err = vo2solve.LoadIonEnv(in_path)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	schedules := []vo2solve.Schedule{}
+	if *schedule != "" {
+		schedules, err = vo2solve.LoadSchedules(*schedule)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	traj, err := vo2solve.Relax(env, schedules, *rate, *dt, *steps, *every, *eps, *eps)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// Write output.
+	traj_out_buf := bytes.NewBufferString(traj.Marshal())
+	ioutil.WriteFile(out_path+"_dynamics.json", traj_out_buf.Bytes(), 0644) // u=rw;go=r
+}
@@ -1,19 +1,40 @@
 package vo2solve
 
 import (
+	"fmt"
 	"math"
 	"math/cmplx"
+	"reflect"
 )
 import (
 	"github.com/tflovorn/cmatrix"
+	"github.com/tflovorn/scExplorer/bzone"
 	vec "github.com/tflovorn/scExplorer/vector"
+	"github.com/tflovorn/vo2mft/tightbinding"
 )
 
 // Calculate 4x4 electronic Hamiltonian.
 // k is in the Cartesian basis, with each component scaled by the corresponding
 // lattice constant; i.e. k = (a kx, a ky, c kz) and a kx, a ky, c kz range
 // over [-pi, pi) and periodic copies of this interval.
+// If env.TightBinding is set, H(k) is given by the tight-binding model.
+// With the Hubbard interactions (see Environment.U) or a Zeeman field, H(k)
+// has a block for each spin (see spinHamiltonian).
 func ElHamiltonian(env *Environment, k vec.Vector) cmatrix.CMatrix {
+	H := spinlessHamiltonian(env, k)
+	if env.spinful() {
+		return env.spinHamiltonian(H, k)
+	}
+	return H
+}
+
+// Calculate the electronic Hamiltonian for one spin without the Hubbard
+// interactions (see ElHamiltonian).
+func spinlessHamiltonian(env *Environment, k vec.Vector) cmatrix.SliceCMatrix {
+	if env.tb != nil {
+		kr := []float64{k[0] / (2.0 * math.Pi), k[1] / (2.0 * math.Pi), k[2] / (2.0 * math.Pi)}
+		return cmatrix.SliceCMatrix(env.tb.Hamiltonian(kr, env.tbParameter))
+	}
 	KQ := vec.Vector{math.Pi, math.Pi, math.Pi}
 	k.Add(&KQ) // now KQ = k + Q
 
@@ -53,25 +74,221 @@ func ElHamiltonian(env *Environment, k vec.Vector) cmatrix.CMatrix {
 
 // Cubic axes, even symmetry (k, p; k, p)
 func EpsilonAE(env *Environment, k vec.Vector) complex128 {
-	rp := -2.0 * (env.Tae*(math.Cos(k[0])+math.Cos(k[1])) + env.Tce*math.Cos(k[2]))
+	rp := -2.0 * (env.Tae_eff()*(math.Cos(k[0])+math.Cos(k[1])) + env.Tce_eff()*math.Cos(k[2]))
 	return complex(rp, 0.0)
 }
 
 // Body diagonal, even symmetry (k, p; k, pbar)
 func EpsilonBE(env *Environment, k vec.Vector) complex128 {
-	rp := -8.0 * env.Tbe * math.Cos(k[0]/2.0) * math.Cos(k[1]/2.0) * math.Cos(k[2]/2.0)
+	rp := -8.0 * env.Tbe_eff() * math.Cos(k[0]/2.0) * math.Cos(k[1]/2.0) * math.Cos(k[2]/2.0)
 	return complex(rp, 0.0)
 }
 
 // Cubic axes, odd symmetry (k, p; k+Q, p)
 func EpsilonAO(env *Environment, k vec.Vector) complex128 {
-	ip := -2.0 * env.M * (env.Tao*(math.Sin(k[0])+math.Sin(k[1])) + env.Tco*math.Sin(k[2]))
+	ip := -2.0 * env.M * (env.Tao_eff()*(math.Sin(k[0])+math.Sin(k[1])) + env.Tco_eff()*math.Sin(k[2]))
 	return complex(0.0, ip)
 }
 
 // Body diagonal, odd symmetry (k, p; k+Q, pbar)
 func EpsilonBO(env *Environment, k vec.Vector) complex128 {
-	rp := -8.0 * env.M * env.Tbo * math.Cos(k[0]/2.0) * math.Cos(k[1]/2.0) * math.Cos(k[2]/2.0)
-	ip := 8.0 * env.M * env.Tbo * math.Sin(k[0]/2.0) * math.Sin(k[1]/2.0) * math.Sin(k[2]/2.0)
+	rp := -8.0 * env.M * env.Tbo_eff() * math.Cos(k[0]/2.0) * math.Cos(k[1]/2.0) * math.Cos(k[2]/2.0)
+	ip := 8.0 * env.M * env.Tbo_eff() * math.Sin(k[0]/2.0) * math.Sin(k[1]/2.0) * math.Sin(k[2]/2.0)
 	return complex(rp, ip)
 }
+
+// Hoppings which enter tight-binding models with their strained values.
+var tb_strained = map[string]func(env *Environment) float64{
+	"Tae": (*Environment).Tae_eff,
+	"Tce": (*Environment).Tce_eff,
+	"Tbe": (*Environment).Tbe_eff,
+	"Tao": (*Environment).Tao_eff,
+	"Tco": (*Environment).Tco_eff,
+	"Tbo": (*Environment).Tbo_eff,
+	// pi* orbitals of the t2g model (see tightbinding.VO2SolveT2gModel),
+	// with the same bond length dependence as the d_par hoppings.
+	"Tae_pi": func(env *Environment) float64 {
+		e_a, _, _ := env.BondStrains()
+		return env.Tae_pi * hoppingScale(env.HoppingScaling, env.Eta_a, e_a)
+	},
+	"Tce_pi": func(env *Environment) float64 {
+		_, e_c, _ := env.BondStrains()
+		return env.Tce_pi * hoppingScale(env.HoppingScaling, env.Eta_c, e_c)
+	},
+	"Tbe_pi": func(env *Environment) float64 {
+		_, _, e_b := env.BondStrains()
+		return env.Tbe_pi * hoppingScale(env.HoppingScaling, env.Eta_b, e_b)
+	},
+}
+
+// Return the value of the tight-binding model parameter with the given name:
+// the strained hopping for the hopping parameters, and otherwise the
+// Environment field with that name.
+func (env *Environment) tbParameter(name string) float64 {
+	if f, ok := tb_strained[name]; ok {
+		return f(env)
+	}
+	return env.GetFloat(name)
+}
+
+// Load the tight-binding model given by env.TightBinding, if any, folded
+// with env.Q if it is set (see wavevector.go). Return an error if its states
+// do not form blocks in the basis of ElHamiltonian (see evalEV) or it has a
+// parameter which is neither a hopping nor a float64 Environment field.
+func (env *Environment) loadTightBinding() error {
+	env.tb = nil
+	if env.TightBinding == "" && !env.folded() {
+		return nil
+	}
+	var m *tightbinding.Model
+	var err error
+	if env.folded() {
+		name := env.TightBinding
+		if name == "" {
+			name = "vo2solve"
+		}
+		if !isPreset(name) {
+			// A model loaded from a file has its own modulation
+			// wavevector, which Q cannot change.
+			return fmt.Errorf("Q requires TightBinding to be one of the presets %v; got %v (leave Q unset for a model loaded from a file)", tightbinding.PresetNames(), name)
+		}
+		m, err = tightbinding.PresetQ(name, *env.Q)
+	} else {
+		m, err = tightbinding.Load(env.TightBinding)
+	}
+	if err != nil {
+		return err
+	}
+	if m.NumOrbitals()%env.numBlocks() != 0 {
+		return fmt.Errorf("Tight-binding model has %v orbitals; expected a multiple of %v (blocks %v)", m.NumOrbitals(), len(el_basis), el_basis)
+	}
+	ev := reflect.ValueOf(env).Elem()
+	for _, name := range m.Parameters() {
+		if _, ok := tb_strained[name]; ok {
+			continue
+		}
+		field := ev.FieldByName(name)
+		if !field.IsValid() || field.Kind() != reflect.Float64 {
+			return fmt.Errorf("Unknown tight-binding model parameter %v", name)
+		}
+	}
+	env.tb = m
+	env.tb_dimer = nil
+	nb := m.NumOrbitals() / env.numBlocks()
+	seen := make([]bool, nb)
+	for _, i := range m.OrbitalsWith([]string{"M"}) {
+		seen[i%nb] = true
+	}
+	for a := 0; a < nb; a++ {
+		if seen[a] {
+			env.tb_dimer = append(env.tb_dimer, a)
+		}
+	}
+	return nil
+}
+
+// Return true if name is one of the tight-binding presets.
+func isPreset(name string) bool {
+	for _, preset := range tightbinding.PresetNames() {
+		if name == preset {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the orbitals (indices within each block of the basis of
+// ElHamiltonian; see evalEV) which are coupled by M, and so give the
+// hopping expectation values. If no orbital is coupled by M, return all
+// orbitals.
+func (env *Environment) dimerOrbitals() []int {
+	if env.tb == nil {
+		return []int{0}
+	}
+	if len(env.tb_dimer) != 0 {
+		return env.tb_dimer
+	}
+	nb := env.numOrbitals()
+	all := make([]int, nb)
+	for a := 0; a < nb; a++ {
+		all[a] = a
+	}
+	return all
+}
+
+// Return true if the electronic Hamiltonian may depend on W.
+func (env *Environment) dependsOnW() bool {
+	if env.tb == nil {
+		return env.EpsilonR != env.EpsilonM
+	}
+	for _, name := range env.tb.Parameters() {
+		if name == "W" {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the values of the tight-binding model parameters other than M, W
+// and Mu (in the order of tightbinding.Model.Parameters), or nil if
+// env.TightBinding is not set.
+func (env *Environment) tbHoppings() []float64 {
+	if env.tb == nil {
+		return nil
+	}
+	vals := []float64{}
+	for _, name := range env.tb.Parameters() {
+		if name == "M" || name == "W" || name == "Mu" {
+			continue
+		}
+		vals = append(vals, env.tbParameter(name))
+	}
+	return vals
+}
+
+// Return true iff a and b have the same length and elements.
+func equalFloats(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Return the occupation per V of each orbital (indices within each block
+// of the basis of ElHamiltonian; see evalEV), including both spins. The
+// occupations sum to the electron number per V (Filling, when Mu is solved).
+// For ElHamiltonian as written, there is one orbital.
+func (env *Environment) OrbitalOccupations() []float64 {
+	nb := env.numOrbitals()
+	n := env.numBlocks() * nb
+	inner := func(k vec.Vector, a int) float64 {
+		evals, evecs := cmatrix.Eigensystem(ElHamiltonian(env, k))
+		sum := 0.0
+		for alpha := range evals {
+			w := 0.0
+			for sigma := 0; sigma < env.spinBlocks(); sigma++ {
+				for b := 0; b < env.numBlocks(); b++ {
+					// Eigenvectors are in rows (see evalEV).
+					w += math.Pow(cmplx.Abs(evecs[alpha][sigma*n+b*nb+a]), 2.0)
+				}
+			}
+			sum += env.Fermi(evals[alpha]) * w
+		}
+		// With both spins in H, each spin counts once.
+		return 0.5 * env.spinDegeneracy() * sum
+	}
+	occs := make([]float64, nb)
+	for a := 0; a < nb; a++ {
+		inner_a := func(k vec.Vector) float64 {
+			return inner(k, a)
+		}
+		// Factor of 2 for spins and 1/2 per V, as in the Mu equation.
+		occs[a] = env.foldScale() * bzone.Avg(env.BZPointsPerDim, 3, inner_a)
+	}
+	return occs
+}
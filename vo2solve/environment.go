@@ -11,6 +11,9 @@ import (
 	"github.com/tflovorn/scExplorer/bzone"
 	"github.com/tflovorn/scExplorer/serialize"
 	vec "github.com/tflovorn/scExplorer/vector"
+	"github.com/tflovorn/vo2mft/meanfield"
+	"github.com/tflovorn/vo2mft/tetra"
+	"github.com/tflovorn/vo2mft/tightbinding"
 )
 
 // Contains parameters necessary to characterize electronic and ionic systems.
@@ -23,14 +26,33 @@ type Environment struct {
 	Tae, Tce, Tbe float64
 	// Hopping parameters, odd symmetry (a, c, diagonal axes).
 	Tao, Tco, Tbo float64
+	// Strain tensor (Cartesian components, with z along the rutile c axis).
+	Strain_xx, Strain_yy, Strain_zz, Strain_xy, Strain_xz, Strain_yz float64
+	// Bond length dependence of the hoppings: "power" (default) or
+	// "exponential" (see hopping_scalings), with exponents for the a axis,
+	// c axis and body diagonal bonds. The hopping parameters above give the
+	// unstrained values. With all exponents 0 (the default), the hoppings
+	// are independent of strain.
+	HoppingScaling      string
+	Eta_a, Eta_c, Eta_b float64
 	// Order parameter <S>.
 	M float64
 	// Order parameter <S^2>.
 	W float64
 	// Chemical potential.
 	Mu float64
-	// Inverse temperature, 1 / (k_B * T).
+	// Electron filling: number of electrons per V (1 for undoped VO2).
+	// Defaults to 1 if not specified.
+	Filling float64
+	// Inverse temperature, 1 / (k_B * T). Inf (written as the largest float
+	// in JSON) gives T = 0, for which the solvers find the ground state (see
+	// ZeroTemperature).
 	Beta float64
+	// Separate inverse temperatures of the electrons (Fermi function and
+	// electronic free energy) and the ions (Boltzmann weights of the ionic
+	// problem), for quasi-equilibrium states in which the two subsystems are
+	// not in equilibrium with each other. 0 (the default) means equal to Beta.
+	BetaEl, BetaIon float64
 	// One-spin term for BEG model: coefficient for (S_i)^2.
 	B float64
 	// Exchange parameters for BEG model: coefficients to S_i dot S_j.
@@ -38,8 +60,39 @@ type Environment struct {
 	Ja, Jc float64
 	// Biquadratic exchange parameters for BEG model: coefficients to (S_i)^2 * (S_j)^2.
 	Ka, Kc, Kb float64
+	// Transverse (tunnelling) term: coefficient of -S^x in the single-site
+	// ionic Hamiltonian, mixing S = -1, 0, 1. If nonzero, the single-site
+	// problem is solved by diagonalization.
+	Gamma float64
 	// On-site energies in M and R phases.
 	EpsilonM, EpsilonR float64
+	// Parameters of the pi* orbitals in the t2g model (TightBinding =
+	// "vo2solve_t2g"; see tightbinding.VO2SolveT2gModel): hoppings along
+	// the a axes, the c axis and the body diagonals, and on-site energies
+	// in the M and R phases (relative to those of d_par, which uses the
+	// parameters above). Unused otherwise.
+	Tae_pi, Tce_pi, Tbe_pi float64
+	EpsilonPiM, EpsilonPiR float64
+	// Hubbard interactions, treated in Hartree-Fock (see hartreeFock.go):
+	// U on each site and orbital, and V between nearest neighbours along
+	// the c axis (the dimer bond) in the orbitals coupled by M. If either is
+	// nonzero, ElHamiltonian includes both spins and the Hartree-Fock mean
+	// fields below are solved for along with Mu.
+	U, V float64
+	// Hartree-Fock mean fields: densities per site of each spin, sublattice
+	// and orbital (see hfDensityIndex), and the uniform and Q-modulated
+	// parts of the c-axis bond orders of each spin, sublattice and orbital
+	// coupled by M (see hfBondIndex; used only if V is nonzero). The values
+	// given are the starting point of the solution; paramagnetic densities
+	// and zero bond orders if not given. Spin-polarised or dimerised
+	// solutions are found by starting from polarised densities or a finite
+	// HFBondQ.
+	HFDensity       []float64
+	HFBond, HFBondQ []float64
+	// Zeeman energy (g mu_B B / 2 for the magnetic field B): the energies of
+	// spin up and down electrons are shifted by -Zeeman and +Zeeman. If
+	// nonzero, ElHamiltonian includes both spins.
+	Zeeman float64
 	// Consider only ionic part of the problem:
 	// only ions contribute to free energy; should solve
 	// for (M, W).
@@ -48,6 +101,32 @@ type Environment struct {
 	// (maybe don't need to fix Mu = 0 -- large negative value could
 	// be better).
 	IonsOnly bool
+	// Electronic Hamiltonian: "" (default) for ElHamiltonian as written,
+	// the name of a tight-binding preset (see tightbinding.Preset) or the
+	// path to a tight-binding model JSON file. Model parameters are
+	// Environment fields, with the hoppings strained (see tbParameter).
+	// The model states must form blocks in the basis of ElHamiltonian (see
+	// evalEV), as for models imported from Wannier90 (see
+	// tightbinding.Wannier90Model).
+	TightBinding string
+	// Modulation wavevector of the order parameters, in the reciprocal
+	// lattice basis: the order parameter of the sites in cell r is
+	// proportional to cos(2 pi Q.r). If not set (nil), Q = (1/2, 1/2, 1/2)
+	// and ElHamiltonian is as written. If set, Q must be commensurate (zero
+	// gives a uniform order parameter) and ElHamiltonian is the
+	// tight-binding preset TightBinding ("vo2solve" if not set) folded with
+	// Q (see wavevector.go); TightBinding must then be a preset.
+	Q *[3]float64
+	// Tight-binding model loaded from TightBinding (or folded with Q); nil if
+	// neither is set.
+	tb *tightbinding.Model
+	// Orbitals of tb coupled by M (see dimerOrbitals).
+	tb_dimer []int
+	// Additional fields coupling to (S, S^2) in the single-site ionic
+	// Hamiltonian as -ion_field[0] S - ion_field[1] S^2. Used to evaluate
+	// the Landau free energy away from self-consistency (see LandauGradient);
+	// always 0 otherwise.
+	ion_field [2]float64
 }
 
 // Environment with all self-consistent values converged.
@@ -56,10 +135,43 @@ type FinalEnvironment struct {
 	Environment
 	Dae, Dce, Dbe, Dao, Dco, Dbo float64
 	FreeEnergy                   float64
+	// Ionic and electronic parts of FreeEnergy (the remainder of FreeEnergy
+	// is the constant mean-field part).
+	FreeEnergyIons, FreeEnergyElectrons float64
+	// Entropy and heat capacity per cell.
+	// Only calculated when requested (see Thermodynamics); 0 otherwise.
+	Entropy, SpecificHeat float64
+	// Hessian of the free energy with respect to HessianVars, its
+	// eigenvalues and the resulting stability label (see Stability); empty
+	// at T = 0 or if the stability analysis failed.
+	Hessian            [][]float64
+	HessianEigenvalues []float64
+	Stability          string
+	// Band gap around Mu and band edge k-points (see BandEdges).
+	// Only calculated when requested, since the band extrema are refined by
+	// a local search in k; zero otherwise and if IonsOnly is set.
+	tetra.BandEdges
+	// Occupation per V of each orbital (see OrbitalOccupations); nil if
+	// IonsOnly is set.
+	OrbitalOccupations []float64
+	// Magnetic moment per site of each sublattice (see SublatticeMoments);
+	// nil without the Hubbard interactions.
+	SublatticeMoments []float64
+	// Magnetisation n_up - n_down (see Magnetization); 0 if IonsOnly is set.
+	Magnetization float64
+	// Spin susceptibility dMagnetization/dZeeman.
+	// Only calculated when requested (see SpinSusceptibility); 0 otherwise.
+	SpinSusceptibility float64
+	// Dae, ..., Dbo of each spin channel (up, then down; see
+	// HoppingEV.SpinResolved); nil if ElHamiltonian does not include both
+	// spins.
+	SpinDs map[string][]float64
 }
 
-func (env *Environment) DeltaS() float64 {
-	return env.B + env.EpsilonM - env.EpsilonR
+// Coefficient of S^2 in the single-site ionic Hamiltonian due to B and the
+// W dependence of the electronic on-site energies (see HoppingEV.Dw).
+func (env *Environment) DeltaS(Ds *HoppingEV) float64 {
+	return env.B + Ds.Dw(env)
 }
 
 // Combined biquadratic coefficient (S_i^2 S_j^2).
@@ -68,52 +180,135 @@ func (env *Environment) QK() float64 {
 }
 
 // Combined renormalized 'exchange' coefficient (S_i S_j) favoring dimers.
+// For a tight-binding model, the electronic part is given by Dm.
 func (env *Environment) QJ(Ds *HoppingEV) float64 {
+	if env.tb != nil {
+		return env.exchangeQ() + Ds.Dm(env)
+	}
 	Dao, Dco := Ds.Dao(env), Ds.Dco(env)
-	return 4.0*(env.Ja+env.Tao*Dao) + 2.0*(env.Jc+env.Tco*Dco)
+	return 4.0*(env.Ja+env.Tao_eff()*Dao) + 2.0*(env.Jc+env.Tco_eff()*Dco)
 }
 
 func (env *Environment) Qele(Ds *HoppingEV) float64 {
 	// TODO - make sure T's here should be even part.
 	Dae, Dce, Dbe := Ds.Dae(env), Ds.Dce(env), Ds.Dbe(env)
-	return 4.0*env.Tae*Dae + 2.0*env.Tce*Dce + 8.0*env.Tbe*Dbe
+	return 4.0*env.Tae_eff()*Dae + 2.0*env.Tce_eff()*Dce + 8.0*env.Tbe_eff()*Dbe
+}
+
+// Coefficient of S^2 in the single-site ionic Hamiltonian.
+func (env *Environment) ionS2Coeff(Ds *HoppingEV) float64 {
+	return env.DeltaS(Ds) - env.W*env.QK() - env.ion_field[1]
+}
+
+// Field coupling to S in the single-site ionic Hamiltonian.
+func (env *Environment) ionSField(Ds *HoppingEV) float64 {
+	return env.M*env.QJ(Ds) + env.ion_field[0]
+}
+
+// Return the Boltzmann distribution over the single-site ionic states at
+// BetaIons: the configurations S = 1, 0, -1 (ion_basis) if Gamma = 0, or the
+// levels of the transverse Hamiltonian otherwise (see quantumIon).
+func (env *Environment) ionBoltzmann(Ds *HoppingEV) *meanfield.Boltzmann {
+	if env.Gamma != 0.0 {
+		dist, _, _, _ := env.quantumIon(Ds)
+		return dist
+	}
+	a, h := env.ionS2Coeff(Ds), env.ionSField(Ds)
+	energies := make([]float64, len(ion_basis))
+	for i, S := range ion_basis {
+		energies[i] = a*S*S - h*S
+	}
+	return meanfield.NewBoltzmann(energies, env.BetaIons())
 }
 
+// Single-site partition function. This overflows at low temperature; the
+// averages and FreeEnergyIons do not use it.
 func (env *Environment) Z1(Ds *HoppingEV) float64 {
-	exp := math.Exp(-env.Beta * (env.DeltaS() - env.W*env.QK()))
-	return 1.0 + 2.0*exp*math.Cosh(env.Beta*env.M*env.QJ(Ds))
+	return math.Exp(env.ionBoltzmann(Ds).LogZ())
+}
+
+// Single-site expectation value <S> for the current M and W.
+func (env *Environment) ExpectS(Ds *HoppingEV) float64 {
+	if env.Gamma != 0.0 {
+		_, S, _, _ := env.quantumIon(Ds)
+		return S
+	}
+	P := env.ionBoltzmann(Ds).Probs
+	return P[0] - P[2]
+}
+
+// Single-site expectation value <S^2> for the current M and W.
+func (env *Environment) ExpectS2(Ds *HoppingEV) float64 {
+	if env.Gamma != 0.0 {
+		_, _, S2, _ := env.quantumIon(Ds)
+		return S2
+	}
+	P := env.ionBoltzmann(Ds).Probs
+	return P[0] + P[2]
 }
 
 // Are electronic hopping finite?
-// If not, don't need to calculate D's.
+// If not, don't need to calculate D's. The hoppings of a tight-binding model
+// (such as a Wannier90 model) need not be given by the Environment, so are
+// taken to be finite.
 func (env *Environment) FiniteHoppings() bool {
+	if env.tb != nil {
+		return true
+	}
 	eps := 1e-9
 	even := (math.Abs(env.Tae) > eps) || (math.Abs(env.Tce) > eps) || (math.Abs(env.Tbe) > eps)
 	odd := (math.Abs(env.Tao) > eps) || (math.Abs(env.Tco) > eps) || (math.Abs(env.Tbo) > eps)
 	return even || odd
 }
 
+// Inverse temperature of the electrons: BetaEl if set, Beta otherwise.
+func (env *Environment) BetaElectrons() float64 {
+	if env.BetaEl == 0.0 {
+		return env.Beta
+	}
+	return env.BetaEl
+}
+
+// Inverse temperature of the ions: BetaIon if set, Beta otherwise.
+func (env *Environment) BetaIons() float64 {
+	if env.BetaIon == 0.0 {
+		return env.Beta
+	}
+	return env.BetaIon
+}
+
+// Are the electrons and ions at the same temperature?
+func (env *Environment) SingleTemperature() bool {
+	return env.BetaElectrons() == env.Beta && env.BetaIons() == env.Beta
+}
+
 // Fermi distribution function.
 func (env *Environment) Fermi(energy float64) float64 {
 	// Need to make this check to be sure we're dividing by a nonzero energy in the next step.
 	if energy == 0.0 {
 		return 0.5
 	}
+	beta := env.BetaElectrons()
 	// Temperature is 0 or e^(Beta*energy) is too big to calculate
-	if env.Beta == math.Inf(1) || env.Beta >= math.Abs(math.MaxFloat64/energy) || math.Abs(env.Beta*energy) >= math.Log(math.MaxFloat64) {
+	if beta == math.Inf(1) || beta >= math.Abs(math.MaxFloat64/energy) || math.Abs(beta*energy) >= math.Log(math.MaxFloat64) {
 		if energy <= 0 {
 			return 1.0
 		}
 		return 0.0
 	}
 	// nonzero temperature
-	return 1.0 / (math.Exp(energy*env.Beta) + 1.0)
+	return 1.0 / (math.Exp(energy*beta) + 1.0)
 }
 
 // Free energy per cell value (Ncell = 2Nsite).
 // Points on the phase diagram include the state with minimum free energy
 // (may not reach this state, depending on initial conditions - need to
 // consider a set of initial conditions and look for minimum).
+// If the electrons and ions are at different temperatures, this is the
+// quasi-equilibrium free energy: the ionic and electronic parts are evaluated
+// at BetaIons and BetaElectrons respectively. Its stationary points are still
+// the self-consistent solutions.
+// At T = 0 (see ZeroTemperature), this is the ground-state energy.
 func (env *Environment) FreeEnergy(Ds *HoppingEV) float64 {
 	ion_part := env.FreeEnergyIons(Ds)
 	// avg_avg_part includes <S><S>, <S^2><S^2>, and <S><c^{\dagger}c> terms.
@@ -126,12 +321,14 @@ func (env *Environment) FreeEnergy(Ds *HoppingEV) float64 {
 	}
 }
 
+// Ionic free energy per cell, -2 T log(Z1); at T = 0, twice the single-site
+// ionic ground-state energy.
 func (env *Environment) FreeEnergyIons(Ds *HoppingEV) float64 {
-	T := 1.0 / env.Beta
-	return -2.0 * T * math.Log(env.Z1(Ds))
+	return 2.0 * env.ionBoltzmann(Ds).FreeEnergy()
 }
 
 func (env *Environment) FreeEnergyElectrons() float64 {
+	beta := env.BetaElectrons()
 	inner := func(k vec.Vector) float64 {
 		H := ElHamiltonian(env, k)
 		dim, _ := H.Dims()
@@ -139,30 +336,41 @@ func (env *Environment) FreeEnergyElectrons() float64 {
 		sum := 0.0
 		for alpha := 0; alpha < dim; alpha++ {
 			eps_ka := evals[alpha]
-			// Mu excluded from exp argument here since it is
-			// included in H.
-			val := 1.0 + math.Exp(-env.Beta*eps_ka)
-			sum += 2.0 * math.Log(val)
+			// Mu is included in H, so eps_ka is measured from it.
+			sum += env.spinDegeneracy() * meanfield.FermionFreeEnergy(beta, eps_ka)
 		}
 		return sum
 	}
 	L := env.BZPointsPerDim
-	T := 1.0 / env.Beta
-	band_part := -T * bzone.Avg(L, 3, inner)
-	n := 1.0
-	mu_part := 2.0 * env.Mu * n
+	band_part := env.foldScale() * bzone.Avg(L, 3, inner)
+	mu_part := 2.0 * env.Mu * env.Filling
 
-	return band_part + mu_part
+	return band_part + mu_part - env.hfDoubleCounting()
 }
 
 // Create an Environment from the given serialized data.
 func NewEnvironment(jsonData string) (*Environment, error) {
-	// initialize env with input data
+	// initialize env with defaults, then input data
 	env := new(Environment)
+	env.Filling = 1.0
 	err := serialize.CopyFromJSON(jsonData, env)
 	if err != nil {
 		return nil, err
 	}
+	// T = 0 is written as the largest float (see Marshal).
+	env.replaceInfBetas(math.MaxFloat64, math.Inf(1))
+	err = env.checkHoppingScaling()
+	if err != nil {
+		return nil, err
+	}
+	err = env.loadTightBinding()
+	if err != nil {
+		return nil, err
+	}
+	err = env.initHartreeFock()
+	if err != nil {
+		return nil, err
+	}
 
 	return env, nil
 }
@@ -177,7 +385,17 @@ func NewFinalEnvironment(env *Environment, Ds *HoppingEV) *FinalEnvironment {
 	Dco := Ds.Dco(env)
 	Dbo := Ds.Dbo(env)
 	FreeEnergy := env.FreeEnergy(Ds)
-	fenv := FinalEnvironment{*env, Dae, Dce, Dbe, Dao, Dco, Dbo, FreeEnergy}
+	FreeEnergyIons := env.FreeEnergyIons(Ds)
+	FreeEnergyElectrons := 0.0
+	if !env.IonsOnly {
+		FreeEnergyElectrons = env.FreeEnergyElectrons()
+	}
+	fenv := FinalEnvironment{*env, Dae, Dce, Dbe, Dao, Dco, Dbo, FreeEnergy, FreeEnergyIons, FreeEnergyElectrons, 0.0, 0.0, nil, nil, "", tetra.BandEdges{}, nil, nil, 0.0, 0.0, Ds.SpinResolved(env)}
+	if !env.IonsOnly {
+		fenv.OrbitalOccupations = env.OrbitalOccupations()
+		fenv.SublatticeMoments = env.SublatticeMoments()
+		fenv.Magnetization = env.Magnetization()
+	}
 	return &fenv
 }
 
@@ -221,17 +439,13 @@ func (env *Environment) String() string {
 }
 
 func (env *Environment) Marshal() string {
-	if env.Beta == math.Inf(1) {
-		// hack to get around JSON's choice to not allow Inf
-		env.Beta = math.MaxFloat64
-	}
+	// hack to get around JSON's choice to not allow Inf
+	env.replaceInfBetas(math.Inf(1), math.MaxFloat64)
 	marshalled, err := serialize.MakeJSON(env)
 	if err != nil {
 		panic(err)
 	}
-	if env.Beta == math.MaxFloat64 {
-		env.Beta = math.Inf(1)
-	}
+	env.replaceInfBetas(math.MaxFloat64, math.Inf(1))
 	return marshalled
 }
 
@@ -241,34 +455,94 @@ func (env *FinalEnvironment) String() string {
 }
 
 func (env *FinalEnvironment) Marshal() string {
-	if env.Beta == math.Inf(1) {
-		// hack to get around JSON's choice to not allow Inf
-		env.Beta = math.MaxFloat64
-	}
+	// hack to get around JSON's choice to not allow Inf
+	env.Environment.replaceInfBetas(math.Inf(1), math.MaxFloat64)
 	marshalled, err := serialize.MakeJSON(env)
 	if err != nil {
 		panic(err)
 	}
-	if env.Beta == math.MaxFloat64 {
-		env.Beta = math.Inf(1)
-	}
+	env.Environment.replaceInfBetas(math.MaxFloat64, math.Inf(1))
 	return marshalled
 }
 
+// Set each of Beta, BetaEl and BetaIon which is equal to from to to.
+func (env *Environment) replaceInfBetas(from, to float64) {
+	for _, beta := range []*float64{&env.Beta, &env.BetaEl, &env.BetaIon} {
+		if *beta == from {
+			*beta = to
+		}
+	}
+}
+
 // Iterate through v and vars simultaneously. vars specifies the names of
 // fields to change in env (they are set to the values given in v).
+// A name of the form "Field[i]" refers to element i of a []float64 field;
+// the slice is copied before it is changed, so that copies of env which
+// share it are not affected.
 // Panics if vars specifies a field not contained in env (or a field of
-// non-float type).
+// non-float type); see CheckVariables.
 func (env *Environment) Set(v vec.Vector, vars []string) {
-	ev := reflect.ValueOf(env).Elem()
 	for i := 0; i < len(vars); i++ {
-		field := ev.FieldByName(vars[i])
-		if field == reflect.Zero(reflect.TypeOf(env)) {
-			panic(fmt.Sprintf("Field %v not present in Environment", vars[i]))
+		field, index, err := env.variableField(vars[i])
+		if err != nil {
+			panic(err)
 		}
-		if field.Type().Kind() != reflect.Float64 {
-			panic(fmt.Sprintf("Field %v is non-float", vars[i]))
+		if index >= 0 {
+			elems := append([]float64{}, field.Interface().([]float64)...)
+			elems[index] = v[i]
+			field.Set(reflect.ValueOf(elems))
+			continue
 		}
 		field.SetFloat(v[i])
 	}
 }
+
+// Return an error if any of vars is not a variable which can be given to Set.
+func (env *Environment) CheckVariables(vars []string) error {
+	for _, name := range vars {
+		_, _, err := env.variableField(name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return the field of env named by the variable name (as in Set), and the
+// index of the element if name refers to an element of a []float64 field
+// (or -1 otherwise). Return an error if there is no such float field or
+// element.
+func (env *Environment) variableField(var_name string) (reflect.Value, int, error) {
+	name, index, err := splitIndexedName(var_name)
+	if err != nil {
+		return reflect.Value{}, -1, err
+	}
+	field := reflect.ValueOf(env).Elem().FieldByName(name)
+	if !field.IsValid() {
+		return reflect.Value{}, -1, fmt.Errorf("Field %v not present in Environment", var_name)
+	}
+	if index >= 0 {
+		if field.Type() != reflect.TypeOf([]float64{}) || index >= field.Len() {
+			return reflect.Value{}, -1, fmt.Errorf("Field %v is not an element of a float slice", var_name)
+		}
+		return field, index, nil
+	}
+	if field.Type().Kind() != reflect.Float64 {
+		return reflect.Value{}, -1, fmt.Errorf("Field %v is non-float", var_name)
+	}
+	return field, -1, nil
+}
+
+// Return the value of the env variable with type float64 with the given name
+// (or element of a []float64 field, named as in Set).
+func (env *Environment) GetFloat(var_name string) float64 {
+	ev := reflect.ValueOf(env).Elem()
+	name, index, err := splitIndexedName(var_name)
+	if err != nil {
+		panic(err)
+	}
+	if index >= 0 {
+		return ev.FieldByName(name).Index(index).Float()
+	}
+	return ev.FieldByName(var_name).Float()
+}
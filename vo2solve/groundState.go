@@ -0,0 +1,83 @@
+package vo2solve
+
+import (
+	"math"
+)
+import (
+	vec "github.com/tflovorn/scExplorer/vector"
+	"github.com/tflovorn/vo2mft/meanfield"
+	"github.com/tflovorn/vo2mft/tetra"
+)
+
+// Are the electrons and ions both at T = 0 (BetaElectrons and BetaIons
+// infinite)? If so, the solvers find the ground state (see groundStateSolve)
+// and FreeEnergy is the ground-state energy.
+func (env *Environment) ZeroTemperature() bool {
+	return math.IsInf(env.BetaElectrons(), 1) && math.IsInf(env.BetaIons(), 1)
+}
+
+// Return the Mu at which the bands of ElHamiltonian hold Filling electrons
+// per V at T = 0, with the Fermi level found by the tetrahedron method on the
+// BZPointsPerDim k-point grid. If the filled bands end at a gap, Mu is in the
+// middle of the gap.
+func (env *Environment) FermiLevel() (float64, error) {
+	L := env.BZPointsPerDim
+	Eks := tetra.EnergyGrid(env.BandEnergies(), L)
+	// Each state holds spinDegeneracy electrons, and the states at k and
+	// k+Q hold 2 V's (see AbsErrorMu).
+	N := 2.0 * env.Filling / (env.foldScale() * env.spinDegeneracy())
+	// The band energies are measured from Mu.
+	EF, err := tetra.FermiLevel(Eks, L, env.recipLattice(), N)
+	if err != nil {
+		return 0.0, err
+	}
+	return env.Mu + EF, nil
+}
+
+// Solve for the ground state of env in-place by iterating the mean-field
+// equations at T = 0. At each step, if withMu is set (and IonsOnly is not),
+// Mu is set to the Fermi level and the Hartree-Fock mean fields to the values
+// implied by the resulting Hamiltonian; then M and W are set to their
+// averages in the ground state of the single-site ionic Hamiltonian, which
+// for Gamma = 0 is the configuration minimising it (with degenerate
+// configurations weighted equally). The electrons have step occupations.
+// The iteration stops when no variable changes by more than
+// epsAbs + epsRel |x| (see meanfield.FixedPoint). As for the finite
+// temperature solutions, the state reached depends on the starting point.
+// Return the solution in the order of the variables of MWMuSystem if withMu
+// is set, or MWSystem otherwise.
+func groundStateSolve(env *Environment, Ds *HoppingEV, epsAbs, epsRel float64, withMu bool) (vec.Vector, error) {
+	variables := []string{"M", "W"}
+	electrons := withMu && !env.IonsOnly
+	if electrons {
+		variables = append(variables, "Mu")
+		variables = append(variables, env.HFVariables()...)
+	}
+	x0 := make([]float64, len(variables))
+	for i, name := range variables {
+		x0[i] = env.GetFloat(name)
+	}
+	return meanfield.FixedPoint(x0, func(x []float64) ([]float64, error) {
+		next := make([]float64, len(x))
+		copy(next, x)
+		if electrons {
+			Mu, err := env.FermiLevel()
+			if err != nil {
+				return nil, err
+			}
+			env.Mu = Mu
+			next[2] = Mu
+			for i, name := range variables[3:] {
+				value, err := env.HFMeanField(name)
+				if err != nil {
+					return nil, err
+				}
+				next[3+i] = value
+			}
+			env.Set(next, variables)
+		}
+		next[0], next[1] = env.ExpectS(Ds), env.ExpectS2(Ds)
+		env.Set(next, variables)
+		return next, nil
+	}, epsAbs, epsRel)
+}
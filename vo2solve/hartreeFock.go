@@ -0,0 +1,349 @@
+package vo2solve
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"strconv"
+	"strings"
+)
+import (
+	"github.com/tflovorn/cmatrix"
+	"github.com/tflovorn/scExplorer/bzone"
+	"github.com/tflovorn/scExplorer/solve"
+	vec "github.com/tflovorn/scExplorer/vector"
+)
+
+// Hartree-Fock treatment of the Hubbard interactions (see Environment.U).
+//
+// The interactions are U n_{r,a,up} n_{r,a,down} on each site r and orbital
+// a, and V n_{r,a} n_{r+c,a} on the c-axis bonds (the dimer bonds) of the
+// orbitals coupled by M (see dimerOrbitals). In Hartree-Fock, the
+// electronic Hamiltonian has a block for each spin sigma (up, then down),
+// which is the Hamiltonian without interactions plus the self-energy
+//     (U n_{s,a,-sigma} + 2 V n_{s,a}) on orbital a of sublattice s,
+//     -V (chi_sigma(r) c^dagger_{r+c} c_r + h.c.) on the c-axis bonds,
+// where n_{s,a,sigma} is the density per site (HFDensity) and
+// chi_sigma(r) = <c^dagger_r c_{r+c}> = HFBond + HFBondQ exp(i Q.r) is the
+// bond order (with Q the modulation wavevector of M). The Fock term
+// renormalizes the even and odd c-axis hoppings; with M = 0, a finite
+// HFBondQ describes singlet dimers formed by the electrons alone.
+
+// Lattice vector of the dimer bond in the reciprocal lattice basis of
+// ElHamiltonian (the c axis).
+var dimer_bond = [3]float64{0.0, 0.0, 1.0}
+
+// Return true if the Hubbard interactions are included.
+func (env *Environment) hartreeFock() bool {
+	return env.U != 0.0 || env.V != 0.0
+}
+
+// Return the number of orbitals in each block of the basis of ElHamiltonian
+// (see evalEV).
+func (env *Environment) numOrbitals() int {
+	if env.tb != nil {
+		return env.tb.NumOrbitals() / env.numBlocks()
+	}
+	return 1
+}
+
+// Return the momentum offsets (k+Q states have offset Q) of the basis
+// states of ElHamiltonian for one spin, in the reciprocal lattice basis.
+func (env *Environment) stateOffsets() [][3]float64 {
+	if env.tb != nil {
+		offsets := make([][3]float64, env.tb.NumOrbitals())
+		for i, orb := range env.tb.Orbitals {
+			offsets[i] = orb.Offset
+		}
+		return offsets
+	}
+	half := [3]float64{0.5, 0.5, 0.5}
+	return [][3]float64{[3]float64{}, half, [3]float64{}, half}
+}
+
+// Index in HFDensity of orbital a on sublattice s with spin sigma (0 for up,
+// 1 for down).
+func (env *Environment) hfDensityIndex(sigma, s, a int) int {
+	return (2*sigma+s)*env.numOrbitals() + a
+}
+
+// Index in HFBond and HFBondQ of the j'th orbital coupled by M (see
+// dimerOrbitals) on sublattice s with spin sigma.
+func (env *Environment) hfBondIndex(sigma, s, j int) int {
+	return (2*sigma+s)*len(env.dimerOrbitals()) + j
+}
+
+// Set the starting values of the Hartree-Fock mean fields which are not
+// given (paramagnetic densities and zero bond orders), or return an error if
+// those given have the wrong length.
+func (env *Environment) initHartreeFock() error {
+	if !env.hartreeFock() {
+		return nil
+	}
+	num_density := 4 * env.numOrbitals()
+	if env.HFDensity == nil {
+		env.HFDensity = make([]float64, num_density)
+		for i := range env.HFDensity {
+			env.HFDensity[i] = env.Filling / float64(num_density)
+		}
+	}
+	if len(env.HFDensity) != num_density {
+		return fmt.Errorf("HFDensity has %v entries; expected %v (spin, sublattice and orbital)", len(env.HFDensity), num_density)
+	}
+	if env.V == 0.0 {
+		return nil
+	}
+	if env.foldOrder() != 2 {
+		return fmt.Errorf("V requires a modulation wavevector Q of order 2; got Q = %v of order %v", *env.Q, env.foldOrder())
+	}
+	num_bond := 4 * len(env.dimerOrbitals())
+	if env.HFBond == nil {
+		env.HFBond = make([]float64, num_bond)
+	}
+	if env.HFBondQ == nil {
+		env.HFBondQ = make([]float64, num_bond)
+	}
+	if len(env.HFBond) != num_bond || len(env.HFBondQ) != num_bond {
+		return fmt.Errorf("HFBond and HFBondQ have %v and %v entries; expected %v (spin, sublattice and orbital)", len(env.HFBond), len(env.HFBondQ), num_bond)
+	}
+	return nil
+}
+
+// Return the names of the Hartree-Fock mean fields to be solved for (in the
+// form accepted by Environment.Set): the densities, and the bond orders if
+// V is nonzero. Return nil without the Hubbard interactions.
+func (env *Environment) HFVariables() []string {
+	if !env.hartreeFock() {
+		return nil
+	}
+	names := []string{}
+	for i := range env.HFDensity {
+		names = append(names, fmt.Sprintf("HFDensity[%d]", i))
+	}
+	if env.V == 0.0 {
+		return names
+	}
+	for _, field := range []string{"HFBond", "HFBondQ"} {
+		for i := range env.HFBond {
+			names = append(names, fmt.Sprintf("%v[%d]", field, i))
+		}
+	}
+	return names
+}
+
+// Split a variable name of the form "Field[i]" into the field name and the
+// index. If name has no index, return (name, -1).
+func splitIndexedName(name string) (string, int, error) {
+	open := strings.Index(name, "[")
+	if open < 0 {
+		return name, -1, nil
+	}
+	if !strings.HasSuffix(name, "]") {
+		return "", -1, fmt.Errorf("Malformed variable name %v", name)
+	}
+	i, err := strconv.Atoi(name[open+1 : len(name)-1])
+	if err != nil || i < 0 {
+		return "", -1, fmt.Errorf("Malformed variable name %v", name)
+	}
+	return name[:open], i, nil
+}
+
+// Return the phase 2 pi (k + q).c of each basis state for one spin, where q
+// is its momentum offset and c the dimer bond (k in the reciprocal lattice
+// basis).
+func (env *Environment) dimerPhases(kr []float64) []float64 {
+	offsets := env.stateOffsets()
+	phases := make([]float64, len(offsets))
+	for p, q := range offsets {
+		for d := 0; d < 3; d++ {
+			phases[p] += 2.0 * math.Pi * (kr[d] + q[d]) * dimer_bond[d]
+		}
+	}
+	return phases
+}
+
+// Return the Hartree-Fock self-energy of spin sigma at k (in the reciprocal
+// lattice basis), in the basis of ElHamiltonian for one spin.
+func (env *Environment) hfSelfEnergy(kr []float64, sigma int) [][]complex128 {
+	nb := env.numOrbitals()
+	n := env.numBlocks() * nb
+	self := make([][]complex128, n)
+	for i := range self {
+		self[i] = make([]complex128, n)
+	}
+	dimer := make(map[int]bool)
+	for _, a := range env.dimerOrbitals() {
+		dimer[a] = true
+	}
+	for s := 0; s < 2; s++ {
+		for a := 0; a < nb; a++ {
+			// Hartree terms, on the k and k+Q states of orbital a (the
+			// k+jQ states for other Q).
+			shift := env.U * env.HFDensity[env.hfDensityIndex(1-sigma, s, a)]
+			if dimer[a] {
+				n_sa := env.HFDensity[env.hfDensityIndex(0, s, a)] + env.HFDensity[env.hfDensityIndex(1, s, a)]
+				shift += 2.0 * env.V * n_sa
+			}
+			for j := 0; j < env.foldOrder(); j++ {
+				b := env.blockIndex(s, j)
+				self[b*nb+a][b*nb+a] += complex(shift, 0.0)
+			}
+		}
+		if env.V == 0.0 {
+			continue
+		}
+		// Fock terms on the c-axis bonds: the uniform part of the bond order
+		// acts within the k and k+Q states; the modulated part couples them.
+		phases := env.dimerPhases(kr)
+		for j, a := range env.dimerOrbitals() {
+			chi := env.HFBond[env.hfBondIndex(sigma, s, j)]
+			chiQ := env.HFBondQ[env.hfBondIndex(sigma, s, j)]
+			I, J := env.blockIndex(s, 0)*nb+a, env.blockIndex(s, 1)*nb+a
+			for _, p := range []int{I, J} {
+				self[p][p] += complex(-2.0*env.V*chi*math.Cos(phases[p]), 0.0)
+			}
+			IJ := complex(-env.V*chiQ, 0.0) * (cmplx.Exp(complex(0.0, -phases[I])) + cmplx.Exp(complex(0.0, phases[J])))
+			self[I][J] += IJ
+			self[J][I] += cmplx.Conj(IJ)
+		}
+	}
+	return self
+}
+
+// Return the value of the Hartree-Fock mean field with the given name (see
+// HFVariables) implied by the current Hamiltonian: the density per site, or
+// the uniform or modulated part of the bond order.
+func (env *Environment) HFMeanField(name string) (float64, error) {
+	field, i, err := splitIndexedName(name)
+	if err != nil {
+		return 0.0, err
+	}
+	nb := env.numOrbitals()
+	n := env.numBlocks() * nb
+	var sigma, s, a int
+	switch field {
+	case "HFDensity":
+		if i >= len(env.HFDensity) {
+			return 0.0, fmt.Errorf("No Hartree-Fock mean field %v", name)
+		}
+		sigma, s, a = i/(2*nb), (i/nb)%2, i%nb
+	case "HFBond", "HFBondQ":
+		nd := len(env.dimerOrbitals())
+		if i >= len(env.HFBond) {
+			return 0.0, fmt.Errorf("No Hartree-Fock mean field %v", name)
+		}
+		sigma, s, a = i/(2*nd), (i/nd)%2, env.dimerOrbitals()[i%nd]
+	default:
+		return 0.0, fmt.Errorf("No Hartree-Fock mean field %v", name)
+	}
+	// Global indices of the k and k+Q states.
+	I, J := sigma*n+env.blockIndex(s, 0)*nb+a, sigma*n+env.blockIndex(s, 1)*nb+a
+	inner := func(k vec.Vector) float64 {
+		kr := []float64{k[0] / (2.0 * math.Pi), k[1] / (2.0 * math.Pi), k[2] / (2.0 * math.Pi)}
+		phases := env.dimerPhases(kr)
+		pI, pJ := phases[I-sigma*n], phases[J-sigma*n]
+		evals, evecs := cmatrix.Eigensystem(ElHamiltonian(env, k))
+		sum := 0.0
+		for alpha := range evals {
+			// Eigenvectors are in rows (see evalEV).
+			psiI, psiJ := evecs[alpha][I], evecs[alpha][J]
+			var ev complex128
+			switch field {
+			case "HFDensity":
+				// Summed over the k+jQ states.
+				for j := 0; j < env.foldOrder(); j++ {
+					psi := evecs[alpha][sigma*n+env.blockIndex(s, j)*nb+a]
+					ev += cmplx.Conj(psi) * psi
+				}
+			case "HFBond":
+				ev = cmplx.Exp(complex(0.0, pI))*cmplx.Conj(psiI)*psiI + cmplx.Exp(complex(0.0, pJ))*cmplx.Conj(psiJ)*psiJ
+			case "HFBondQ":
+				ev = cmplx.Exp(complex(0.0, pJ))*cmplx.Conj(psiI)*psiJ + cmplx.Exp(complex(0.0, pI))*cmplx.Conj(psiJ)*psiI
+			}
+			sum += env.Fermi(evals[alpha]) * real(ev)
+		}
+		return sum
+	}
+	// The k and k+Q states both range over the BZ, so each state is
+	// counted twice (as in the Mu equation).
+	return 0.5 * env.foldScale() * bzone.Avg(env.BZPointsPerDim, 3, inner), nil
+}
+
+// Return the absolute errors of the Hartree-Fock mean fields (see
+// HFVariables) and their gradients w.r.t. the given variables.
+func AbsErrorHF(env *Environment, variables []string) []solve.Diffable {
+	diffs := []solve.Diffable{}
+	for _, name := range env.HFVariables() {
+		diffs = append(diffs, AbsErrorHFField(env, name, variables))
+	}
+	return diffs
+}
+
+// Return the absolute error of the Hartree-Fock mean field with the given
+// name and its gradient w.r.t. the given variables.
+func AbsErrorHFField(env *Environment, name string, variables []string) solve.Diffable {
+	F := func(v vec.Vector) (float64, error) {
+		env.Set(v, variables)
+		rhs, err := env.HFMeanField(name)
+		if err != nil {
+			return 0.0, err
+		}
+		return env.GetFloat(name) - rhs, nil
+	}
+	h := 1e-6
+	epsabs := 1e-4
+	return solve.SimpleDiffable(F, len(variables), h, epsabs)
+}
+
+// Return the part of the Hartree-Fock interaction energy counted twice in
+// the band energy, per cell (with the normalization of
+// FreeEnergyElectrons).
+func (env *Environment) hfDoubleCounting() float64 {
+	if !env.hartreeFock() {
+		return 0.0
+	}
+	sum := 0.0
+	for s := 0; s < 2; s++ {
+		for a := 0; a < env.numOrbitals(); a++ {
+			sum += env.U * env.HFDensity[env.hfDensityIndex(0, s, a)] * env.HFDensity[env.hfDensityIndex(1, s, a)]
+		}
+		if env.V == 0.0 {
+			continue
+		}
+		for j, a := range env.dimerOrbitals() {
+			n_sa := env.HFDensity[env.hfDensityIndex(0, s, a)] + env.HFDensity[env.hfDensityIndex(1, s, a)]
+			sum += env.V * n_sa * n_sa
+			for sigma := 0; sigma < 2; sigma++ {
+				chi := env.HFBond[env.hfBondIndex(sigma, s, j)]
+				chiQ := env.HFBondQ[env.hfBondIndex(sigma, s, j)]
+				sum -= env.V * (chi*chi + chiQ*chiQ)
+			}
+		}
+	}
+	// The band energy counts each site twice (see HFMeanField).
+	return 2.0 * sum
+}
+
+// Return the Zeeman energy, U, V and the Hartree-Fock mean fields, which
+// together with M, W, Mu and the hoppings determine ElHamiltonian.
+func (env *Environment) hfFields() []float64 {
+	fields := []float64{env.Zeeman, env.U, env.V}
+	fields = append(fields, env.HFDensity...)
+	fields = append(fields, env.HFBond...)
+	return append(fields, env.HFBondQ...)
+}
+
+// Return the magnetic moment per site (n_up - n_down) of each sublattice,
+// summed over orbitals; nil without the Hubbard interactions.
+func (env *Environment) SublatticeMoments() []float64 {
+	if !env.hartreeFock() {
+		return nil
+	}
+	moments := make([]float64, 2)
+	for s := 0; s < 2; s++ {
+		for a := 0; a < env.numOrbitals(); a++ {
+			moments[s] += env.HFDensity[env.hfDensityIndex(0, s, a)] - env.HFDensity[env.hfDensityIndex(1, s, a)]
+		}
+	}
+	return moments
+}
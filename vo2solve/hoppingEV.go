@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"math"
 	"math/cmplx"
+	"strings"
 )
 import (
 	"github.com/tflovorn/cmatrix"
@@ -22,22 +23,41 @@ type HoppingEV struct {
 	w_cached map[string]float64
 	// Value of Mu for which the contained hopping e.v.'s have been calculated.
 	mu_cached map[string]float64
+	// Hoppings (see Environment.Hoppings) for which the contained hopping
+	// e.v.'s have been calculated.
+	t_cached map[string][6]float64
+	// Tight-binding model parameters other than M, W and Mu (see
+	// tbHoppings) for which the contained hopping e.v.'s have been
+	// calculated.
+	tb_cached map[string][]float64
+	// Zeeman energy, Hubbard interactions and Hartree-Fock mean fields (see
+	// hfFields) for which the contained hopping e.v.'s have been calculated.
+	hf_cached map[string][]float64
 	// If hopping e.v.'s have not been calculated yet, init = false.
 	init map[string]bool
+	// Hopping e.v.'s of each spin channel (see perSpin).
+	spin map[string][]float64
 	// Hopping e.v.'s for even symmetry (pre-calculated).
 	dae, dce, dbe float64
 	// Hopping e.v.'s for odd symmetry (pre-calculated).
 	dao, dco, dbo float64
+	// Derivatives of the electronic energy with respect to W and M (see Dw
+	// and Dm).
+	dw, dm float64
 }
 
 func NewHoppingEV() *HoppingEV {
-	names := []string{"dae", "dce", "dbe", "dao", "dco", "dbo"}
+	names := []string{"dae", "dce", "dbe", "dao", "dco", "dbo", "dw", "dm"}
 
 	Ds := new(HoppingEV)
 	Ds.m_cached = make(map[string]float64)
 	Ds.w_cached = make(map[string]float64)
 	Ds.mu_cached = make(map[string]float64)
+	Ds.t_cached = make(map[string][6]float64)
+	Ds.tb_cached = make(map[string][]float64)
+	Ds.hf_cached = make(map[string][]float64)
 	Ds.init = make(map[string]bool)
+	Ds.spin = make(map[string][]float64)
 
 	for _, name := range names {
 		Ds.init[name] = false
@@ -53,30 +73,39 @@ func (Ds *HoppingEV) Dae(env *Environment) float64 {
 	if !env.FiniteHoppings() {
 		return 0.0
 	}
-
-	inner := func(k vec.Vector) float64 {
-		ev := GetEV_K0_K0(env, k)
-		// make sure that ev is pure real
-		//if math.Abs(imag(ev)) > zero_threshold {
-		//	panic("Expected pure real value for <c_{k,0}^{\\dagger} c_{k,0}>, got finite imaginary part")
-		//}
-		return 4.0 * math.Cos(k[0]) * real(ev)
+	if env.folded() {
+		return Ds.cacheEV(env, "dae", env.foldedD, &Ds.dae)
 	}
-	dae := 0.5 * bzone.Avg(env.BZPointsPerDim, 3, inner)
 
-	inner_im := func(k vec.Vector) float64 {
-		ev := GetEV_K0_K0(env, k)
-		return -4.0 * math.Cos(k[0]) * imag(ev)
-	}
-	dae_im := bzone.Avg(env.BZPointsPerDim, 3, inner_im)
-	if math.Abs(dae_im) > zero_threshold {
-		panic("Expected real value for Dae, got finite imaginary part.")
-	}
+	dae := Ds.perSpin(env, "dae", func(sigma int) float64 {
+		inner := func(k vec.Vector) float64 {
+			ev := GetEV_K0_K0(env, k, sigma)
+			// make sure that ev is pure real
+			//if math.Abs(imag(ev)) > zero_threshold {
+			//	panic("Expected pure real value for <c_{k,0}^{\\dagger} c_{k,0}>, got finite imaginary part")
+			//}
+			return 4.0 * math.Cos(k[0]) * real(ev)
+		}
+		dae := 0.5 * bzone.Avg(env.BZPointsPerDim, 3, inner)
+
+		inner_im := func(k vec.Vector) float64 {
+			ev := GetEV_K0_K0(env, k, sigma)
+			return -4.0 * math.Cos(k[0]) * imag(ev)
+		}
+		dae_im := bzone.Avg(env.BZPointsPerDim, 3, inner_im)
+		if math.Abs(dae_im) > zero_threshold {
+			panic("Expected real value for Dae, got finite imaginary part.")
+		}
+		return dae
+	})
 
 	Ds.init["dae"] = true
 	Ds.m_cached["dae"] = env.M
 	Ds.w_cached["dae"] = env.W
 	Ds.mu_cached["dae"] = env.Mu
+	Ds.t_cached["dae"] = env.Hoppings()
+	Ds.tb_cached["dae"] = env.tbHoppings()
+	Ds.hf_cached["dae"] = env.hfFields()
 	Ds.dae = dae
 	return dae
 }
@@ -88,30 +117,39 @@ func (Ds *HoppingEV) Dce(env *Environment) float64 {
 	if !env.FiniteHoppings() {
 		return 0.0
 	}
-
-	inner := func(k vec.Vector) float64 {
-		ev := GetEV_K0_K0(env, k)
-		// make sure that ev is pure real
-		//if math.Abs(imag(ev)) > zero_threshold {
-		//	panic("Expected pure real value for <c_{k,0}^{\\dagger} c_{k,0}>, got finite imaginary part")
-		//}
-		return 4.0 * math.Cos(k[2]) * real(ev)
+	if env.folded() {
+		return Ds.cacheEV(env, "dce", env.foldedD, &Ds.dce)
 	}
-	dce := 0.5 * bzone.Avg(env.BZPointsPerDim, 3, inner)
 
-	inner_im := func(k vec.Vector) float64 {
-		ev := GetEV_K0_K0(env, k)
-		return -4.0 * math.Cos(k[2]) * imag(ev)
-	}
-	dce_im := bzone.Avg(env.BZPointsPerDim, 3, inner_im)
-	if math.Abs(dce_im) > zero_threshold {
-		panic("Expected real value for Dce, got finite imaginary part.")
-	}
+	dce := Ds.perSpin(env, "dce", func(sigma int) float64 {
+		inner := func(k vec.Vector) float64 {
+			ev := GetEV_K0_K0(env, k, sigma)
+			// make sure that ev is pure real
+			//if math.Abs(imag(ev)) > zero_threshold {
+			//	panic("Expected pure real value for <c_{k,0}^{\\dagger} c_{k,0}>, got finite imaginary part")
+			//}
+			return 4.0 * math.Cos(k[2]) * real(ev)
+		}
+		dce := 0.5 * bzone.Avg(env.BZPointsPerDim, 3, inner)
+
+		inner_im := func(k vec.Vector) float64 {
+			ev := GetEV_K0_K0(env, k, sigma)
+			return -4.0 * math.Cos(k[2]) * imag(ev)
+		}
+		dce_im := bzone.Avg(env.BZPointsPerDim, 3, inner_im)
+		if math.Abs(dce_im) > zero_threshold {
+			panic("Expected real value for Dce, got finite imaginary part.")
+		}
+		return dce
+	})
 
 	Ds.init["dce"] = true
 	Ds.m_cached["dce"] = env.M
 	Ds.w_cached["dce"] = env.W
 	Ds.mu_cached["dce"] = env.Mu
+	Ds.t_cached["dce"] = env.Hoppings()
+	Ds.tb_cached["dce"] = env.tbHoppings()
+	Ds.hf_cached["dce"] = env.hfFields()
 	Ds.dce = dce
 	return dce
 }
@@ -123,17 +161,25 @@ func (Ds *HoppingEV) Dbe(env *Environment) float64 {
 	if !env.FiniteHoppings() {
 		return 0.0
 	}
-
-	inner := func(k vec.Vector) float64 {
-		ev := GetEV_K0_K1(env, k)
-		return 2.0 * real(ev+cmplx.Conj(ev))
+	if env.folded() {
+		return Ds.cacheEV(env, "dbe", env.foldedD, &Ds.dbe)
 	}
-	dbe := 0.5 * bzone.Avg(env.BZPointsPerDim, 3, inner)
+
+	dbe := Ds.perSpin(env, "dbe", func(sigma int) float64 {
+		inner := func(k vec.Vector) float64 {
+			ev := GetEV_K0_K1(env, k, sigma)
+			return 2.0 * real(ev+cmplx.Conj(ev))
+		}
+		return 0.5 * bzone.Avg(env.BZPointsPerDim, 3, inner)
+	})
 
 	Ds.init["dbe"] = true
 	Ds.m_cached["dbe"] = env.M
 	Ds.w_cached["dbe"] = env.W
 	Ds.mu_cached["dbe"] = env.Mu
+	Ds.t_cached["dbe"] = env.Hoppings()
+	Ds.tb_cached["dbe"] = env.tbHoppings()
+	Ds.hf_cached["dbe"] = env.hfFields()
 	Ds.dbe = dbe
 	return dbe
 }
@@ -145,32 +191,41 @@ func (Ds *HoppingEV) Dao(env *Environment) float64 {
 	if !env.FiniteHoppings() {
 		return 0.0
 	}
-
-	inner := func(k vec.Vector) float64 {
-		ev := GetEV_KQ0_K0(env, k)
-		//println("Dao", ev)
-		// make sure that ev is pure imaginary
-		//if math.Abs(real(ev)) > zero_threshold {
-		//	panic("Expected pure imaginary value for <c_{k+Q,0}^{\\dagger} c_{k,0}>, got finite real part")
-		//}
-		// 2i * ev = -2 * imag(ev)
-		return -2.0 * math.Sin(k[0]) * imag(ev)
+	if env.tb != nil {
+		return Ds.cacheEV(env, "dao", env.tbOddD, &Ds.dao)
 	}
-	dao := 0.5 * bzone.Avg(env.BZPointsPerDim, 3, inner)
 
-	inner_re := func(k vec.Vector) float64 {
-		ev := GetEV_KQ0_K0(env, k)
-		return 2.0 * math.Sin(k[0]) * real(ev)
-	}
-	dao_re := bzone.Avg(env.BZPointsPerDim, 3, inner_re)
-	if math.Abs(dao_re) > zero_threshold {
-		panic("Expected real value for Dao, got finite imaginary part.")
-	}
+	dao := Ds.perSpin(env, "dao", func(sigma int) float64 {
+		inner := func(k vec.Vector) float64 {
+			ev := GetEV_KQ0_K0(env, k, sigma)
+			//println("Dao", ev)
+			// make sure that ev is pure imaginary
+			//if math.Abs(real(ev)) > zero_threshold {
+			//	panic("Expected pure imaginary value for <c_{k+Q,0}^{\\dagger} c_{k,0}>, got finite real part")
+			//}
+			// 2i * ev = -2 * imag(ev)
+			return -2.0 * math.Sin(k[0]) * imag(ev)
+		}
+		dao := 0.5 * bzone.Avg(env.BZPointsPerDim, 3, inner)
+
+		inner_re := func(k vec.Vector) float64 {
+			ev := GetEV_KQ0_K0(env, k, sigma)
+			return 2.0 * math.Sin(k[0]) * real(ev)
+		}
+		dao_re := bzone.Avg(env.BZPointsPerDim, 3, inner_re)
+		if math.Abs(dao_re) > zero_threshold {
+			panic("Expected real value for Dao, got finite imaginary part.")
+		}
+		return dao
+	})
 
 	Ds.init["dao"] = true
 	Ds.m_cached["dao"] = env.M
 	Ds.w_cached["dao"] = env.W
 	Ds.mu_cached["dao"] = env.Mu
+	Ds.t_cached["dao"] = env.Hoppings()
+	Ds.tb_cached["dao"] = env.tbHoppings()
+	Ds.hf_cached["dao"] = env.hfFields()
 	Ds.dao = dao
 	return dao
 }
@@ -182,31 +237,40 @@ func (Ds *HoppingEV) Dco(env *Environment) float64 {
 	if !env.FiniteHoppings() {
 		return 0.0
 	}
-
-	inner := func(k vec.Vector) float64 {
-		ev := GetEV_KQ0_K0(env, k)
-		// make sure that ev is pure imaginary
-		//if math.Abs(real(ev)) > zero_threshold {
-		//	panic("Expected pure imaginary value for <c_{k+Q,0}^{\\dagger} c_{k,0}>, got finite real part")
-		//}
-		// 2i * ev = -2 * imag(ev)
-		return -2.0 * math.Sin(k[2]) * imag(ev)
+	if env.tb != nil {
+		return Ds.cacheEV(env, "dco", env.tbOddD, &Ds.dco)
 	}
-	dco := 0.5 * bzone.Avg(env.BZPointsPerDim, 3, inner)
 
-	inner_re := func(k vec.Vector) float64 {
-		ev := GetEV_KQ0_K0(env, k)
-		return 2.0 * math.Sin(k[2]) * real(ev)
-	}
-	dco_re := bzone.Avg(env.BZPointsPerDim, 3, inner_re)
-	if math.Abs(dco_re) > zero_threshold {
-		panic("Expected real value for Dco, got finite imaginary part.")
-	}
+	dco := Ds.perSpin(env, "dco", func(sigma int) float64 {
+		inner := func(k vec.Vector) float64 {
+			ev := GetEV_KQ0_K0(env, k, sigma)
+			// make sure that ev is pure imaginary
+			//if math.Abs(real(ev)) > zero_threshold {
+			//	panic("Expected pure imaginary value for <c_{k+Q,0}^{\\dagger} c_{k,0}>, got finite real part")
+			//}
+			// 2i * ev = -2 * imag(ev)
+			return -2.0 * math.Sin(k[2]) * imag(ev)
+		}
+		dco := 0.5 * bzone.Avg(env.BZPointsPerDim, 3, inner)
+
+		inner_re := func(k vec.Vector) float64 {
+			ev := GetEV_KQ0_K0(env, k, sigma)
+			return 2.0 * math.Sin(k[2]) * real(ev)
+		}
+		dco_re := bzone.Avg(env.BZPointsPerDim, 3, inner_re)
+		if math.Abs(dco_re) > zero_threshold {
+			panic("Expected real value for Dco, got finite imaginary part.")
+		}
+		return dco
+	})
 
 	Ds.init["dco"] = true
 	Ds.m_cached["dco"] = env.M
 	Ds.w_cached["dco"] = env.W
 	Ds.mu_cached["dco"] = env.Mu
+	Ds.t_cached["dco"] = env.Hoppings()
+	Ds.tb_cached["dco"] = env.tbHoppings()
+	Ds.hf_cached["dco"] = env.hfFields()
 	Ds.dco = dco
 	return dco
 }
@@ -218,22 +282,204 @@ func (Ds *HoppingEV) Dbo(env *Environment) float64 {
 	if !env.FiniteHoppings() {
 		return 0.0
 	}
-
-	inner := func(k vec.Vector) float64 {
-		ev := GetEV_KQ0_K1(env, k)
-		//println("Dbo", ev)
-		return real(ev + cmplx.Conj(ev))
+	if env.tb != nil {
+		return Ds.cacheEV(env, "dbo", env.tbOddD, &Ds.dbo)
 	}
-	dbo := 0.5 * bzone.Avg(env.BZPointsPerDim, 3, inner)
+
+	dbo := Ds.perSpin(env, "dbo", func(sigma int) float64 {
+		inner := func(k vec.Vector) float64 {
+			ev := GetEV_KQ0_K1(env, k, sigma)
+			//println("Dbo", ev)
+			return real(ev + cmplx.Conj(ev))
+		}
+		return 0.5 * bzone.Avg(env.BZPointsPerDim, 3, inner)
+	})
 
 	Ds.init["dbo"] = true
 	Ds.m_cached["dbo"] = env.M
 	Ds.w_cached["dbo"] = env.W
 	Ds.mu_cached["dbo"] = env.Mu
+	Ds.t_cached["dbo"] = env.Hoppings()
+	Ds.tb_cached["dbo"] = env.tbHoppings()
+	Ds.hf_cached["dbo"] = env.hfFields()
 	Ds.dbo = dbo
 	return dbo
 }
 
+// Evaluate the hopping e.v. with the given name for each spin channel by
+// eval (see foldedD and tbOddD), store it in d and cache it (see perSpin).
+func (Ds *HoppingEV) cacheEV(env *Environment, dname string, eval func(string, int) float64, d *float64) float64 {
+	*d = Ds.perSpin(env, dname, func(sigma int) float64 {
+		return eval(dname, sigma)
+	})
+	Ds.init[dname] = true
+	Ds.m_cached[dname] = env.M
+	Ds.w_cached[dname] = env.W
+	Ds.mu_cached[dname] = env.Mu
+	Ds.t_cached[dname] = env.Hoppings()
+	Ds.tb_cached[dname] = env.tbHoppings()
+	Ds.hf_cached[dname] = env.hfFields()
+	return *d
+}
+
+// Evaluate the hopping e.v. with the given name for each spin channel of
+// ElHamiltonian (see spinHamiltonian) by eval, keep the values of the
+// channels (see SpinResolved) and return their average. The average is the
+// e.v. per spin which couples to the ions, as without a Zeeman field or the
+// Hubbard interactions, where the spins are degenerate.
+func (Ds *HoppingEV) perSpin(env *Environment, dname string, eval func(sigma int) float64) float64 {
+	vals := make([]float64, env.spinBlocks())
+	sum := 0.0
+	for sigma := range vals {
+		vals[sigma] = eval(sigma)
+		sum += vals[sigma]
+	}
+	Ds.spin[dname] = vals
+	return sum / float64(len(vals))
+}
+
+// Return the odd hopping e.v. with the given name ("dao", "dco" or "dbo";
+// see HoppingEV) or -<dH/dM>/4 ("dm"; see Dm) of spin channel sigma for a
+// tight-binding model (see Environment.TightBinding). By Hellmann-Feynman,
+// with M = 1,
+// <dH/dTao> = -16 Dao, <dH/dTco> = -8 Dco and <dH/dTbo> = -32 Dbo per cell,
+// so that -<dH/dM>/4 = 4 Tao Dao + 2 Tco Dco + 8 Tbo Dbo. For the vo2solve
+// preset, Dao and Dco are those of ElHamiltonian as written.
+func (env *Environment) tbOddD(name string, sigma int) float64 {
+	var param string
+	var scale float64
+	switch name {
+	case "dao":
+		param, scale = "Tao", -1.0/16.0
+	case "dco":
+		param, scale = "Tco", -1.0/8.0
+	case "dbo":
+		param, scale = "Tbo", -1.0/32.0
+	case "dm":
+		param, scale = "M", -1.0/4.0
+	}
+	inner := func(k vec.Vector) float64 {
+		return scale * env.hoppingDerivativeEV(k, param)[sigma]
+	}
+	return 0.5 * env.foldScale() * bzone.Avg(env.BZPointsPerDim, 3, inner)
+}
+
+// Return the sum over occupied states at k of <dH/dname> for each spin
+// channel of ElHamiltonian, where H is the tight-binding model with M = 1
+// (so that the odd hoppings are those per unit M).
+func (env *Environment) hoppingDerivativeEV(k vec.Vector, name string) []float64 {
+	kr := []float64{k[0] / (2.0 * math.Pi), k[1] / (2.0 * math.Pi), k[2] / (2.0 * math.Pi)}
+	value := func(p string) float64 {
+		if p == "M" {
+			return 1.0
+		}
+		return env.tbParameter(p)
+	}
+	return env.derivativeEV(k, env.tb.Derivative(kr, value, name))
+}
+
+// Return the electronic part of QJ: 4 Tao Dao + 2 Tco Dco for
+// ElHamiltonian as written. For a tight-binding model, it is -<dH/dM>/4 per
+// cell (see tbOddD), so that the ions couple to all of the hoppings
+// modulated by M, including those of a Wannier90 model (see
+// tightbinding.Wannier90Mapping) and the odd body diagonal hoppings of the
+// vo2solve preset (8 Tbo Dbo), which ElHamiltonian as written leaves out of
+// QJ.
+func (Ds *HoppingEV) Dm(env *Environment) float64 {
+	if env.tb == nil {
+		return 4.0*env.Tao_eff()*Ds.Dao(env) + 2.0*env.Tco_eff()*Ds.Dco(env)
+	}
+	if Ds.cacheOk(env, "dm") {
+		return Ds.dm
+	}
+	return Ds.cacheEV(env, "dm", env.tbOddD, &Ds.dm)
+}
+
+// Return the derivative with respect to W of the electronic energy per V,
+// <dH/dW> (including both spins), which couples W to the ions.
+// For ElHamiltonian as written, this is (EpsilonM - EpsilonR) * Filling.
+// For a tight-binding model (see Environment.TightBinding), it is evaluated
+// with the occupied states, so that on-site energies of several orbitals
+// with different W dependence are weighted by the orbital occupations.
+func (Ds *HoppingEV) Dw(env *Environment) float64 {
+	if env.tb == nil {
+		return (env.EpsilonM - env.EpsilonR) * env.Filling
+	}
+	if Ds.cacheOk(env, "dw") {
+		return Ds.dw
+	}
+	inner := func(k vec.Vector) float64 {
+		kr := []float64{k[0] / (2.0 * math.Pi), k[1] / (2.0 * math.Pi), k[2] / (2.0 * math.Pi)}
+		dH := env.tb.Derivative(kr, env.tbParameter, "W")
+		sum := 0.0
+		for _, ev := range env.derivativeEV(k, dH) {
+			sum += ev
+		}
+		// With both spins in H, each spin counts once.
+		return 0.5 * env.spinDegeneracy() * sum
+	}
+	// Factor of 2 for spins and 1/2 per V, as in the Mu equation.
+	dw := env.foldScale() * bzone.Avg(env.BZPointsPerDim, 3, inner)
+
+	Ds.init["dw"] = true
+	Ds.m_cached["dw"] = env.M
+	Ds.w_cached["dw"] = env.W
+	Ds.mu_cached["dw"] = env.Mu
+	Ds.t_cached["dw"] = env.Hoppings()
+	Ds.tb_cached["dw"] = env.tbHoppings()
+	Ds.hf_cached["dw"] = env.hfFields()
+	Ds.dw = dw
+	return dw
+}
+
+// Return the sum over occupied states at k of <dH> for each spin channel of
+// ElHamiltonian, where dH acts on each spin block.
+func (env *Environment) derivativeEV(k vec.Vector, dH [][]complex128) []float64 {
+	n := len(dH)
+	evals, evecs := cmatrix.Eigensystem(ElHamiltonian(env, k))
+	sums := make([]float64, env.spinBlocks())
+	for alpha := range evals {
+		occ := env.Fermi(evals[alpha])
+		for sigma := range sums {
+			// Eigenvectors are in rows (see evalEV).
+			ev := complex(0.0, 0.0)
+			for i := range dH {
+				for j := range dH {
+					if dH[i][j] == 0.0 {
+						continue
+					}
+					ev += cmplx.Conj(evecs[alpha][sigma*n+i]) * dH[i][j] * evecs[alpha][sigma*n+j]
+				}
+			}
+			sums[sigma] += occ * real(ev)
+		}
+	}
+	return sums
+}
+
+// Return the hopping e.v.'s Dae, ..., Dbo of each spin channel of
+// ElHamiltonian (up, then down; see spinHamiltonian), whose averages are the
+// values returned by Dae, ..., Dbo. Return nil if ElHamiltonian does not
+// include both spins.
+func (Ds *HoppingEV) SpinResolved(env *Environment) map[string][]float64 {
+	if !env.spinful() {
+		return nil
+	}
+	Dfuncs := map[string]func(*HoppingEV, *Environment) float64{"Dae": (*HoppingEV).Dae, "Dce": (*HoppingEV).Dce,
+		"Dbe": (*HoppingEV).Dbe, "Dao": (*HoppingEV).Dao, "Dco": (*HoppingEV).Dco, "Dbo": (*HoppingEV).Dbo}
+	resolved := make(map[string][]float64)
+	for name, D := range Dfuncs {
+		D(Ds, env)
+		vals := make([]float64, env.spinBlocks())
+		// Left at 0 if the hoppings vanish (see FiniteHoppings).
+		if env.FiniteHoppings() {
+			copy(vals, Ds.spin[strings.ToLower(name)])
+		}
+		resolved[name] = vals
+	}
+	return resolved
+}
+
 // Return true iff the cached evaluation of the given D value is still
 // OK to use.
 func (Ds *HoppingEV) cacheOk(env *Environment, dname string) bool {
@@ -244,9 +490,12 @@ func (Ds *HoppingEV) cacheOk(env *Environment, dname string) bool {
 	Mu_ok := env.Mu == Ds.mu_cached[dname]
 	// Possible to ignore W value here:
 	// If EpsilonR == EpsilonM, H(k) is independent of W.
-	W_ok := (env.W == Ds.w_cached[dname]) || (env.EpsilonR == env.EpsilonM)
+	W_ok := (env.W == Ds.w_cached[dname]) || !env.dependsOnW()
+	T_ok := env.Hoppings() == Ds.t_cached[dname]
+	TB_ok := equalFloats(env.tbHoppings(), Ds.tb_cached[dname])
+	HF_ok := equalFloats(env.hfFields(), Ds.hf_cached[dname])
 
-	return M_ok && Mu_ok && W_ok
+	return M_ok && Mu_ok && W_ok && T_ok && TB_ok && HF_ok
 }
 
 // Convert to string by marshalling to JSON.
@@ -264,6 +513,7 @@ func (Ds *HoppingEV) MarshalEnv(env *Environment) string {
 	repr["Dao"] = Ds.Dao(env)
 	repr["Dco"] = Ds.Dco(env)
 	repr["Dbo"] = Ds.Dbo(env)
+	repr["Dm"] = Ds.Dm(env)
 	marshalled, err := json.Marshal(repr)
 	if err != nil {
 		panic(err)
@@ -271,46 +521,68 @@ func (Ds *HoppingEV) MarshalEnv(env *Environment) string {
 	return string(marshalled)
 }
 
-// Evaluate <c^{\dagger}_{k,0} c_{k,0}>.
-func GetEV_K0_K0(env *Environment, k vec.Vector) complex128 {
-	return evalEV(env, k, 1, 1)
+// Evaluate <c^{\dagger}_{k,0} c_{k,0}> for spin channel sigma.
+func GetEV_K0_K0(env *Environment, k vec.Vector, sigma int) complex128 {
+	return evalEV(env, k, 1, 1, sigma)
 }
 
-// Evaluate <c^{\dagger}_{k+Q,0} c_{k,0}>.
-func GetEV_KQ0_K0(env *Environment, k vec.Vector) complex128 {
-	return evalEV(env, k, 2, 1)
+// Evaluate <c^{\dagger}_{k+Q,0} c_{k,0}> for spin channel sigma.
+func GetEV_KQ0_K0(env *Environment, k vec.Vector, sigma int) complex128 {
+	return evalEV(env, k, 2, 1, sigma)
 }
 
-// Evaluate <c^{\dagger}_{k,0} c_{k,1}>.
-func GetEV_K0_K1(env *Environment, k vec.Vector) complex128 {
-	return evalEV(env, k, 1, 3)
+// Evaluate <c^{\dagger}_{k,0} c_{k,1}> for spin channel sigma.
+func GetEV_K0_K1(env *Environment, k vec.Vector, sigma int) complex128 {
+	return evalEV(env, k, 1, 3, sigma)
 }
 
-// Evaluate <c^{\dagger}_{k+Q,0} c_{k,1}>.
-func GetEV_KQ0_K1(env *Environment, k vec.Vector) complex128 {
-	return evalEV(env, k, 2, 3)
+// Evaluate <c^{\dagger}_{k+Q,0} c_{k,1}> for spin channel sigma.
+func GetEV_KQ0_K1(env *Environment, k vec.Vector, sigma int) complex128 {
+	return evalEV(env, k, 2, 3, sigma)
 }
 
 // Evaluate <c^{\dagger}_{indexL} c_{indexR}> where the index values have
 // the following correspondence:
 // 	1 <--> k, 0 ; 2 <--> k+Q, 0 ; 3 <--> k, 1 ; 4 <--> k+Q, 1
-func evalEV(env *Environment, k vec.Vector, indexL, indexR int) complex128 {
+// If the Hamiltonian has more than 4 states (a tight-binding model with
+// several orbitals per site), the states form 4 equal blocks in this order
+// and the expectation value is summed over the orbitals in the block which
+// are coupled by M (see dimerOrbitals). If the Hamiltonian includes both
+// spins (see ElHamiltonian), the expectation value is that of spin channel
+// sigma (0 for up and 1 for down); otherwise sigma must be 0.
+// For a modulation wavevector other than the default, there are 2N blocks
+// (see wavevector.go), and k+Q is the block (k+Q, s).
+func evalEV(env *Environment, k vec.Vector, indexL, indexR, sigma int) complex128 {
+	block := func(index int) int {
+		return env.blockIndex((index-1)/2, (index-1)%2)
+	}
+	return blockEVs(env, k, [][2]int{[2]int{block(indexL), block(indexR)}}, sigma)[0]
+}
+
+// Evaluate <c^{\dagger}_{bL} c_{bR}> for each pair of block indices (bL, bR)
+// (see blockIndex) for spin channel sigma, as in evalEV.
+func blockEVs(env *Environment, k vec.Vector, pairs [][2]int, sigma int) []complex128 {
 	H := ElHamiltonian(env, k)
 	dim, _ := H.Dims()
+	nb := env.numOrbitals()
+	n := env.numBlocks() * nb
 	evals, evecs := cmatrix.Eigensystem(H)
-	sum := complex(0.0, 0.0)
+	sums := make([]complex128, len(pairs))
 	for alpha := 0; alpha < dim; alpha++ {
-		// Coefficients psi^*_{alpha} psi_{alpha}.
-		// Indices reversed relative to matrix since Eigensystem returns
-		// a slice of eigenvectors (i.e. eigenvectors in rows instead of columns).
-		// Shifted by 1 since the slice is zero-indexed.
-		left := cmplx.Conj(evecs[alpha][indexL-1])
-		right := evecs[alpha][indexR-1]
 		// Fermi-Dirac occupation.
 		// Mu is included in H, so not included here.
 		occ := env.Fermi(evals[alpha])
-		// alpha'th eigenvector contribution to EV.
-		sum += left * right * complex(occ, 0.0)
+		for p, pair := range pairs {
+			for _, a := range env.dimerOrbitals() {
+				// Coefficients psi^*_{alpha} psi_{alpha}.
+				// Indices reversed relative to matrix since Eigensystem returns
+				// a slice of eigenvectors (i.e. eigenvectors in rows instead of columns).
+				left := cmplx.Conj(evecs[alpha][sigma*n+pair[0]*nb+a])
+				right := evecs[alpha][sigma*n+pair[1]*nb+a]
+				// alpha'th eigenvector contribution to EV.
+				sums[p] += left * right * complex(occ, 0.0)
+			}
+		}
 	}
-	return sum
+	return sums
 }
@@ -0,0 +1,80 @@
+package vo2solve
+
+import (
+	"fmt"
+)
+import (
+	"github.com/tflovorn/scExplorer/solve"
+	"github.com/tflovorn/vo2mft/meanfield"
+)
+
+// Free energy evaluated on a grid of fixed values of some variables, with
+// another set of variables solved for at each grid point (see
+// meanfield.Landscape).
+type Landscape = meanfield.Landscape
+
+type LandscapePoint = meanfield.LandscapePoint
+
+// Return the system of self-consistency equations for the variables in relax
+// (any of "M", "W", "Mu" and the Hartree-Fock mean fields; see
+// HFVariables), with all other variables fixed to their values in env.
+func RelaxSystem(env *Environment, Ds *HoppingEV, relax []string) (solve.DiffSystem, []float64, error) {
+	diffs := []solve.Diffable{}
+	start := []float64{}
+	for _, name := range relax {
+		switch name {
+		case "M":
+			diffs = append(diffs, AbsErrorM(env, Ds, relax))
+		case "W":
+			diffs = append(diffs, AbsErrorW(env, Ds, relax))
+		case "Mu":
+			diffs = append(diffs, AbsErrorMu(env, relax))
+		default:
+			field, _, err := splitIndexedName(name)
+			if err != nil || (field != "HFDensity" && field != "HFBond" && field != "HFBondQ") {
+				return solve.DiffSystem{}, nil, fmt.Errorf("Cannot relax variable %v", name)
+			}
+			diffs = append(diffs, AbsErrorHFField(env, name, relax))
+		}
+		start = append(start, env.GetFloat(name))
+	}
+	return solve.Combine(diffs), start, nil
+}
+
+// Evaluate the free energy on the grid given by the outer product of
+// grid_vals (grid_vals[i] is the list of values taken by grid_vars[i]),
+// solving for the variables in relax at each point. env is not modified.
+// Return an error if a grid variable is not in env, or is also in relax.
+func NewLandscape(env *Environment, grid_vars []string, grid_vals [][]float64, relax []string, epsAbs, epsRel float64) (*Landscape, error) {
+	err := env.CheckVariables(grid_vars)
+	if err != nil {
+		return nil, err
+	}
+	// Check the relaxed variables before evaluating any points.
+	_, _, err = RelaxSystem(env, NewHoppingEV(), relax)
+	if err != nil {
+		return nil, err
+	}
+	evaluate := func(x []float64) ([]float64, float64, bool, error) {
+		point_env := *env
+		point_env.Set(x, grid_vars)
+		// Ds caches depend on the grid variables, so use a new one.
+		Ds := NewHoppingEV()
+		if len(relax) > 0 {
+			system, start, err := RelaxSystem(&point_env, Ds, relax)
+			if err != nil {
+				return nil, 0.0, false, err
+			}
+			_, err = solve.MultiDim(system, start, epsAbs, epsRel)
+			if err != nil {
+				return nil, 0.0, false, nil
+			}
+		}
+		relaxed := make([]float64, len(relax))
+		for i, name := range relax {
+			relaxed[i] = point_env.GetFloat(name)
+		}
+		return relaxed, point_env.FreeEnergy(Ds), true, nil
+	}
+	return meanfield.NewLandscape(grid_vars, grid_vals, relax, evaluate)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+import (
+	"github.com/tflovorn/vo2mft/meanfield"
+	"github.com/tflovorn/vo2mft/vo2solve"
+)
+
+var eps = flag.Float64("eps", 1e-6, "Converged when error below eps")
+var ions = flag.Bool("ions", false, "Consider only ionic system")
+var vars = flag.String("vars", "M,W", "Comma-separated names of variables fixed on the grid")
+var mins = flag.String("min", "0,0", "Comma-separated minimum values of grid variables")
+var maxs = flag.String("max", "1,1", "Comma-separated maximum values of grid variables")
+var nums = flag.String("num", "21,21", "Comma-separated numbers of grid points for each grid variable")
+var relax = flag.String("relax", "Mu", "Comma-separated names of variables solved for at each grid point (empty to freeze all)")
+var csv = flag.Bool("csv", false, "Write CSV instead of JSON")
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 2 {
+		fmt.Println("Usage: landscape_front [--eps EPS] [--ions] [--vars VARS] [--min MINS] [--max MAXS] [--num NUMS] [--relax RELAX] [--csv] in_path out_path")
+		fmt.Println("For flag descriptions, use: landscape_front --help")
+		os.Exit(2)
+	}
+	in_path := args[0]
+	out_path := args[1]
+
+	var env *vo2solve.Environment
+	var err error
+	if !*ions {
+		env, err = vo2solve.LoadEnv(in_path)
+	} else {
+		env, err = vo2solve.LoadIonEnv(in_path)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	grid_vars := meanfield.SplitList(*vars)
+	grid_vals, err := meanfield.GridValues(len(grid_vars), *mins, *maxs, *nums)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	ls, err := vo2solve.NewLandscape(env, grid_vars, grid_vals, meanfield.SplitList(*relax), *eps, *eps)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// Write output.
+	if *csv {
+		ls_out_buf := bytes.NewBufferString(ls.CSV())
+		ioutil.WriteFile(out_path+"_landscape.csv", ls_out_buf.Bytes(), 0644) // u=rw;go=r
+	} else {
+		ls_out_buf := bytes.NewBufferString(ls.Marshal())
+		ioutil.WriteFile(out_path+"_landscape.json", ls_out_buf.Bytes(), 0644) // u=rw;go=r
+	}
+}
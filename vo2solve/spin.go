@@ -0,0 +1,131 @@
+package vo2solve
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+)
+import (
+	"github.com/tflovorn/cmatrix"
+	"github.com/tflovorn/scExplorer/bzone"
+	vec "github.com/tflovorn/scExplorer/vector"
+)
+
+// Step in Zeeman used to take the finite-difference spin susceptibility.
+const spin_dh = 1e-3
+
+// Return true if ElHamiltonian includes both spins: with the Hubbard
+// interactions or a Zeeman field.
+func (env *Environment) spinful() bool {
+	return env.hartreeFock() || env.Zeeman != 0.0
+}
+
+// Number of spin blocks in ElHamiltonian: 2 if it includes both spins and 1
+// otherwise.
+func (env *Environment) spinBlocks() int {
+	if env.spinful() {
+		return 2
+	}
+	return 1
+}
+
+// Number of spin states per eigenstate of ElHamiltonian: 1 if it includes
+// both spins and 2 (the spin degeneracy) otherwise.
+func (env *Environment) spinDegeneracy() float64 {
+	return 2.0 / float64(env.spinBlocks())
+}
+
+// Return the electronic Hamiltonian with both spins (up, then down), given
+// the Hamiltonian H for one spin without interactions at k (see
+// ElHamiltonian). Each spin block includes the Zeeman energy and the
+// Hartree-Fock self-energy of that spin (see hartreeFock.go).
+func (env *Environment) spinHamiltonian(H cmatrix.SliceCMatrix, k vec.Vector) cmatrix.SliceCMatrix {
+	n := len(H)
+	kr := []float64{k[0] / (2.0 * math.Pi), k[1] / (2.0 * math.Pi), k[2] / (2.0 * math.Pi)}
+	Hs := cmatrix.InitSliceCMatrix(2*n, 2*n)
+	for sigma := 0; sigma < 2; sigma++ {
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				Hs[sigma*n+i][sigma*n+j] = H[i][j]
+			}
+			Hs[sigma*n+i][sigma*n+i] += complex(-env.Zeeman*spinSign(sigma), 0.0)
+		}
+		if !env.hartreeFock() {
+			continue
+		}
+		self := env.hfSelfEnergy(kr, sigma)
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				Hs[sigma*n+i][sigma*n+j] += self[i][j]
+			}
+		}
+	}
+	return Hs
+}
+
+// Return 1 for spin up (sigma = 0) and -1 for spin down (sigma = 1).
+func spinSign(sigma int) float64 {
+	if sigma == 0 {
+		return 1.0
+	}
+	return -1.0
+}
+
+// Return the magnetisation n_up - n_down, in the units of Filling (so that
+// n_up + n_down = Filling when Mu is solved). It is 0 if ElHamiltonian does
+// not include both spins.
+func (env *Environment) Magnetization() float64 {
+	if !env.spinful() {
+		return 0.0
+	}
+	inner := func(k vec.Vector) float64 {
+		evals, evecs := cmatrix.Eigensystem(ElHamiltonian(env, k))
+		n := len(evals) / 2
+		sum := 0.0
+		for alpha := range evals {
+			// Eigenvectors are in rows (see evalEV).
+			w := 0.0
+			for i := 0; i < n; i++ {
+				w += math.Pow(cmplx.Abs(evecs[alpha][i]), 2.0) - math.Pow(cmplx.Abs(evecs[alpha][n+i]), 2.0)
+			}
+			sum += env.Fermi(evals[alpha]) * w
+		}
+		return sum
+	}
+	// The k and k+Q states both range over the BZ, as in the Mu equation.
+	return 0.5 * env.foldScale() * bzone.Avg(env.BZPointsPerDim, 3, inner)
+}
+
+// Return the spin susceptibility dm/dZeeman at the solved env, where m is
+// the Magnetization.
+// m is evaluated at Zeeman +/- spin_dh by solving the system again, starting
+// from the solution in env, so the derivative includes the response of the
+// order parameters, Mu and the Hartree-Fock mean fields. env is not
+// modified.
+func SpinSusceptibility(env *Environment, epsAbs, epsRel float64) (float64, error) {
+	if env.IonsOnly {
+		return 0.0, fmt.Errorf("SpinSusceptibility requires the electrons (IonsOnly is set)")
+	}
+	mp, err := solvedMagnetization(env, env.Zeeman+spin_dh, epsAbs, epsRel)
+	if err != nil {
+		return 0.0, err
+	}
+	mm, err := solvedMagnetization(env, env.Zeeman-spin_dh, epsAbs, epsRel)
+	if err != nil {
+		return 0.0, err
+	}
+	return (mp - mm) / (2.0 * spin_dh), nil
+}
+
+// Solve a copy of env with the given Zeeman energy and return its
+// magnetisation.
+func solvedMagnetization(env *Environment, zeeman, epsAbs, epsRel float64) (float64, error) {
+	shifted := *env
+	shifted.Zeeman = zeeman
+	Ds := NewHoppingEV()
+	_, err := Solve(&shifted, Ds, epsAbs, epsRel)
+	if err != nil {
+		return 0.0, err
+	}
+	return shifted.Magnetization(), nil
+}
@@ -0,0 +1,104 @@
+package vo2solve
+
+import (
+	"github.com/tflovorn/scExplorer/solve"
+	vec "github.com/tflovorn/scExplorer/vector"
+	"github.com/tflovorn/vo2mft/meanfield"
+)
+
+// Stability labels (see meanfield.StabilityLabel).
+const (
+	Stable     = meanfield.Stable
+	Metastable = meanfield.Metastable
+	Unstable   = meanfield.Unstable
+	Unknown    = meanfield.Unknown
+)
+
+// Names of the order parameters with respect to which the free energy Hessian
+// is taken.
+var HessianVars = []string{"M", "W"}
+
+// Return the Hessian of the Landau free energy per cell with respect to
+// HessianVars, its eigenvalues (in ascending order) and the corresponding
+// stability label, evaluated at the solved env (see meanfield.Hessian).
+// env is not modified. If chi is singular, the Hessian diverges and nil is
+// returned for it and its eigenvalues.
+//
+// The Landau free energy G(x) is the mean-field free energy expressed as a
+// function of the order parameters x = (M, W), with the ionic fields chosen
+// to produce x. It is equal to FreeEnergy at a solution. Its Hessian there is
+// 2 chi^{-1} J, where chi is the single-site susceptibility
+// d<(S, S^2)>/d(field), J is the Jacobian of the self-consistency residuals
+// x - <(S, S^2)> with respect to x, and the factor 2 counts the two sites per
+// cell.
+// Unless env.IonsOnly is set, Mu is solved again at each displaced value of x
+// so that the Hessian is taken at fixed electron number.
+func Stability(env *Environment, epsAbs, epsRel float64) ([][]float64, []float64, string, error) {
+	Ds := NewHoppingEV()
+	jac, err := ResidualJacobian(env, Ds, HessianVars, epsAbs, epsRel)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	hess, evals, label := meanfield.Hessian(env.IonSusceptibility(Ds), jac, 2.0)
+	return hess, evals, label, nil
+}
+
+// Return the single-site susceptibility chi = BetaIons * Cov(S, S^2): the
+// derivative of (<S>, <S^2>) with respect to the fields coupling to
+// (S, S^2) in the single-site ionic Hamiltonian.
+// If Gamma is nonzero, chi is the static (Kubo) susceptibility instead.
+func (env *Environment) IonSusceptibility(Ds *HoppingEV) [][]float64 {
+	if env.Gamma != 0.0 {
+		_, _, _, chi := env.quantumIon(Ds)
+		return chi
+	}
+	beta := env.BetaIons()
+	// Probabilities of S = 1, 0, -1.
+	P := env.ionBoltzmann(Ds).Probs
+	Pp, P0, Pm := P[0], P[1], P[2]
+	// Covariances written in terms of probabilities to avoid cancellation
+	// when one configuration dominates.
+	cov_SS := (Pp+Pm)*P0 + 4.0*Pp*Pm
+	cov_SS2 := (Pp - Pm) * P0
+	cov_S2S2 := (Pp + Pm) * P0
+	return [][]float64{[]float64{meanfield.ScaleBeta(beta, cov_SS), meanfield.ScaleBeta(beta, cov_SS2)},
+		[]float64{meanfield.ScaleBeta(beta, cov_SS2), meanfield.ScaleBeta(beta, cov_S2S2)}}
+}
+
+// Return the Jacobian of the (M, W) self-consistency residuals with respect
+// to variables at env (see meanfield.Jacobian).
+// Unless env.IonsOnly is set, Mu is solved for at each displaced point.
+func ResidualJacobian(env *Environment, Ds *HoppingEV, variables []string, epsAbs, epsRel float64) ([][]float64, error) {
+	x0 := make([]float64, len(variables))
+	for i, name := range variables {
+		x0[i] = env.GetFloat(name)
+	}
+	return meanfield.Jacobian(x0, func(x []float64) ([]float64, error) {
+		return fixedResiduals(env, Ds, x, variables, epsAbs, epsRel)
+	})
+}
+
+// Return the (M, W) self-consistency residuals of a copy of env with
+// variables set to the values in x. Unless env.IonsOnly is set, Mu is solved
+// for first.
+func fixedResiduals(env *Environment, Ds *HoppingEV, x vec.Vector, variables []string, epsAbs, epsRel float64) ([]float64, error) {
+	fixed := *env
+	fixed.Set(x, variables)
+	if !fixed.IonsOnly {
+		system, start := MuSystem(&fixed)
+		_, err := solve.MultiDim(system, start, epsAbs, epsRel)
+		if err != nil {
+			return nil, err
+		}
+	}
+	diffs := []solve.Diffable{AbsErrorM(&fixed, Ds, variables), AbsErrorW(&fixed, Ds, variables)}
+	R := make([]float64, len(diffs))
+	for i, diff := range diffs {
+		val, err := diff.F(x)
+		if err != nil {
+			return nil, err
+		}
+		R[i] = val
+	}
+	return R, nil
+}
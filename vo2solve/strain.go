@@ -0,0 +1,95 @@
+package vo2solve
+
+import (
+	"fmt"
+	"math"
+)
+
+// Dependence of the hoppings on bond length d (with unstrained length d0):
+// "power" gives t = t0 (d/d0)^(-Eta) and "exponential" gives
+// t = t0 exp(-Eta (d-d0)/d0). The two agree to linear order in the strain.
+var hopping_scalings = []string{"power", "exponential"}
+
+// Return the strain tensor (Cartesian components, with z along the rutile c
+// axis).
+func (env *Environment) Strain() [3][3]float64 {
+	var e [3][3]float64
+	e[0][0], e[1][1], e[2][2] = env.Strain_xx, env.Strain_yy, env.Strain_zz
+	e[0][1], e[0][2], e[1][2] = env.Strain_xy, env.Strain_xz, env.Strain_yz
+	e[1][0], e[2][0], e[2][1] = e[0][1], e[0][2], e[1][2]
+	return e
+}
+
+// Return the relative change in bond length along the a axes, the c axis and
+// the body diagonals.
+// The model has one hopping per bond type, so the a-axis bonds use the
+// average of e_xx and e_yy and the body diagonal bonds use the average over
+// the four diagonals, (e_xx + e_yy + e_zz)/3; shear strain does not enter.
+func (env *Environment) BondStrains() (float64, float64, float64) {
+	e := env.Strain()
+	e_a := 0.5 * (e[0][0] + e[1][1])
+	e_c := e[2][2]
+	e_b := (e[0][0] + e[1][1] + e[2][2]) / 3.0
+	return e_a, e_c, e_b
+}
+
+// Hoppings for the strained lattice.
+func (env *Environment) Tae_eff() float64 {
+	e_a, _, _ := env.BondStrains()
+	return env.Tae * hoppingScale(env.HoppingScaling, env.Eta_a, e_a)
+}
+
+func (env *Environment) Tce_eff() float64 {
+	_, e_c, _ := env.BondStrains()
+	return env.Tce * hoppingScale(env.HoppingScaling, env.Eta_c, e_c)
+}
+
+func (env *Environment) Tbe_eff() float64 {
+	_, _, e_b := env.BondStrains()
+	return env.Tbe * hoppingScale(env.HoppingScaling, env.Eta_b, e_b)
+}
+
+func (env *Environment) Tao_eff() float64 {
+	e_a, _, _ := env.BondStrains()
+	return env.Tao * hoppingScale(env.HoppingScaling, env.Eta_a, e_a)
+}
+
+func (env *Environment) Tco_eff() float64 {
+	_, e_c, _ := env.BondStrains()
+	return env.Tco * hoppingScale(env.HoppingScaling, env.Eta_c, e_c)
+}
+
+func (env *Environment) Tbo_eff() float64 {
+	_, _, e_b := env.BondStrains()
+	return env.Tbo * hoppingScale(env.HoppingScaling, env.Eta_b, e_b)
+}
+
+// Return the strained hoppings (Tae, Tce, Tbe, Tao, Tco, Tbo).
+func (env *Environment) Hoppings() [6]float64 {
+	return [6]float64{env.Tae_eff(), env.Tce_eff(), env.Tbe_eff(), env.Tao_eff(), env.Tco_eff(), env.Tbo_eff()}
+}
+
+// Return the factor t/t0 for a bond with relative change in length e, using
+// the given scaling ("" is equivalent to "power") and exponent eta.
+func hoppingScale(scaling string, eta, e float64) float64 {
+	if eta == 0.0 {
+		return 1.0
+	}
+	if scaling == "exponential" {
+		return math.Exp(-eta * e)
+	}
+	return math.Pow(1.0+e, -eta)
+}
+
+// Return an error if HoppingScaling does not name a known scaling.
+func (env *Environment) checkHoppingScaling() error {
+	if env.HoppingScaling == "" {
+		return nil
+	}
+	for _, s := range hopping_scalings {
+		if env.HoppingScaling == s {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown HoppingScaling %v; expected one of %v", env.HoppingScaling, hopping_scalings)
+}
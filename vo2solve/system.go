@@ -5,13 +5,21 @@ import (
 	vec "github.com/tflovorn/scExplorer/vector"
 )
 
+// Return the system of equations for (M, W, Mu), followed by the
+// Hartree-Fock mean fields if the Hubbard interactions are included (see
+// HFVariables).
 func MWMuSystem(env *Environment, Ds *HoppingEV) (solve.DiffSystem, []float64) {
-	variables := []string{"M", "W", "Mu"}
+	hf_vars := env.HFVariables()
+	variables := append([]string{"M", "W", "Mu"}, hf_vars...)
 	diffM := AbsErrorM(env, Ds, variables)
 	diffW := AbsErrorW(env, Ds, variables)
 	diffMu := AbsErrorMu(env, variables)
-	system := solve.Combine([]solve.Diffable{diffM, diffW, diffMu})
+	diffs := append([]solve.Diffable{diffM, diffW, diffMu}, AbsErrorHF(env, variables)...)
+	system := solve.Combine(diffs)
 	start := []float64{env.M, env.W, env.Mu}
+	for _, name := range hf_vars {
+		start = append(start, env.GetFloat(name))
+	}
 	return system, start
 }
 
@@ -24,7 +32,12 @@ func MWSystem(env *Environment, Ds *HoppingEV) (solve.DiffSystem, []float64) {
 	return system, start
 }
 
+// Solve for (M, W) in-place with Mu fixed; at T = 0, by the ground-state
+// iteration (see groundStateSolve).
 func MWSolve(env *Environment, Ds *HoppingEV, epsAbs, epsRel float64) (vec.Vector, error) {
+	if env.ZeroTemperature() {
+		return groundStateSolve(env, Ds, epsAbs, epsRel, false)
+	}
 	system, start := MWSystem(env, Ds)
 	solution, err := solve.MultiDim(system, start, epsAbs, epsRel)
 	if err != nil {
@@ -33,15 +46,28 @@ func MWSolve(env *Environment, Ds *HoppingEV, epsAbs, epsRel float64) (vec.Vecto
 	return solution, nil
 }
 
+// Return the system of equations for the electrons with the ionic order
+// parameters fixed: Mu, followed by the Hartree-Fock mean fields if the
+// Hubbard interactions are included (see HFVariables).
 func MuSystem(env *Environment) (solve.DiffSystem, []float64) {
-	variables := []string{"Mu"}
+	hf_vars := env.HFVariables()
+	variables := append([]string{"Mu"}, hf_vars...)
 	diffMu := AbsErrorMu(env, variables)
-	system := solve.Combine([]solve.Diffable{diffMu})
+	diffs := append([]solve.Diffable{diffMu}, AbsErrorHF(env, variables)...)
+	system := solve.Combine(diffs)
 	start := []float64{env.Mu}
+	for _, name := range hf_vars {
+		start = append(start, env.GetFloat(name))
+	}
 	return system, start
 }
 
+// Solve for the variables of MWMuSystem in-place; at T = 0, by the
+// ground-state iteration (see groundStateSolve).
 func MWMuSolve(env *Environment, Ds *HoppingEV, epsAbs, epsRel float64) (vec.Vector, error) {
+	if env.ZeroTemperature() {
+		return groundStateSolve(env, Ds, epsAbs, epsRel, true)
+	}
 	system, start := MWMuSystem(env, Ds)
 	solution, err := solve.MultiDim(system, start, epsAbs, epsRel)
 	if err != nil {
@@ -50,13 +76,24 @@ func MWMuSolve(env *Environment, Ds *HoppingEV, epsAbs, epsRel float64) (vec.Vec
 	return solution, nil
 }
 
+// Solve for the self-consistent variables of env in-place: (M, W) if
+// env.IonsOnly is set, or (M, W, Mu) and any Hartree-Fock mean fields
+// otherwise.
+func Solve(env *Environment, Ds *HoppingEV, epsAbs, epsRel float64) (vec.Vector, error) {
+	if env.IonsOnly {
+		return MWSolve(env, Ds, epsAbs, epsRel)
+	}
+	return MWMuSolve(env, Ds, epsAbs, epsRel)
+}
+
 func MWMuSolve_Iterative(env *Environment, Ds *HoppingEV, epsAbs, epsRel float64) (vec.Vector, error) {
 	Mu_system, Mu_start := MuSystem(env)
 	MW_system, MW_start := MWSystem(env, Ds)
 	stages := []solve.DiffSystem{Mu_system, MW_system}
 	start := []vec.Vector{Mu_start, MW_start}
+	Mu_vars := append([]string{"Mu"}, env.HFVariables()...)
 	accept := func(x []vec.Vector) {
-		env.Mu = x[0][0]
+		env.Set(x[0], Mu_vars)
 		env.M = x[1][0]
 		env.W = x[1][1]
 	}
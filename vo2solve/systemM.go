@@ -1,8 +1,5 @@
 package vo2solve
 
-import (
-	"math"
-)
 import (
 	"github.com/tflovorn/scExplorer/solve"
 	vec "github.com/tflovorn/scExplorer/vector"
@@ -13,9 +10,8 @@ import (
 func AbsErrorM(env *Environment, Ds *HoppingEV, variables []string) solve.Diffable {
 	F := func(v vec.Vector) (float64, error) {
 		env.Set(v, variables)
-		exp := math.Exp(-env.Beta * (env.DeltaS() - env.W*env.QK()))
 		lhs := env.M
-		rhs := 2.0 * exp * math.Sinh(env.Beta*env.M*env.QJ(Ds)) / env.Z1(Ds)
+		rhs := env.ExpectS(Ds)
 		return lhs - rhs, nil
 	}
 	h := 1e-6
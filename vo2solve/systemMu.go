@@ -16,8 +16,10 @@ func AbsErrorMu(env *Environment, variables []string) solve.Diffable {
 		innerClosure := func(k vec.Vector) float64 {
 			return innerMu(env, k)
 		}
-		lhs := 1.0
-		rhs := 0.5 * bzone.Avg(L, 3, innerClosure)
+		lhs := env.Filling
+		// The states at k and k+Q both range over the BZ (see
+		// wavevector.go for other Q).
+		rhs := 0.5 * env.foldScale() * bzone.Avg(L, 3, innerClosure)
 		return lhs - rhs, nil
 	}
 	h := 1e-6
@@ -34,6 +36,6 @@ func innerMu(env *Environment, k vec.Vector) float64 {
 		// Mu is included in H, so not included here.
 		sum += env.Fermi(evals[alpha])
 	}
-	// Multiply by 2 for spin degeneracy.
-	return 2.0 * sum
+	// Multiply by 2 for spin degeneracy, unless H includes both spins.
+	return env.spinDegeneracy() * sum
 }
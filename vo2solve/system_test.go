@@ -3,11 +3,19 @@ package vo2solve
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"math"
+	"math/cmplx"
+	"os"
+	"sort"
 	"testing"
 )
 import (
+	"github.com/tflovorn/cmatrix"
 	"github.com/tflovorn/scExplorer/solve"
+	vec "github.com/tflovorn/scExplorer/vector"
+	"github.com/tflovorn/vo2mft/tetra"
+	"github.com/tflovorn/vo2mft/tightbinding"
 )
 
 var regression_vals = flag.Bool("regression_vals", false, "Run all regression tests, printing output without checking for errors")
@@ -130,3 +138,1115 @@ func TestSolveSystemIons(t *testing.T) {
 	}
 	fmt.Println(result)
 }
+
+func TestThermodynamicsIons(t *testing.T) {
+	env, err := LoadEnv("system_test_env_ions.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Ds := NewHoppingEV()
+	eps := 1e-9
+	_, err = MWSolve(env, Ds, eps, eps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	S, C, err := Thermodynamics(env, eps, eps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("S = ", S, "C = ", C)
+	// Each cell has two sites, each with three states.
+	if S < 0.0 || S > 2.0*math.Log(3.0) {
+		t.Fatalf("Entropy S = %f outside of allowed range [0, 2 log 3]", S)
+	}
+	if C < 0.0 {
+		t.Fatalf("Negative heat capacity C = %f", C)
+	}
+}
+
+func TestStabilityIons(t *testing.T) {
+	// At low temperature, the ordered solution is a minimum of the free
+	// energy and the disordered (M = 0) solution is a saddle point.
+	for _, M_start := range []float64{1.0, 0.0} {
+		env, err := LoadEnv("system_test_env_ions.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		env.M = M_start
+		Ds := NewHoppingEV()
+		eps := 1e-9
+		_, err = MWSolve(env, Ds, eps, eps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, evals, label, err := Stability(env, eps, eps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Println("M = ", env.M, "evals = ", evals, label)
+		expected := Stable
+		if M_start == 0.0 {
+			expected = Unstable
+		}
+		if label != expected {
+			t.Fatalf("Incorrect stability %s for M = %f; expected %s", label, env.M, expected)
+		}
+	}
+}
+
+func TestRegressionFilling(t *testing.T) {
+	// Undoped system (Filling = 1) must reproduce the regression values
+	// obtained before Filling was introduced, whether or not Filling is
+	// given explicitly.
+	expected_result := []float64{0.9999999999971739, 0.9999999999971739, -2.459610225368738}
+	for _, set_filling := range []bool{false, true} {
+		env, err := LoadEnv("system_test_regression_env.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if env.Filling != 1.0 {
+			t.Fatalf("Incorrect default Filling = %f; expected 1", env.Filling)
+		}
+		env.Set([]float64{1.0, 1.0, 10.0}, []string{"M", "W", "Beta"})
+		if set_filling {
+			env.Set([]float64{1.0}, []string{"Filling"})
+		}
+		Ds := NewHoppingEV()
+		eps := 1e-6
+		result, err := MWMuSolve(env, Ds, eps, eps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, name := range []string{"M", "W", "Mu"} {
+			if diff_float(result[i], expected_result[i]) {
+				t.Fatalf("Incorrect %s = %f; expected %f.", name, result[i], expected_result[i])
+			}
+		}
+	}
+}
+
+func TestSolveSystemDoped(t *testing.T) {
+	// Mu must increase with the electron filling.
+	last_Mu := math.Inf(-1)
+	for _, filling := range []float64{0.9, 1.0, 1.1} {
+		env, err := LoadEnv("system_test_regression_env.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		env.Filling = filling
+		Ds := NewHoppingEV()
+		eps := 1e-6
+		_, err = MWMuSolve(env, Ds, eps, eps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Println("Filling = ", filling, "Mu = ", env.Mu)
+		if env.Mu <= last_Mu {
+			t.Fatalf("Mu = %f at Filling = %f does not increase with filling", env.Mu, filling)
+		}
+		last_Mu = env.Mu
+	}
+}
+
+func TestStrainedHoppings(t *testing.T) {
+	env, err := LoadEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.BZPointsPerDim = 4
+	Ds := NewHoppingEV()
+	Dce := Ds.Dce(env)
+
+	env.Strain_zz, env.Eta_c = 0.01, 3.0
+	expected := env.Tce * math.Pow(1.01, -3.0)
+	if math.Abs(env.Tce_eff()-expected) > 1e-12 {
+		t.Fatalf("power law Tce = %v; expected %v", env.Tce_eff(), expected)
+	}
+	if env.Tae_eff() != env.Tae || env.Tbe_eff() != env.Tbe {
+		t.Fatalf("Tae and Tbe should be independent of strain when Eta_a = Eta_b = 0")
+	}
+	env.HoppingScaling = "exponential"
+	expected = env.Tce * math.Exp(-0.03)
+	if math.Abs(env.Tce_eff()-expected) > 1e-12 {
+		t.Fatalf("exponential Tce = %v; expected %v", env.Tce_eff(), expected)
+	}
+	// Cached hopping expectation values must be recalculated under strain.
+	if Ds.Dce(env) == Dce {
+		t.Fatalf("Dce not recalculated after changing strain")
+	}
+}
+
+func TestTransverseIons(t *testing.T) {
+	env, err := LoadEnv("system_test_env_ions.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.M, env.W = 0.3, 0.6
+	Ds := NewHoppingEV()
+	Z1, S, S2, chi := env.Z1(Ds), env.ExpectS(Ds), env.ExpectS2(Ds), env.IonSusceptibility(Ds)
+	// A vanishingly small Gamma must reproduce the classical values.
+	env.Gamma = 1e-8
+	dist_q, S_q, S2_q, chi_q := env.quantumIon(Ds)
+	Z1_q := math.Exp(dist_q.LogZ())
+	tol := 1e-6
+	if math.Abs(Z1_q-Z1) > tol*Z1 || math.Abs(S_q-S) > tol || math.Abs(S2_q-S2) > tol {
+		t.Fatalf("Gamma -> 0 gives (Z1, <S>, <S^2>) = (%v, %v, %v); expected (%v, %v, %v)", Z1_q, S_q, S2_q, Z1, S, S2)
+	}
+	for a := 0; a < 2; a++ {
+		for b := 0; b < 2; b++ {
+			if math.Abs(chi_q[a][b]-chi[a][b]) > tol*math.Max(1.0, math.Abs(chi[a][b])) {
+				t.Fatalf("Gamma -> 0 gives chi = %v; expected %v", chi_q, chi)
+			}
+		}
+	}
+	// Tunnelling suppresses the order.
+	eps := 1e-9
+	env.M, env.W = 1.0, 1.0
+	_, err = MWSolve(env, Ds, eps, eps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	M_classical := env.M
+	env.Gamma = 0.5 * env.QJ(Ds)
+	_, err = MWSolve(env, Ds, eps, eps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("M (Gamma = 0) = ", M_classical, "M (Gamma = ", env.Gamma, ") = ", env.M)
+	if math.Abs(env.M) >= math.Abs(M_classical) {
+		t.Fatalf("Gamma did not reduce M: %v >= %v", math.Abs(env.M), math.Abs(M_classical))
+	}
+}
+
+func TestTwoTemperatures(t *testing.T) {
+	env, err := LoadEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Beta = 10.0
+	hot := *env
+	hot.Beta = 1.0
+	Ds := NewHoppingEV()
+	// The ions only see BetaIon and the electrons only see BetaEl.
+	env.BetaIon, env.BetaEl = 1.0, 1.0
+	if env.ExpectS(Ds) != hot.ExpectS(Ds) || env.Fermi(0.3) != hot.Fermi(0.3) {
+		t.Fatalf("BetaIon = BetaEl = 1 differs from Beta = 1")
+	}
+	if env.FreeEnergyIons(Ds) != hot.FreeEnergyIons(Ds) {
+		t.Fatalf("Ionic free energy at BetaIon = 1 differs from Beta = 1")
+	}
+	env.BetaEl = 0.0
+	if env.Fermi(0.3) == hot.Fermi(0.3) {
+		t.Fatalf("Unset BetaEl does not fall back to Beta")
+	}
+	_, _, err = Thermodynamics(env, 1e-9, 1e-9)
+	if err == nil {
+		t.Fatalf("Thermodynamics accepted separate electron and ion temperatures")
+	}
+}
+
+func TestDos(t *testing.T) {
+	env, err := LoadEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dos := env.Dos(8, 200)
+	// Four bands per cell.
+	dE := dos.E[1] - dos.E[0]
+	total := 0.0
+	for _, D := range dos.DOS {
+		total += D * dE
+	}
+	if math.Abs(total-4.0) > 0.05 {
+		t.Fatalf("DOS integrates to %v; expected 4", total)
+	}
+}
+
+func TestBandEdges(t *testing.T) {
+	env, err := LoadEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Dimerized phase with Mu in the gap between the second and third bands.
+	env.Tae, env.Tce, env.Tbe, env.Tao, env.Tco, env.Tbo = 0.2, 1.0, 0.4, 0.08, 0.4, 0.16
+	env.EpsilonM, env.EpsilonR = 0.05, 0.05
+	env.M, env.W, env.Mu = 1.0, 1.0, 0.09
+	edges := env.BandEdges()
+	if !edges.IsInsulator || edges.Gap <= 0.0 || edges.DirectGap < edges.Gap {
+		t.Fatalf("Expected insulator; got %+v", edges)
+	}
+	// The refined gap is no larger than the gap on the mesh.
+	Eks := tetra.EnergyGrid(env.BandEnergies(), env.BZPointsPerDim)
+	mesh_vbm, mesh_cbm := math.Inf(-1), math.Inf(1)
+	for _, Ek := range Eks {
+		mesh_vbm = math.Max(mesh_vbm, Ek[1])
+		mesh_cbm = math.Min(mesh_cbm, Ek[2])
+	}
+	if edges.Gap > mesh_cbm-mesh_vbm+1e-12 {
+		t.Fatalf("Refined gap %v larger than mesh gap %v", edges.Gap, mesh_cbm-mesh_vbm)
+	}
+
+	env.Mu = -1.0
+	if env.BandEdges().IsInsulator {
+		t.Fatalf("Expected metal with Mu inside the bands")
+	}
+}
+
+func TestLandauGradientIons(t *testing.T) {
+	env, err := LoadEnv("system_test_env_ions.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Beta = 0.5
+	env.M, env.W = 0.5, 0.7
+	Ds := NewHoppingEV()
+	eps := 1e-10
+	grad, G, _, err := LandauGradient(env, Ds, eps, eps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Compare to the finite-difference gradient of G.
+	h := 1e-5
+	for i, name := range HessianVars {
+		x := env.GetFloat(name)
+		env.Set([]float64{x + h}, []string{name})
+		_, Gp, _, err := LandauGradient(env, Ds, eps, eps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		env.Set([]float64{x - h}, []string{name})
+		_, Gm, _, err := LandauGradient(env, Ds, eps, eps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		env.Set([]float64{x}, []string{name})
+		fd := (Gp - Gm) / (2.0 * h)
+		if math.Abs(fd-grad[i]) > 1e-5*math.Max(1.0, math.Abs(fd)) {
+			t.Fatalf("dG/d%s = %v; finite difference gives %v", name, grad[i], fd)
+		}
+	}
+	// Relaxation lowers G and approaches the self-consistent solution.
+	traj, err := Relax(env, []Schedule{}, 1.0, 0.05, 200, 50, eps, eps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := traj.Points[len(traj.Points)-1]
+	if last.FreeEnergy > G {
+		t.Fatalf("Relaxation raised G from %v to %v", G, last.FreeEnergy)
+	}
+	env.M, env.W = last.Values[0], last.Values[1]
+	_, err = MWSolve(env, Ds, eps, eps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(env.M-last.Values[0]) > 1e-4 || math.Abs(env.W-last.Values[1]) > 1e-4 {
+		t.Fatalf("Relaxation ended at (M, W) = %v; solution is (%v, %v)", last.Values, env.M, env.W)
+	}
+	// At the solution, the Hessian of Stability is the derivative of the
+	// gradient (both per cell).
+	hess, _, _, err := Stability(env, eps, eps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for j, name := range HessianVars {
+		x := env.GetFloat(name)
+		env.Set([]float64{x + h}, []string{name})
+		grad_p, _, _, err := LandauGradient(env, Ds, eps, eps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		env.Set([]float64{x - h}, []string{name})
+		grad_m, _, _, err := LandauGradient(env, Ds, eps, eps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		env.Set([]float64{x}, []string{name})
+		for i := range HessianVars {
+			fd := (grad_p[i] - grad_m[i]) / (2.0 * h)
+			if math.Abs(fd-hess[i][j]) > 1e-3*math.Max(1.0, math.Abs(fd)) {
+				t.Fatalf("Hessian[%d][%d] = %v; finite difference of the gradient gives %v", i, j, hess[i][j], fd)
+			}
+		}
+	}
+}
+
+func TestTightBindingPreset(t *testing.T) {
+	env, err := LoadEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.BZPointsPerDim = 4
+	env.M, env.W, env.Mu = 0.3, 0.6, 0.1
+	env.EpsilonR, env.EpsilonM = 0.05, -0.02
+	env.Strain_xx, env.Strain_zz, env.Eta_a, env.Eta_c, env.Eta_b = 0.01, -0.02, 2.0, 3.0, 4.0
+	tb_env := *env
+	tb_env.TightBinding = "vo2solve"
+	err = tb_env.loadTightBinding()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks := []vec.Vector{vec.Vector{0.0, 0.0, 0.0}, vec.Vector{0.3, -1.2, 2.5}, vec.Vector{-2.9, 0.7, -0.4}, vec.Vector{math.Pi, 1.1, -math.Pi / 2.0}}
+	for _, k := range ks {
+		H := ElHamiltonian(env, k)
+		H_tb := ElHamiltonian(&tb_env, k)
+		for i := 0; i < 4; i++ {
+			for j := 0; j < 4; j++ {
+				if cmplx.Abs(H.At(i, j)-H_tb.At(i, j)) > 1e-12 {
+					t.Fatalf("preset H[%d][%d] = %v at k = %v; expected %v", i, j, H_tb.At(i, j), k, H.At(i, j))
+				}
+			}
+		}
+	}
+	// HoppingEV and the free energy go through the preset.
+	Ds, Ds_tb := NewHoppingEV(), NewHoppingEV()
+	if math.Abs(Ds.Dao(env)-Ds_tb.Dao(&tb_env)) > 1e-12 || math.Abs(Ds.Dco(env)-Ds_tb.Dco(&tb_env)) > 1e-12 {
+		t.Fatalf("preset Dao, Dco = %v, %v; expected %v, %v", Ds_tb.Dao(&tb_env), Ds_tb.Dco(&tb_env), Ds.Dao(env), Ds.Dco(env))
+	}
+	if math.Abs(env.FreeEnergyElectrons()-tb_env.FreeEnergyElectrons()) > 1e-12 {
+		t.Fatalf("preset electronic free energy = %v; expected %v", tb_env.FreeEnergyElectrons(), env.FreeEnergyElectrons())
+	}
+	// Dbo has the normalization of Dao: d(electronic free energy)/dTbo =
+	// -128 M Dbo at fixed Mu (with the strained Tbo).
+	h := 1e-5
+	tbo_env := tb_env
+	tbo_env.Tbo += h
+	Fp := tbo_env.FreeEnergyElectrons()
+	tbo_env.Tbo -= 2.0 * h
+	Fm := tbo_env.FreeEnergyElectrons()
+	dF, expected := (Fp-Fm)/(2.0*h), -128.0*tb_env.M*Ds_tb.Dbo(&tb_env)*tb_env.Tbo_eff()/tb_env.Tbo
+	if math.Abs(dF-expected) > 1e-6 {
+		t.Fatalf("preset dF/dTbo = %v; expected %v", dF, expected)
+	}
+	expected = 4.0*tb_env.Tao_eff()*Ds_tb.Dao(&tb_env) + 2.0*tb_env.Tco_eff()*Ds_tb.Dco(&tb_env) + 8.0*tb_env.Tbo_eff()*Ds_tb.Dbo(&tb_env)
+	if math.Abs(Ds_tb.Dm(&tb_env)-expected) > 1e-12 {
+		t.Fatalf("preset Dm = %v; expected %v", Ds_tb.Dm(&tb_env), expected)
+	}
+}
+
+func TestTightBindingOrbitalBlocks(t *testing.T) {
+	env, err := LoadEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.BZPointsPerDim = 4
+	env.M, env.W, env.Mu = 0.3, 0.6, 0.1
+	// Two decoupled copies of the preset, with the states of each site
+	// grouped into blocks of two orbitals.
+	preset := tightbinding.VO2SolveModel()
+	doubled := &tightbinding.Model{Lattice: preset.Lattice}
+	for _, orb := range preset.Orbitals {
+		for a := 0; a < 2; a++ {
+			doubled.Orbitals = append(doubled.Orbitals, tightbinding.Orbital{Label: fmt.Sprintf("%v,%d", orb.Label, a), Offset: orb.Offset})
+		}
+	}
+	for _, term := range preset.Terms {
+		for a := 0; a < 2; a++ {
+			copied := term
+			copied.I, copied.J = 2*term.I+a, 2*term.J+a
+			doubled.Terms = append(doubled.Terms, copied)
+		}
+	}
+	f, err := ioutil.TempFile("", "vo2solve_tb_model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(doubled.Marshal())
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tb_env := *env
+	tb_env.TightBinding = f.Name()
+	err = tb_env.loadTightBinding()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Hopping expectation values are summed over the orbitals of each site.
+	Ds, Ds_tb := NewHoppingEV(), NewHoppingEV()
+	if math.Abs(2.0*Ds.Dao(env)-Ds_tb.Dao(&tb_env)) > 1e-12 {
+		t.Fatalf("doubled model Dao = %v; expected %v", Ds_tb.Dao(&tb_env), 2.0*Ds.Dao(env))
+	}
+}
+
+// Return the Wannier90 form of the vo2solve preset for env with
+// EpsilonM = EpsilonR (see tightbinding.Wannier90Model): one Wannier function
+// per sublattice, with the odd hoppings given as modulations by M of the
+// even ones if modulated is true.
+func vo2SolveWannier90(env *Environment, modulated bool) (*tightbinding.Model, error) {
+	hr := &tightbinding.Hr{NumWann: 2}
+	add := func(R [3]int, m, n int, h float64) {
+		hr.Entries = append(hr.Entries, tightbinding.HrEntry{R: R, M: m, N: n, H: complex(h, 0.0)})
+	}
+	mods := []tightbinding.ModulatedHopping{}
+	for m := 1; m <= 2; m++ {
+		add([3]int{}, m, m, env.EpsilonR)
+		for _, R := range [][3]int{[3]int{1, 0, 0}, [3]int{0, 1, 0}, [3]int{0, 0, 1}} {
+			t, scale := env.Tae_eff(), 2.0*env.Tao_eff()/env.Tae_eff()
+			if R[2] == 1 {
+				t, scale = env.Tce_eff(), 2.0*env.Tco_eff()/env.Tce_eff()
+			}
+			add(R, m, m, -t)
+			add([3]int{-R[0], -R[1], -R[2]}, m, m, -t)
+			mods = append(mods, tightbinding.ModulatedHopping{R: R, M: m, N: m, Order: "M", Scale: scale})
+		}
+	}
+	for R0 := 0; R0 < 2; R0++ {
+		for R1 := 0; R1 < 2; R1++ {
+			for R2 := 0; R2 < 2; R2++ {
+				add([3]int{R0, R1, R2}, 1, 2, -env.Tbe_eff())
+				add([3]int{-R0, -R1, -R2}, 2, 1, -env.Tbe_eff())
+			}
+		}
+	}
+	for _, R := range [][3]int{[3]int{1, 0, 0}, [3]int{0, 1, 0}, [3]int{0, 0, 1}, [3]int{1, 1, 1}} {
+		mods = append(mods, tightbinding.ModulatedHopping{R: R, M: 1, N: 2, Order: "M", Scale: 2.0 * env.Tbo_eff() / env.Tbe_eff()})
+	}
+	if !modulated {
+		mods = nil
+	}
+	mapping := &tightbinding.Wannier90Mapping{
+		Lattice:    [3][3]float64{[3]float64{1.0, 0.0, 0.0}, [3]float64{0.0, 1.0, 0.0}, [3]float64{0.0, 0.0, 1.0}},
+		Sublattice: []int{0, 1},
+		Q:          [3]float64{0.5, 0.5, 0.5},
+		MuScale:    -1.0,
+		Modulated:  mods,
+	}
+	return tightbinding.Wannier90Model(hr, mapping)
+}
+
+func TestWannier90(t *testing.T) {
+	env, err := LoadEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.BZPointsPerDim = 6
+	env.EpsilonM = env.EpsilonR
+	tb_env := *env
+	tb_env.TightBinding = "vo2solve"
+	err = tb_env.loadTightBinding()
+	if err != nil {
+		t.Fatal(err)
+	}
+	load := func(modulated bool) (*Environment, func()) {
+		model, err := vo2SolveWannier90(env, modulated)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := ioutil.TempFile("", "vo2solve_wannier90_model")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = f.WriteString(model.Marshal())
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		w_env := *env
+		w_env.TightBinding = f.Name()
+		err = w_env.loadTightBinding()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &w_env, func() { os.Remove(f.Name()) }
+	}
+	w_env, cleanup := load(true)
+	defer cleanup()
+	// The Wannier90 model has no odd hopping parameters; the ions couple to
+	// its modulated hoppings through Dm, so that its self-consistent
+	// solution is that of the preset.
+	eps := 1e-8
+	Ds_tb, Ds_w := NewHoppingEV(), NewHoppingEV()
+	tb_sol, err := MWMuSolve(&tb_env, Ds_tb, eps, eps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w_sol, err := MWMuSolve(w_env, Ds_w, eps, eps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"M", "W", "Mu"} {
+		if math.Abs(tb_sol[i]-w_sol[i]) > 1e-6 {
+			t.Fatalf("Wannier90 model solution has %v = %v; expected %v", name, w_sol[i], tb_sol[i])
+		}
+	}
+	w_env.M, w_env.W, w_env.Mu = w_sol[0], w_sol[1], w_sol[2]
+	if Ds_w.Dao(w_env) != 0.0 || math.Abs(Ds_w.Dm(w_env)) < 1e-3 {
+		t.Fatalf("Wannier90 model has Dao = %v, Dm = %v; expected Dao = 0 and finite Dm", Ds_w.Dao(w_env), Ds_w.Dm(w_env))
+	}
+	// At the solution, Dm is given by the derivative of the electronic free
+	// energy: d(electronic free energy)/dM = -16 Dm at fixed Mu.
+	h := 1e-5
+	m_env := *w_env
+	m_env.M += h
+	Fp := m_env.FreeEnergyElectrons()
+	m_env.M -= 2.0 * h
+	Fm := m_env.FreeEnergyElectrons()
+	if dF := (Fp - Fm) / (2.0 * h); math.Abs(dF+16.0*Ds_w.Dm(w_env)) > 1e-6 {
+		t.Fatalf("Wannier90 model dF/dM = %v; expected %v", dF, -16.0*Ds_w.Dm(w_env))
+	}
+	// Without the modulated hoppings, the electrons do not couple to M.
+	u_env, cleanup_u := load(false)
+	defer cleanup_u()
+	u_env.M, u_env.W, u_env.Mu = w_env.M, w_env.W, w_env.Mu
+	if math.Abs(NewHoppingEV().Dm(u_env)) > 1e-12 {
+		t.Fatalf("Wannier90 model without modulated hoppings has Dm = %v; expected 0", NewHoppingEV().Dm(u_env))
+	}
+}
+
+func TestT2g(t *testing.T) {
+	env, err := LoadEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.BZPointsPerDim = 4
+	env.M, env.W, env.Mu = 0.3, 0.6, 0.1
+	env.EpsilonR, env.EpsilonM = 0.05, -0.02
+	env.Tae_pi, env.Tce_pi, env.Tbe_pi = 0.2, 0.1, 0.15
+	t2g_env := *env
+	t2g_env.TightBinding = "vo2solve_t2g"
+	err = t2g_env.loadTightBinding()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// With the pi* orbitals far above Mu, the hopping expectation values
+	// are those of the one-orbital model.
+	t2g_env.EpsilonPiR, t2g_env.EpsilonPiM = 50.0, 50.0
+	Ds, Ds_t2g := NewHoppingEV(), NewHoppingEV()
+	if math.Abs(Ds.Dao(env)-Ds_t2g.Dao(&t2g_env)) > 1e-9 || math.Abs(Ds.Dbe(env)-Ds_t2g.Dbe(&t2g_env)) > 1e-9 {
+		t.Fatalf("t2g (Dao, Dbe) = (%v, %v); expected (%v, %v)", Ds_t2g.Dao(&t2g_env), Ds_t2g.Dbe(&t2g_env), Ds.Dao(env), Ds.Dbe(env))
+	}
+	occs := t2g_env.OrbitalOccupations()
+	if len(occs) != 3 || math.Abs(occs[0]-env.OrbitalOccupations()[0]) > 1e-9 || occs[1] > 1e-9 || occs[2] > 1e-9 {
+		t.Fatalf("t2g orbital occupations = %v; expected only d_par occupied", occs)
+	}
+	// With the pi* orbitals occupied, W couples to the ions through the
+	// occupation-weighted crystal-field splitting.
+	t2g_env.EpsilonPiR, t2g_env.EpsilonPiM = 0.1, 0.4
+	occs = t2g_env.OrbitalOccupations()
+	if occs[1] < 1e-3 || math.Abs(occs[1]-occs[2]) > 1e-9 {
+		t.Fatalf("t2g orbital occupations = %v; expected equal, finite pi* occupations", occs)
+	}
+	expected := (t2g_env.EpsilonM-t2g_env.EpsilonR)*occs[0] + (t2g_env.EpsilonPiM-t2g_env.EpsilonPiR)*(occs[1]+occs[2])
+	if math.Abs(Ds_t2g.Dw(&t2g_env)-expected) > 1e-9 {
+		t.Fatalf("t2g Dw = %v; expected %v", Ds_t2g.Dw(&t2g_env), expected)
+	}
+}
+
+func TestHartreeFock(t *testing.T) {
+	env, err := LoadEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.BZPointsPerDim = 6
+	env.M, env.W, env.Mu = 0.0, 0.0, 0.0
+	env.Filling = 2.0
+	env.U = 8.0
+	// Paramagnetic and antiferromagnetic solutions at fixed (M, W).
+	eps := 1e-8
+	F := []float64{}
+	moments := [][]float64{}
+	for _, start := range [][]float64{nil, []float64{0.45, 0.05, 0.05, 0.45}} {
+		hf_env := *env
+		hf_env.HFDensity = start
+		err = hf_env.initHartreeFock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		system, start := MuSystem(&hf_env)
+		_, err = solve.MultiDim(system, start, eps, eps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := 0.0
+		for _, n := range hf_env.HFDensity {
+			sum += n
+		}
+		if math.Abs(sum-hf_env.Filling) > 1e-6 {
+			t.Fatalf("Hartree-Fock densities %v sum to %v; expected %v", hf_env.HFDensity, sum, hf_env.Filling)
+		}
+		F = append(F, hf_env.FreeEnergyElectrons())
+		moments = append(moments, hf_env.SublatticeMoments())
+	}
+	if math.Abs(moments[0][0]) > 1e-6 || math.Abs(moments[0][1]) > 1e-6 {
+		t.Fatalf("paramagnetic solution has moments %v", moments[0])
+	}
+	if moments[1][0] < 0.5 || math.Abs(moments[1][0]+moments[1][1]) > 1e-6 {
+		t.Fatalf("antiferromagnetic solution has moments %v", moments[1])
+	}
+	if F[1] >= F[0] {
+		t.Fatalf("antiferromagnetic free energy %v not below paramagnetic %v", F[1], F[0])
+	}
+
+	// With V and finite M, the solution is a stationary point of the
+	// free energy with respect to the mean fields, and the bond order gives
+	// the derivative of the free energy with respect to Tce.
+	hf_env := *env
+	hf_env.M = 0.5
+	hf_env.U, hf_env.V = 2.0, 3.0
+	err = hf_env.initHartreeFock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	system, start := MuSystem(&hf_env)
+	_, err = solve.MultiDim(system, start, eps, eps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(hf_env.HFBondQ[0]) < 1e-3 {
+		t.Fatalf("HFBondQ = %v; expected finite modulated bond order with M = %v", hf_env.HFBondQ, hf_env.M)
+	}
+	h := 1e-4
+	for _, name := range []string{"HFDensity[0]", "HFBond[0]", "HFBondQ[0]"} {
+		x := hf_env.GetFloat(name)
+		shifted := hf_env
+		shifted.Set([]float64{x + h}, []string{name})
+		Fp := shifted.FreeEnergyElectrons()
+		shifted.Set([]float64{x - h}, []string{name})
+		Fm := shifted.FreeEnergyElectrons()
+		if math.Abs(Fp-Fm)/(2.0*h) > 1e-5 {
+			t.Fatalf("free energy not stationary w.r.t. %v: derivative %v", name, (Fp-Fm)/(2.0*h))
+		}
+		// Set copies the mean fields, leaving hf_env unchanged.
+		if hf_env.GetFloat(name) != x {
+			t.Fatalf("Set on a copy of env changed %v", name)
+		}
+	}
+	shifted := hf_env
+	shifted.Tce += h
+	Fp := shifted.FreeEnergyElectrons()
+	shifted.Tce -= 2.0 * h
+	Fm := shifted.FreeEnergyElectrons()
+	// Two bonds per site (one per cell of each sublattice) counted twice.
+	bond_sum := 0.0
+	for _, chi := range hf_env.HFBond {
+		bond_sum += chi
+	}
+	if math.Abs((Fp-Fm)/(2.0*h)+4.0*bond_sum) > 1e-6 {
+		t.Fatalf("dF/dTce = %v; expected %v from the bond orders", (Fp-Fm)/(2.0*h), -4.0*bond_sum)
+	}
+}
+
+func TestZeeman(t *testing.T) {
+	env, err := LoadEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.BZPointsPerDim = 6
+	// The spin bands are the bands without the field shifted by
+	// -Zeeman and +Zeeman.
+	k := vec.Vector{0.3, -1.2, 2.1}
+	evals, _ := cmatrix.Eigensystem(ElHamiltonian(env, k))
+	field_env := *env
+	field_env.Zeeman = 0.05
+	field_evals, _ := cmatrix.Eigensystem(ElHamiltonian(&field_env, k))
+	expected := []float64{}
+	for _, E := range evals {
+		expected = append(expected, E-field_env.Zeeman, E+field_env.Zeeman)
+	}
+	sort.Float64s(expected)
+	sort.Float64s(field_evals)
+	for i := range expected {
+		if math.Abs(field_evals[i]-expected[i]) > 1e-9 {
+			t.Fatalf("bands with Zeeman field = %v; expected %v", field_evals, expected)
+		}
+	}
+	// The magnetisation is the derivative of the free energy with respect
+	// to the field (two sites per cell).
+	h := 1e-4
+	shifted := field_env
+	shifted.Zeeman += h
+	Fp := shifted.FreeEnergyElectrons()
+	shifted.Zeeman -= 2.0 * h
+	Fm := shifted.FreeEnergyElectrons()
+	m := field_env.Magnetization()
+	if m <= 0.0 || math.Abs((Fp-Fm)/(2.0*h)+2.0*m) > 1e-6 {
+		t.Fatalf("Magnetization = %v; expected positive with dF/dZeeman = %v = -2m", m, (Fp-Fm)/(2.0*h))
+	}
+	// Without interactions, the D values are averaged over the spins and
+	// so are unchanged to first order in the field.
+	Ds, Ds_field := NewHoppingEV(), NewHoppingEV()
+	small_env := *env
+	small_env.Zeeman = 1e-5
+	if math.Abs(Ds.Dao(env)-Ds_field.Dao(&small_env)) > 1e-8 {
+		t.Fatalf("Dao with small field = %v; expected %v", Ds_field.Dao(&small_env), Ds.Dao(env))
+	}
+	// In a finite field, the spin channels have different D values; their
+	// average couples to the ions, and gives the derivative of the
+	// electronic free energy as without the field: dF/dTao = -64 M Dao.
+	Ds_field = NewHoppingEV()
+	field_env.Zeeman = 0.5
+	spin_Ds := Ds_field.SpinResolved(&field_env)
+	dao := spin_Ds["Dao"]
+	if len(dao) != 2 || math.Abs(dao[0]-dao[1]) < 1e-4 || math.Abs(0.5*(dao[0]+dao[1])-Ds_field.Dao(&field_env)) > 1e-12 {
+		t.Fatalf("spin-resolved Dao = %v with Dao = %v; expected distinct values with average Dao", dao, Ds_field.Dao(&field_env))
+	}
+	tao_env := field_env
+	tao_env.Tao += h
+	Fp = tao_env.FreeEnergyElectrons()
+	tao_env.Tao -= 2.0 * h
+	Fm = tao_env.FreeEnergyElectrons()
+	if dF := (Fp - Fm) / (2.0 * h); math.Abs(dF+64.0*field_env.M*Ds_field.Dao(&field_env)) > 1e-6 {
+		t.Fatalf("dF/dTao with Zeeman field = %v; expected %v", dF, -64.0*field_env.M*Ds_field.Dao(&field_env))
+	}
+	if Ds.SpinResolved(env) != nil {
+		t.Fatalf("expected no spin-resolved D values without the field")
+	}
+	// The susceptibility gives the linear response of the solved system.
+	eps := 1e-8
+	_, err = Solve(env, NewHoppingEV(), eps, eps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chi, err := SpinSusceptibility(env, eps, eps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err = solvedMagnetization(env, 0.01, eps, eps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chi <= 0.0 || math.Abs(m/0.01-chi) > 1e-2*chi {
+		t.Fatalf("spin susceptibility = %v; expected positive, close to m(h)/h = %v", chi, m/0.01)
+	}
+}
+
+func TestWavevector(t *testing.T) {
+	env, err := LoadEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.BZPointsPerDim = 4
+	env.M, env.W, env.Mu = 0.4, 0.6, 0.1
+	env.Strain_xx, env.Strain_zz, env.Eta_a, env.Eta_c, env.Eta_b = 0.01, -0.02, 2.0, 3.0, 4.0
+	// The default Q given explicitly reproduces ElHamiltonian as written and
+	// its hopping e.v.'s.
+	q_env := *env
+	q_env.Q = &[3]float64{0.5, 0.5, 0.5}
+	err = q_env.loadTightBinding()
+	if err != nil {
+		t.Fatal(err)
+	}
+	Ds, Ds_q := NewHoppingEV(), NewHoppingEV()
+	Dfuncs := map[string]func(*HoppingEV, *Environment) float64{"Dae": (*HoppingEV).Dae, "Dce": (*HoppingEV).Dce,
+		"Dbe": (*HoppingEV).Dbe, "Dao": (*HoppingEV).Dao, "Dco": (*HoppingEV).Dco}
+	for name, D := range Dfuncs {
+		if math.Abs(D(Ds, env)-D(Ds_q, &q_env)) > 1e-12 {
+			t.Fatalf("%v with explicit Q = %v; expected %v", name, D(Ds_q, &q_env), D(Ds, env))
+		}
+	}
+	// Dbo is that of the unfolded preset (see TestTightBindingPreset).
+	tb_env := *env
+	tb_env.TightBinding = "vo2solve"
+	err = tb_env.loadTightBinding()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(NewHoppingEV().Dbo(&tb_env)-Ds_q.Dbo(&q_env)) > 1e-12 {
+		t.Fatalf("Dbo with explicit Q = %v; expected %v", Ds_q.Dbo(&q_env), NewHoppingEV().Dbo(&tb_env))
+	}
+	if math.Abs(env.FreeEnergyElectrons()-q_env.FreeEnergyElectrons()) > 1e-12 {
+		t.Fatalf("electronic free energy with explicit Q = %v; expected %v", q_env.FreeEnergyElectrons(), env.FreeEnergyElectrons())
+	}
+	// Q of order 4 doubles the basis.
+	q_env.Q = &[3]float64{0.25, 0.0, 0.5}
+	err = q_env.loadTightBinding()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dim, _ := ElHamiltonian(&q_env, vec.Vector{0.1, 0.2, 0.3}).Dims()
+	if dim != 8 {
+		t.Fatalf("Q = %v gives %v states; expected 8", *q_env.Q, dim)
+	}
+	// The odd hopping e.v.'s keep their normalization: d(electronic free
+	// energy)/dTao = -64 M Dao at fixed Mu (with the strained Tao), as for
+	// the default Q.
+	Ds_q = NewHoppingEV()
+	h := 1e-5
+	tao_env := q_env
+	tao_env.Tao += h
+	Fp := tao_env.FreeEnergyElectrons()
+	tao_env.Tao -= 2.0 * h
+	Fm := tao_env.FreeEnergyElectrons()
+	dF, expected := (Fp-Fm)/(2.0*h), -64.0*q_env.M*Ds_q.Dao(&q_env)*q_env.Tao_eff()/q_env.Tao
+	if math.Abs(dF-expected) > 1e-6 {
+		t.Fatalf("dF/dTao with Q = %v is %v; expected %v", *q_env.Q, dF, expected)
+	}
+	// Without dimerisation, the folded bands are those of the default Q.
+	env.M, q_env.M = 0.0, 0.0
+	Ds, Ds_q = NewHoppingEV(), NewHoppingEV()
+	for _, name := range []string{"Dae", "Dce", "Dbe"} {
+		D := Dfuncs[name]
+		if math.Abs(D(Ds, env)-D(Ds_q, &q_env)) > 1e-12 {
+			t.Fatalf("%v with Q = %v and M = 0 is %v; expected %v", name, *q_env.Q, D(Ds_q, &q_env), D(Ds, env))
+		}
+	}
+	if math.Abs(env.FreeEnergyElectrons()-q_env.FreeEnergyElectrons()) > 1e-12 {
+		t.Fatalf("electronic free energy with Q = %v and M = 0 is %v; expected %v", *q_env.Q, q_env.FreeEnergyElectrons(), env.FreeEnergyElectrons())
+	}
+	// The modulated Fock term requires Q of order 2.
+	q_env.V = 1.0
+	if q_env.initHartreeFock() == nil {
+		t.Fatalf("expected error for V with Q of order 4")
+	}
+	// Q = 0 can be given explicitly: a uniform order parameter, as for
+	// Q = (1, 0, 0).
+	q_env.V = 0.0
+	q_env.M = 0.4
+	q_env.Q = &[3]float64{0.0, 0.0, 0.0}
+	err = q_env.loadTightBinding()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q_env.foldOrder() != 1 {
+		t.Fatalf("Q = 0 has order %v; expected 1", q_env.foldOrder())
+	}
+	uniform_env := q_env
+	uniform_env.Q = &[3]float64{1.0, 0.0, 0.0}
+	err = uniform_env.loadTightBinding()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(q_env.FreeEnergyElectrons()-uniform_env.FreeEnergyElectrons()) > 1e-12 {
+		t.Fatalf("electronic free energy with Q = 0 is %v; expected %v", q_env.FreeEnergyElectrons(), uniform_env.FreeEnergyElectrons())
+	}
+	// Q cannot fold a model loaded from a file.
+	m, err := tightbinding.Preset("vo2solve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", "vo2solve_tb_model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(m.Marshal())
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q_env.TightBinding = f.Name()
+	if q_env.loadTightBinding() == nil {
+		t.Fatalf("expected error for Q with a tight-binding model loaded from a file")
+	}
+}
+
+func TestLowTemperatureIons(t *testing.T) {
+	env, err := LoadEnv("system_test_env_ions.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.M, env.W = 0.3, 0.6
+	Ds := NewHoppingEV()
+	// At T = 0 the ions are in the single-site ground state.
+	a, h := env.ionS2Coeff(Ds), env.ionSField(Ds)
+	E0, S0 := 0.0, 0.0
+	for _, S := range ion_basis {
+		if E := a*S*S - h*S; E < E0 {
+			E0, S0 = E, S
+		}
+	}
+	if E0 == 0.0 {
+		t.Fatalf("test expects an ordered ground state; got a = %v, h = %v", a, h)
+	}
+	env.Beta = math.Inf(1)
+	if env.FreeEnergyIons(Ds) != 2.0*E0 || env.ExpectS(Ds) != S0 || env.ExpectS2(Ds) != S0*S0 {
+		t.Fatalf("At T = 0 got (F_ion, <S>, <S^2>) = (%v, %v, %v); expected (%v, %v, %v)", env.FreeEnergyIons(Ds), env.ExpectS(Ds), env.ExpectS2(Ds), 2.0*E0, S0, S0*S0)
+	}
+	// Large Beta, for which exp(Beta E0) overflows, approaches T = 0.
+	for _, beta := range []float64{1e3, 1e5} {
+		env.Beta = beta
+		F, S := env.FreeEnergyIons(Ds), env.ExpectS(Ds)
+		if math.Abs(F-2.0*E0) > 1e-6 || math.Abs(S-S0) > 1e-6 {
+			t.Fatalf("At Beta = %v got (F_ion, <S>) = (%v, %v); expected (%v, %v)", beta, F, S, 2.0*E0, S0)
+		}
+	}
+	// The susceptibility and the transverse model stay finite.
+	for _, gamma := range []float64{0.0, 0.1} {
+		env.Beta, env.Gamma = math.Inf(1), gamma
+		chi := env.IonSusceptibility(Ds)
+		values := []float64{env.FreeEnergyIons(Ds), env.ExpectS(Ds), env.ExpectS2(Ds), chi[0][0], chi[0][1], chi[1][1]}
+		for _, v := range values {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				t.Fatalf("At T = 0 and Gamma = %v got (F_ion, <S>, <S^2>, chi) = %v; expected finite values", gamma, values)
+			}
+		}
+	}
+}
+
+func TestGroundState(t *testing.T) {
+	eps := 1e-9
+	// Ions only: the single-site configuration minimising H_Ion.
+	env, err := LoadIonEnv("system_test_env_ions.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Beta = math.Inf(1)
+	env.M, env.W = 1.0, 1.0
+	Ds := NewHoppingEV()
+	_, err = Solve(env, Ds, eps, eps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.M != 1.0 || env.W != 1.0 {
+		t.Fatalf("Ionic ground state has (M, W) = (%v, %v); expected (1, 1)", env.M, env.W)
+	}
+	// With B > 0, S = 1 has single-site energy B - QJ.
+	expected := 2.0*(env.B-env.QJ(Ds)) + env.QJ(Ds) + env.QK()
+	if math.Abs(env.FreeEnergy(Ds)-expected) > 1e-12 {
+		t.Fatalf("Ionic ground-state energy %v; expected %v", env.FreeEnergy(Ds), expected)
+	}
+	// The ground state round-trips through JSON.
+	reloaded, err := NewEnvironment(env.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.ZeroTemperature() {
+		t.Fatalf("Beta = %v after reloading the ground state; expected Inf", reloaded.Beta)
+	}
+
+	// With electrons, Mu is the tetrahedron Fermi level.
+	env, err = LoadEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Beta = math.Inf(1)
+	Ds = NewHoppingEV()
+	_, err = MWMuSolve(env, Ds, eps, eps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("Ground state M = ", env.M, "W = ", env.W, "Mu = ", env.Mu, "E = ", env.FreeEnergy(Ds))
+	if env.ExpectS(Ds) != env.M || env.ExpectS2(Ds) != env.W {
+		t.Fatalf("(M, W) = (%v, %v) is not the ionic ground state (%v, %v)", env.M, env.W, env.ExpectS(Ds), env.ExpectS2(Ds))
+	}
+	Mu, err := env.FermiLevel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(Mu-env.Mu) > 1e-8 {
+		t.Fatalf("Mu = %v differs from the Fermi level %v", env.Mu, Mu)
+	}
+	// Low temperature approaches the ground state, without overflow.
+	cold := *env
+	cold.Beta = 100.0
+	if F := cold.FreeEnergyElectrons(); math.Abs(F-env.FreeEnergyElectrons()) > 1e-3 {
+		t.Fatalf("Electronic free energy %v at Beta = 100; expected close to the ground-state energy %v", F, env.FreeEnergyElectrons())
+	}
+}
+
+func TestLandscape(t *testing.T) {
+	env, err := LoadEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.BZPointsPerDim = 4
+	grid_vars := []string{"M", "W"}
+	grid_vals := [][]float64{[]float64{0.0, 0.5}, []float64{0.2, 0.8}}
+	eps := 1e-9
+	for _, relax := range [][]string{[]string{}, []string{"Mu"}} {
+		ls, err := NewLandscape(env, grid_vars, grid_vals, relax, eps, eps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ls.Points) != 4 {
+			t.Fatalf("Landscape has %d points; expected 4", len(ls.Points))
+		}
+		// The last grid variable varies fastest.
+		if ls.Points[1].Grid[0] != 0.0 || ls.Points[1].Grid[1] != 0.8 {
+			t.Fatalf("Second landscape point is at %v; expected [0 0.8]", ls.Points[1].Grid)
+		}
+		for _, point := range ls.Points {
+			if !point.Converged {
+				t.Fatalf("Landscape point %v not converged", point.Grid)
+			}
+			point_env := *env
+			point_env.Set(point.Grid, grid_vars)
+			point_env.Set(point.Relaxed, relax)
+			F := point_env.FreeEnergy(NewHoppingEV())
+			if math.Abs(point.FreeEnergy-F) > 1e-9 {
+				t.Fatalf("Landscape at %v, %v = %v has F = %v; expected %v", point.Grid, relax, point.Relaxed, point.FreeEnergy, F)
+			}
+			if len(relax) > 0 {
+				Mu_err, err := AbsErrorMu(&point_env, relax).F(point.Relaxed)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if math.Abs(Mu_err) > 1e-6 {
+					t.Fatalf("Landscape at %v has filling error %v", point.Grid, Mu_err)
+				}
+			}
+		}
+	}
+	if env.M != 1.0 || env.W != 1.0 {
+		t.Fatalf("NewLandscape modified env")
+	}
+	// The hopping e.v.'s depend on grid variables other than the order
+	// parameters, such as Beta: each point agrees with a separate evaluation.
+	ls, err := NewLandscape(env, []string{"Beta"}, [][]float64{[]float64{20.0, 5.0}}, []string{}, eps, eps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, point := range ls.Points {
+		point_env := *env
+		point_env.Beta = point.Grid[0]
+		F := point_env.FreeEnergy(NewHoppingEV())
+		if math.Abs(point.FreeEnergy-F) > 1e-9 {
+			t.Fatalf("Landscape at Beta = %v has F = %v; expected %v", point.Grid[0], point.FreeEnergy, F)
+		}
+	}
+
+	_, err = NewLandscape(env, []string{"Bogus"}, grid_vals[:1], []string{"Mu"}, eps, eps)
+	if err == nil {
+		t.Fatalf("Expected error for an unknown grid variable")
+	}
+	_, err = NewLandscape(env, grid_vars, grid_vals, []string{"W", "Mu"}, eps, eps)
+	if err == nil {
+		t.Fatalf("Expected error for a variable both fixed on the grid and relaxed")
+	}
+	_, err = NewLandscape(env, grid_vars, grid_vals, []string{"Bogus"}, eps, eps)
+	if err == nil {
+		t.Fatalf("Expected error relaxing an unknown variable")
+	}
+}
+
+func TestProjectedDos(t *testing.T) {
+	env, err := LoadEnv("system_test_env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.M, env.W = 0.5, 0.7
+	// Each band has unit weight in total, and the band energies are those
+	// of BandEnergies.
+	Efn := env.BandEnergies()
+	Pfn := env.BandProjections()
+	k := []float64{0.1, 0.2, 0.3}
+	Es, weights := Pfn(k)
+	Es_b := Efn(k)
+	if len(weights) != len(env.basisLabels()) {
+		t.Fatalf("Got %d bands; expected one per basis state %v", len(weights), env.basisLabels())
+	}
+	for b, ws := range weights {
+		sum := 0.0
+		for _, w := range ws {
+			sum += w
+		}
+		if math.Abs(sum-1.0) > 1e-12 || math.Abs(Es[b]-Es_b[b]) > 1e-12 {
+			t.Fatalf("Band %d has E = %v and total weight %v; expected E = %v and weight 1", b, Es[b], sum, Es_b[b])
+		}
+	}
+	// The projected DOS summed over the basis states is the total DOS.
+	n, num_dos := 6, 50
+	dos := env.Dos(n, num_dos)
+	pdos := env.ProjectedDos(n, num_dos)
+	for i := range dos.DOS {
+		sum := 0.0
+		for s := range pdos.Basis {
+			sum += pdos.Projected[s][i]
+		}
+		tol := 1e-9 * math.Max(1.0, dos.DOS[i])
+		if math.Abs(pdos.E[i]-dos.E[i]) > 1e-12 || math.Abs(pdos.DOS[i]-dos.DOS[i]) > tol || math.Abs(sum-dos.DOS[i]) > tol {
+			t.Fatalf("At E = %v, projected DOS sums to %v; expected total DOS %v", dos.E[i], sum, dos.DOS[i])
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package vo2solve
+
+import (
+	"math"
+)
+import (
+	"github.com/tflovorn/cmatrix"
+	"github.com/tflovorn/vo2mft/meanfield"
+)
+
+// Matrix element of S^x between S = 0 and S = +/-1 for spin 1.
+var sx_elem = 1.0 / math.Sqrt2
+
+// Values of S for the basis states of the single-site ionic Hamiltonian.
+var ion_basis = []float64{1.0, 0.0, -1.0}
+
+// Return the eigenvalues and (real) eigenvectors of the single-site ionic
+// Hamiltonian a S^2 - h S - Gamma S^x (with a = ionS2Coeff, h = ionSField),
+// with eigenvector components in the basis ion_basis. evecs[n] is the n'th
+// eigenvector.
+func (env *Environment) ionEigensystem(Ds *HoppingEV) ([]float64, [][]float64) {
+	a := env.ionS2Coeff(Ds)
+	h := env.ionSField(Ds)
+	n := len(ion_basis)
+	H := cmatrix.InitSliceCMatrix(n, n)
+	for i, S := range ion_basis {
+		H[i][i] = complex(a*S*S-h*S, 0.0)
+	}
+	g := complex(-env.Gamma*sx_elem, 0.0)
+	H[0][1], H[1][0], H[1][2], H[2][1] = g, g, g, g
+
+	evals, cevecs := cmatrix.Eigensystem(H)
+	evecs := make([][]float64, n)
+	for m := 0; m < n; m++ {
+		evecs[m] = make([]float64, n)
+		for i := 0; i < n; i++ {
+			evecs[m][i] = real(cevecs[m][i])
+		}
+	}
+	return evals, evecs
+}
+
+// Return the Boltzmann distribution over the single-site levels, <S> and
+// <S^2> including the transverse term, and the susceptibility (see
+// IonSusceptibility).
+func (env *Environment) quantumIon(Ds *HoppingEV) (*meanfield.Boltzmann, float64, float64, [][]float64) {
+	evals, evecs := env.ionEigensystem(Ds)
+	obs := [][]float64{make([]float64, len(ion_basis)), make([]float64, len(ion_basis))}
+	for i, S := range ion_basis {
+		obs[0][i] = S
+		obs[1][i] = S * S
+	}
+	dist, avgs, chi := meanfield.ThermalAverages(evals, evecs, obs, env.BetaIons())
+	return dist, avgs[0], avgs[1], chi
+}
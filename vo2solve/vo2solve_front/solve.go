@@ -6,17 +6,22 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
 )
 
 var eps = flag.Float64("eps", 1e-6, "Converged when error below eps")
 var ions = flag.Bool("ions", false, "Solve only ionic system")
+var thermo = flag.Bool("thermo", false, "Calculate entropy and specific heat (solves the system at two additional temperatures)")
+var spin = flag.Bool("spin", false, "Calculate spin susceptibility (solves the system at two additional Zeeman fields)")
+var edges = flag.Bool("edges", false, "Calculate the band gap and band edges (refines the band extrema by local search in k)")
+var ground_state = flag.Bool("ground_state", false, "Find the T = 0 ground state (sets Beta to Inf); FreeEnergy is then the ground-state energy, and the stability analysis, --thermo and --spin are skipped")
 
 func main() {
 	flag.Parse()
 	args := flag.Args()
 	if len(args) < 2 {
-		fmt.Println("Usage: vo2solve_front [--eps EPS] [--ions] in_path out_path")
+		fmt.Println("Usage: vo2solve_front [--eps EPS] [--ions] [--thermo] [--spin] [--edges] [--ground_state] in_path out_path")
 		fmt.Println("For flag descriptions, use: vo2solve_front --help")
 		os.Exit(2)
 	}
@@ -35,6 +40,9 @@ func main() {
 			fmt.Println(err)
 			os.Exit(1)
 		}
+		if *ground_state {
+			env.Beta, env.BetaEl, env.BetaIon = math.Inf(1), 0.0, 0.0
+		}
 		_, err = vo2solve.MWMuSolve(env, Ds, *eps, *eps)
 		if err != nil {
 			fmt.Println(err)
@@ -47,6 +55,9 @@ func main() {
 			fmt.Println(err)
 			os.Exit(1)
 		}
+		if *ground_state {
+			env.Beta, env.BetaEl, env.BetaIon = math.Inf(1), 0.0, 0.0
+		}
 		_, err = vo2solve.MWSolve(env, Ds, *eps, *eps)
 		if err != nil {
 			fmt.Println(err)
@@ -57,8 +68,44 @@ func main() {
 
 	// Calculate additional data for export from solved Environment.
 	fenv := vo2solve.NewFinalEnvironment(solved_env, Ds)
+	if *edges && !*ions {
+		fenv.BandEdges = solved_env.BandEdges()
+	}
+	if solved_env.ZeroTemperature() {
+		// The finite temperature analyses do not apply to the ground state.
+		write_fenv(fenv, out_path)
+		return
+	}
+	// The stability analysis is optional: if it fails, keep the solution
+	// and leave Stability empty.
+	hess, hess_evals, stability, err := vo2solve.Stability(solved_env, *eps, *eps)
+	if err != nil {
+		fmt.Println("Stability analysis failed:", err)
+	} else {
+		fenv.Hessian, fenv.HessianEigenvalues, fenv.Stability = hess, hess_evals, stability
+	}
+	if *thermo {
+		S, C, err := vo2solve.Thermodynamics(solved_env, *eps, *eps)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fenv.Entropy, fenv.SpecificHeat = S, C
+	}
+	if *spin && !*ions {
+		chi, err := vo2solve.SpinSusceptibility(solved_env, *eps, *eps)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fenv.SpinSusceptibility = chi
+	}
+
+	write_fenv(fenv, out_path)
+}
 
-	// Write output system.
+// Write the output system to out_path + "_fenv.json".
+func write_fenv(fenv *vo2solve.FinalEnvironment, out_path string) {
 	fenv_out_buf := bytes.NewBufferString(fenv.Marshal())
 	fenv_out_data := fenv_out_buf.Bytes()
 	ioutil.WriteFile(out_path+"_fenv.json", fenv_out_data, 0644) // u=rw;go=r
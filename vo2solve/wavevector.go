@@ -0,0 +1,122 @@
+package vo2solve
+
+import (
+	"math"
+)
+import (
+	"github.com/tflovorn/scExplorer/bzone"
+	vec "github.com/tflovorn/scExplorer/vector"
+	"github.com/tflovorn/vo2mft/tightbinding"
+)
+
+// Modulation wavevector Q of the order parameters given explicitly
+// (see Environment.Q).
+//
+// With Q of order N (the smallest N such that N Q is a reciprocal lattice
+// vector; see tightbinding.FoldOrder), ElHamiltonian is the tight-binding
+// model folded with Q (see tightbinding.PresetQ), whose states form 2N
+// blocks (k, 0), (k+Q, 0), ..., (k+(N-1)Q, 0), (k, 1), ..., (k+(N-1)Q, 1).
+// The default Q = (1/2, 1/2, 1/2) has N = 2 and the four blocks of
+// el_basis. The states of each sublattice at k + jQ range over the BZ N
+// times, so that BZ averages over the states are weighted by 2/N relative to
+// the default Q (see foldScale).
+//
+// The hopping e.v.'s are given in the normalization of those for the
+// default Q: the even ones are summed over the blocks (see foldedD); the
+// odd ones, which couple the blocks, are given by the derivative of the
+// electronic energy with respect to Tao, Tco and Tbo, as for any
+// tight-binding model (see tbOddD). The ionic
+// problem is solved for a single site as for the default Q, with the
+// exchange energy of the modulation Q (see exchangeQ); for N > 2 this is
+// the approximation in which each site has the order parameter amplitude M.
+
+// Return true if env.Q is set, so that ElHamiltonian is a folded preset (see
+// wavevector.go).
+func (env *Environment) folded() bool {
+	return env.Q != nil
+}
+
+// Return the order N of the modulation wavevector (see wavevector.go): 2 for
+// the default Q.
+func (env *Environment) foldOrder() int {
+	if !env.folded() {
+		return 2
+	}
+	N, err := tightbinding.FoldOrder(*env.Q)
+	if err != nil {
+		// Checked by loadTightBinding.
+		panic(err)
+	}
+	return N
+}
+
+// Return the number of blocks in the basis of ElHamiltonian for one spin
+// (see evalEV): 2N for the modulation wavevector of order N.
+func (env *Environment) numBlocks() int {
+	return 2 * env.foldOrder()
+}
+
+// Return the index of block (k+jQ, s) in the basis of ElHamiltonian for one
+// spin, with j taken modulo the order of Q.
+func (env *Environment) blockIndex(s, j int) int {
+	N := env.foldOrder()
+	return s*N + ((j%N)+N)%N
+}
+
+// Return the weight of BZ averages over the states of ElHamiltonian relative
+// to the default Q: 2/N for Q of order N (see wavevector.go).
+func (env *Environment) foldScale() float64 {
+	return 2.0 / float64(env.foldOrder())
+}
+
+// Return the exchange part of QJ for the modulation wavevector: the Fourier
+// transform J(Q) of Ja and Jc over the cubic axes, 4 Ja + 2 Jc for the
+// default Q.
+func (env *Environment) exchangeQ() float64 {
+	if !env.folded() {
+		return 4.0*env.Ja + 2.0*env.Jc
+	}
+	cx, cy, cz := math.Cos(2.0*math.Pi*env.Q[0]), math.Cos(2.0*math.Pi*env.Q[1]), math.Cos(2.0*math.Pi*env.Q[2])
+	return -2.0*env.Ja*(cx+cy) - 2.0*env.Jc*cz
+}
+
+// Return the even hopping e.v. with the given name (see HoppingEV) of spin
+// channel sigma for a modulation wavevector given explicitly (see
+// wavevector.go).
+func (env *Environment) foldedD(name string, sigma int) float64 {
+	N := env.foldOrder()
+	// Axis of the a and c bond form factors.
+	axis := map[string]int{"dae": 0, "dce": 2}
+	var inner func(k vec.Vector) float64
+	switch name {
+	case "dae", "dce":
+		inner = func(k vec.Vector) float64 {
+			pairs := make([][2]int, N)
+			for j := 0; j < N; j++ {
+				pairs[j] = [2]int{env.blockIndex(0, j), env.blockIndex(0, j)}
+			}
+			evs := blockEVs(env, k, pairs, sigma)
+			sum := 0.0
+			for j, ev := range evs {
+				kj := k[axis[name]] + 2.0*math.Pi*float64(j)*env.Q[axis[name]]
+				sum += 4.0 * math.Cos(kj) * real(ev)
+			}
+			return sum
+		}
+	case "dbe":
+		// Body diagonal bond within the cell.
+		inner = func(k vec.Vector) float64 {
+			pairs := make([][2]int, N)
+			for j := 0; j < N; j++ {
+				pairs[j] = [2]int{env.blockIndex(0, j), env.blockIndex(1, j)}
+			}
+			sum := 0.0
+			for _, ev := range blockEVs(env, k, pairs, sigma) {
+				sum += 4.0 * real(ev)
+			}
+			return sum
+		}
+	}
+	// Each of the N blocks contributes the e.v. at k of the default Q.
+	return 0.25 * env.foldScale() * bzone.Avg(env.BZPointsPerDim, 3, inner)
+}